@@ -26,6 +26,9 @@ var (
 	// metric api.get_target is how long it takes to get a target
 	getTargetDuration = stats.NewLatencyHistogram15s32("api.get_target")
 
+	// metric api.get_target.canceled is how many fetches of a target were aborted or not started because their request was canceled
+	getTargetCanceled = stats.NewCounter32("api.get_target.canceled")
+
 	// metric api.iters_to_points is how long it takes to decode points from a chunk iterator
 	itersToPointsDuration = stats.NewLatencyHistogram15s32("api.iters_to_points")
 
@@ -50,6 +53,7 @@ type Server struct {
 	shutdown        chan struct{}
 	Tracer          opentracing.Tracer
 	prioritySetters []PrioritySetter
+	renderCache     *renderCache // nil if disabled
 }
 
 func (s *Server) BindMetricIndex(i idx.MetricIndex) {
@@ -104,6 +108,8 @@ func NewServer() (*Server, error) {
 		shutdown: make(chan struct{}),
 		Macaron:  m,
 		Tracer:   opentracing.NoopTracer{},
+
+		renderCache: newRenderCache(renderCacheMaxSize, renderCacheAlign, renderCacheRecent, renderCacheRecentTTL, renderCacheTTL),
 	}, nil
 }
 
@@ -206,15 +206,18 @@ func (s *Server) indexList(ctx *middleware.Context, req models.IndexList) {
 }
 
 func (s *Server) getData(ctx *middleware.Context, request models.GetData) {
-	series, err := s.getTargetsLocal(ctx.Req.Context(), request.Requests)
+	// the other limits are enforced by the node that handles the render request
+	reqCtx, cancel := withQueryLimits(ctx.Req.Context(), queryLimits{MaxPoints: request.MaxPoints})
+	defer cancel()
+	series, err := s.getTargetsLocal(reqCtx, request.Requests)
 	if err != nil {
-		// the only errors returned are from us catching panics, so we should treat them
-		// all as internalServerErrors
+		// besides exceeding the points limit, the only errors returned are from us catching panics,
+		// so we should treat them all as internalServerErrors
 		log.Error(3, "HTTP getData() %s", err.Error())
 		response.Write(ctx, response.WrapError(err))
 		return
 	}
-	response.Write(ctx, response.NewMsgp(200, &models.GetDataResp{Series: series}))
+	response.Write(ctx, response.NewMsgpStream(200, &models.GetDataResp{Series: series}))
 }
 
 func (s *Server) indexDelete(ctx *middleware.Context, req models.IndexDelete) {
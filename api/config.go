@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"time"
 
+	"github.com/grafana/metrictank/expr"
 	"github.com/raintank/dur"
 	"github.com/raintank/worldping-api/pkg/log"
 	"github.com/rakyll/globalconf"
@@ -18,6 +19,14 @@ var (
 	logMinDurStr        string
 	logMinDur           uint32
 
+	slowQueryThreshold time.Duration
+
+	maxSeriesPerReq        int
+	maxPointsFetchedPerReq int
+	maxReqTimeStr          string
+	maxReqTime             time.Duration
+	orgLimitsFile          string
+
 	Addr             string
 	UseSSL           bool
 	useGzip          bool
@@ -26,6 +35,17 @@ var (
 	multiTenant      bool
 	fallbackGraphite string
 	timeZoneStr      string
+	userFuncsFile    string
+
+	renderCacheMaxSize      uint64
+	renderCacheAlignStr     string
+	renderCacheAlign        uint32
+	renderCacheRecentStr    string
+	renderCacheRecent       uint32
+	renderCacheRecentTTLStr string
+	renderCacheRecentTTL    time.Duration
+	renderCacheTTLStr       string
+	renderCacheTTL          time.Duration
 
 	getTargetsConcurrency int
 	tagdbDefaultLimit     uint
@@ -39,7 +59,13 @@ func ConfigSetup() {
 	apiCfg := flag.NewFlagSet("http", flag.ExitOnError)
 	apiCfg.IntVar(&maxPointsPerReqSoft, "max-points-per-req-soft", 1000000, "lower resolution rollups will be used to try and keep requests below this number of datapoints. (0 disables limit)")
 	apiCfg.IntVar(&maxPointsPerReqHard, "max-points-per-req-hard", 20000000, "limit of number of datapoints a request can return. Requests that exceed this limit will be rejected. (0 disables limit)")
+	apiCfg.IntVar(&maxSeriesPerReq, "max-series-per-req", 0, "limit of number of series the targets of a request can match. Requests that exceed this limit will be rejected. (0 disables limit)")
+	apiCfg.IntVar(&maxPointsFetchedPerReq, "max-points-fetched-per-req", 0, "limit of number of datapoints a request can fetch from memory and the store, across all cluster nodes, before any consolidation. Requests that exceed this limit will be rejected. (0 disables limit)")
+	apiCfg.StringVar(&maxReqTimeStr, "max-req-time", "0", "limit of the time a request can take to fetch and process its data. Requests that exceed this limit will be rejected. (0 disables limit)")
+	apiCfg.StringVar(&orgLimitsFile, "org-limits-file", "", "path to a file with per-org overrides of max-series-per-req, max-points-fetched-per-req and max-req-time. see docs/http-api.md (empty to disable)")
 	apiCfg.StringVar(&logMinDurStr, "log-min-dur", "5min", "only log incoming requests if their timerange is at least this duration. Use 0 to disable")
+	// unlike the other durations, this one takes sub-second values such as 500ms, as those are the most useful ones
+	apiCfg.DurationVar(&slowQueryThreshold, "slow-query-threshold", 0, "log /render and prometheus queries that take at least this long (e.g. 500ms or 5s), with the details of where their time went. Use 0 to disable")
 
 	apiCfg.StringVar(&Addr, "listen", ":6060", "http listener address.")
 	apiCfg.BoolVar(&UseSSL, "ssl", false, "use HTTPS")
@@ -52,11 +78,22 @@ func ConfigSetup() {
 	apiCfg.IntVar(&getTargetsConcurrency, "get-targets-concurrency", 20, "maximum number of concurrent threads for fetching data on the local node. Each thread handles a single series.")
 	apiCfg.UintVar(&tagdbDefaultLimit, "tagdb-default-limit", 100, "default limit for tagdb query results, can be overridden with query parameter \"limit\"")
 	apiCfg.Float64Var(&speculationThreshold, "speculation-threshold", 1, "ratio of peer responses after which speculation is used. Set to 1 to disable.")
+	apiCfg.StringVar(&userFuncsFile, "user-functions-file", "", "path to a file with user-defined graphite functions. see docs/graphite.md (empty to disable)")
+	apiCfg.Uint64Var(&renderCacheMaxSize, "render-cache-max-size", 0, "maximum size in bytes of the cache of /render outputs. 0 disables it")
+	apiCfg.StringVar(&renderCacheAlignStr, "render-cache-align", "10s", "when the render cache is enabled, from and to of /render requests are aligned to this interval, so that requests for nearly the same time range can be served from the same cache entry")
+	apiCfg.StringVar(&renderCacheRecentStr, "render-cache-recent", "10min", "/render requests whose to is within this duration of now are considered recent, see render-cache-recent-ttl")
+	apiCfg.StringVar(&renderCacheRecentTTLStr, "render-cache-recent-ttl", "10s", "how long the outputs of recent /render requests are cached, as their data may still change")
+	apiCfg.StringVar(&renderCacheTTLStr, "render-cache-ttl", "1h", "how long the outputs of other /render requests are cached")
 	globalconf.Register("http", apiCfg)
 }
 
 func ConfigProcess() {
 	logMinDur = dur.MustParseDuration("log-min-dur", logMinDurStr)
+	maxReqTime = time.Duration(dur.MustParseDuration("max-req-time", maxReqTimeStr)) * time.Second
+	renderCacheAlign = dur.MustParseDuration("render-cache-align", renderCacheAlignStr)
+	renderCacheRecent = dur.MustParseDuration("render-cache-recent", renderCacheRecentStr)
+	renderCacheRecentTTL = time.Duration(dur.MustParseDuration("render-cache-recent-ttl", renderCacheRecentTTLStr)) * time.Second
+	renderCacheTTL = time.Duration(dur.MustParseDuration("render-cache-ttl", renderCacheTTLStr)) * time.Second
 
 	//validate the addr
 	_, err := net.ResolveTCPAddr("tcp", Addr)
@@ -78,4 +115,24 @@ func ConfigProcess() {
 			log.Fatal(4, "API Cannot load timezone %q: %s", timeZoneStr, err)
 		}
 	}
+
+	if orgLimitsFile != "" {
+		orgLimits, err = readOrgLimits(orgLimitsFile, globalLimits())
+		if err != nil {
+			log.Fatal(4, "API Cannot read org-limits-file %q: %s", orgLimitsFile, err)
+		}
+		log.Info("API loaded limits of %d orgs", len(orgLimits))
+	}
+
+	if userFuncsFile != "" {
+		fns, err := expr.ReadUserFuncs(userFuncsFile)
+		if err != nil {
+			log.Fatal(4, "API Cannot read user-functions-file %q: %s", userFuncsFile, err)
+		}
+		err = expr.RegisterUserFuncs(fns)
+		if err != nil {
+			log.Fatal(4, "API Cannot register user-defined functions: %s", err)
+		}
+		log.Info("API registered %d user-defined functions", len(fns))
+	}
 }
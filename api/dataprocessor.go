@@ -5,11 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"net/http"
 	"runtime"
 	"sync"
 	"time"
 
 	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/api/response"
+	"github.com/grafana/metrictank/cluster"
 	"github.com/grafana/metrictank/consolidation"
 	"github.com/grafana/metrictank/mdata"
 	"github.com/grafana/metrictank/mdata/chunk"
@@ -44,6 +47,16 @@ type limiter chan struct{}
 func (l limiter) enter() { l <- struct{}{} }
 func (l limiter) leave() { <-l }
 
+// enterCtx is like enter, but gives up when the context is canceled while waiting. it returns whether it entered.
+func (l limiter) enterCtx(ctx context.Context) bool {
+	select {
+	case l <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
 func newLimiter(l int) limiter {
 	return make(chan struct{}, l)
 }
@@ -200,7 +213,10 @@ func (s *Server) getTargets(ctx context.Context, reqs []models.Req) ([]models.Se
 
 // getTargetsRemote issues the requests on other nodes
 // it's nothing more than a thin network wrapper around getTargetsLocal of a peer.
+// the points fetched by peers count towards the limits of the request, peers enforce
+// the number of points that was left when they were asked.
 func (s *Server) getTargetsRemote(ctx context.Context, remoteReqs map[string][]models.Req) ([]models.Series, error) {
+	usage := queryUsageFrom(ctx)
 	responses := make(chan getTargetsResp, len(remoteReqs))
 	rCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -211,22 +227,44 @@ func (s *Server) getTargetsRemote(ctx context.Context, remoteReqs map[string][]m
 		go func(reqs []models.Req) {
 			defer wg.Done()
 			node := reqs[0].Node
-			buf, err := node.Post(rCtx, "getTargetsRemote", "/getdata", models.GetData{Requests: reqs})
+			getData := models.GetData{Requests: reqs}
+			if usage != nil {
+				getData.MaxPoints = usage.remainingPoints()
+			}
+			body, err := node.PostStream(rCtx, "getTargetsRemote", "/getdata", getData)
 			if err != nil {
 				cancel()
+				if cErr, ok := err.(*cluster.Error); ok && cErr.Code() == http.StatusRequestEntityTooLarge {
+					// the peer's response doesn't include the message, so we restore it
+					err = errMaxPointsFetchedPerReq
+				}
 				responses <- getTargetsResp{nil, err}
 				return
 			}
-			var resp models.GetDataResp
-			_, err = resp.UnmarshalMsg(buf)
+			// the series are decoded while they are received, so that we never hold the encoded
+			// response as a whole, and so that we can bail out as soon as the points limit is exceeded.
+			var series []models.Series
+			err = models.DecodeGetDataResp(body, func(serie models.Series) error {
+				if usage != nil {
+					if err := usage.addPoints(len(serie.Datapoints)); err != nil {
+						return err
+					}
+				}
+				series = append(series, serie)
+				return nil
+			})
+			body.Close()
 			if err != nil {
 				cancel()
-				log.Error(3, "DP getTargetsRemote: error unmarshaling body from %s/getdata: %q", node.GetName(), err)
+				_, isResponseErr := err.(response.Error)
+				if !isResponseErr && rCtx.Err() == nil {
+					log.Error(3, "DP getTargetsRemote: error unmarshaling body from %s/getdata: %q", node.GetName(), err)
+				}
 				responses <- getTargetsResp{nil, err}
 				return
 			}
-			log.Debug("DP getTargetsRemote: %s returned %d series", node.GetName(), len(resp.Series))
-			responses <- getTargetsResp{resp.Series, nil}
+			log.Debug("DP getTargetsRemote: %s returned %d series", node.GetName(), len(series))
+			responses <- getTargetsResp{series, nil}
 		}(nodeReqs)
 	}
 
@@ -257,24 +295,23 @@ func (s *Server) getTargetsLocal(ctx context.Context, reqs []models.Req) ([]mode
 
 	rCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
-LOOP:
-	for _, req := range reqs {
-		// check to see if the request has been canceled, if so abort now.
-		select {
-		case <-rCtx.Done():
-			//request canceled
-			break LOOP
-		default:
-		}
+	for i, req := range reqs {
 		// if there are already getDataConcurrency goroutines running, then block
-		// until a slot becomes free.
-		reqLimiter.enter()
+		// until a slot becomes free, unless the request is canceled, in which case we abort now.
+		if rCtx.Err() != nil || !reqLimiter.enterCtx(rCtx) {
+			getTargetCanceled.Add(len(reqs) - i)
+			break
+		}
 		wg.Add(1)
 		go func(req models.Req) {
 			rCtx, span := tracing.NewSpan(rCtx, s.Tracer, "getTargetsLocal")
 			req.Trace(span)
 			pre := time.Now()
 			points, interval, err := s.getTarget(rCtx, req)
+			if rCtx.Err() != nil {
+				// the points may be incomplete, but they will be discarded anyway
+				getTargetCanceled.Inc()
+			}
 			if err != nil {
 				tags.Error.Set(span, true)
 				cancel() // cancel all other requests.
@@ -415,6 +452,11 @@ func (s *Server) getSeriesFixed(ctx context.Context, req models.Req, consolidato
 	default:
 	}
 	res.Points = append(s.itersToPoints(rctx, res.Iters), res.Points...)
+	if usage := queryUsageFrom(ctx); usage != nil {
+		if err := usage.addPoints(len(res.Points)); err != nil {
+			return nil, err
+		}
+	}
 	return Fix(res.Points, req.From, req.To, req.ArchInterval), nil
 }
 
@@ -517,6 +559,8 @@ func (s *Server) getSeriesCachedStore(ctx *requestContext, until uint32) ([]chun
 		return iters, err
 	}
 	log.Debug("cache: result start %d, end %d", len(cacheRes.Start), len(cacheRes.End))
+	qStats := queryStatsFrom(ctx.ctx)
+	qStats.addCacheResult(cacheRes)
 
 	// check to see if the request has been canceled, if so abort now.
 	select {
@@ -553,6 +597,7 @@ func (s *Server) getSeriesCachedStore(ctx *requestContext, until uint32) ([]chun
 			if err != nil {
 				return iters, err
 			}
+			qStats.addStoreChunks(len(storeIterGens))
 			// check to see if the request has been canceled, if so abort now.
 			select {
 			case <-ctx.ctx.Done():
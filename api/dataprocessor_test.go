@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"reflect"
@@ -777,3 +778,36 @@ func BenchmarkDivide1M(b *testing.B) {
 	}
 	b.SetBytes(int64(l * 12))
 }
+
+func TestGetTargetsLocalCanceled(t *testing.T) {
+	store := mdata.NewMockStore()
+	mdata.SetSingleAgg(conf.Avg, conf.Min, conf.Max)
+	mdata.SetSingleSchema(conf.NewRetentionMT(10, 100, 600, 10, true))
+	metrics := mdata.NewAggMetrics(store, &cache.MockCache{}, false, 0, 0, 0)
+	srv, _ := NewServer()
+	srv.BindBackendStore(store)
+	srv.BindMemoryStore(metrics)
+
+	var reqs []models.Req
+	for i := 1; i <= 10; i++ {
+		id := test.GetMKey(i)
+		metrics.GetOrCreate(id, 0, 0).Add(20, 1)
+		req := models.NewReq(id, "", "", 10, 30, 1000, 10, consolidation.Avg, 0, cluster.Manager.ThisNode(), 0, 0)
+		req.ArchInterval = 10
+		reqs = append(reqs, req)
+	}
+
+	ctx, cancel := context.WithCancel(test.NewContext())
+	cancel()
+	pre := getTargetCanceled.Peek()
+	series, err := srv.getTargetsLocal(ctx, reqs)
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err)
+	}
+	if len(series) != 0 {
+		t.Fatalf("expected no series for a canceled request, got %d", len(series))
+	}
+	if canceled := getTargetCanceled.Peek() - pre; canceled != uint32(len(reqs)) {
+		t.Fatalf("expected %d canceled fetches, got %d", len(reqs), canceled)
+	}
+}
@@ -2,10 +2,11 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
-	"math"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -23,7 +24,6 @@ import (
 	"github.com/grafana/metrictank/mdata"
 	"github.com/grafana/metrictank/stats"
 	"github.com/grafana/metrictank/tracing"
-	"github.com/grafana/metrictank/util"
 	opentracing "github.com/opentracing/opentracing-go"
 	tags "github.com/opentracing/opentracing-go/ext"
 	"github.com/raintank/dur"
@@ -180,9 +180,13 @@ func (s *Server) renderMetrics(ctx *middleware.Context, request models.GraphiteR
 	span.SetTag("process", request.Process)
 
 	now := time.Now()
+	if reqCtx, qStats := withQueryStats(ctx.Req.Context(), now); qStats != nil {
+		ctx.Req = macaron.Request{ctx.Req.WithContext(reqCtx)}
+		defer qStats.logIfSlow("render", ctx.OrgId, strings.Join(request.Targets, "&"))
+	}
 	defaultFrom := uint32(now.Add(-time.Duration(24) * time.Hour).Unix())
 	defaultTo := uint32(now.Unix())
-	fromUnix, toUnix, err := getFromTo(request.FromTo, now, defaultFrom, defaultTo)
+	fromUnix, toUnix, loc, err := getFromTo(request.FromTo, now, defaultFrom, defaultTo)
 	if err != nil {
 		response.Write(ctx, response.NewError(http.StatusBadRequest, err.Error()))
 		return
@@ -192,6 +196,13 @@ func (s *Server) renderMetrics(ctx *middleware.Context, request models.GraphiteR
 		return
 	}
 
+	// with the render cache, requests for nearly the same time range (e.g. of dashboards that refresh periodically)
+	// are aligned to the same range, so they can be served from the same cache entry.
+	cacheable := false
+	if s.renderCache != nil {
+		fromUnix, toUnix, cacheable = s.renderCache.Align(fromUnix, toUnix)
+	}
+
 	span.SetTag("fromUnix", fromUnix)
 	span.SetTag("toUnix", toUnix)
 	span.SetTag("span", toUnix-fromUnix)
@@ -224,7 +235,7 @@ func (s *Server) renderMetrics(ctx *middleware.Context, request models.GraphiteR
 		// as graphite needs high-res data to perform its processing.
 		mdp = 0
 	}
-	plan, err := expr.NewPlan(exprs, fromUnix, toUnix, mdp, stable, nil)
+	plan, err := expr.NewPlan(exprs, fromUnix, toUnix, mdp, stable, loc, nil)
 	if err != nil {
 		if fun, ok := err.(expr.ErrUnknownFunction); ok {
 			if request.NoProxy {
@@ -247,6 +258,29 @@ func (s *Server) renderMetrics(ctx *middleware.Context, request models.GraphiteR
 		ctx.Error(http.StatusBadRequest, err.Error())
 		return
 	}
+	qStats := queryStatsFrom(ctx.Req.Context())
+	qStats.addStage("plan", time.Since(now))
+	qStats.setPlan(plan)
+
+	var cacheKey renderCacheKey
+	if cacheable {
+		cacheKey = renderCacheKey{
+			orgId: ctx.OrgId,
+			plan:  plan.Key(),
+			tz:    loc.String(),
+			from:  fromUnix,
+			to:    toUnix,
+			mdp:   mdp,
+		}
+		if out, ok := s.renderCache.Get(cacheKey, now); ok {
+			span.SetTag("render_cache", "hit")
+			preWrite := time.Now()
+			writeRenderResponse(ctx, request.Format, out)
+			qStats.addStage("write", time.Since(preWrite))
+			return
+		}
+		span.SetTag("render_cache", "miss")
+	}
 
 	newctx, span := tracing.NewSpan(ctx.Req.Context(), s.Tracer, "executePlan")
 	defer span.Finish()
@@ -281,23 +315,34 @@ func (s *Server) renderMetrics(ctx *middleware.Context, request models.GraphiteR
 		span.SetTag("nodatapoints", true)
 	}
 
-	switch request.Format {
+	if cacheable {
+		s.renderCache.Add(cacheKey, out, now)
+	}
+	preWrite := time.Now()
+	writeRenderResponse(ctx, request.Format, out)
+	qStats.addStage("write", time.Since(preWrite))
+	plan.Clean()
+}
+
+// writeRenderResponse writes the output of a /render request in the requested format.
+// except for msgpack, the output is written while it is encoded, rather than encoded in memory first.
+func writeRenderResponse(ctx *middleware.Context, format string, out []models.Series) {
+	switch format {
 	case "msgp":
-		response.Write(ctx, response.NewMsgp(200, models.SeriesByTarget(out)))
+		response.Write(ctx, response.NewMsgpStream(200, models.SeriesByTarget(out)))
 	case "msgpack":
 		response.Write(ctx, response.NewMsgpack(200, models.SeriesByTarget(out).ForGraphite("msgpack")))
 	case "pickle":
-		response.Write(ctx, response.NewPickle(200, models.SeriesByTarget(out)))
+		response.Write(ctx, response.NewPickleStream(200, models.SeriesByTarget(out)))
 	default:
-		response.Write(ctx, response.NewFastJson(200, models.SeriesByTarget(out)))
+		response.Write(ctx, response.NewFastJsonStream(200, models.SeriesByTarget(out)))
 	}
-	plan.Clean()
 }
 
 func (s *Server) metricsFind(ctx *middleware.Context, request models.GraphiteFind) {
 	now := time.Now()
 	var defaultFrom, defaultTo uint32
-	fromUnix, toUnix, err := getFromTo(request.FromTo, now, defaultFrom, defaultTo)
+	fromUnix, toUnix, _, err := getFromTo(request.FromTo, now, defaultFrom, defaultTo)
 	if err != nil {
 		response.Write(ctx, response.NewError(http.StatusBadRequest, err.Error()))
 		return
@@ -649,16 +694,81 @@ func (s *Server) metricsDeleteRemote(ctx context.Context, orgId uint32, query st
 // executePlan looks up the needed data, retrieves it, and then invokes the processing
 // note if you do something like sum(foo.*) and all of those metrics happen to be on another node,
 // we will collect all the indidividual series from the peer, and then sum here. that could be optimized
-func (s *Server) executePlan(ctx context.Context, orgId uint32, plan expr.Plan) ([]models.Series, error) {
+// the request is subject to the limits of the org, see limitsFor.
+func (s *Server) executePlan(ctx context.Context, orgId uint32, plan expr.Plan) (out []models.Series, err error) {
+	ctx, cancel := withQueryLimits(ctx, limitsFor(orgId))
+	defer func() {
+		if ctx.Err() == context.DeadlineExceeded {
+			out, err = nil, errMaxReqTime
+		}
+		cancel()
+	}()
+
+	data, err := s.fetchPlanData(ctx, orgId, plan.Reqs, plan.MaxDataPoints)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-ctx.Done():
+		//request canceled
+		return nil, nil
+	default:
+	}
+	if data == nil {
+		if len(plan.Reqs) > 0 {
+			// none of the requested series were found
+			return nil, nil
+		}
+		// the plan only has functions that generate their own series (e.g. constantLine),
+		// so we run it without any input.
+		data = make(map[expr.Req][]models.Series)
+	}
+
+	// some functions (e.g. applyByNode) can only determine which data they need once they have seen
+	// the data of their inputs. fetch their data in additional rounds, until they are all satisfied.
+	for {
+		dynReqs, err := plan.DynamicReqs(data)
+		if err != nil {
+			return nil, response.NewError(http.StatusBadRequest, err.Error())
+		}
+		if len(dynReqs) == 0 {
+			break
+		}
+		dynData, err := s.fetchPlanData(ctx, orgId, dynReqs, plan.MaxDataPoints)
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			//request canceled
+			return nil, nil
+		default:
+		}
+		for k, series := range dynData {
+			data[k] = append(data[k], series...)
+		}
+	}
+
+	preRun := time.Now()
+	out, err = plan.Run(data)
+	planRunDuration.Value(time.Since(preRun))
+	queryStatsFrom(ctx).addStage("run", time.Since(preRun))
+	return out, err
+}
+
+// fetchPlanData looks up the series for the given requests and retrieves their data,
+// which it returns keyed by the request they were fetched for.
+// if the request was canceled, or no series were found, it returns a nil map.
+func (s *Server) fetchPlanData(ctx context.Context, orgId uint32, planReqs []expr.Req, maxDataPoints uint32) (map[expr.Req][]models.Series, error) {
 
-	minFrom := uint32(math.MaxUint32)
-	var maxTo uint32
 	var reqs []models.Req
+	qStats := queryStatsFrom(ctx)
+	preFind := time.Now()
 
 	// note that different patterns to query can have different from / to, so they require different index lookups
 	// e.g. target=movingAvg(foo.*, "1h")&target=foo.*
 	// note that in this case we fetch foo.* twice. can be optimized later
-	for _, r := range plan.Reqs {
+	for _, r := range planReqs {
 		select {
 		case <-ctx.Done():
 			//request canceled
@@ -667,13 +777,12 @@ func (s *Server) executePlan(ctx context.Context, orgId uint32, plan expr.Plan)
 		}
 		var err error
 		var series []Series
-		const SeriesByTagIdent = "seriesByTag("
-		if strings.HasPrefix(r.Query, SeriesByTagIdent) {
-			startPos := len(SeriesByTagIdent)
-			endPos := strings.LastIndex(r.Query, ")")
-			exprs := strings.Split(r.Query[startPos:endPos], ",")
-			for i, e := range exprs {
-				exprs[i] = strings.Trim(e, " '\"")
+		numReqs := len(reqs)
+		if expr.IsSeriesByTag(r.Query) {
+			var exprs []string
+			exprs, err = expr.ParseSeriesByTag(r.Query)
+			if err != nil {
+				return nil, response.NewError(http.StatusBadRequest, err.Error())
 			}
 			series, err = s.clusterFindByTag(ctx, orgId, exprs, int64(r.From))
 		} else {
@@ -683,9 +792,6 @@ func (s *Server) executePlan(ctx context.Context, orgId uint32, plan expr.Plan)
 			return nil, err
 		}
 
-		minFrom = util.Min(minFrom, r.From)
-		maxTo = util.Max(maxTo, r.To)
-
 		for _, s := range series {
 			for _, metric := range s.Series {
 				for _, archive := range metric.Defs {
@@ -703,12 +809,14 @@ func (s *Server) executePlan(ctx context.Context, orgId uint32, plan expr.Plan)
 					}
 
 					newReq := models.NewReq(
-						archive.Id, archive.NameWithTags(), r.Query, r.From, r.To, plan.MaxDataPoints, uint32(archive.Interval), cons, consReq, s.Node, archive.SchemaId, archive.AggId)
+						archive.Id, archive.NameWithTags(), r.Query, r.From, r.To, maxDataPoints, uint32(archive.Interval), cons, consReq, s.Node, archive.SchemaId, archive.AggId)
 					reqs = append(reqs, newReq)
 				}
 			}
 		}
+		qStats.addSeries(r.Query, r.From, r.To, len(reqs)-numReqs)
 	}
+	qStats.addStage("find", time.Since(preFind))
 
 	select {
 	case <-ctx.Done():
@@ -721,9 +829,14 @@ func (s *Server) executePlan(ctx context.Context, orgId uint32, plan expr.Plan)
 	if len(reqs) == 0 {
 		return nil, nil
 	}
+	if usage := queryUsageFrom(ctx); usage != nil {
+		if err := usage.addSeries(len(reqs)); err != nil {
+			return nil, err
+		}
+	}
 
 	// note: if 1 series has a movingAvg that requires a long time range extension, it may push other reqs into another archive. can be optimized later
-	reqs, pointsFetch, pointsReturn, err := alignRequests(uint32(time.Now().Unix()), minFrom, maxTo, reqs)
+	reqs, pointsFetch, pointsReturn, err := alignRequests(uint32(time.Now().Unix()), reqs)
 	if err != nil {
 		log.Error(3, "HTTP Render alignReq error: %s", err)
 		return nil, err
@@ -739,7 +852,9 @@ func (s *Server) executePlan(ctx context.Context, orgId uint32, plan expr.Plan)
 		}
 	}
 
+	preFetch := time.Now()
 	out, err := s.getTargets(ctx, reqs)
+	qStats.addStage("fetch", time.Since(preFetch))
 	if err != nil {
 		log.Error(3, "HTTP Render %s", err.Error())
 		return nil, err
@@ -761,16 +876,14 @@ func (s *Server) executePlan(ctx context.Context, orgId uint32, plan expr.Plan)
 		sort.Sort(models.SeriesByTarget(data[k]))
 	}
 
-	preRun := time.Now()
-	out, err = plan.Run(data)
-	planRunDuration.Value(time.Since(preRun))
-	return out, err
+	return data, nil
 }
 
-func getFromTo(ft models.FromTo, now time.Time, defaultFrom, defaultTo uint32) (uint32, uint32, error) {
+// getFromTo returns the requested time range, and the timezone it was specified in
+func getFromTo(ft models.FromTo, now time.Time, defaultFrom, defaultTo uint32) (uint32, uint32, *time.Location, error) {
 	loc, err := getLocation(ft.Tz)
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, nil, err
 	}
 
 	from := ft.From
@@ -781,15 +894,15 @@ func getFromTo(ft models.FromTo, now time.Time, defaultFrom, defaultTo uint32) (
 
 	fromUnix, err := dur.ParseDateTime(from, loc, now, defaultFrom)
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, nil, err
 	}
 
 	toUnix, err := dur.ParseDateTime(to, loc, now, defaultTo)
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, nil, err
 	}
 
-	return fromUnix, toUnix, nil
+	return fromUnix, toUnix, loc, nil
 }
 
 func getLocation(desc string) (*time.Location, error) {
@@ -1073,9 +1186,48 @@ func (s *Server) clusterAutoCompleteTagValues(ctx context.Context, orgId uint32,
 	return vals, nil
 }
 
+// graphiteFunctions describes the functions that can be used in queries. those are graphite's,
+// so we get their descriptions from graphite, and add the user-defined functions to them.
 func (s *Server) graphiteFunctions(ctx *middleware.Context) {
 	ctx.Req.Request.Body = ctx.Body
-	graphiteProxy.ServeHTTP(ctx.Resp, ctx.Req.Request)
+	userFuncs := expr.UserFuncDescriptions()
+	if len(userFuncs) == 0 {
+		graphiteProxy.ServeHTTP(ctx.Resp, ctx.Req.Request)
+		return
+	}
+	jsonp := ctx.Query("jsonp")
+	if name := ctx.Params(":func"); name != "" {
+		if desc, ok := userFuncs[name]; ok {
+			response.Write(ctx, response.NewJson(200, desc, jsonp))
+			return
+		}
+		graphiteProxy.ServeHTTP(ctx.Resp, ctx.Req.Request)
+		return
+	}
+
+	buf, err := proxyBuffered(ctx.Req.Request, "jsonp")
+	if err != nil {
+		response.Write(ctx, response.NewError(http.StatusBadGateway, err.Error()))
+		return
+	}
+	if buf.code != http.StatusOK {
+		buf.writeTo(ctx.Resp)
+		return
+	}
+	var descs map[string]interface{}
+	err = json.Unmarshal(buf.body.Bytes(), &descs)
+	if err != nil {
+		response.Write(ctx, response.NewError(http.StatusBadGateway, "failed to decode the functions of graphite: "+err.Error()))
+		return
+	}
+	if grouped, _ := strconv.ParseBool(ctx.Query("grouped")); grouped {
+		descs[expr.UserFuncGroup] = userFuncs
+	} else {
+		for name, desc := range userFuncs {
+			descs[name] = desc
+		}
+	}
+	response.Write(ctx, response.NewJson(200, descs, jsonp))
 }
 
 func (s *Server) graphiteTagDelSeries(ctx *middleware.Context, request models.GraphiteTagDelSeries) {
@@ -1127,7 +1279,7 @@ func (s *Server) showPlan(ctx *middleware.Context, request models.GraphiteRender
 	now := time.Now()
 	defaultFrom := uint32(now.Add(-time.Duration(24) * time.Hour).Unix())
 	defaultTo := uint32(now.Unix())
-	fromUnix, toUnix, err := getFromTo(request.FromTo, now, defaultFrom, defaultTo)
+	fromUnix, toUnix, loc, err := getFromTo(request.FromTo, now, defaultFrom, defaultTo)
 	if err != nil {
 		response.Write(ctx, response.NewError(http.StatusBadRequest, err.Error()))
 		return
@@ -1152,7 +1304,7 @@ func (s *Server) showPlan(ctx *middleware.Context, request models.GraphiteRender
 	stable := request.Process == "stable"
 	mdp := request.MaxDataPoints
 
-	plan, err := expr.NewPlan(exprs, fromUnix, toUnix, mdp, stable, nil)
+	plan, err := expr.NewPlan(exprs, fromUnix, toUnix, mdp, stable, loc, nil)
 	if err != nil {
 		response.Write(ctx, response.NewError(http.StatusBadRequest, err.Error()))
 		return
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"bytes"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
@@ -48,3 +49,58 @@ func NewGraphiteProxy(u *url.URL) *httputil.ReverseProxy {
 	}
 	return graphiteProxy
 }
+
+// proxyBuffer is a http.ResponseWriter that holds on to the response of graphite, so it can be altered before it is returned
+type proxyBuffer struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (b *proxyBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *proxyBuffer) Write(buf []byte) (int, error) {
+	return b.body.Write(buf)
+}
+
+func (b *proxyBuffer) WriteHeader(code int) {
+	b.code = code
+}
+
+func (b *proxyBuffer) writeTo(w http.ResponseWriter) {
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(b.code)
+	w.Write(b.body.Bytes())
+}
+
+// proxyBuffered proxies the request to graphite, without the given query parameters, and returns its uncompressed response
+func proxyBuffered(req *http.Request, dropParams ...string) (*proxyBuffer, error) {
+	u := *req.URL
+	query := u.Query()
+	for _, p := range dropParams {
+		query.Del(p)
+	}
+	u.RawQuery = query.Encode()
+
+	r := req.WithContext(req.Context())
+	r.URL = &u
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Del("Accept-Encoding")
+
+	buf := &proxyBuffer{
+		header: make(http.Header),
+		code:   http.StatusOK,
+	}
+	graphiteProxy.ServeHTTP(buf, r)
+	if buf.code == http.StatusBadGateway {
+		return nil, fmt.Errorf("could not reach graphite")
+	}
+	return buf, nil
+}
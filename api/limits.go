@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/alyu/configparser"
+	"github.com/grafana/metrictank/api/response"
+	"github.com/raintank/dur"
+)
+
+var (
+	errMaxSeriesPerReq        = response.NewError(413, "request exceeds max-series-per-req limit. Reduce the number of series matched by the targets or ask your admin to increase the limit.")
+	errMaxPointsFetchedPerReq = response.NewError(413, "request exceeds max-points-fetched-per-req limit. Reduce the time range or number of targets or ask your admin to increase the limit.")
+	errMaxReqTime             = response.NewError(413, "request exceeds max-req-time limit. Reduce the time range or number of targets or ask your admin to increase the limit.")
+)
+
+// queryLimits are the limits on the resources a single render request may use. 0 means unlimited.
+type queryLimits struct {
+	MaxSeries uint64        // number of series matched by the targets
+	MaxPoints uint64        // number of points decoded, across all nodes
+	MaxTime   time.Duration // wall time to fetch and process the data
+}
+
+// orgLimits holds the limits of the orgs that override the global limits, by org id
+var orgLimits map[uint32]queryLimits
+
+// globalLimits returns the limits configured in the http section
+func globalLimits() queryLimits {
+	return queryLimits{
+		MaxSeries: uint64(maxSeriesPerReq),
+		MaxPoints: uint64(maxPointsFetchedPerReq),
+		MaxTime:   maxReqTime,
+	}
+}
+
+// limitsFor returns the limits that apply to the requests of the given org
+func limitsFor(orgId uint32) queryLimits {
+	if limits, ok := orgLimits[orgId]; ok {
+		return limits
+	}
+	return globalLimits()
+}
+
+// readOrgLimits reads the per-org limits from the given file. each section overrides the limits of one org:
+//
+//	[42]
+//	max-series-per-req = 100000
+//	max-points-fetched-per-req = 50000000
+//	max-req-time = 30s
+//
+// limits that are not specified in a section are the global ones.
+func readOrgLimits(file string, global queryLimits) (map[uint32]queryLimits, error) {
+	config, err := configparser.Read(file)
+	if err != nil {
+		return nil, err
+	}
+	sections, err := config.AllSections()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[uint32]queryLimits)
+	for _, sec := range sections {
+		name := strings.Trim(strings.SplitN(sec.String(), "\n", 2)[0], " []")
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+		orgId, err := strconv.ParseUint(name, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("[%s]: section name must be an org id", name)
+		}
+		limits := global
+		for key, val := range sec.Options() {
+			if key == "" || strings.HasPrefix(key, "#") || strings.HasPrefix(key, ";") {
+				continue // blank lines and comments
+			}
+			switch key {
+			case "max-series-per-req":
+				limits.MaxSeries, err = strconv.ParseUint(val, 10, 64)
+			case "max-points-fetched-per-req":
+				limits.MaxPoints, err = strconv.ParseUint(val, 10, 64)
+			case "max-req-time":
+				var secs uint32
+				secs, err = dur.ParseDuration(val)
+				limits.MaxTime = time.Duration(secs) * time.Second
+			default:
+				err = fmt.Errorf("unknown option %q", key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("[%s]: %s: %s", name, key, err)
+			}
+		}
+		out[uint32(orgId)] = limits
+	}
+	return out, nil
+}
+
+// queryUsage tracks the resources used by a request, so they can be checked against its limits.
+// it is shared by all goroutines working on the request.
+type queryUsage struct {
+	limits queryLimits
+	series uint64 // accessed atomically
+	points uint64 // accessed atomically
+}
+
+func newQueryUsage(limits queryLimits) *queryUsage {
+	return &queryUsage{limits: limits}
+}
+
+// addSeries accounts for n more series, and returns an error if that exceeds the limit
+func (u *queryUsage) addSeries(n int) error {
+	series := atomic.AddUint64(&u.series, uint64(n))
+	if u.limits.MaxSeries > 0 && series > u.limits.MaxSeries {
+		return errMaxSeriesPerReq
+	}
+	return nil
+}
+
+// addPoints accounts for n more points, and returns an error if that exceeds the limit
+func (u *queryUsage) addPoints(n int) error {
+	points := atomic.AddUint64(&u.points, uint64(n))
+	if u.limits.MaxPoints > 0 && points > u.limits.MaxPoints {
+		return errMaxPointsFetchedPerReq
+	}
+	return nil
+}
+
+// remainingPoints returns how many more points may be fetched, or 0 if unlimited.
+// this is the limit a peer should enforce on its own part of the request.
+func (u *queryUsage) remainingPoints() uint64 {
+	if u.limits.MaxPoints == 0 {
+		return 0
+	}
+	points := atomic.LoadUint64(&u.points)
+	if points >= u.limits.MaxPoints {
+		return 1 // not 0, which would mean unlimited
+	}
+	return u.limits.MaxPoints - points
+}
+
+type queryUsageKey struct{}
+
+// withQueryLimits returns a context that tracks the usage of the request against the given limits,
+// and that is canceled once the time limit passes.
+func withQueryLimits(ctx context.Context, limits queryLimits) (context.Context, context.CancelFunc) {
+	ctx = context.WithValue(ctx, queryUsageKey{}, newQueryUsage(limits))
+	if limits.MaxTime > 0 {
+		return context.WithTimeout(ctx, limits.MaxTime)
+	}
+	return context.WithCancel(ctx)
+}
+
+// queryUsageFrom returns the usage tracked in the given context, or nil if the request is not limited
+func queryUsageFrom(ctx context.Context) *queryUsage {
+	u, _ := ctx.Value(queryUsageKey{}).(*queryUsage)
+	return u
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/consolidation"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/mdata/cache"
+	"github.com/grafana/metrictank/test"
+)
+
+func TestQueryUsage(t *testing.T) {
+	u := newQueryUsage(queryLimits{MaxSeries: 10, MaxPoints: 100})
+	if err := u.addSeries(6); err != nil {
+		t.Fatalf("expected no error adding 6 series, got %q", err)
+	}
+	if err := u.addSeries(4); err != nil {
+		t.Fatalf("expected no error adding 4 more series, got %q", err)
+	}
+	if err := u.addSeries(1); err != errMaxSeriesPerReq {
+		t.Fatalf("expected errMaxSeriesPerReq adding an 11th series, got %v", err)
+	}
+
+	if err := u.addPoints(60); err != nil {
+		t.Fatalf("expected no error adding 60 points, got %q", err)
+	}
+	if rem := u.remainingPoints(); rem != 40 {
+		t.Fatalf("expected 40 remaining points, got %d", rem)
+	}
+	if err := u.addPoints(41); err != errMaxPointsFetchedPerReq {
+		t.Fatalf("expected errMaxPointsFetchedPerReq adding 41 more points, got %v", err)
+	}
+	if rem := u.remainingPoints(); rem != 1 {
+		t.Fatalf("expected 1 remaining point once the limit is exceeded, got %d", rem)
+	}
+
+	unlimited := newQueryUsage(queryLimits{})
+	if err := unlimited.addSeries(1000000); err != nil {
+		t.Fatalf("expected no error without series limit, got %q", err)
+	}
+	if err := unlimited.addPoints(1000000000); err != nil {
+		t.Fatalf("expected no error without points limit, got %q", err)
+	}
+	if rem := unlimited.remainingPoints(); rem != 0 {
+		t.Fatalf("expected 0 (unlimited) remaining points without points limit, got %d", rem)
+	}
+}
+
+func TestWithQueryLimits(t *testing.T) {
+	if queryUsageFrom(context.Background()) != nil {
+		t.Fatalf("expected no usage in a context without limits")
+	}
+
+	ctx, cancel := withQueryLimits(context.Background(), queryLimits{MaxTime: time.Millisecond})
+	defer cancel()
+	if queryUsageFrom(ctx) == nil {
+		t.Fatalf("expected usage in a context with limits")
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("expected context to be done after the time limit")
+	}
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", ctx.Err())
+	}
+
+	ctx, cancel = withQueryLimits(context.Background(), queryLimits{})
+	cancel()
+	if ctx.Err() != context.Canceled {
+		t.Fatalf("expected context.Canceled without time limit, got %v", ctx.Err())
+	}
+}
+
+func TestReadOrgLimits(t *testing.T) {
+	file, err := ioutil.TempFile("", "orglimits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`
+[1]
+max-series-per-req = 100
+max-req-time = 1min
+
+[2]
+max-points-fetched-per-req = 0
+`)
+	file.Close()
+
+	global := queryLimits{MaxSeries: 10, MaxPoints: 1000, MaxTime: 10 * time.Second}
+	limits, err := readOrgLimits(file.Name(), global)
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err)
+	}
+	exp := map[uint32]queryLimits{
+		1: {MaxSeries: 100, MaxPoints: 1000, MaxTime: time.Minute},
+		2: {MaxSeries: 10, MaxPoints: 0, MaxTime: 10 * time.Second},
+	}
+	if len(limits) != len(exp) {
+		t.Fatalf("expected limits of %d orgs, got %d: %v", len(exp), len(limits), limits)
+	}
+	for org, e := range exp {
+		if limits[org] != e {
+			t.Fatalf("org %d: expected %+v, got %+v", org, e, limits[org])
+		}
+	}
+}
+
+func TestReadOrgLimitsInvalid(t *testing.T) {
+	cases := []string{
+		"[foo]\nmax-series-per-req = 100\n",
+		"[1]\nmax-series = 100\n",
+		"[1]\nmax-series-per-req = many\n",
+		"[1]\nmax-req-time = 1fortnight\n",
+	}
+	for i, c := range cases {
+		file, err := ioutil.TempFile("", "orglimits")
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.WriteString(c)
+		file.Close()
+		_, err = readOrgLimits(file.Name(), queryLimits{})
+		os.Remove(file.Name())
+		if err == nil {
+			t.Fatalf("case %d: expected error reading %q", i, c)
+		}
+	}
+}
+
+func TestGetSeriesFixedMaxPoints(t *testing.T) {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	store := mdata.NewMockStore()
+	store.Drop = true
+
+	mdata.SetSingleAgg(conf.Avg, conf.Min, conf.Max)
+	mdata.SetSingleSchema(conf.NewRetentionMT(10, 100, 600, 10, true))
+
+	metrics := mdata.NewAggMetrics(store, &cache.MockCache{}, false, 0, 0, 0)
+	srv, _ := NewServer()
+	srv.BindBackendStore(store)
+	srv.BindMemoryStore(metrics)
+
+	id := test.GetMKey(1)
+	metric := metrics.GetOrCreate(id, 0, 0)
+	for ts := uint32(10); ts <= 100; ts += 10 {
+		metric.Add(ts, float64(ts))
+	}
+	req := models.NewReq(id, "", "", 10, 101, 1000, 10, consolidation.Avg, 0, cluster.Manager.ThisNode(), 0, 0)
+	req.ArchInterval = 10
+
+	ctx, cancel := withQueryLimits(test.NewContext(), queryLimits{MaxPoints: 15})
+	defer cancel()
+	points, err := srv.getSeriesFixed(ctx, req, consolidation.None)
+	if err != nil {
+		t.Fatalf("expected no error fetching 10 points with a limit of 15, got %q", err)
+	}
+	if len(points) != 10 {
+		t.Fatalf("expected 10 points, got %d", len(points))
+	}
+	_, err = srv.getSeriesFixed(ctx, req, consolidation.None)
+	if err != errMaxPointsFetchedPerReq {
+		t.Fatalf("expected errMaxPointsFetchedPerReq fetching 20 points with a limit of 15, got %v", err)
+	}
+}
@@ -1,7 +1,10 @@
 package models
 
 import (
+	"io"
+
 	"github.com/grafana/metrictank/idx"
+	"github.com/tinylib/msgp/msgp"
 )
 
 //go:generate msgp
@@ -24,6 +27,51 @@ type GetDataResp struct {
 	Series []Series
 }
 
+// WriteMsg writes the same output as MarshalMsg to w, one series at a time,
+// so that the output as a whole never needs to be in memory. b is used as buffer and returned for reuse.
+func (z *GetDataResp) WriteMsg(w io.Writer, b []byte) ([]byte, error) {
+	b = msgp.AppendMapHeader(b, 1)
+	b = msgp.AppendString(b, "Series")
+	b = msgp.AppendArrayHeader(b, uint32(len(z.Series)))
+	return writeSeriesMsg(w, b, z.Series)
+}
+
+// DecodeGetDataResp decodes a msgp encoded GetDataResp from r, calling fn with every series as soon as it
+// has been decoded, rather than collecting them all. decoding stops at the first error returned by fn.
+func DecodeGetDataResp(r io.Reader, fn func(Series) error) error {
+	dc := msgp.NewReader(r)
+	fields, err := dc.ReadMapHeader()
+	if err != nil {
+		return err
+	}
+	for ; fields > 0; fields-- {
+		field, err := dc.ReadMapKeyPtr()
+		if err != nil {
+			return err
+		}
+		if string(field) != "Series" {
+			if err = dc.Skip(); err != nil {
+				return err
+			}
+			continue
+		}
+		num, err := dc.ReadArrayHeader()
+		if err != nil {
+			return err
+		}
+		for ; num > 0; num-- {
+			var s Series
+			if err = s.DecodeMsg(dc); err != nil {
+				return err
+			}
+			if err = fn(s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 type MetricsDeleteResp struct {
 	DeletedDefs int `json:"deletedDefs"`
 }
@@ -156,7 +156,8 @@ func (i IndexFind) TraceDebug(span opentracing.Span) {
 }
 
 type GetData struct {
-	Requests []Req `json:"requests" binding:"Required"`
+	Requests  []Req  `json:"requests" binding:"Required"`
+	MaxPoints uint64 `json:"maxPoints"` // max number of points to fetch for all requests. 0 means unlimited
 }
 
 func (g GetData) Trace(span opentracing.Span) {
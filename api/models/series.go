@@ -2,12 +2,14 @@ package models
 
 import (
 	"bytes"
+	"io"
 	"math"
 	"strconv"
 	"strings"
 
 	"github.com/grafana/metrictank/consolidation"
 	pickle "github.com/kisielk/og-rek"
+	"github.com/tinylib/msgp/msgp"
 	"gopkg.in/raintank/schema.v1"
 )
 
@@ -50,42 +52,98 @@ func (g SeriesByTarget) Less(i, j int) bool { return g[i].Target < g[j].Target }
 // regular graphite output
 func (series SeriesByTarget) MarshalJSONFast(b []byte) ([]byte, error) {
 	b = append(b, '[')
-	for _, s := range series {
-		b = append(b, `{"target":`...)
-		b = strconv.AppendQuoteToASCII(b, s.Target)
-		if len(s.Tags) != 0 {
-			b = append(b, `,"tags":{`...)
-			for name, value := range s.Tags {
-				b = strconv.AppendQuoteToASCII(b, name)
-				b = append(b, ':')
-				b = strconv.AppendQuoteToASCII(b, value)
-				b = append(b, ',')
-			}
-			// Replace trailing comma with a closing bracket
-			b[len(b)-1] = '}'
+	for i, s := range series {
+		if i > 0 {
+			b = append(b, ',')
 		}
-		b = append(b, `,"datapoints":[`...)
-		for _, p := range s.Datapoints {
-			b = append(b, '[')
-			if math.IsNaN(p.Val) {
-				b = append(b, `null,`...)
-			} else {
-				b = strconv.AppendFloat(b, p.Val, 'f', -1, 64)
-				b = append(b, ',')
-			}
-			b = strconv.AppendUint(b, uint64(p.Ts), 10)
-			b = append(b, `],`...)
+		b = appendJSONFast(b, s)
+	}
+	b = append(b, ']')
+	return b, nil
+}
+
+// WriteJSONFast writes the same output as MarshalJSONFast to w, one series at a time,
+// so that the output as a whole never needs to be in memory. b is used as buffer and returned for reuse.
+func (series SeriesByTarget) WriteJSONFast(w io.Writer, b []byte) ([]byte, error) {
+	var err error
+	b = append(b, '[')
+	for i, s := range series {
+		if i > 0 {
+			b = append(b, ',')
 		}
-		if len(s.Datapoints) != 0 {
-			b = b[:len(b)-1] // cut last comma
+		b = appendJSONFast(b, s)
+		if b, err = flushStream(w, b, false); err != nil {
+			return b, err
 		}
-		b = append(b, `]},`...)
 	}
-	if len(series) != 0 {
+	b = append(b, ']')
+	return flushStream(w, b, true)
+}
+
+func appendJSONFast(b []byte, s Series) []byte {
+	b = append(b, `{"target":`...)
+	b = strconv.AppendQuoteToASCII(b, s.Target)
+	if len(s.Tags) != 0 {
+		b = append(b, `,"tags":{`...)
+		for name, value := range s.Tags {
+			b = strconv.AppendQuoteToASCII(b, name)
+			b = append(b, ':')
+			b = strconv.AppendQuoteToASCII(b, value)
+			b = append(b, ',')
+		}
+		// Replace trailing comma with a closing bracket
+		b[len(b)-1] = '}'
+	}
+	b = append(b, `,"datapoints":[`...)
+	for _, p := range s.Datapoints {
+		b = append(b, '[')
+		if math.IsNaN(p.Val) {
+			b = append(b, `null,`...)
+		} else {
+			b = strconv.AppendFloat(b, p.Val, 'f', -1, 64)
+			b = append(b, ',')
+		}
+		b = strconv.AppendUint(b, uint64(p.Ts), 10)
+		b = append(b, `],`...)
+	}
+	if len(s.Datapoints) != 0 {
 		b = b[:len(b)-1] // cut last comma
 	}
-	b = append(b, ']')
-	return b, nil
+	b = append(b, `]}`...)
+	return b
+}
+
+// WriteMsg writes the same output as MarshalMsg to w, one series at a time,
+// so that the output as a whole never needs to be in memory. b is used as buffer and returned for reuse.
+func (series SeriesByTarget) WriteMsg(w io.Writer, b []byte) ([]byte, error) {
+	b = msgp.AppendArrayHeader(b, uint32(len(series)))
+	return writeSeriesMsg(w, b, series)
+}
+
+func writeSeriesMsg(w io.Writer, b []byte, series []Series) ([]byte, error) {
+	var err error
+	for _, s := range series {
+		if b, err = s.MarshalMsg(b); err != nil {
+			return b, err
+		}
+		if b, err = flushStream(w, b, false); err != nil {
+			return b, err
+		}
+	}
+	return flushStream(w, b, true)
+}
+
+// streamFlushSize is the size of the buffer at which the Write* methods write it out
+const streamFlushSize = 64 * 1024
+
+// flushStream writes b to w if it reached streamFlushSize, or if force is set.
+// it returns the buffer to continue with.
+func flushStream(w io.Writer, b []byte, force bool) ([]byte, error) {
+	if !force && len(b) < streamFlushSize {
+		return b, nil
+	}
+	_, err := w.Write(b)
+	return b[:0], err
 }
 
 func (series SeriesByTarget) MarshalJSON() ([]byte, error) {
@@ -99,28 +157,33 @@ func (series SeriesByTarget) ForGraphite(format string) SeriesListForPickle {
 	}
 	data := make(SeriesListForPickle, len(series))
 	for i, s := range series {
-		datapoints := make([]interface{}, len(s.Datapoints))
-		for j, p := range s.Datapoints {
-			if math.IsNaN(p.Val) {
-				datapoints[j] = none
-			} else {
-				datapoints[j] = p.Val
-			}
-		}
-		data[i] = SeriesForPickle{
-			Name:           s.Target,
-			Step:           s.Interval,
-			Values:         datapoints,
-			PathExpression: s.QueryPatt,
-		}
-		if len(datapoints) > 0 {
-			data[i].Start = s.Datapoints[0].Ts
-			data[i].End = s.Datapoints[len(s.Datapoints)-1].Ts + s.Interval
+		data[i] = forGraphite(s, none)
+	}
+	return data
+}
+
+func forGraphite(s Series, none interface{}) SeriesForPickle {
+	datapoints := make([]interface{}, len(s.Datapoints))
+	for j, p := range s.Datapoints {
+		if math.IsNaN(p.Val) {
+			datapoints[j] = none
 		} else {
-			data[i].Start = s.QueryFrom
-			data[i].End = s.QueryTo
+			datapoints[j] = p.Val
 		}
 	}
+	data := SeriesForPickle{
+		Name:           s.Target,
+		Step:           s.Interval,
+		Values:         datapoints,
+		PathExpression: s.QueryPatt,
+	}
+	if len(datapoints) > 0 {
+		data.Start = s.Datapoints[0].Ts
+		data.End = s.Datapoints[len(s.Datapoints)-1].Ts + s.Interval
+	} else {
+		data.Start = s.QueryFrom
+		data.End = s.QueryTo
+	}
 	return data
 }
 
@@ -131,6 +194,27 @@ func (series SeriesByTarget) Pickle(buf []byte) ([]byte, error) {
 	return buffer.Bytes(), err
 }
 
+// WritePickle writes the same output as Pickle to w, one series at a time,
+// so that the output as a whole never needs to be in memory. b is used as buffer and returned for reuse.
+// it encodes the list itself, so that it can encode its items one by one.
+func (series SeriesByTarget) WritePickle(w io.Writer, b []byte) ([]byte, error) {
+	var err error
+	b = append(b, ']', '(') // empty list, mark
+	for _, s := range series {
+		buffer := bytes.NewBuffer(b)
+		if err = pickle.NewEncoder(buffer).Encode(forGraphite(s, pickle.None{})); err != nil {
+			return buffer.Bytes(), err
+		}
+		b = buffer.Bytes()
+		b = b[:len(b)-1] // cut the stop opcode: the series is only an item of the list
+		if b, err = flushStream(w, b, false); err != nil {
+			return b, err
+		}
+	}
+	b = append(b, 'e', '.') // append the items since the mark, stop
+	return flushStream(w, b, true)
+}
+
 type SeriesListForPickle []SeriesForPickle
 
 type SeriesForPickle struct {
@@ -1,9 +1,14 @@
 package models
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
 	"testing"
 
+	pickle "github.com/kisielk/og-rek"
 	"gopkg.in/raintank/schema.v1"
 )
 
@@ -90,3 +95,159 @@ func TestJsonMarshal(t *testing.T) {
 		}
 	}
 }
+
+func streamTestSeries() [][]Series {
+	large := Series{
+		Target:     "large",
+		Datapoints: make([]schema.Point, 10000),
+		Interval:   10,
+		QueryPatt:  "large",
+	}
+	for i := range large.Datapoints {
+		large.Datapoints[i] = schema.Point{Val: float64(i) / 3, Ts: uint32(10 * (i + 1))}
+	}
+	large.Datapoints[5].Val = math.NaN()
+	return [][]Series{
+		{},
+		{
+			{
+				Target:     "a",
+				Datapoints: []schema.Point{},
+				Interval:   60,
+				QueryFrom:  60,
+				QueryTo:    120,
+			},
+		},
+		{
+			{
+				Target:     "a;foo=bar",
+				Tags:       map[string]string{"foo": "bar"}, // a single tag, as the encoding order of tags is random
+				Datapoints: []schema.Point{{Val: 1, Ts: 60}, {Val: math.NaN(), Ts: 120}},
+				Interval:   60,
+			},
+			large,
+			large,
+			large,
+		},
+	}
+}
+
+// countingWriter counts the writes, to check the output is actually streamed
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestWriteJSONFast(t *testing.T) {
+	for i, in := range streamTestSeries() {
+		exp, _ := SeriesByTarget(in).MarshalJSONFast(nil)
+		var w countingWriter
+		_, err := SeriesByTarget(in).WriteJSONFast(&w, nil)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error %q", i, err)
+		}
+		if !bytes.Equal(exp, w.Bytes()) {
+			t.Fatalf("case %d: output differs from MarshalJSONFast.\nexpected:%s\ngot:     %s", i, exp, w.Bytes())
+		}
+		if len(exp) > 2*streamFlushSize && w.writes < 2 {
+			t.Fatalf("case %d: expected %d bytes to be written in multiple writes, got %d", i, len(exp), w.writes)
+		}
+	}
+}
+
+func TestWriteMsg(t *testing.T) {
+	for i, in := range streamTestSeries() {
+		exp, _ := SeriesByTarget(in).MarshalMsg(nil)
+		var w countingWriter
+		_, err := SeriesByTarget(in).WriteMsg(&w, nil)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error %q", i, err)
+		}
+		if !bytes.Equal(exp, w.Bytes()) {
+			t.Fatalf("case %d: output differs from MarshalMsg", i)
+		}
+		if len(exp) > 2*streamFlushSize && w.writes < 2 {
+			t.Fatalf("case %d: expected %d bytes to be written in multiple writes, got %d", i, len(exp), w.writes)
+		}
+	}
+}
+
+func TestWritePickle(t *testing.T) {
+	for i, in := range streamTestSeries() {
+		exp, _ := SeriesByTarget(in).Pickle(nil)
+		var w countingWriter
+		_, err := SeriesByTarget(in).WritePickle(&w, nil)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error %q", i, err)
+		}
+		// the order in which the fields of the series are encoded is random, so we compare the decoded outputs
+		expDecoded, err := pickle.NewDecoder(bytes.NewReader(exp)).Decode()
+		if err != nil {
+			t.Fatalf("case %d: unexpected error decoding Pickle output %q", i, err)
+		}
+		gotDecoded, err := pickle.NewDecoder(bytes.NewReader(w.Bytes())).Decode()
+		if err != nil {
+			t.Fatalf("case %d: unexpected error decoding WritePickle output %q", i, err)
+		}
+		if !reflect.DeepEqual(expDecoded, gotDecoded) {
+			t.Fatalf("case %d: output differs from Pickle", i)
+		}
+		if len(exp) > 2*streamFlushSize && w.writes < 2 {
+			t.Fatalf("case %d: expected %d bytes to be written in multiple writes, got %d", i, len(exp), w.writes)
+		}
+	}
+}
+
+func TestDecodeGetDataResp(t *testing.T) {
+	for i, in := range streamTestSeries() {
+		resp := GetDataResp{Series: in}
+		var w bytes.Buffer
+		_, err := resp.WriteMsg(&w, nil)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error %q", i, err)
+		}
+		exp, _ := resp.MarshalMsg(nil)
+		if !bytes.Equal(exp, w.Bytes()) {
+			t.Fatalf("case %d: output differs from MarshalMsg", i)
+		}
+
+		var got []Series
+		err = DecodeGetDataResp(bytes.NewReader(exp), func(s Series) error {
+			got = append(got, s)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("case %d: unexpected error decoding %q", i, err)
+		}
+		if len(got) != len(in) {
+			t.Fatalf("case %d: expected %d series, got %d", i, len(in), len(got))
+		}
+		for j := range in {
+			if got[j].Target != in[j].Target || len(got[j].Datapoints) != len(in[j].Datapoints) {
+				t.Fatalf("case %d: series %d: expected %s with %d points, got %s with %d points", i, j, in[j].Target, len(in[j].Datapoints), got[j].Target, len(got[j].Datapoints))
+			}
+		}
+	}
+}
+
+func TestDecodeGetDataRespStops(t *testing.T) {
+	in := streamTestSeries()[2]
+	buf, _ := (&GetDataResp{Series: in}).MarshalMsg(nil)
+	errStop := errors.New("stop")
+	var calls int
+	err := DecodeGetDataResp(bytes.NewReader(buf), func(s Series) error {
+		calls++
+		return errStop
+	})
+	if err != errStop {
+		t.Fatalf("expected the error of fn, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected decoding to stop after the first series, fn was called %d times", calls)
+	}
+}
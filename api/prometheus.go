@@ -112,6 +112,7 @@ func (s *Server) prometheusLabelValues(ctx *middleware.Context) {
 }
 
 func (s *Server) prometheusQueryRange(ctx *middleware.Context, request models.PrometheusRangeQuery) {
+	pre := time.Now()
 	start, err := parseTime(request.Start)
 	if err != nil {
 		response.Write(ctx, promQueryResultBadData(fmt.Errorf("invalid start time: %v", err)))
@@ -143,6 +144,8 @@ func (s *Server) prometheusQueryRange(ctx *middleware.Context, request models.Pr
 	}
 
 	newCtx := context.WithValue(ctx.Req.Context(), orgID("org-id"), ctx.OrgId)
+	newCtx, qStats := withQueryStats(newCtx, pre)
+	defer qStats.logIfSlow("prometheus_query_range", ctx.OrgId, request.Query)
 	res := qry.Exec(newCtx)
 
 	if res.Err != nil {
@@ -173,6 +176,7 @@ func (s *Server) prometheusQueryRange(ctx *middleware.Context, request models.Pr
 }
 
 func (s *Server) prometheusQueryInstant(ctx *middleware.Context, request models.PrometheusQueryInstant) {
+	pre := time.Now()
 	ts, err := parseTime(request.Time)
 	if err != nil {
 		response.Write(ctx, promQueryResultBadData(fmt.Errorf("invalid ts time: %v", err)))
@@ -187,6 +191,8 @@ func (s *Server) prometheusQueryInstant(ctx *middleware.Context, request models.
 	}
 
 	newCtx := context.WithValue(ctx.Req.Context(), orgID("org-id"), ctx.OrgId)
+	newCtx, qStats := withQueryStats(newCtx, pre)
+	defer qStats.logIfSlow("prometheus_query", ctx.OrgId, request.Query)
 	res := qry.Exec(newCtx)
 
 	if res.Err != nil {
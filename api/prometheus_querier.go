@@ -4,12 +4,12 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"strings"
 	"time"
 
 	"github.com/grafana/metrictank/api/models"
 	"github.com/grafana/metrictank/consolidation"
 	"github.com/grafana/metrictank/mdata"
-	"github.com/grafana/metrictank/util"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/storage"
@@ -47,8 +47,6 @@ func NewQuerier(ctx context.Context, s *Server, from, to uint32, orgId uint32, m
 
 // Select returns a set of series that matches the given label matchers.
 func (q *querier) Select(matchers ...*labels.Matcher) (storage.SeriesSet, error) {
-	minFrom := uint32(math.MaxUint32)
-	var maxTo uint32
 	var target string
 	var reqs []models.Req
 
@@ -64,7 +62,10 @@ func (q *querier) Select(matchers ...*labels.Matcher) (storage.SeriesSet, error)
 		}
 	}
 
+	qStats := queryStatsFrom(q.ctx)
+	preFind := time.Now()
 	series, err := q.clusterFindByTag(q.ctx, q.OrgID, expressions, 0)
+	qStats.addStage("find", time.Since(preFind))
 	if err != nil {
 		return nil, err
 	}
@@ -73,8 +74,6 @@ func (q *querier) Select(matchers ...*labels.Matcher) (storage.SeriesSet, error)
 		return BuildMetadataSeriesSet(series)
 	}
 
-	minFrom = util.Min(minFrom, q.from)
-	maxTo = util.Max(maxTo, q.to)
 	for _, s := range series {
 		for _, metric := range s.Series {
 			for _, archive := range metric.Defs {
@@ -96,18 +95,21 @@ func (q *querier) Select(matchers ...*labels.Matcher) (storage.SeriesSet, error)
 	}
 
 	reqRenderSeriesCount.Value(len(reqs))
+	qStats.addSeries(strings.Join(expressions, ","), q.from, q.to, len(reqs))
 	if len(reqs) == 0 {
 		return nil, fmt.Errorf("no series found")
 	}
 
 	// note: if 1 series has a movingAvg that requires a long time range extension, it may push other reqs into another archive. can be optimized later
-	reqs, _, _, err = alignRequests(uint32(time.Now().Unix()), minFrom, maxTo, reqs)
+	reqs, _, _, err = alignRequests(uint32(time.Now().Unix()), reqs)
 	if err != nil {
 		log.Error(3, "HTTP Render alignReq error: %s", err)
 		return nil, err
 	}
 
+	preFetch := time.Now()
 	out, err := q.getTargets(q.ctx, reqs)
+	qStats.addStage("fetch", time.Since(preFetch))
 	if err != nil {
 		log.Error(3, "HTTP Render %s", err.Error())
 		return nil, err
@@ -25,10 +25,13 @@ var (
 )
 
 // alignRequests updates the requests with all details for fetching, making sure all metrics are in the same, optimal interval
-// note: it is assumed that all requests have the same maxDataPoints, from & to.
+// note: it is assumed that all requests have the same maxDataPoints.
+// requests may cover different time ranges (e.g. due to timeShift): the widest range determines the
+// interval needed to stay within the points limits, and the oldest from determines the TTL needed.
 // also takes a "now" value which we compare the TTL against
-func alignRequests(now, from, to uint32, reqs []models.Req) ([]models.Req, uint32, uint32, error) {
-	tsRange := to - from
+func alignRequests(now uint32, reqs []models.Req) ([]models.Req, uint32, uint32, error) {
+	var tsRange uint32
+	minFrom := uint32(math.MaxUint32)
 
 	var listIntervals []uint32
 	var seenIntervals = make(map[uint32]struct{})
@@ -38,9 +41,11 @@ func alignRequests(now, from, to uint32, reqs []models.Req) ([]models.Req, uint3
 		req := &reqs[i]
 		req.Archive = -1
 		targets[req.Target] = struct{}{}
+		tsRange = util.Max(tsRange, req.To-req.From)
+		minFrom = util.Min(minFrom, req.From)
 	}
 	numTargets := uint32(len(targets))
-	minTTL := now - reqs[0].From
+	minTTL := now - minFrom
 
 	minIntervalSoft := uint32(0)
 	minIntervalHard := uint32(0)
@@ -29,7 +29,7 @@ func testAlign(reqs []models.Req, retentions [][]conf.Retention, outReqs []model
 	}
 
 	mdata.Schemas = conf.NewSchemas(schemas)
-	out, _, _, err := alignRequests(now, reqs[0].From, reqs[0].To, reqs)
+	out, _, _, err := alignRequests(now, reqs)
 	if err != outErr {
 		t.Errorf("different err value expected: %v, got: %v", outErr, err)
 	}
@@ -416,6 +416,29 @@ func TestAlignRequestsHuh(t *testing.T) {
 var hour uint32 = 60 * 60
 var day uint32 = 24 * hour
 
+// 2 series requested with equal raw intervals, but one of them is shifted back in time (e.g. timeShift). req 1000-1030 and 0-30. now 1200.
+// the shifted request does not fit in raw, so both need the rollup
+func TestAlignRequestsShifted(t *testing.T) {
+	testAlign([]models.Req{
+		reqRaw(test.GetMKey(1), 1000, 1030, 800, 60, consolidation.Avg, 0, 0),
+		reqRaw(test.GetMKey(2), 0, 30, 800, 60, consolidation.Avg, 0, 0),
+	},
+		[][]conf.Retention{
+			{
+				conf.NewRetentionMT(60, 1199, 0, 0, true), // just not long enough for the shifted request
+				conf.NewRetentionMT(120, 1200, 600, 2, true),
+			},
+		},
+		[]models.Req{
+			reqOut(test.GetMKey(1), 1000, 1030, 800, 60, consolidation.Avg, 0, 0, 1, 120, 1200, 120, 1),
+			reqOut(test.GetMKey(2), 0, 30, 800, 60, consolidation.Avg, 0, 0, 1, 120, 1200, 120, 1),
+		},
+		nil,
+		1200,
+		t,
+	)
+}
+
 func testMaxPointsPerReq(maxPointsSoft, maxPointsHard int, reqs []models.Req, t *testing.T) ([]models.Req, error) {
 	origMaxPointsPerReqSoft := maxPointsPerReqSoft
 	origMaxPointsPerReqHard := maxPointsPerReqHard
@@ -431,7 +454,7 @@ func testMaxPointsPerReq(maxPointsSoft, maxPointsHard int, reqs []models.Req, t
 		}),
 	}})
 
-	out, _, _, err := alignRequests(30*day, reqs[0].From, reqs[0].To, reqs)
+	out, _, _, err := alignRequests(30*day, reqs)
 	maxPointsPerReqSoft = origMaxPointsPerReqSoft
 	maxPointsPerReqHard = origMaxPointsPerReqHard
 	return out, err
@@ -524,7 +547,7 @@ func BenchmarkAlignRequests(b *testing.B) {
 	})
 
 	for n := 0; n < b.N; n++ {
-		res, _, _, _ = alignRequests(14*24*3600, 0, 3600*24*7, reqs)
+		res, _, _, _ = alignRequests(14*24*3600, reqs)
 	}
 	result = res
 }
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/stats"
+	schema "gopkg.in/raintank/schema.v1"
+)
+
+var (
+	// metric api.request.render.cache.hit is how many /render requests were served from the render cache
+	renderCacheHit = stats.NewCounter32("api.request.render.cache.hit")
+
+	// metric api.request.render.cache.miss is how many cacheable /render requests were not in the render cache
+	renderCacheMiss = stats.NewCounter32("api.request.render.cache.miss")
+
+	// metric api.request.render.cache.evict is how many outputs were evicted from the render cache to stay within its max size
+	renderCacheEvict = stats.NewCounter32("api.request.render.cache.evict")
+
+	// metric api.request.render.cache.size is the estimated size of the render cache in bytes
+	renderCacheSize = stats.NewGauge64("api.request.render.cache.size")
+)
+
+// renderCacheKey identifies the output of a /render request.
+// the plan is normalized, so that differences in how the targets are written don't matter.
+type renderCacheKey struct {
+	orgId uint32
+	plan  string
+	tz    string
+	from  uint32
+	to    uint32
+	mdp   uint32
+}
+
+type renderCacheEntry struct {
+	key     renderCacheKey
+	series  []models.Series
+	size    uint64
+	expires time.Time
+}
+
+// renderCache holds the output of recent /render requests, so that identical requests (e.g. of dashboards
+// that many users refresh periodically) don't have to fetch and process their data again.
+// it is bounded by an estimate of the memory used by the outputs, evicting the least recently used ones first.
+type renderCache struct {
+	sync.Mutex
+	maxSize   uint64
+	align     uint32        // from and to of requests are aligned to this many seconds
+	recent    uint32        // requests up to less than this many seconds ago are considered recent
+	recentTTL time.Duration // how long outputs of recent requests are cached
+	ttl       time.Duration // how long outputs of other requests are cached
+	size      uint64
+	lru       *list.List // of *renderCacheEntry. the most recently used is at the front
+	entries   map[renderCacheKey]*list.Element
+}
+
+// newRenderCache returns a render cache, or nil if maxSize is 0, which disables caching
+func newRenderCache(maxSize uint64, align, recent uint32, recentTTL, ttl time.Duration) *renderCache {
+	if maxSize == 0 {
+		return nil
+	}
+	return &renderCache{
+		maxSize:   maxSize,
+		align:     align,
+		recent:    recent,
+		recentTTL: recentTTL,
+		ttl:       ttl,
+		lru:       list.New(),
+		entries:   make(map[renderCacheKey]*list.Element),
+	}
+}
+
+// Align aligns the requested (graphite style) time range, so that requests for nearly the same range
+// share their cache entry. if the aligned range would be empty, the range is returned as is, and can't be cached.
+func (c *renderCache) Align(from, to uint32) (uint32, uint32, bool) {
+	if c.align <= 1 {
+		return from, to, true
+	}
+	alignedFrom := from - from%c.align
+	alignedTo := to - to%c.align
+	if alignedFrom >= alignedTo {
+		return from, to, false
+	}
+	return alignedFrom, alignedTo, true
+}
+
+// Get returns the cached output for the given key, if it has not expired yet.
+// the output is shared, so it must not be modified.
+func (c *renderCache) Get(key renderCacheKey, now time.Time) ([]models.Series, bool) {
+	c.Lock()
+	defer c.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		renderCacheMiss.Inc()
+		return nil, false
+	}
+	entry := elem.Value.(*renderCacheEntry)
+	if now.After(entry.expires) {
+		c.remove(elem)
+		renderCacheMiss.Inc()
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	renderCacheHit.Inc()
+	return entry.series, true
+}
+
+// Add caches a copy of the given output, as its points will be returned to the pool once it has been sent.
+// how long it is cached depends on how recent the end of the requested range (to) is.
+func (c *renderCache) Add(key renderCacheKey, series []models.Series, now time.Time) {
+	entry := &renderCacheEntry{
+		key:     key,
+		series:  make([]models.Series, len(series)),
+		size:    uint64(len(key.plan)),
+		expires: now.Add(c.ttl),
+	}
+	if int64(key.to)+int64(c.recent) > now.Unix() {
+		entry.expires = now.Add(c.recentTTL)
+	}
+	for i, serie := range series {
+		entry.series[i] = serie
+		entry.series[i].Datapoints = make([]schema.Point, len(serie.Datapoints))
+		copy(entry.series[i].Datapoints, serie.Datapoints)
+		entry.size += seriesSize(serie)
+	}
+	if entry.size > c.maxSize {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	for c.size+entry.size > c.maxSize {
+		c.remove(c.lru.Back())
+		renderCacheEvict.Inc()
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.size += entry.size
+	renderCacheSize.AddUint64(entry.size)
+}
+
+// remove removes the given element. the lock must be held.
+func (c *renderCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*renderCacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+	renderCacheSize.DecUint64(entry.size)
+}
+
+// seriesSize estimates how many bytes of memory the given series uses
+func seriesSize(serie models.Series) uint64 {
+	size := uint64(200 + len(serie.Target) + len(serie.QueryPatt) + 16*len(serie.Datapoints))
+	for k, v := range serie.Tags {
+		size += uint64(len(k) + len(v))
+	}
+	return size
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func renderCacheOutput(target string, points int) []models.Series {
+	serie := models.Series{
+		Target:     target,
+		QueryPatt:  target,
+		Tags:       map[string]string{"name": target},
+		Datapoints: make([]schema.Point, points),
+		Interval:   10,
+	}
+	for i := range serie.Datapoints {
+		serie.Datapoints[i] = schema.Point{Val: float64(i), Ts: uint32(10 * (i + 1))}
+	}
+	return []models.Series{serie}
+}
+
+func TestRenderCacheAlign(t *testing.T) {
+	c := newRenderCache(1000, 10, 600, time.Second, time.Hour)
+	cases := []struct {
+		from, to       uint32
+		expFrom, expTo uint32
+		expCacheable   bool
+	}{
+		{100, 200, 100, 200, true},
+		{105, 209, 100, 200, true},
+		{101, 111, 100, 110, true},
+		{101, 109, 101, 109, false},
+	}
+	for _, cas := range cases {
+		from, to, cacheable := c.Align(cas.from, cas.to)
+		if from != cas.expFrom || to != cas.expTo || cacheable != cas.expCacheable {
+			t.Fatalf("case %d-%d: expected %d-%d %t, got %d-%d %t", cas.from, cas.to, cas.expFrom, cas.expTo, cas.expCacheable, from, to, cacheable)
+		}
+	}
+}
+
+func TestRenderCacheGetAdd(t *testing.T) {
+	now := time.Unix(10000, 0)
+	c := newRenderCache(10000, 10, 600, 10*time.Second, time.Hour)
+	recent := renderCacheKey{orgId: 1, plan: "a", from: 9000, to: 9990, mdp: 800}
+	old := renderCacheKey{orgId: 1, plan: "a", from: 1000, to: 2000, mdp: 800}
+
+	out := renderCacheOutput("a", 10)
+	c.Add(recent, out, now)
+	c.Add(old, out, now)
+	// the cache holds a copy, as the original points go back to the pool
+	out[0].Datapoints[0].Val = 42
+
+	for _, key := range []renderCacheKey{recent, old} {
+		got, ok := c.Get(key, now.Add(5*time.Second))
+		if !ok || len(got) != 1 || got[0].Target != "a" || len(got[0].Datapoints) != 10 || got[0].Datapoints[0].Val != 0 {
+			t.Fatalf("key %v: expected the cached output, got %v %t", key, got, ok)
+		}
+	}
+	for _, key := range []renderCacheKey{{orgId: 2, plan: "a", from: 1000, to: 2000, mdp: 800}, {orgId: 1, plan: "a", from: 1000, to: 2000, mdp: 0}} {
+		if _, ok := c.Get(key, now); ok {
+			t.Fatalf("key %v: expected a miss, got a hit", key)
+		}
+	}
+
+	// the recent output expires sooner
+	if _, ok := c.Get(recent, now.Add(11*time.Second)); ok {
+		t.Fatal("expected the recent output to have expired")
+	}
+	if _, ok := c.Get(old, now.Add(11*time.Second)); !ok {
+		t.Fatal("expected the old output to be cached still")
+	}
+	if _, ok := c.Get(old, now.Add(time.Hour+time.Second)); ok {
+		t.Fatal("expected the old output to have expired")
+	}
+	if c.size != 0 || len(c.entries) != 0 || c.lru.Len() != 0 {
+		t.Fatalf("expected an empty cache, got size %d with %d entries", c.size, len(c.entries))
+	}
+}
+
+func TestRenderCacheEvict(t *testing.T) {
+	now := time.Unix(10000, 0)
+	out := renderCacheOutput("a", 10)
+	size := seriesSize(out[0]) + 1
+	// room for 3 outputs
+	c := newRenderCache(3*size+1, 10, 600, time.Minute, time.Minute)
+	keys := []renderCacheKey{
+		{orgId: 1, plan: "a"},
+		{orgId: 1, plan: "b"},
+		{orgId: 1, plan: "c"},
+		{orgId: 1, plan: "d"},
+	}
+	c.Add(keys[0], out, now)
+	c.Add(keys[1], out, now)
+	c.Add(keys[2], out, now)
+	// a is now used more recently than b, which gets evicted to make room for d
+	c.Get(keys[0], now)
+	c.Add(keys[3], out, now)
+
+	for i, exp := range []bool{true, false, true, true} {
+		if _, ok := c.Get(keys[i], now); ok != exp {
+			t.Fatalf("key %v: expected cached %t, got %t", keys[i], exp, ok)
+		}
+	}
+	if c.size != 3*size {
+		t.Fatalf("expected size %d, got %d", 3*size, c.size)
+	}
+
+	// replacing an output does not count twice
+	c.Add(keys[3], out, now)
+	if c.size != 3*size || len(c.entries) != 3 {
+		t.Fatalf("expected size %d with 3 entries, got %d with %d", 3*size, c.size, len(c.entries))
+	}
+
+	// outputs bigger than the cache are not cached
+	c.Add(keys[1], renderCacheOutput("a", 100), now)
+	if _, ok := c.Get(keys[1], now); ok {
+		t.Fatal("expected an output bigger than the cache not to be cached")
+	}
+}
+
+func TestNewRenderCacheDisabled(t *testing.T) {
+	if c := newRenderCache(0, 10, 600, time.Minute, time.Minute); c != nil {
+		t.Fatal("expected no render cache when its max size is 0")
+	}
+}
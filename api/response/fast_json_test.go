@@ -151,3 +151,27 @@ func BenchmarkHttpRespFastJson1MMetricNamesNeedEscaping(b *testing.B) {
 		resp.Close()
 	}
 }
+
+func TestFastJsonStream(t *testing.T) {
+	for _, c := range testSeries() {
+		w := httptest.NewRecorder()
+		Write(w, NewFastJsonStream(200, models.SeriesByTarget(c.in)))
+		got := w.Body.String()
+		if c.out != got {
+			t.Fatalf("bad json output.\nexpected:%s\ngot:     %s\n", c.out, got)
+		}
+		if ct := w.Header().Get("content-type"); ct != "application/json" {
+			t.Fatalf("expected content-type application/json, got %q", ct)
+		}
+
+		resp := NewFastJsonStream(200, models.SeriesByTarget(c.in))
+		body, err := resp.Body()
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		if c.out != string(body) {
+			t.Fatalf("bad json body.\nexpected:%s\ngot:     %s\n", c.out, body)
+		}
+		resp.Close()
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/grafana/metrictank/util"
+	"github.com/raintank/worldping-api/pkg/log"
 )
 
 var ErrMetricNotFound = errors.New("metric not found")
@@ -13,6 +14,10 @@ var BufferPool = util.NewBufferPool() // used by pickle, fastjson and msgp respo
 
 func Write(w http.ResponseWriter, resp Response) {
 	defer resp.Close()
+	if stream, ok := resp.(Streaming); ok {
+		writeStream(w, stream)
+		return
+	}
 	body, err := resp.Body()
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -27,6 +32,19 @@ func Write(w http.ResponseWriter, resp Response) {
 	return
 }
 
+// writeStream writes the streaming response. once the body is being written, the status code
+// can't be changed anymore, so errors can only be logged, and the client will get an invalid body.
+func writeStream(w http.ResponseWriter, resp Streaming) {
+	for k, v := range resp.Headers() {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(resp.Code())
+	err := resp.WriteBody(w)
+	if err != nil {
+		log.Error(3, "API failed to write streaming response: %s", err)
+	}
+}
+
 type Response interface {
 	Code() int
 	Body() ([]byte, error)
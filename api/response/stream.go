@@ -0,0 +1,89 @@
+package response
+
+import (
+	"io"
+)
+
+type JSONWriter interface {
+	WriteJSONFast(io.Writer, []byte) ([]byte, error)
+}
+
+type MsgpWriter interface {
+	WriteMsg(io.Writer, []byte) ([]byte, error)
+}
+
+type PickleWriter interface {
+	WritePickle(io.Writer, []byte) ([]byte, error)
+}
+
+// Streaming is a response that can write its body as it is being encoded,
+// rather than encoding it completely in memory first.
+type Streaming interface {
+	Response
+	WriteBody(io.Writer) error
+}
+
+// Stream is a streaming response. the body is encoded in parts into a buffer,
+// which is written out whenever it has grown large enough.
+type Stream struct {
+	code        int
+	contentType string
+	write       func(io.Writer, []byte) ([]byte, error)
+	buf         []byte
+}
+
+func NewFastJsonStream(code int, body JSONWriter) *Stream {
+	return newStream(code, "application/json", body.WriteJSONFast)
+}
+
+func NewMsgpStream(code int, body MsgpWriter) *Stream {
+	return newStream(code, "application/msgpack", body.WriteMsg)
+}
+
+func NewPickleStream(code int, body PickleWriter) *Stream {
+	return newStream(code, "application/pickle", body.WritePickle)
+}
+
+func newStream(code int, contentType string, write func(io.Writer, []byte) ([]byte, error)) *Stream {
+	return &Stream{
+		code:        code,
+		contentType: contentType,
+		write:       write,
+		buf:         BufferPool.Get(),
+	}
+}
+
+func (r *Stream) Code() int {
+	return r.code
+}
+
+func (r *Stream) Close() {
+	BufferPool.Put(r.buf)
+}
+
+// Body returns the complete body, for when it can't be streamed
+func (r *Stream) Body() ([]byte, error) {
+	w := &appendWriter{}
+	var err error
+	r.buf, err = r.write(w, r.buf)
+	return w.buf, err
+}
+
+func (r *Stream) WriteBody(w io.Writer) error {
+	var err error
+	r.buf, err = r.write(w, r.buf)
+	return err
+}
+
+func (r *Stream) Headers() (headers map[string]string) {
+	return map[string]string{"content-type": r.contentType}
+}
+
+type appendWriter struct {
+	buf []byte
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
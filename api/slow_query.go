@@ -0,0 +1,143 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/grafana/metrictank/expr"
+	"github.com/grafana/metrictank/mdata/cache"
+	"github.com/raintank/worldping-api/pkg/log"
+)
+
+// queryStats records where the time of a query went, so that it can be logged if the query is slow.
+// all methods can be called on a nil *queryStats, which does nothing, so that the callers don't have to check
+// whether the slow query log is enabled.
+// the cache and store stats only cover the data fetched by this node, not by its peers.
+type queryStats struct {
+	sync.Mutex
+	start        time.Time
+	plan         *expr.Plan
+	stages       []queryStage // in the order they were first seen. stages that run several times are summed up
+	seriesPerReq []string     // formatted as <query>[<from>-<to>]=<number of series>
+
+	cacheHit     uint32 // chunk cache searches that returned all data. accessed atomically
+	cachePartial uint32 // chunk cache searches that returned some data. accessed atomically
+	cacheMiss    uint32 // chunk cache searches that returned no data. accessed atomically
+	storeChunks  uint32 // chunks read from the store. accessed atomically
+}
+
+type queryStage struct {
+	name string
+	dur  time.Duration
+}
+
+type queryStatsKey struct{}
+
+// withQueryStats returns a context that records the stats of the query, if the slow query log is enabled
+func withQueryStats(ctx context.Context, start time.Time) (context.Context, *queryStats) {
+	if slowQueryThreshold == 0 {
+		return ctx, nil
+	}
+	q := &queryStats{start: start}
+	return context.WithValue(ctx, queryStatsKey{}, q), q
+}
+
+// queryStatsFrom returns the stats recorded in the given context, or nil if they are not being recorded
+func queryStatsFrom(ctx context.Context) *queryStats {
+	q, _ := ctx.Value(queryStatsKey{}).(*queryStats)
+	return q
+}
+
+func (q *queryStats) setPlan(plan expr.Plan) {
+	if q == nil {
+		return
+	}
+	q.Lock()
+	q.plan = &plan
+	q.Unlock()
+}
+
+// addStage records that the given stage took the given duration
+func (q *queryStats) addStage(name string, dur time.Duration) {
+	if q == nil {
+		return
+	}
+	q.Lock()
+	defer q.Unlock()
+	for i := range q.stages {
+		if q.stages[i].name == name {
+			q.stages[i].dur += dur
+			return
+		}
+	}
+	q.stages = append(q.stages, queryStage{name, dur})
+}
+
+// addSeries records the number of series found for the given query
+func (q *queryStats) addSeries(query string, from, to uint32, series int) {
+	if q == nil {
+		return
+	}
+	q.Lock()
+	q.seriesPerReq = append(q.seriesPerReq, fmt.Sprintf("%s[%d-%d]=%d", query, from, to, series))
+	q.Unlock()
+}
+
+func (q *queryStats) addCacheResult(res *cache.CCSearchResult) {
+	if q == nil {
+		return
+	}
+	switch {
+	case res.Complete:
+		atomic.AddUint32(&q.cacheHit, 1)
+	case len(res.Start) > 0 || len(res.End) > 0:
+		atomic.AddUint32(&q.cachePartial, 1)
+	default:
+		atomic.AddUint32(&q.cacheMiss, 1)
+	}
+}
+
+func (q *queryStats) addStoreChunks(chunks int) {
+	if q == nil {
+		return
+	}
+	atomic.AddUint32(&q.storeChunks, uint32(chunks))
+}
+
+// logIfSlow logs the stats of the query, if it took at least slowQueryThreshold.
+// the record is a single line, so that it can be easily grepped for.
+func (q *queryStats) logIfSlow(endpoint string, orgId uint32, query string) {
+	if q == nil {
+		return
+	}
+	dur := time.Since(q.start)
+	if dur < slowQueryThreshold {
+		return
+	}
+	log.Warn("%s", q.format(endpoint, orgId, query, dur))
+}
+
+func (q *queryStats) format(endpoint string, orgId uint32, query string, dur time.Duration) string {
+	q.Lock()
+	defer q.Unlock()
+	stages := make([]string, len(q.stages))
+	for i, stage := range q.stages {
+		stages[i] = fmt.Sprintf("%s:%s", stage.name, stage.dur)
+	}
+	line := fmt.Sprintf("SLOW %s org=%d dur=%s query=%q stages=%s series=%q cache=hit:%d,partial:%d,miss:%d chunks=%d",
+		endpoint, orgId, dur, query, strings.Join(stages, ","), strings.Join(q.seriesPerReq, " "),
+		atomic.LoadUint32(&q.cacheHit), atomic.LoadUint32(&q.cachePartial), atomic.LoadUint32(&q.cacheMiss),
+		atomic.LoadUint32(&q.storeChunks))
+	if q.plan != nil {
+		var buf bytes.Buffer
+		q.plan.Dump(&buf)
+		line += " plan=" + strconv.Quote(buf.String())
+	}
+	return line
+}
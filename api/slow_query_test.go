@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/expr"
+	"github.com/grafana/metrictank/mdata/cache"
+	"github.com/grafana/metrictank/mdata/chunk"
+)
+
+func TestQueryStatsDisabled(t *testing.T) {
+	orig := slowQueryThreshold
+	defer func() { slowQueryThreshold = orig }()
+	slowQueryThreshold = 0
+
+	ctx, q := withQueryStats(context.Background(), time.Now())
+	if q != nil || queryStatsFrom(ctx) != nil {
+		t.Fatalf("expected no stats when the slow query log is disabled")
+	}
+	// none of these should panic
+	q.addStage("find", time.Second)
+	q.addSeries("foo.*", 10, 20, 3)
+	q.addCacheResult(&cache.CCSearchResult{})
+	q.addStoreChunks(1)
+	q.setPlan(expr.Plan{})
+	q.logIfSlow("render", 1, "foo.*")
+}
+
+func TestQueryStatsFormat(t *testing.T) {
+	orig := slowQueryThreshold
+	defer func() { slowQueryThreshold = orig }()
+	slowQueryThreshold = time.Second
+
+	ctx, q := withQueryStats(context.Background(), time.Now())
+	if q == nil || queryStatsFrom(ctx) != q {
+		t.Fatalf("expected the stats to be recorded in the context")
+	}
+
+	exprs, err := expr.ParseMany([]string{"sum(foo.*)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := expr.NewPlan(exprs, 10, 20, 800, true, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.setPlan(plan)
+	q.addStage("find", 2*time.Millisecond)
+	q.addStage("fetch", 3*time.Millisecond)
+	q.addStage("find", 5*time.Millisecond) // a second round, e.g. of dynamic requests
+	q.addSeries("foo.*", 10, 20, 3)
+	q.addSeries("bar.*", 10, 20, 0)
+	q.addCacheResult(&cache.CCSearchResult{Complete: true})
+	q.addCacheResult(&cache.CCSearchResult{Start: []chunk.IterGen{{}}})
+	q.addCacheResult(&cache.CCSearchResult{})
+	q.addCacheResult(&cache.CCSearchResult{})
+	q.addStoreChunks(4)
+	q.addStoreChunks(2)
+
+	line := q.format("render", 12, "sum(foo.*)", 2*time.Second)
+	if strings.Contains(line, "\n") {
+		t.Fatalf("expected a single line, got %q", line)
+	}
+	exp := []string{
+		"SLOW render org=12 dur=2s",
+		`query="sum(foo.*)"`,
+		"stages=find:7ms,fetch:3ms",
+		`series="foo.*[10-20]=3 bar.*[10-20]=0"`,
+		"cache=hit:1,partial:1,miss:2",
+		"chunks=6",
+		`plan="Plan:\n* Exprs:\n`,
+	}
+	for _, e := range exp {
+		if !strings.Contains(line, e) {
+			t.Fatalf("expected line to contain %q, got %q", e, line)
+		}
+	}
+}
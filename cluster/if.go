@@ -2,6 +2,7 @@ package cluster
 
 import (
 	"context"
+	"io"
 )
 
 type Node interface {
@@ -10,5 +11,6 @@ type Node interface {
 	GetPartitions() []int32
 	GetPriority() int
 	Post(context.Context, string, string, Traceable) ([]byte, error)
+	PostStream(context.Context, string, string, Traceable) (io.ReadCloser, error)
 	GetName() string
 }
@@ -1,7 +1,10 @@
 package cluster
 
 import (
+	"bytes"
 	"context"
+	"io"
+	"io/ioutil"
 	"sort"
 	"time"
 )
@@ -35,6 +38,10 @@ func (n MockNode) Post(ctx context.Context, name, path string, body Traceable) (
 	return n.postResponse, nil
 }
 
+func (n MockNode) PostStream(ctx context.Context, name, path string, body Traceable) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(n.postResponse)), nil
+}
+
 func (n *MockNode) GetName() string {
 	return n.name
 }
@@ -5,16 +5,21 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"time"
 
+	"github.com/grafana/metrictank/stats"
 	"github.com/grafana/metrictank/tracing"
 	opentracing "github.com/opentracing/opentracing-go"
 	tags "github.com/opentracing/opentracing-go/ext"
 	"github.com/raintank/worldping-api/pkg/log"
 )
 
+// metric cluster.http.canceled is how many requests to peers were aborted because the request they were issued for was canceled
+var httpCanceled = stats.NewCounter32("cluster.http.canceled")
+
 //go:generate stringer -type=NodeState
 type NodeState int
 
@@ -142,23 +147,10 @@ func (n HTTPNode) Post(ctx context.Context, name, path string, body Traceable) (
 		span.Finish()
 	}(time.Now())
 
-	b, err := json.Marshal(body)
-	if err != nil {
-		return nil, NewError(http.StatusInternalServerError, err)
-	}
-	var reader *bytes.Reader
-	reader = bytes.NewReader(b)
-	addr := n.RemoteURL() + path
-	req, err := http.NewRequest("POST", addr, reader)
-	if err != nil {
-		return nil, NewError(http.StatusInternalServerError, err)
-	}
-	carrier := opentracing.HTTPHeadersCarrier(req.Header)
-	err = Tracer.Inject(span.Context(), opentracing.HTTPHeaders, carrier)
+	req, err := n.newRequest(ctx, span, path, body)
 	if err != nil {
-		log.Error(3, "CLU failed to inject span into headers: %s", err)
+		return nil, err
 	}
-	req.Header.Add("Content-Type", "application/json")
 
 	c := make(chan struct {
 		r   *http.Response
@@ -178,8 +170,13 @@ func (n HTTPNode) Post(ctx context.Context, name, path string, body Traceable) (
 	select {
 	case <-ctx.Done():
 		log.Debug("CLU HTTPNode: context canceled. terminating request to peer %s", n.Name)
-		transport.CancelRequest(req)
-		<-c // Wait for client.Do but ignore result
+		httpCanceled.Inc()
+		// the request carries the context, so client.Do returns as soon as it has been aborted.
+		// note that transport.CancelRequest would not work: the client uses a copy of the request to enforce its timeout.
+		resp := <-c
+		if resp.err == nil {
+			resp.r.Body.Close()
+		}
 	case resp := <-c:
 		err := resp.err
 		rsp := resp.r
@@ -194,6 +191,80 @@ func (n HTTPNode) Post(ctx context.Context, name, path string, body Traceable) (
 	return nil, nil
 }
 
+// PostStream is like Post, but returns the body of the response as soon as it starts to arrive, so that it can be
+// decoded while it is being received. the caller must close it. canceling the context aborts the request,
+// also while its body is being read.
+func (n HTTPNode) PostStream(ctx context.Context, name, path string, body Traceable) (ret io.ReadCloser, err error) {
+	ctx, span := tracing.NewSpan(ctx, Tracer, name)
+	tags.SpanKindRPCClient.Set(span)
+	tags.PeerService.Set(span, "metrictank")
+	tags.PeerAddress.Set(span, n.RemoteAddr)
+	tags.PeerHostname.Set(span, n.Name)
+	body.Trace(span)
+	defer func() {
+		if err != nil {
+			tags.Error.Set(span, true)
+			body.TraceDebug(span)
+			span.Finish()
+		}
+	}()
+
+	req, err := n.newRequest(ctx, span, path, body)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			log.Debug("CLU HTTPNode: context canceled. terminated request to peer %s", n.Name)
+			httpCanceled.Inc()
+			return nil, ctx.Err()
+		}
+		log.Error(3, "CLU HTTPNode: %s unreachable. %s", n.Name, err.Error())
+		return nil, NewError(http.StatusServiceUnavailable, fmt.Errorf("cluster node unavailable"))
+	}
+	if rsp.StatusCode != 200 {
+		_, err = handleResp(rsp)
+		return nil, err
+	}
+	return spanReadCloser{rsp.Body, span}, nil
+}
+
+// spanReadCloser finishes the span of the request once its body is closed
+type spanReadCloser struct {
+	io.ReadCloser
+	span opentracing.Span
+}
+
+func (s spanReadCloser) Close() error {
+	err := s.ReadCloser.Close()
+	s.span.Finish()
+	return err
+}
+
+// newRequest creates the request to post the given body to the given path of the node.
+// the request is aborted when the context is canceled.
+func (n HTTPNode) newRequest(ctx context.Context, span opentracing.Span, path string, body Traceable) (*http.Request, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, NewError(http.StatusInternalServerError, err)
+	}
+	var reader *bytes.Reader
+	reader = bytes.NewReader(b)
+	addr := n.RemoteURL() + path
+	req, err := http.NewRequest("POST", addr, reader)
+	if err != nil {
+		return nil, NewError(http.StatusInternalServerError, err)
+	}
+	carrier := opentracing.HTTPHeadersCarrier(req.Header)
+	err = Tracer.Inject(span.Context(), opentracing.HTTPHeaders, carrier)
+	if err != nil {
+		log.Error(3, "CLU failed to inject span into headers: %s", err)
+	}
+	req.Header.Add("Content-Type", "application/json")
+	return req.WithContext(ctx), nil
+}
+
 func (n HTTPNode) GetName() string {
 	return n.Name
 }
@@ -0,0 +1,107 @@
+package cluster
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+type testBody struct{}
+
+func (b testBody) Trace(span opentracing.Span)      {}
+func (b testBody) TraceDebug(span opentracing.Span) {}
+
+func testContext() context.Context {
+	span := opentracing.NoopTracer{}.StartSpan("test")
+	return opentracing.ContextWithSpan(context.Background(), span)
+}
+
+// blockingNode returns a node whose requests block until the returned function is called
+func blockingNode(sendHeader bool) (HTTPNode, func()) {
+	unblock := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sendHeader {
+			w.WriteHeader(200)
+			w.Write([]byte("partial"))
+			w.(http.Flusher).Flush()
+		}
+		<-unblock
+	}))
+	u, _ := url.Parse(srv.URL)
+	host, port, _ := net.SplitHostPort(u.Host)
+	apiPort, _ := strconv.Atoi(port)
+	node := HTTPNode{
+		Name:       "peer",
+		RemoteAddr: host,
+		ApiPort:    apiPort,
+		ApiScheme:  "http",
+	}
+	return node, func() {
+		close(unblock)
+		srv.Close()
+	}
+}
+
+func TestPostCanceled(t *testing.T) {
+	Tracer = opentracing.NoopTracer{}
+	node, done := blockingNode(false)
+	defer done()
+
+	ctx, cancel := context.WithCancel(testContext())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	pre := time.Now()
+	node.Post(ctx, "test", "/", testBody{})
+	if time.Since(pre) > 5*time.Second {
+		t.Fatalf("expected Post to return once its context was canceled")
+	}
+}
+
+func TestPostStreamCanceled(t *testing.T) {
+	Tracer = opentracing.NoopTracer{}
+	node, done := blockingNode(false)
+	defer done()
+
+	ctx, cancel := context.WithCancel(testContext())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	pre := time.Now()
+	_, err := node.PostStream(ctx, "test", "/", testBody{})
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if time.Since(pre) > 5*time.Second {
+		t.Fatalf("expected PostStream to return once its context was canceled")
+	}
+}
+
+func TestPostStreamCanceledWhileReading(t *testing.T) {
+	Tracer = opentracing.NoopTracer{}
+	node, done := blockingNode(true)
+	defer done()
+
+	ctx, cancel := context.WithCancel(testContext())
+	body, err := node.PostStream(ctx, "test", "/", testBody{})
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err)
+	}
+	defer body.Close()
+	buf := make([]byte, 7)
+	if _, err := body.Read(buf); err != nil || string(buf) != "partial" {
+		t.Fatalf("expected to read the partial body, got %q, err %v", buf, err)
+	}
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+	pre := time.Now()
+	if _, err := body.Read(buf); err == nil {
+		t.Fatalf("expected an error reading the body of a canceled request")
+	}
+	if time.Since(pre) > 5*time.Second {
+		t.Fatalf("expected reading to be aborted once the context was canceled")
+	}
+}
@@ -29,11 +29,14 @@ import (
 	inPrometheus "github.com/grafana/metrictank/input/prometheus"
 	"github.com/grafana/metrictank/mdata"
 	"github.com/grafana/metrictank/mdata/cache"
+	"github.com/grafana/metrictank/mdata/chunk"
 	"github.com/grafana/metrictank/mdata/notifierKafka"
 	"github.com/grafana/metrictank/mdata/notifierNsq"
 	"github.com/grafana/metrictank/stats"
 	statsConfig "github.com/grafana/metrictank/stats/config"
 	cassandraStore "github.com/grafana/metrictank/store/cassandra"
+	diskStore "github.com/grafana/metrictank/store/disk"
+	"github.com/grafana/metrictank/store/tiered"
 	"github.com/raintank/dur"
 	"github.com/raintank/worldping-api/pkg/log"
 	"github.com/rakyll/globalconf"
@@ -50,6 +53,7 @@ var (
 	apiServer   *api.Server
 	inputs      []input.Plugin
 	store       mdata.Store
+	coldStore   *tiered.TieredStore
 
 	// Misc:
 	instance    = flag.String("instance", "default", "instance identifier. must be unique. used in clustering messages, for naming queue consumers and emitted metrics")
@@ -58,6 +62,7 @@ var (
 
 	// Data:
 	dropFirstChunk    = flag.Bool("drop-first-chunk", false, "forego persisting of first received (and typically incomplete) chunk")
+	compactFormats    = flag.Bool("compact-chunk-formats", false, "persist chunks in a more compact format than tsz, if there is one for their data. older versions can't read these formats, so only enable once all nodes are upgraded.")
 	chunkMaxStaleStr  = flag.String("chunk-max-stale", "1h", "max age for a chunk before to be considered stale and to be persisted to Cassandra.")
 	metricMaxStaleStr = flag.String("metric-max-stale", "6h", "max age for a metric before to be considered stale and to be purged from memory.")
 	gcIntervalStr     = flag.String("gc-interval", "1h", "Interval to run garbage collection job.")
@@ -141,6 +146,12 @@ func main() {
 	// cassandra Store
 	cassandraStore.ConfigSetup()
 
+	// disk Store
+	diskStore.ConfigSetup()
+
+	// cold Store
+	tiered.ConfigSetup()
+
 	config.ParseAll()
 
 	/***********************************
@@ -203,6 +214,7 @@ func main() {
 	notifierKafka.ConfigProcess(*instance)
 	statsConfig.ConfigProcess(*instance)
 	mdata.ConfigProcess()
+	tiered.ConfigProcess()
 
 	if !inCarbon.Enabled && !inKafkaMdm.Enabled && !inPrometheus.Enabled {
 		log.Fatal(4, "you should enable at least 1 input plugin")
@@ -270,9 +282,25 @@ func main() {
 	/***********************************
 		Initialize our backendStore
 	***********************************/
-	store, err = cassandraStore.NewCassandraStore(cassandraStore.CliConfig, mdata.TTLs())
-	if err != nil {
-		log.Fatal(4, "failed to initialize cassandra. %s", err)
+	// when old chunks are moved to the cold store, the backendStore only needs to keep new chunks for a while
+	ttls := tiered.CliConfig.HotTTLs(mdata.TTLs())
+	if diskStore.CliConfig.Enabled {
+		store, err = diskStore.NewDiskStore(diskStore.CliConfig, ttls)
+		if err != nil {
+			log.Fatal(4, "failed to initialize disk store. %s", err)
+		}
+	} else {
+		store, err = cassandraStore.NewCassandraStore(cassandraStore.CliConfig, ttls)
+		if err != nil {
+			log.Fatal(4, "failed to initialize cassandra. %s", err)
+		}
+	}
+	if tiered.CliConfig.Enabled {
+		coldStore, err = tiered.NewTieredStore(tiered.CliConfig, store)
+		if err != nil {
+			log.Fatal(4, "failed to initialize cold store. %s", err)
+		}
+		store = coldStore
 	}
 	store.SetTracer(tracer)
 
@@ -285,7 +313,17 @@ func main() {
 	/***********************************
 		Initialize our MemoryStore
 	***********************************/
+	chunk.CompactFormats = *compactFormats
 	metrics = mdata.NewAggMetrics(store, ccache, *dropFirstChunk, chunkMaxStale, metricMaxStale, gcInterval)
+	if mdata.SnapshotEnabled {
+		err = metrics.LoadSnapshot(mdata.SnapshotFile)
+		if err != nil {
+			log.Error(3, "failed to restore snapshot %s: %s", mdata.SnapshotFile, err)
+		}
+		if mdata.SnapshotInterval > 0 {
+			metrics.StartSnapshots(mdata.SnapshotFile, mdata.SnapshotInterval)
+		}
+	}
 
 	/***********************************
 		Initialize our Inputs
@@ -367,6 +405,11 @@ func main() {
 	}
 	log.Info("metricIndex initialized in %s. starting data consumption", time.Now().Sub(pre))
 
+	// the cold store finds the series to move in the index
+	if coldStore != nil {
+		coldStore.Start(metricIndex)
+	}
+
 	/***********************************
 		Initialize MetricPersist notifiers
 	***********************************/
@@ -462,8 +505,21 @@ func shutdown() {
 		timer.Stop()
 	}
 
+	if mdata.SnapshotEnabled {
+		metrics.StopSnapshots()
+	}
+
 	log.Info("closing store")
 	store.Stop()
+
+	// the inputs have stopped, and the store has saved what it could: the snapshot has all the data that is left
+	if mdata.SnapshotEnabled {
+		log.Info("writing snapshot")
+		err := metrics.WriteSnapshot(mdata.SnapshotFile)
+		if err != nil {
+			log.Error(3, "failed to write snapshot to %s: %s", mdata.SnapshotFile, err)
+		}
+	}
 	metricIndex.Stop()
 	log.Info("terminating.")
 	log.Close()
@@ -2,7 +2,9 @@ package expr
 
 import (
 	"math"
+	"testing"
 
+	"github.com/grafana/metrictank/api/models"
 	"gopkg.in/raintank/schema.v1"
 )
 
@@ -61,6 +63,15 @@ var d = []schema.Point{
 	{Val: 250, Ts: 60},
 }
 
+var allNaN = []schema.Point{
+	{Val: math.NaN(), Ts: 10},
+	{Val: math.NaN(), Ts: 20},
+	{Val: math.NaN(), Ts: 30},
+	{Val: math.NaN(), Ts: 40},
+	{Val: math.NaN(), Ts: 50},
+	{Val: math.NaN(), Ts: 60},
+}
+
 var sumab = []schema.Point{
 	{Val: 0, Ts: 10},
 	{Val: math.MaxFloat64, Ts: 20},
@@ -238,3 +249,42 @@ func getCopy(in []schema.Point) []schema.Point {
 	copy(out, in)
 	return out
 }
+
+// getSeriesNamed returns series a, b, c, d and allNaN with their name as target and query pattern
+func getSeriesNamed() []models.Series {
+	series := []models.Series{
+		{Target: "a", Datapoints: getCopy(a)},
+		{Target: "b", Datapoints: getCopy(b)},
+		{Target: "c", Datapoints: getCopy(c)},
+		{Target: "d", Datapoints: getCopy(d)},
+		{Target: "allNaN", Datapoints: getCopy(allNaN)},
+	}
+	for i := range series {
+		series[i].QueryPatt = series[i].Target
+		series[i].Interval = 10
+	}
+	return series
+}
+
+// checkSeriesPoints validates the target and datapoints of the given output series
+func checkSeriesPoints(name string, exp, got []models.Series, t *testing.T) {
+	if len(got) != len(exp) {
+		t.Fatalf("case %q: expected %d output series, got %d", name, len(exp), len(got))
+	}
+	for i, o := range exp {
+		g := got[i]
+		if o.Target != g.Target {
+			t.Fatalf("case %q: expected target %q, got %q", name, o.Target, g.Target)
+		}
+		if len(o.Datapoints) != len(g.Datapoints) {
+			t.Fatalf("case %q: len output expected %d, got %d", name, len(o.Datapoints), len(g.Datapoints))
+		}
+		for j, p := range o.Datapoints {
+			bothNaN := math.IsNaN(p.Val) && math.IsNaN(g.Datapoints[j].Val)
+			if (bothNaN || p.Val == g.Datapoints[j].Val) && p.Ts == g.Datapoints[j].Ts {
+				continue
+			}
+			t.Fatalf("case %q: output point %d - expected %v got %v", name, j, p, g.Datapoints[j])
+		}
+	}
+}
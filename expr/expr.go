@@ -1,8 +1,11 @@
 package expr
 
 import (
+	"bytes"
 	"fmt"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -55,6 +58,47 @@ func (e expr) Print(indent int) string {
 	return "HUH-SHOULD-NEVER-HAPPEN"
 }
 
+// normalize writes the expression in a canonical form: whitespace and quoting are made consistent,
+// numbers are written in their shortest form, and keyword arguments are sorted.
+// equivalent expressions that were written differently have the same normalized form.
+func (e expr) normalize(buf *bytes.Buffer) {
+	switch e.etype {
+	case etName:
+		buf.WriteString(e.str)
+	case etBool:
+		buf.WriteString(strconv.FormatBool(e.bool))
+	case etInt:
+		buf.WriteString(strconv.FormatInt(e.int, 10))
+	case etFloat:
+		buf.WriteString(strconv.FormatFloat(e.float, 'g', -1, 64))
+	case etString:
+		buf.WriteString(strconv.Quote(e.str))
+	case etFunc:
+		buf.WriteString(e.str)
+		buf.WriteByte('(')
+		for i, a := range e.args {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			a.normalize(buf)
+		}
+		keys := make([]string, 0, len(e.namedArgs))
+		for k := range e.namedArgs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for i, k := range keys {
+			if i > 0 || len(e.args) > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(k)
+			buf.WriteByte('=')
+			e.namedArgs[k].normalize(buf)
+		}
+		buf.WriteByte(')')
+	}
+}
+
 // consumeBasicArg verifies that the argument at given pos matches the expected arg
 // it's up to the caller to assure that given pos is valid before calling.
 // if arg allows for multiple arguments, pos is advanced to cover all accepted arguments.
@@ -171,6 +215,15 @@ func (e expr) consumeBasicArg(pos int, exp Arg) (int, error) {
 			*v.val = append(*v.val, *e.args[pos])
 		}
 		return pos, nil
+	case ArgIn:
+		alt, err := resolveArgIn(got, v)
+		if err != nil {
+			return 0, err
+		}
+		if alt == nil {
+			break
+		}
+		return e.consumeBasicArg(pos, alt)
 	default:
 		return 0, fmt.Errorf("unsupported type %T for consumeBasicArg", exp)
 	}
@@ -178,6 +231,68 @@ func (e expr) consumeBasicArg(pos int, exp Arg) (int, error) {
 	return pos, nil
 }
 
+// resolveArgIn returns the option of the given ArgIn that matches the type of the given expression.
+// it returns a nil Arg if the expression is None, meaning the argument should be left unset.
+func resolveArgIn(got *expr, in ArgIn) (Arg, error) {
+	if got.etype == etName && got.str == "None" {
+		return nil, nil
+	}
+	for _, a := range in.args {
+		switch a.(type) {
+		case ArgSeries, ArgSeriesList, ArgSeriesLists:
+			if got.etype == etName || got.etype == etFunc {
+				return a, nil
+			}
+		case ArgInt, ArgInts:
+			if got.etype == etInt {
+				return a, nil
+			}
+		case ArgFloat:
+			if got.etype == etFloat || got.etype == etInt {
+				return a, nil
+			}
+		case ArgString, ArgStrings, ArgRegex:
+			if got.etype == etString {
+				return a, nil
+			}
+		case ArgBool:
+			if got.etype == etBool {
+				return a, nil
+			}
+		case ArgStringsOrInts:
+			if got.etype == etString || got.etype == etInt {
+				return a, nil
+			}
+		}
+	}
+	return nil, ErrBadArgumentStr{"one of the types supported by " + in.key, got.etype.String()}
+}
+
+// seriesArg describes where an argument that is a series (or list thereof) was given,
+// so that it can be set up once the context is known.
+// for keyword arguments key is set, otherwise pos is the position in the args.
+type seriesArg struct {
+	pos int
+	key string
+	arg Arg
+}
+
+// asSeriesArg returns the series argument that the given expression resolves to for the given expected argument, if any.
+func asSeriesArg(got *expr, exp Arg) (Arg, bool) {
+	if in, ok := exp.(ArgIn); ok {
+		alt, err := resolveArgIn(got, in)
+		if err != nil || alt == nil {
+			return nil, false
+		}
+		exp = alt
+	}
+	switch exp.(type) {
+	case ArgSeries, ArgSeriesList, ArgSeriesLists:
+		return exp, true
+	}
+	return nil, false
+}
+
 func generateValidatorError(key string, err error) error {
 	if len(key) == 0 {
 		return err
@@ -242,8 +357,10 @@ func (e expr) consumeSeriesArg(pos int, exp Arg, context Context, stable bool, r
 
 // consumeKwarg consumes the kwarg (by key k) and verifies it
 // if the specified argument is valid, it is saved in exp.val
-// where exp is the arg specified by the function that has the given key
-func (e expr) consumeKwarg(key string, optArgs []Arg) error {
+// where exp is the arg specified by the function that has the given key.
+// if the argument is a series (or list thereof), it is returned instead, so it can be set up
+// once the context is known.
+func (e expr) consumeKwarg(key string, optArgs []Arg) (Arg, error) {
 	var found bool
 	var exp Arg
 	for _, exp = range optArgs {
@@ -253,13 +370,28 @@ func (e expr) consumeKwarg(key string, optArgs []Arg) error {
 		}
 	}
 	if !found {
-		return ErrUnknownKwarg{key}
+		return nil, ErrUnknownKwarg{key}
 	}
 	got := e.namedArgs[key]
+	if in, ok := exp.(ArgIn); ok {
+		alt, err := resolveArgIn(got, in)
+		if err != nil {
+			return nil, err
+		}
+		if alt == nil {
+			return nil, nil
+		}
+		exp = alt
+	}
 	switch v := exp.(type) {
+	case ArgSeries, ArgSeriesList, ArgSeriesLists:
+		if got.etype != etName && got.etype != etFunc {
+			return nil, ErrBadKwarg{key, exp, got.etype}
+		}
+		return exp, nil
 	case ArgInt:
 		if got.etype != etInt {
-			return ErrBadKwarg{key, exp, got.etype}
+			return nil, ErrBadKwarg{key, exp, got.etype}
 		}
 		*v.val = got.int
 	case ArgFloat:
@@ -270,11 +402,11 @@ func (e expr) consumeKwarg(key string, optArgs []Arg) error {
 		case etFloat:
 			*v.val = got.float
 		default:
-			return ErrBadKwarg{key, exp, got.etype}
+			return nil, ErrBadKwarg{key, exp, got.etype}
 		}
 	case ArgString:
 		if got.etype != etString {
-			return ErrBadKwarg{key, exp, got.etype}
+			return nil, ErrBadKwarg{key, exp, got.etype}
 		}
 		*v.val = got.str
 	case ArgBool:
@@ -288,9 +420,9 @@ func (e expr) consumeKwarg(key string, optArgs []Arg) error {
 				break
 			}
 		}
-		return ErrBadKwarg{key, exp, got.etype}
+		return nil, ErrBadKwarg{key, exp, got.etype}
 	default:
-		return fmt.Errorf("unsupported type %T for consumeKwarg", exp)
+		return nil, fmt.Errorf("unsupported type %T for consumeKwarg", exp)
 	}
-	return nil
+	return nil, nil
 }
@@ -0,0 +1,21 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+func TestAbsolute(t *testing.T) {
+	NaN := math.NaN()
+	f := NewAbsolute().(*FuncAbsolute)
+	f.in = NewMock([]models.Series{getModel("a", nullsPoints(-1, NaN, 0, 2.5))})
+
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	exp := []models.Series{getModel("absolute(a)", nullsPoints(1, NaN, 0, 2.5))}
+	checkSeriesPoints("absolute", exp, got, t)
+}
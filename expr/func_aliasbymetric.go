@@ -0,0 +1,41 @@
+package expr
+
+import (
+	"github.com/grafana/metrictank/api/models"
+)
+
+type FuncAliasByMetric struct {
+	in GraphiteFunc
+}
+
+func NewAliasByMetric() GraphiteFunc {
+	return &FuncAliasByMetric{}
+}
+
+func (s *FuncAliasByMetric) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncAliasByMetric) Context(context Context) Context {
+	return context
+}
+
+// Exec names every series by the last node of its metric name,
+// so functions applied to the series as well as tags are stripped off.
+func (s *FuncAliasByMetric) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	last := []expr{{etype: etInt, int: -1}}
+	outputs := make([]models.Series, 0, len(series))
+	for _, serie := range series {
+		n := aggKey(serie, last)
+		serie.Target = n
+		serie.QueryPatt = n
+		outputs = append(outputs, serie)
+	}
+	return outputs, nil
+}
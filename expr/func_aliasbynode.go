@@ -18,7 +18,7 @@ func NewAliasByNode() GraphiteFunc {
 func (s *FuncAliasByNode) Signature() ([]Arg, []Arg) {
 	return []Arg{
 		ArgSeriesList{val: &s.in},
-		ArgStringsOrInts{val: &s.nodes},
+		ArgStringsOrInts{key: "nodes", val: &s.nodes},
 	}, []Arg{ArgSeries{}}
 }
 
@@ -31,34 +31,43 @@ func (s *FuncAliasByNode) Exec(cache map[Req][]models.Series) ([]models.Series,
 	if err != nil {
 		return nil, err
 	}
-	for i, serie := range series {
-		// Extract metric may not find a target if `seriesByTag` was used.
-		// If so, then we can try to grab the "name" tag.
-		metric := extractMetric(serie.Target)
-		if len(metric) == 0 {
-			metric = serie.Tags["name"]
-		}
-		// Trim off tags (if they are there) and split on '.'
-		parts := strings.Split(strings.SplitN(metric, ";", 2)[0], ".")
-		var name []string
-		for _, n := range s.nodes {
-			if n.etype == etInt {
-				idx := int(n.int)
-				if idx < 0 {
-					idx += len(parts)
-				}
-				if idx >= len(parts) || idx < 0 {
-					continue
-				}
-				name = append(name, parts[idx])
-			} else if n.etype == etString {
-				s := n.str
-				name = append(name, serie.Tags[s])
+	outputs := make([]models.Series, 0, len(series))
+	for _, serie := range series {
+		n := aggKey(serie, s.nodes)
+		serie.Target = n
+		serie.QueryPatt = n
+		outputs = append(outputs, serie)
+	}
+	return outputs, nil
+}
+
+// aggKey returns the name made up of the given nodes of the series name, joined by '.'.
+// nodes may be ints (node positions, negative values count from the end) or strings (tag names).
+// in accordance with graphite, a node that is out of range or a tag that is not set results in an empty string.
+func aggKey(serie models.Series, nodes []expr) string {
+	// Extract metric may not find a target if `seriesByTag` was used.
+	// If so, then we can try to grab the "name" tag.
+	metric := extractMetric(serie.Target)
+	if len(metric) == 0 {
+		metric = serie.Tags["name"]
+	}
+	// Trim off tags (if they are there) and split on '.'
+	parts := strings.Split(strings.SplitN(metric, ";", 2)[0], ".")
+	var name []string
+	for _, n := range nodes {
+		if n.etype == etInt {
+			idx := int(n.int)
+			if idx < 0 {
+				idx += len(parts)
+			}
+			if idx >= len(parts) || idx < 0 {
+				name = append(name, "")
+				continue
 			}
+			name = append(name, parts[idx])
+		} else if n.etype == etString {
+			name = append(name, serie.Tags[n.str])
 		}
-		n := strings.Join(name, ".")
-		series[i].Target = n
-		series[i].QueryPatt = n
 	}
-	return series, nil
+	return strings.Join(name, ".")
 }
@@ -0,0 +1,118 @@
+package expr
+
+import (
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+func TestAliasByNode(t *testing.T) {
+	cases := []struct {
+		name   string
+		target string
+		tags   map[string]string
+		nodes  []expr
+		exp    string
+	}{
+		{
+			"nodes",
+			"app.web1.cpu.idle",
+			nil,
+			[]expr{{etype: etInt, int: 1}, {etype: etInt, int: -1}},
+			"web1.idle",
+		},
+		{
+			"function applied",
+			"perSecond(app.web1.cpu.idle)",
+			nil,
+			[]expr{{etype: etInt, int: 0}, {etype: etInt, int: 2}},
+			"app.cpu",
+		},
+		{
+			"tags",
+			"cpu.idle;dc=east;host=web1",
+			map[string]string{"name": "cpu.idle", "dc": "east", "host": "web1"},
+			[]expr{{etype: etString, str: "dc"}, {etype: etString, str: "host"}},
+			"east.web1",
+		},
+		{
+			"nodes and tags mixed",
+			"cpu.idle;dc=east;host=web1",
+			map[string]string{"name": "cpu.idle", "dc": "east", "host": "web1"},
+			[]expr{{etype: etString, str: "host"}, {etype: etInt, int: 1}, {etype: etString, str: "name"}},
+			"web1.idle.cpu.idle",
+		},
+		{
+			"missing node and tag",
+			"cpu.idle;dc=east",
+			map[string]string{"name": "cpu.idle", "dc": "east"},
+			[]expr{{etype: etString, str: "dc"}, {etype: etString, str: "host"}, {etype: etInt, int: 5}},
+			"east..",
+		},
+	}
+	for _, c := range cases {
+		in := []models.Series{{Target: c.target, QueryPatt: c.target, Tags: c.tags}}
+		f := NewAliasByNode().(*FuncAliasByNode)
+		f.in = NewMock(in)
+		f.nodes = c.nodes
+		got, err := f.Exec(make(map[Req][]models.Series))
+		if err != nil {
+			t.Fatalf("case %q: unexpected error %s", c.name, err)
+		}
+		if len(got) != 1 || got[0].Target != c.exp || got[0].QueryPatt != c.exp {
+			t.Fatalf("case %q: expected %q, got %v", c.name, c.exp, got)
+		}
+		if in[0].Target != c.target {
+			t.Fatalf("case %q: input was modified: %q", c.name, in[0].Target)
+		}
+	}
+}
+
+func TestAliasByTagsPlan(t *testing.T) {
+	exprs, err := ParseMany([]string{`aliasByTags(seriesByTag('name=cpu.idle'), 'host', 1)`})
+	if err != nil {
+		t.Fatalf("unexpected parse error %s", err)
+	}
+	plan, err := NewPlan(exprs, 1000, 2000, 800, true, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected plan error %s", err)
+	}
+	req := plan.Reqs[0]
+	serie := models.Series{Target: "cpu.idle;host=web1", QueryPatt: `seriesByTag('name=cpu.idle')`}
+	serie.SetTags()
+	got, err := plan.Run(map[Req][]models.Series{req: {serie}})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(got) != 1 || got[0].Target != "web1.idle" {
+		t.Fatalf("expected web1.idle, got %v", got)
+	}
+}
+
+func TestAliasByMetric(t *testing.T) {
+	cases := []struct {
+		target string
+		exp    string
+	}{
+		{"app.web1.cpu.idle", "idle"},
+		{"perSecond(app.web1.cpu.idle)", "idle"},
+		{"sumSeries(app.*.cpu.idle)", "idle"},
+		{"cpu.idle;dc=east", "idle"},
+		{"idle", "idle"},
+	}
+	for _, c := range cases {
+		in := []models.Series{getModel(c.target, a)}
+		f := NewAliasByMetric().(*FuncAliasByMetric)
+		f.in = NewMock(in)
+		got, err := f.Exec(make(map[Req][]models.Series))
+		if err != nil {
+			t.Fatalf("case %q: unexpected error %s", c.target, err)
+		}
+		if len(got) != 1 || got[0].Target != c.exp || got[0].QueryPatt != c.exp {
+			t.Fatalf("case %q: expected %q, got %v", c.target, c.exp, got)
+		}
+		if in[0].Target != c.target {
+			t.Fatalf("case %q: input was modified: %q", c.target, in[0].Target)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package expr
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+type FuncApplyByNode struct {
+	in               GraphiteFunc
+	nodeNum          int64
+	templateFunction string
+	newName          string
+
+	planned  bool
+	prefixes []string       // sorted prefixes of the input series
+	fns      []GraphiteFunc // the planned template for each prefix
+}
+
+func NewApplyByNode() GraphiteFunc {
+	return &FuncApplyByNode{}
+}
+
+func (s *FuncApplyByNode) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgInt{key: "nodeNum", val: &s.nodeNum},
+		ArgString{key: "templateFunction", val: &s.templateFunction},
+		ArgString{key: "newName", opt: true, val: &s.newName},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncApplyByNode) Context(context Context) Context {
+	return context
+}
+
+// PlanDynamic determines the unique prefixes (up to and including nodeNum) of the input series,
+// and plans the template with '%' replaced by each of them.
+func (s *FuncApplyByNode) PlanDynamic(cache map[Req][]models.Series, plan func(target string) (GraphiteFunc, error)) error {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]struct{})
+	for _, serie := range series {
+		prefix := applyByNodePrefix(serie.Target, int(s.nodeNum))
+		if _, ok := seen[prefix]; ok {
+			continue
+		}
+		seen[prefix] = struct{}{}
+		s.prefixes = append(s.prefixes, prefix)
+	}
+	sort.Strings(s.prefixes)
+
+	for _, prefix := range s.prefixes {
+		fn, err := plan(strings.Replace(s.templateFunction, "%", prefix, -1))
+		if err != nil {
+			return err
+		}
+		s.fns = append(s.fns, fn)
+	}
+	s.planned = true
+	return nil
+}
+
+func (s *FuncApplyByNode) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	if !s.planned {
+		return nil, errNotPlanned
+	}
+	var outputs []models.Series
+	for i, fn := range s.fns {
+		series, err := fn.Exec(cache)
+		if err != nil {
+			return nil, err
+		}
+		for _, serie := range series {
+			if s.newName != "" {
+				name := strings.Replace(s.newName, "%", s.prefixes[i], -1)
+				serie.Target = name
+				serie.QueryPatt = name
+			}
+			outputs = append(outputs, serie)
+		}
+	}
+	return outputs, nil
+}
+
+// applyByNodePrefix returns the first nodes of the given name, up to and including nodeNum.
+// in accordance with graphite (which uses a python slice), a negative nodeNum counts from the end.
+func applyByNodePrefix(target string, nodeNum int) string {
+	parts := strings.Split(strings.SplitN(target, ";", 2)[0], ".")
+	end := nodeNum + 1
+	if end < 0 {
+		end += len(parts)
+		if end < 0 {
+			end = 0
+		}
+	}
+	if end > len(parts) {
+		end = len(parts)
+	}
+	return strings.Join(parts[:end], ".")
+}
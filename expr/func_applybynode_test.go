@@ -0,0 +1,236 @@
+package expr
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+func TestApplyByNodePrefix(t *testing.T) {
+	cases := []struct {
+		target  string
+		nodeNum int
+		exp     string
+	}{
+		{"a.b.c.d", 0, "a"},
+		{"a.b.c.d", 1, "a.b"},
+		{"a.b.c.d", 3, "a.b.c.d"},
+		{"a.b.c.d", 10, "a.b.c.d"},
+		{"a.b.c.d", -2, "a.b.c"},
+		{"a.b.c.d", -1, ""},
+		{"a.b.c.d", -10, ""},
+		{"a.b.c.d;foo=bar", 1, "a.b"},
+	}
+	for _, c := range cases {
+		got := applyByNodePrefix(c.target, c.nodeNum)
+		if got != c.exp {
+			t.Fatalf("case %q, %d: expected %q, got %q", c.target, c.nodeNum, c.exp, got)
+		}
+	}
+}
+
+func TestApplyByNode(t *testing.T) {
+	from := uint32(1000)
+	to := uint32(2000)
+	exprs, err := ParseMany([]string{`applyByNode(app.*.cpu, 1, "sumSeries(%.*)", "%.total")`})
+	if err != nil {
+		t.Fatalf("unexpected parse error %s", err)
+	}
+	plan, err := NewPlan(exprs, from, to, 800, true, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected plan error %s", err)
+	}
+	if !reflect.DeepEqual(plan.Reqs, []Req{NewReq("app.*.cpu", from, to, 0)}) {
+		t.Fatalf("unexpected reqs %v", plan.Reqs)
+	}
+
+	data := map[Req][]models.Series{
+		NewReq("app.*.cpu", from, to, 0): {
+			getModel("app.b.cpu", c),
+			getModel("app.a.cpu", c),
+		},
+	}
+	reqs, err := plan.DynamicReqs(data)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	expReqs := []Req{
+		NewReq("app.a.*", from, to, 0),
+		NewReq("app.b.*", from, to, 0),
+	}
+	if !reflect.DeepEqual(reqs, expReqs) {
+		t.Fatalf("expected dynamic reqs %v, got %v", expReqs, reqs)
+	}
+
+	data[expReqs[0]] = []models.Series{getModel("app.a.cpu", c), getModel("app.a.mem", d)}
+	data[expReqs[1]] = []models.Series{getModel("app.b.cpu", c)}
+	reqs, err = plan.DynamicReqs(data)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(reqs) != 0 {
+		t.Fatalf("expected no more dynamic reqs, got %v", reqs)
+	}
+
+	got, err := plan.Run(data)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	exp := []models.Series{
+		getModel("app.a.total", sumcd),
+		getModel("app.b.total", c),
+	}
+	checkSeriesPoints("applyByNode", exp, got, t)
+}
+
+func TestApplyByNodeNested(t *testing.T) {
+	from := uint32(1000)
+	to := uint32(2000)
+	// note: like in graphite, all occurrences of '%' in the template are replaced, including those of a nested template
+	exprs, err := ParseMany([]string{`applyByNode(app.*, 0, "applyByNode(%.*.cpu, 1, 'sumSeries(app.*.*.cpu)')")`})
+	if err != nil {
+		t.Fatalf("unexpected parse error %s", err)
+	}
+	plan, err := NewPlan(exprs, from, to, 800, true, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected plan error %s", err)
+	}
+	data := map[Req][]models.Series{
+		NewReq("app.*", from, to, 0): {
+			getModel("app.a", c),
+		},
+	}
+
+	// first round: the outer applyByNode plans its template
+	reqs, err := plan.DynamicReqs(data)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	expReqs := []Req{NewReq("app.*.cpu", from, to, 0)}
+	if !reflect.DeepEqual(reqs, expReqs) {
+		t.Fatalf("round 1: expected dynamic reqs %v, got %v", expReqs, reqs)
+	}
+	data[expReqs[0]] = []models.Series{getModel("app.a.cpu", c)}
+
+	// second round: the inner applyByNode plans its template
+	reqs, err = plan.DynamicReqs(data)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	expReqs = []Req{NewReq("app.*.*.cpu", from, to, 0)}
+	if !reflect.DeepEqual(reqs, expReqs) {
+		t.Fatalf("round 2: expected dynamic reqs %v, got %v", expReqs, reqs)
+	}
+	x := getModel("app.a.x.cpu", c)
+	y := getModel("app.a.y.cpu", d)
+	x.QueryPatt = "app.*.*.cpu"
+	y.QueryPatt = "app.*.*.cpu"
+	data[expReqs[0]] = []models.Series{x, y}
+
+	reqs, err = plan.DynamicReqs(data)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(reqs) != 0 {
+		t.Fatalf("expected no more dynamic reqs, got %v", reqs)
+	}
+	got, err := plan.Run(data)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	exp := []models.Series{
+		getModel("sumSeries(app.*.*.cpu)", sumcd),
+	}
+	checkSeriesPoints("applyByNode-nested", exp, got, t)
+}
+
+func TestApplyByNodeNestedInput(t *testing.T) {
+	from := uint32(1000)
+	to := uint32(2000)
+	exprs, err := ParseMany([]string{`applyByNode(applyByNode(app.*.cpu, 1, "%.mem"), 1, "sumSeries(%.*)", "%.total")`})
+	if err != nil {
+		t.Fatalf("unexpected parse error %s", err)
+	}
+	plan, err := NewPlan(exprs, from, to, 800, true, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected plan error %s", err)
+	}
+	data := map[Req][]models.Series{
+		NewReq("app.*.cpu", from, to, 0): {
+			getModel("app.a.cpu", c),
+		},
+	}
+
+	// first round: the inner applyByNode plans its template.
+	// the outer one has to wait for that data
+	reqs, err := plan.DynamicReqs(data)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	expReqs := []Req{NewReq("app.a.mem", from, to, 0)}
+	if !reflect.DeepEqual(reqs, expReqs) {
+		t.Fatalf("round 1: expected dynamic reqs %v, got %v", expReqs, reqs)
+	}
+	data[expReqs[0]] = []models.Series{getModel("app.a.mem", d)}
+
+	// second round: the outer applyByNode plans its template, based on the output of the inner one
+	reqs, err = plan.DynamicReqs(data)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	expReqs = []Req{NewReq("app.a.*", from, to, 0)}
+	if !reflect.DeepEqual(reqs, expReqs) {
+		t.Fatalf("round 2: expected dynamic reqs %v, got %v", expReqs, reqs)
+	}
+	data[expReqs[0]] = []models.Series{getModel("app.a.cpu", c), getModel("app.a.mem", d)}
+
+	reqs, err = plan.DynamicReqs(data)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(reqs) != 0 {
+		t.Fatalf("expected no more dynamic reqs, got %v", reqs)
+	}
+	got, err := plan.Run(data)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	exp := []models.Series{
+		getModel("app.a.total", sumcd),
+	}
+	checkSeriesPoints("applyByNode-nested-input", exp, got, t)
+}
+
+func TestApplyByNodeNotPlanned(t *testing.T) {
+	exprs, err := ParseMany([]string{`applyByNode(app.*.cpu, 1, "sumSeries(%.*)")`})
+	if err != nil {
+		t.Fatalf("unexpected parse error %s", err)
+	}
+	plan, err := NewPlan(exprs, 1000, 2000, 800, true, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected plan error %s", err)
+	}
+	_, err = plan.Run(make(map[Req][]models.Series))
+	if err != errNotPlanned {
+		t.Fatalf("expected error %q, got %v", errNotPlanned, err)
+	}
+}
+
+func TestApplyByNodeInvalidTemplate(t *testing.T) {
+	exprs, err := ParseMany([]string{`applyByNode(app.*.cpu, 1, "sumSeries(%.*")`})
+	if err != nil {
+		t.Fatalf("unexpected parse error %s", err)
+	}
+	plan, err := NewPlan(exprs, 1000, 2000, 800, true, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected plan error %s", err)
+	}
+	data := map[Req][]models.Series{
+		NewReq("app.*.cpu", 1000, 2000, 0): {getModel("app.a.cpu", c)},
+	}
+	_, err = plan.DynamicReqs(data)
+	if err == nil {
+		t.Fatalf("expected an error for an invalid template, got nil")
+	}
+}
@@ -0,0 +1,232 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+var errAsPercentTotalLength = errors.New("asPercent second argument must be missing, a single digit, reference exactly 1 series or reference the same number of series as the first argument")
+var errAsPercentNodesTotal = errors.New("asPercent total must be None or a seriesList when nodes are specified")
+
+type FuncAsPercent struct {
+	in          GraphiteFunc
+	totalFloat  float64
+	totalSeries GraphiteFunc
+	nodes       []expr
+}
+
+func NewAsPercent() GraphiteFunc {
+	return &FuncAsPercent{totalFloat: math.NaN()}
+}
+
+func (s *FuncAsPercent) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgIn{
+			key: "total",
+			opt: true,
+			args: []Arg{
+				ArgFloat{val: &s.totalFloat},
+				ArgSeriesList{val: &s.totalSeries},
+			},
+		},
+		ArgStringsOrInts{key: "nodes", opt: true, val: &s.nodes},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncAsPercent) Context(context Context) Context {
+	return context
+}
+
+func (s *FuncAsPercent) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	var totals []models.Series
+	if s.totalSeries != nil {
+		totals, err = s.totalSeries.Exec(cache)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(s.nodes) > 0 {
+		if !math.IsNaN(s.totalFloat) {
+			return nil, errAsPercentNodesTotal
+		}
+		return s.execNodes(cache, series, totals)
+	}
+
+	var outputs []models.Series
+	switch {
+	case s.totalSeries != nil && len(totals) == 1:
+		for _, serie := range series {
+			outputs = append(outputs, asPercentSeries(cache, serie, totals[0], totals[0].Target))
+		}
+	case s.totalSeries != nil:
+		// like divideSeriesLists, series and totals are matched by their position
+		if len(totals) != len(series) {
+			return nil, errAsPercentTotalLength
+		}
+		for i, serie := range series {
+			outputs = append(outputs, asPercentSeries(cache, serie, totals[i], totals[i].Target))
+		}
+	case !math.IsNaN(s.totalFloat):
+		for _, serie := range series {
+			out := pointSlicePool.Get().([]schema.Point)
+			for _, p := range serie.Datapoints {
+				out = append(out, schema.Point{Val: asPercent(p.Val, s.totalFloat), Ts: p.Ts})
+			}
+			outputs = append(outputs, asPercentOutput(cache, serie, out, fmt.Sprintf("%g", s.totalFloat)))
+		}
+	default:
+		if len(series) == 0 {
+			return nil, nil
+		}
+		total := sumSeriesForAsPercent(cache, series)
+		for _, serie := range series {
+			outputs = append(outputs, asPercentSeries(cache, serie, total, total.Target))
+		}
+	}
+	return outputs, nil
+}
+
+// execNodes groups the series and the totals by the given nodes, and computes every series as a percentage
+// of the total of its group. if no totals are given, the total of a group is the sum of its series.
+// in accordance with graphite, the groups are returned sorted by key, and a group that only has series
+// (or only a total) results in series of nulls.
+func (s *FuncAsPercent) execNodes(cache map[Req][]models.Series, series, totals []models.Series) ([]models.Series, error) {
+	var keys []string
+	groups := make(map[string][]models.Series)
+	for _, serie := range series {
+		key := aggKey(serie, s.nodes)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], serie)
+	}
+
+	totalGroups := make(map[string][]models.Series)
+	if s.totalSeries == nil {
+		for key, group := range groups {
+			totalGroups[key] = group
+		}
+	} else {
+		for _, serie := range totals {
+			key := aggKey(serie, s.nodes)
+			if _, ok := groups[key]; !ok {
+				if _, ok := totalGroups[key]; !ok {
+					keys = append(keys, key)
+				}
+			}
+			totalGroups[key] = append(totalGroups[key], serie)
+		}
+	}
+	sort.Strings(keys)
+
+	var outputs []models.Series
+	for _, key := range keys {
+		group, ok := groups[key]
+		totalGroup, totalOk := totalGroups[key]
+		if !ok {
+			total := sumSeriesForAsPercent(cache, totalGroup)
+			outputs = append(outputs, asPercentOutput(cache, total, asPercentNulls(total.Datapoints), "MISSING", total.Target))
+			continue
+		}
+		if !totalOk {
+			for _, serie := range group {
+				outputs = append(outputs, asPercentOutput(cache, serie, asPercentNulls(serie.Datapoints), "MISSING"))
+			}
+			continue
+		}
+		total := sumSeriesForAsPercent(cache, totalGroup)
+		for _, serie := range group {
+			outputs = append(outputs, asPercentSeries(cache, serie, total, total.Target))
+		}
+	}
+	return outputs, nil
+}
+
+// asPercentSeries returns the given series as a percentage of the given total series
+func asPercentSeries(cache map[Req][]models.Series, serie, total models.Series, totalName string) models.Series {
+	out := pointSlicePool.Get().([]schema.Point)
+	for i, p := range serie.Datapoints {
+		totalVal := math.NaN()
+		if i < len(total.Datapoints) {
+			totalVal = total.Datapoints[i].Val
+		}
+		out = append(out, schema.Point{Val: asPercent(p.Val, totalVal), Ts: p.Ts})
+	}
+	return asPercentOutput(cache, serie, out, totalName)
+}
+
+// asPercentOutput creates the output series with the given points, and adds it to the cache.
+// the name is made up of the given names, or the name of the given series if only one name is given.
+func asPercentOutput(cache map[Req][]models.Series, serie models.Series, points []schema.Point, names ...string) models.Series {
+	if len(names) == 1 {
+		names = []string{serie.Target, names[0]}
+	}
+	name := fmt.Sprintf("asPercent(%s)", strings.Join(names, ","))
+	output := models.Series{
+		Target:       name,
+		QueryPatt:    name,
+		Tags:         map[string]string{"name": name},
+		Datapoints:   points,
+		Interval:     serie.Interval,
+		Consolidator: serie.Consolidator,
+		QueryCons:    serie.QueryCons,
+	}
+	cache[Req{}] = append(cache[Req{}], output)
+	return output
+}
+
+// asPercentNulls returns a slice of nulls for the timestamps of the given points
+func asPercentNulls(in []schema.Point) []schema.Point {
+	out := pointSlicePool.Get().([]schema.Point)
+	for _, p := range in {
+		out = append(out, schema.Point{Val: math.NaN(), Ts: p.Ts})
+	}
+	return out
+}
+
+// sumSeriesForAsPercent returns the sum of the given series, named like graphite's sumSeries would.
+// a single series is returned as is.
+func sumSeriesForAsPercent(cache map[Req][]models.Series, series []models.Series) models.Series {
+	if len(series) == 1 {
+		return series[0]
+	}
+	var patts []string
+	seen := make(map[string]struct{})
+	for _, serie := range series {
+		if _, ok := seen[serie.QueryPatt]; ok {
+			continue
+		}
+		seen[serie.QueryPatt] = struct{}{}
+		patts = append(patts, serie.QueryPatt)
+	}
+	name := fmt.Sprintf("sumSeries(%s)", strings.Join(patts, ","))
+	sum := models.Series{
+		Target:     name,
+		QueryPatt:  name,
+		Interval:   series[0].Interval,
+		Datapoints: pointSlicePool.Get().([]schema.Point),
+	}
+	crossSeriesSum(series, &sum.Datapoints)
+	cache[Req{}] = append(cache[Req{}], sum)
+	return sum
+}
+
+// asPercent returns val as a percentage of total, or null if either is null or total is 0
+func asPercent(val, total float64) float64 {
+	if math.IsNaN(val) || math.IsNaN(total) || total == 0 {
+		return math.NaN()
+	}
+	return val / total * 100
+}
@@ -0,0 +1,338 @@
+package expr
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/test"
+	"gopkg.in/raintank/schema.v1"
+)
+
+var tens = []schema.Point{
+	{Val: 10, Ts: 10},
+	{Val: 10, Ts: 20},
+	{Val: 10, Ts: 30},
+	{Val: 10, Ts: 40},
+	{Val: 10, Ts: 50},
+	{Val: 10, Ts: 60},
+}
+
+var zeroes = []schema.Point{
+	{Val: 0, Ts: 10},
+	{Val: 0, Ts: 20},
+	{Val: 0, Ts: 30},
+	{Val: 0, Ts: 40},
+	{Val: 0, Ts: 50},
+	{Val: 0, Ts: 60},
+}
+
+var cPercentOfTens = []schema.Point{
+	{Val: 0, Ts: 10},
+	{Val: 0, Ts: 20},
+	{Val: 10, Ts: 30},
+	{Val: 20, Ts: 40},
+	{Val: 30, Ts: 50},
+	{Val: 40, Ts: 60},
+}
+
+// pct computes the expected percentage at runtime, in the same way as asPercent
+func pct(val, total float64) float64 {
+	return val / total * 100
+}
+
+func TestAsPercentPlan(t *testing.T) {
+	from := uint32(1000)
+	to := uint32(2000)
+	cases := []struct {
+		target string
+		exp    []Req
+	}{
+		{"asPercent(a)", []Req{NewReq("a", from, to, 0)}},
+		{"asPercent(a, 100)", []Req{NewReq("a", from, to, 0)}},
+		{"asPercent(a, b)", []Req{NewReq("a", from, to, 0), NewReq("b", from, to, 0)}},
+		{"asPercent(a, None, 1)", []Req{NewReq("a", from, to, 0)}},
+		{"asPercent(a, b, 1, 2)", []Req{NewReq("a", from, to, 0), NewReq("b", from, to, 0)}},
+		{"asPercent(a, total=sumSeries(b))", []Req{NewReq("a", from, to, 0), NewReq("b", from, to, 0)}},
+		{"asPercent(a, total=10)", []Req{NewReq("a", from, to, 0)}},
+	}
+	for _, c := range cases {
+		exprs, err := ParseMany([]string{c.target})
+		if err != nil {
+			t.Fatalf("case %q: unexpected parse error %s", c.target, err)
+		}
+		plan, err := NewPlan(exprs, from, to, 800, true, nil, nil)
+		if err != nil {
+			t.Fatalf("case %q: unexpected plan error %s", c.target, err)
+		}
+		if len(plan.Reqs) != len(c.exp) {
+			t.Fatalf("case %q: expected reqs %v, got %v", c.target, c.exp, plan.Reqs)
+		}
+		for i := range c.exp {
+			if plan.Reqs[i] != c.exp[i] {
+				t.Fatalf("case %q: expected reqs %v, got %v", c.target, c.exp, plan.Reqs)
+			}
+		}
+	}
+}
+
+func TestAsPercentPlanInvalid(t *testing.T) {
+	for _, target := range []string{
+		"asPercent(a, 'foo')",
+		"asPercent(a, true)",
+	} {
+		exprs, err := ParseMany([]string{target})
+		if err != nil {
+			t.Fatalf("case %q: unexpected parse error %s", target, err)
+		}
+		_, err = NewPlan(exprs, 1000, 2000, 800, true, nil, nil)
+		if err == nil {
+			t.Fatalf("case %q: expected plan error, got none", target)
+		}
+	}
+}
+
+func TestAsPercentConstant(t *testing.T) {
+	f := NewAsPercent().(*FuncAsPercent)
+	f.in = NewMock([]models.Series{getModel("c", c)})
+	f.totalFloat = 10
+	exp := []models.Series{getModel("asPercent(c,10)", cPercentOfTens)}
+	testAsPercent("constant", f, exp, t)
+}
+
+func TestAsPercentConstantZero(t *testing.T) {
+	f := NewAsPercent().(*FuncAsPercent)
+	f.in = NewMock([]models.Series{getModel("c", c)})
+	f.totalFloat = 0
+	exp := []models.Series{getModel("asPercent(c,0)", allNaN)}
+	testAsPercent("constant zero", f, exp, t)
+}
+
+func TestAsPercentSingleTotal(t *testing.T) {
+	f := NewAsPercent().(*FuncAsPercent)
+	f.in = NewMock([]models.Series{getModel("c", c), getModel("tens", tens)})
+	f.totalSeries = NewMock([]models.Series{getModel("total", tens)})
+	exp := []models.Series{
+		getModel("asPercent(c,total)", cPercentOfTens),
+		getModel("asPercent(tens,total)", []schema.Point{
+			{Val: 100, Ts: 10},
+			{Val: 100, Ts: 20},
+			{Val: 100, Ts: 30},
+			{Val: 100, Ts: 40},
+			{Val: 100, Ts: 50},
+			{Val: 100, Ts: 60},
+		}),
+	}
+	testAsPercent("single total", f, exp, t)
+}
+
+func TestAsPercentTotalList(t *testing.T) {
+	f := NewAsPercent().(*FuncAsPercent)
+	f.in = NewMock([]models.Series{getModel("c", c), getModel("a", a)})
+	f.totalSeries = NewMock([]models.Series{getModel("tens", tens), getModel("zeroes", zeroes)})
+	exp := []models.Series{
+		getModel("asPercent(c,tens)", cPercentOfTens),
+		getModel("asPercent(a,zeroes)", allNaN),
+	}
+	testAsPercent("total list", f, exp, t)
+}
+
+func TestAsPercentTotalListMismatch(t *testing.T) {
+	f := NewAsPercent().(*FuncAsPercent)
+	f.in = NewMock([]models.Series{getModel("c", c), getModel("a", a), getModel("d", d)})
+	f.totalSeries = NewMock([]models.Series{getModel("tens", tens), getModel("zeroes", zeroes)})
+	_, err := f.Exec(make(map[Req][]models.Series))
+	if err != errAsPercentTotalLength {
+		t.Fatalf("expected error %q, got %v", errAsPercentTotalLength, err)
+	}
+}
+
+func TestAsPercentNoTotal(t *testing.T) {
+	f := NewAsPercent().(*FuncAsPercent)
+	f.in = NewMock([]models.Series{getModel("c", c), getModel("tens", tens)})
+	exp := []models.Series{
+		getModel("asPercent(c,sumSeries(c,tens))", []schema.Point{
+			{Val: 0, Ts: 10},
+			{Val: 0, Ts: 20},
+			{Val: pct(1.0, 11), Ts: 30},
+			{Val: pct(2.0, 12), Ts: 40},
+			{Val: pct(3.0, 13), Ts: 50},
+			{Val: pct(4.0, 14), Ts: 60},
+		}),
+		getModel("asPercent(tens,sumSeries(c,tens))", []schema.Point{
+			{Val: 100, Ts: 10},
+			{Val: 100, Ts: 20},
+			{Val: pct(10.0, 11), Ts: 30},
+			{Val: pct(10.0, 12), Ts: 40},
+			{Val: pct(10.0, 13), Ts: 50},
+			{Val: pct(10.0, 14), Ts: 60},
+		}),
+	}
+	testAsPercent("no total", f, exp, t)
+}
+
+func TestAsPercentNodes(t *testing.T) {
+	f := NewAsPercent().(*FuncAsPercent)
+	f.in = NewMock([]models.Series{
+		getModel("app.b.cpu", c),
+		getModel("app.a.cpu", tens),
+		getModel("app.c.cpu", c),
+	})
+	f.totalSeries = NewMock([]models.Series{
+		getModel("app.a.total", tens),
+		getModel("app.b.total", tens),
+		getModel("app.d.total", tens),
+	})
+	f.nodes = []expr{{etype: etInt, int: 1}}
+	exp := []models.Series{
+		getModel("asPercent(app.a.cpu,app.a.total)", []schema.Point{
+			{Val: 100, Ts: 10},
+			{Val: 100, Ts: 20},
+			{Val: 100, Ts: 30},
+			{Val: 100, Ts: 40},
+			{Val: 100, Ts: 50},
+			{Val: 100, Ts: 60},
+		}),
+		getModel("asPercent(app.b.cpu,app.b.total)", cPercentOfTens),
+		getModel("asPercent(app.c.cpu,MISSING)", allNaN),
+		getModel("asPercent(MISSING,app.d.total)", allNaN),
+	}
+	testAsPercent("nodes", f, exp, t)
+}
+
+func TestAsPercentNodesNoTotal(t *testing.T) {
+	f := NewAsPercent().(*FuncAsPercent)
+	f.in = NewMock([]models.Series{
+		getModel("app.b.cpu", tens),
+		getModel("app.a.cpu", c),
+		getModel("app.a.mem", tens),
+	})
+	f.nodes = []expr{{etype: etInt, int: 1}}
+	exp := []models.Series{
+		getModel("asPercent(app.a.cpu,sumSeries(app.a.cpu,app.a.mem))", []schema.Point{
+			{Val: 0, Ts: 10},
+			{Val: 0, Ts: 20},
+			{Val: pct(1.0, 11), Ts: 30},
+			{Val: pct(2.0, 12), Ts: 40},
+			{Val: pct(3.0, 13), Ts: 50},
+			{Val: pct(4.0, 14), Ts: 60},
+		}),
+		getModel("asPercent(app.a.mem,sumSeries(app.a.cpu,app.a.mem))", []schema.Point{
+			{Val: 100, Ts: 10},
+			{Val: 100, Ts: 20},
+			{Val: pct(10.0, 11), Ts: 30},
+			{Val: pct(10.0, 12), Ts: 40},
+			{Val: pct(10.0, 13), Ts: 50},
+			{Val: pct(10.0, 14), Ts: 60},
+		}),
+		getModel("asPercent(app.b.cpu,app.b.cpu)", []schema.Point{
+			{Val: 100, Ts: 10},
+			{Val: 100, Ts: 20},
+			{Val: 100, Ts: 30},
+			{Val: 100, Ts: 40},
+			{Val: 100, Ts: 50},
+			{Val: 100, Ts: 60},
+		}),
+	}
+	testAsPercent("nodes without total", f, exp, t)
+}
+
+func TestAsPercentNodesConstant(t *testing.T) {
+	f := NewAsPercent().(*FuncAsPercent)
+	f.in = NewMock([]models.Series{getModel("app.a.cpu", c)})
+	f.totalFloat = 10
+	f.nodes = []expr{{etype: etInt, int: 1}}
+	_, err := f.Exec(make(map[Req][]models.Series))
+	if err != errAsPercentNodesTotal {
+		t.Fatalf("expected error %q, got %v", errAsPercentNodesTotal, err)
+	}
+}
+
+func testAsPercent(name string, f *FuncAsPercent, exp []models.Series, t *testing.T) {
+	inputCopy := []schema.Point{}
+	in, _ := f.in.Exec(nil)
+	for _, serie := range in {
+		inputCopy = append(inputCopy, serie.Datapoints...)
+	}
+
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("case %q: unexpected error %s", name, err)
+	}
+	checkSeriesPoints(name, exp, got, t)
+
+	// make sure the input hasn't been modified
+	i := 0
+	for _, serie := range in {
+		for _, p := range serie.Datapoints {
+			q := inputCopy[i]
+			if !(math.IsNaN(p.Val) && math.IsNaN(q.Val)) && p != q {
+				t.Fatalf("case %q: input was modified: expected %v, got %v", name, q, p)
+			}
+			i++
+		}
+	}
+}
+
+func BenchmarkAsPercent10k_1NoNulls(b *testing.B) {
+	benchmarkAsPercent(b, 1, test.RandFloats10k, test.RandFloats10k)
+}
+func BenchmarkAsPercent10k_10NoNulls(b *testing.B) {
+	benchmarkAsPercent(b, 10, test.RandFloats10k, test.RandFloats10k)
+}
+func BenchmarkAsPercent10k_100NoNulls(b *testing.B) {
+	benchmarkAsPercent(b, 100, test.RandFloats10k, test.RandFloats10k)
+}
+func BenchmarkAsPercent10k_1000NoNulls(b *testing.B) {
+	benchmarkAsPercent(b, 1000, test.RandFloats10k, test.RandFloats10k)
+}
+func BenchmarkAsPercent10k_1SomeSeriesHalfNulls(b *testing.B) {
+	benchmarkAsPercent(b, 1, test.RandFloats10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkAsPercent10k_10SomeSeriesHalfNulls(b *testing.B) {
+	benchmarkAsPercent(b, 10, test.RandFloats10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkAsPercent10k_100SomeSeriesHalfNulls(b *testing.B) {
+	benchmarkAsPercent(b, 100, test.RandFloats10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkAsPercent10k_1000SomeSeriesHalfNulls(b *testing.B) {
+	benchmarkAsPercent(b, 1000, test.RandFloats10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkAsPercent10k_1AllSeriesHalfNulls(b *testing.B) {
+	benchmarkAsPercent(b, 1, test.RandFloatsWithNulls10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkAsPercent10k_10AllSeriesHalfNulls(b *testing.B) {
+	benchmarkAsPercent(b, 10, test.RandFloatsWithNulls10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkAsPercent10k_100AllSeriesHalfNulls(b *testing.B) {
+	benchmarkAsPercent(b, 100, test.RandFloatsWithNulls10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkAsPercent10k_1000AllSeriesHalfNulls(b *testing.B) {
+	benchmarkAsPercent(b, 1000, test.RandFloatsWithNulls10k, test.RandFloatsWithNulls10k)
+}
+func benchmarkAsPercent(b *testing.B, numSeries int, fn0, fn1 func() []schema.Point) {
+	var input []models.Series
+	for i := 0; i < numSeries; i++ {
+		series := models.Series{
+			QueryPatt: strconv.Itoa(i),
+		}
+		if i%2 == 0 {
+			series.Datapoints = fn0()
+		} else {
+			series.Datapoints = fn1()
+		}
+		input = append(input, series)
+	}
+	b.ResetTimer()
+	var err error
+	for i := 0; i < b.N; i++ {
+		f := NewAsPercent().(*FuncAsPercent)
+		f.in = NewMock(input)
+		results, err = f.Exec(make(map[Req][]models.Series))
+		if err != nil {
+			b.Fatalf("%s", err)
+		}
+	}
+	b.SetBytes(int64(numSeries * len(results[0].Datapoints) * 12))
+}
@@ -0,0 +1,102 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/batch"
+	"gopkg.in/raintank/schema.v1"
+)
+
+type FuncCactiStyle struct {
+	in     GraphiteFunc
+	system string
+	units  string
+}
+
+func NewCactiStyle() GraphiteFunc {
+	return &FuncCactiStyle{}
+}
+
+func (s *FuncCactiStyle) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgString{key: "system", opt: true, validator: []Validator{IsUnitSystem}, val: &s.system},
+		ArgString{key: "units", opt: true, val: &s.units},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncCactiStyle) Context(context Context) Context {
+	return context
+}
+
+// Exec appends the current, max and min value to the name of every series, in columns aligned across all series.
+// this is a port of graphite's cactiStyle, including how it determines the column widths.
+func (s *FuncCactiStyle) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	var nameLen, lastLen, maxLen, minLen int
+	for _, serie := range series {
+		nameLen = maxInt(nameLen, len(serie.Target))
+		lastLen = maxInt(lastLen, len(s.format(cactiWidthValue(batch.Lst, serie.Datapoints))))
+		maxLen = maxInt(maxLen, len(s.format(cactiWidthValue(batch.Max, serie.Datapoints))))
+		minLen = maxInt(minLen, len(s.format(cactiWidthValue(batch.Min, serie.Datapoints))))
+	}
+	lastLen += 3
+	maxLen += 3
+	minLen += 3
+
+	outputs := make([]models.Series, 0, len(series))
+	for _, serie := range series {
+		name := fmt.Sprintf("%-*s Current:%-*s Max:%-*s Min:%-*s ",
+			nameLen, serie.Target,
+			lastLen, s.formatOrNaN(batch.Lst(serie.Datapoints)),
+			maxLen, s.formatOrNaN(batch.Max(serie.Datapoints)),
+			minLen, s.formatOrNaN(batch.Min(serie.Datapoints)),
+		)
+		serie.Target = name
+		serie.QueryPatt = name
+		outputs = append(outputs, serie)
+	}
+	return outputs, nil
+}
+
+func (s *FuncCactiStyle) format(v float64) string {
+	prefix := ""
+	if s.system != "" {
+		v, prefix = formatUnits(v, s.system)
+	}
+	if s.units != "" {
+		return fmt.Sprintf("%.2f %s%s", v, prefix, s.units)
+	}
+	return fmt.Sprintf("%.2f%s", v, prefix)
+}
+
+// formatOrNaN formats the value, or returns "nan" for a missing value, like graphite
+func (s *FuncCactiStyle) formatOrNaN(v float64) string {
+	if math.IsNaN(v) {
+		return "nan"
+	}
+	return s.format(v)
+}
+
+// cactiWidthValue returns the value used to determine the width of a column.
+// like graphite, it's the truncated value, or 3 if the value is null or 0.
+func cactiWidthValue(fn func([]schema.Point) float64, points []schema.Point) float64 {
+	v := fn(points)
+	if math.IsNaN(v) || v == 0 {
+		return 3
+	}
+	return math.Trunc(v)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,41 @@
+package expr
+
+import (
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func TestCactiStyle(t *testing.T) {
+	f := NewCactiStyle().(*FuncCactiStyle)
+	f.in = NewMock([]models.Series{getModel("a", c), getModel("longer.name", allNaN)})
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	exp := []string{
+		"a           Current:4.00    Max:4.00    Min:0.00    ",
+		"longer.name Current:nan     Max:nan     Min:nan     ",
+	}
+	for i, e := range exp {
+		if got[i].Target != e || got[i].QueryPatt != e {
+			t.Fatalf("series %d: expected %q, got %q", i, e, got[i].Target)
+		}
+	}
+}
+
+func TestCactiStyleUnits(t *testing.T) {
+	f := NewCactiStyle().(*FuncCactiStyle)
+	f.in = NewMock([]models.Series{getModel("bytes", []schema.Point{{Val: 1024, Ts: 10}, {Val: 2048000, Ts: 20}})})
+	f.system = "si"
+	f.units = "b"
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	exp := "bytes Current:2.05 Mb    Max:2.05 Mb    Min:1.02 Kb    "
+	if got[0].Target != exp {
+		t.Fatalf("expected %q, got %q", exp, got[0].Target)
+	}
+}
@@ -0,0 +1,50 @@
+package expr
+
+import (
+	"fmt"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+type FuncConstantLine struct {
+	value float64
+	from  uint32
+	to    uint32
+}
+
+func NewConstantLine() GraphiteFunc {
+	return &FuncConstantLine{}
+}
+
+func (s *FuncConstantLine) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgFloat{key: "value", val: &s.value},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncConstantLine) Context(context Context) Context {
+	s.from = context.from
+	s.to = context.to
+	return context
+}
+
+func (s *FuncConstantLine) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	name := fmt.Sprintf("%g", s.value)
+	return []models.Series{constantLine(cache, name, fmt.Sprintf("constantLine(%g)", s.value), s.value, s.from, s.to)}, nil
+}
+
+// constantLine returns a series with the given value at the start, the middle and the end of the requested range,
+// like graphite does.
+func constantLine(cache map[Req][]models.Series, name, queryPatt string, value float64, from, to uint32) models.Series {
+	start, end := syntheticRange(from, to)
+	step := (end - start) / 2
+	if step == 0 {
+		step = 1
+	}
+	out := pointSlicePool.Get().([]schema.Point)
+	for i := uint32(0); i < 3; i++ {
+		out = append(out, schema.Point{Val: value, Ts: start + i*step})
+	}
+	return syntheticOutput(cache, name, queryPatt, from, to, step, out)
+}
@@ -0,0 +1,79 @@
+package expr
+
+import (
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func TestConstantLine(t *testing.T) {
+	// graphite from 1000 until 1600
+	cases := []struct {
+		target   string
+		from     uint32
+		to       uint32
+		exp      models.Series
+		interval uint32
+	}{
+		{
+			`constantLine(90)`, 1001, 1601,
+			models.Series{
+				Target:     "90",
+				QueryPatt:  "constantLine(90)",
+				Datapoints: []schema.Point{{Val: 90, Ts: 1000}, {Val: 90, Ts: 1300}, {Val: 90, Ts: 1600}},
+			},
+			300,
+		},
+		{
+			`constantLine(-1.5)`, 1001, 1002,
+			models.Series{
+				Target:     "-1.5",
+				QueryPatt:  "constantLine(-1.5)",
+				Datapoints: []schema.Point{{Val: -1.5, Ts: 1000}, {Val: -1.5, Ts: 1001}, {Val: -1.5, Ts: 1002}},
+			},
+			1,
+		},
+		{
+			`threshold(99.9)`, 1001, 1601,
+			models.Series{
+				Target:     "99.9",
+				QueryPatt:  "constantLine(99.9)",
+				Datapoints: []schema.Point{{Val: 99.9, Ts: 1000}, {Val: 99.9, Ts: 1300}, {Val: 99.9, Ts: 1600}},
+			},
+			300,
+		},
+		{
+			`threshold(99.9, "slo", "red")`, 1001, 1601,
+			models.Series{
+				Target:     "slo",
+				QueryPatt:  "constantLine(99.9)",
+				Datapoints: []schema.Point{{Val: 99.9, Ts: 1000}, {Val: 99.9, Ts: 1300}, {Val: 99.9, Ts: 1600}},
+			},
+			300,
+		},
+		{
+			`threshold(99.9, label="slo")`, 1001, 1601,
+			models.Series{
+				Target:     "slo",
+				QueryPatt:  "constantLine(99.9)",
+				Datapoints: []schema.Point{{Val: 99.9, Ts: 1000}, {Val: 99.9, Ts: 1300}, {Val: 99.9, Ts: 1600}},
+			},
+			300,
+		},
+	}
+	for _, c := range cases {
+		out := runSynthetic([]string{c.target}, c.from, c.to, true, t)
+		if len(out) != 1 {
+			t.Fatalf("case %q: expected 1 series, got %d", c.target, len(out))
+		}
+		got := out[0]
+		if got.Target != c.exp.Target || got.QueryPatt != c.exp.QueryPatt || got.Tags["name"] != c.exp.Target {
+			t.Fatalf("case %q: expected target %q, query pattern %q and name tag, got %q, %q and %v", c.target, c.exp.Target, c.exp.QueryPatt, got.Target, got.QueryPatt, got.Tags)
+		}
+		if got.Interval != c.interval || got.QueryFrom != c.from || got.QueryTo != c.to {
+			t.Fatalf("case %q: expected interval %d and query range %d-%d, got %d and %d-%d", c.target, c.interval, c.from, c.to, got.Interval, got.QueryFrom, got.QueryTo)
+		}
+		checkSeriesPoints(c.target, []models.Series{c.exp}, out, t)
+	}
+}
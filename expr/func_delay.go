@@ -0,0 +1,55 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+type FuncDelay struct {
+	in    GraphiteFunc
+	steps int64
+}
+
+func NewDelay() GraphiteFunc {
+	return &FuncDelay{}
+}
+
+func (s *FuncDelay) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgInt{key: "steps", val: &s.steps},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncDelay) Context(context Context) Context {
+	return context
+}
+
+// Exec shifts the values of every series the given number of steps forward in time, keeping the timestamps.
+// the first steps values become null. a negative number of steps shifts the values back in time instead,
+// in which case the last steps values become null.
+func (s *FuncDelay) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	var outputs []models.Series
+	for _, serie := range series {
+		out := pointSlicePool.Get().([]schema.Point)
+		for i, p := range serie.Datapoints {
+			src := int64(i) - s.steps
+			val := math.NaN()
+			if src >= 0 && src < int64(len(serie.Datapoints)) {
+				val = serie.Datapoints[src].Val
+			}
+			out = append(out, schema.Point{Val: val, Ts: p.Ts})
+		}
+		name := fmt.Sprintf("delay(%s,%d)", serie.Target, s.steps)
+		outputs = append(outputs, transformOutput(cache, serie, name, "delay", strconv.FormatInt(s.steps, 10), out))
+	}
+	return outputs, nil
+}
@@ -0,0 +1,35 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+func TestDelay(t *testing.T) {
+	NaN := math.NaN()
+	cases := []struct {
+		steps int64
+		name  string
+		exp   []float64
+	}{
+		{0, "delay(a,0)", []float64{1, 2, 3, 4}},
+		{2, "delay(a,2)", []float64{NaN, NaN, 1, 2}},
+		{-1, "delay(a,-1)", []float64{2, 3, 4, NaN}},
+		{5, "delay(a,5)", []float64{NaN, NaN, NaN, NaN}},
+	}
+	for _, c := range cases {
+		in := []models.Series{getModel("a", nullsPoints(1, 2, 3, 4))}
+		f := NewDelay().(*FuncDelay)
+		f.in = NewMock(in)
+		f.steps = c.steps
+
+		got, err := f.Exec(make(map[Req][]models.Series))
+		if err != nil {
+			t.Fatalf("case %q: unexpected error %s", c.name, err)
+		}
+		checkSeriesPoints(c.name, []models.Series{getModel(c.name, nullsPoints(c.exp...))}, got, t)
+		checkSeriesPoints(c.name+" input", []models.Series{getModel("a", nullsPoints(1, 2, 3, 4))}, in, t)
+	}
+}
@@ -0,0 +1,62 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+type FuncDerivative struct {
+	in GraphiteFunc
+}
+
+func NewDerivative() GraphiteFunc {
+	return &FuncDerivative{}
+}
+
+func (s *FuncDerivative) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncDerivative) Context(context Context) Context {
+	context.consol = 0
+	return context
+}
+
+func (s *FuncDerivative) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs []models.Series
+	for _, serie := range series {
+		out := pointSlicePool.Get().([]schema.Point)
+		prev := math.NaN()
+		for _, p := range serie.Datapoints {
+			// math with a null value yields a null value, which is what we want
+			out = append(out, schema.Point{Val: p.Val - prev, Ts: p.Ts})
+			prev = p.Val
+		}
+		name := fmt.Sprintf("derivative(%s)", serie.Target)
+		tags := make(map[string]string, len(serie.Tags)+1)
+		for k, v := range serie.Tags {
+			tags[k] = v
+		}
+		tags["derivative"] = "1"
+		output := models.Series{
+			Target:     name,
+			QueryPatt:  name,
+			Tags:       tags,
+			Datapoints: out,
+			Interval:   serie.Interval,
+		}
+		outputs = append(outputs, output)
+		cache[Req{}] = append(cache[Req{}], output)
+	}
+	return outputs, nil
+}
@@ -0,0 +1,72 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func TestDerivative(t *testing.T) {
+	testDerivative(
+		"derivative",
+		[]models.Series{
+			getModel("a", a),
+			getModel("c", c),
+			getModel("d", d),
+		},
+		[]models.Series{
+			getModel("derivative(a)", []schema.Point{
+				{Val: math.NaN(), Ts: 10},
+				{Val: 0, Ts: 20},
+				{Val: 5.5, Ts: 30},
+				{Val: math.NaN(), Ts: 40},
+				{Val: math.NaN(), Ts: 50},
+				{Val: math.NaN(), Ts: 60},
+			}),
+			getModel("derivative(c)", []schema.Point{
+				{Val: math.NaN(), Ts: 10},
+				{Val: 0, Ts: 20},
+				{Val: 1, Ts: 30},
+				{Val: 1, Ts: 40},
+				{Val: 1, Ts: 50},
+				{Val: 1, Ts: 60},
+			}),
+			getModel("derivative(d)", []schema.Point{
+				{Val: math.NaN(), Ts: 10},
+				{Val: 33, Ts: 20},
+				{Val: 166, Ts: 30},
+				{Val: -170, Ts: 40},
+				{Val: 51, Ts: 50},
+				{Val: 170, Ts: 60},
+			}),
+		},
+		t,
+	)
+}
+
+func testDerivative(name string, in []models.Series, out []models.Series, t *testing.T) {
+	inCopy := make([]models.Series, len(in))
+	for i, serie := range in {
+		inCopy[i] = serie
+		inCopy[i].Datapoints = getCopy(serie.Datapoints)
+	}
+	f := NewDerivative()
+	f.(*FuncDerivative).in = NewMock(in)
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("case %q: err should be nil. got %q", name, err)
+	}
+	checkSeriesPoints(name, out, got, t)
+	for i, g := range got {
+		if g.QueryPatt != out[i].QueryPatt {
+			t.Fatalf("case %q: expected querypatt %q, got %q", name, out[i].QueryPatt, g.QueryPatt)
+		}
+		if g.Tags["derivative"] != "1" {
+			t.Fatalf("case %q: expected tag derivative=1, got %v", name, g.Tags)
+		}
+	}
+	// the input should not have been modified
+	checkSeriesPoints(name, inCopy, in, t)
+}
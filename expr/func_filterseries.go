@@ -0,0 +1,87 @@
+package expr
+
+import (
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+type FuncFilterSeries struct {
+	in        GraphiteFunc
+	fn        string
+	operator  string
+	threshold float64
+	generic   bool // whether fn and operator are specified by the user, as for filterSeries()
+}
+
+func NewFilterSeries() GraphiteFunc {
+	return &FuncFilterSeries{generic: true}
+}
+
+// NewFilterSeriesConstructor takes the name of a series aggregation function and a comparison operator
+// and returns a constructor function for a filter that only takes the threshold as argument, such as currentAbove()
+func NewFilterSeriesConstructor(fn, operator string) func() GraphiteFunc {
+	return func() GraphiteFunc {
+		return &FuncFilterSeries{fn: fn, operator: operator}
+	}
+}
+
+func (s *FuncFilterSeries) Signature() ([]Arg, []Arg) {
+	if s.generic {
+		return []Arg{
+			ArgSeriesList{val: &s.in},
+			ArgString{key: "func", validator: []Validator{IsSeriesAggFunc}, val: &s.fn},
+			ArgString{key: "operator", validator: []Validator{IsOperator}, val: &s.operator},
+			ArgFloat{key: "threshold", val: &s.threshold},
+		}, []Arg{ArgSeriesList{}}
+	}
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgFloat{key: "n", val: &s.threshold},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncFilterSeries) Context(context Context) Context {
+	return context
+}
+
+func (s *FuncFilterSeries) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	aggFunc := getSeriesAggFunc(s.fn)
+	operatorFunc := getOperatorFunc(s.operator)
+
+	var outputs []models.Series
+	for _, serie := range series {
+		val := aggFunc(serie.Datapoints)
+		// series without a value never pass the filter
+		if math.IsNaN(val) {
+			continue
+		}
+		if operatorFunc(val, s.threshold) {
+			outputs = append(outputs, serie)
+		}
+	}
+	return outputs, nil
+}
+
+func getOperatorFunc(operator string) func(float64, float64) bool {
+	switch operator {
+	case "=":
+		return func(val, threshold float64) bool { return val == threshold }
+	case "!=":
+		return func(val, threshold float64) bool { return val != threshold }
+	case ">":
+		return func(val, threshold float64) bool { return val > threshold }
+	case ">=":
+		return func(val, threshold float64) bool { return val >= threshold }
+	case "<":
+		return func(val, threshold float64) bool { return val < threshold }
+	case "<=":
+		return func(val, threshold float64) bool { return val <= threshold }
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package expr
+
+import (
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+func TestCurrentAbove(t *testing.T) {
+	testFilterSeries("currentAbove", NewFilterSeriesConstructor("last", ">"), "", "", 250, getSeriesNamed(), []string{"a", "b"}, t)
+}
+
+func TestCurrentBelow(t *testing.T) {
+	// in accordance with graphite, below is inclusive
+	testFilterSeries("currentBelow", NewFilterSeriesConstructor("last", "<="), "", "", 250, getSeriesNamed(), []string{"c", "d"}, t)
+}
+
+func TestAverageAbove(t *testing.T) {
+	testFilterSeries("averageAbove", NewFilterSeriesConstructor("average", ">"), "", "", 98.5, getSeriesNamed(), []string{"a", "b"}, t)
+}
+
+func TestAverageBelow(t *testing.T) {
+	testFilterSeries("averageBelow", NewFilterSeriesConstructor("average", "<="), "", "", 98.5, getSeriesNamed(), []string{"c", "d"}, t)
+}
+
+func TestMaximumAbove(t *testing.T) {
+	testFilterSeries("maximumAbove", NewFilterSeriesConstructor("max", ">"), "", "", 4, getSeriesNamed(), []string{"a", "b", "d"}, t)
+}
+
+func TestMinimumBelow(t *testing.T) {
+	testFilterSeries("minimumBelow", NewFilterSeriesConstructor("min", "<="), "", "", 0, getSeriesNamed(), []string{"a", "b", "c", "d"}, t)
+}
+
+func TestFilterSeriesGeneric(t *testing.T) {
+	testFilterSeries("filterSeries-sum-eq", NewFilterSeries, "sum", "=", 10, getSeriesNamed(), []string{"c"}, t)
+	testFilterSeries("filterSeries-max-ne", NewFilterSeries, "max", "!=", 250, getSeriesNamed(), []string{"a", "b", "c"}, t)
+	testFilterSeries("filterSeries-last-ge", NewFilterSeries, "last", ">=", 250, getSeriesNamed(), []string{"a", "b", "d"}, t)
+	testFilterSeries("filterSeries-median-lt", NewFilterSeries, "median", "<", 2, getSeriesNamed(), []string{"c"}, t)
+}
+
+func testFilterSeries(name string, constr func() GraphiteFunc, fn, operator string, threshold float64, in []models.Series, out []string, t *testing.T) {
+	f := constr()
+	filter := f.(*FuncFilterSeries)
+	filter.in = NewMock(in)
+	filter.threshold = threshold
+	if fn != "" {
+		filter.fn = fn
+		filter.operator = operator
+	}
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("case %q: err should be nil. got %q", name, err)
+	}
+	if len(got) != len(out) {
+		t.Fatalf("case %q: expected %d output series, got %d", name, len(out), len(got))
+	}
+	for i, o := range out {
+		if o != got[i].Target {
+			t.Fatalf("case %q: expected target %q at position %d, got %q", name, o, i, got[i].Target)
+		}
+	}
+}
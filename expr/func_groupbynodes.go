@@ -0,0 +1,86 @@
+package expr
+
+import (
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+type FuncGroupByNodes struct {
+	in         GraphiteFunc
+	aggregator string
+	nodes      []expr
+
+	groupByNode bool  // whether we were invoked as groupByNode, which takes a single node
+	nodeNum     int64 // the node for groupByNode
+}
+
+// NewGroupByNodesConstructor returns a constructor for groupByNode (single node, optional aggregator)
+// or groupByNodes (aggregator and any number of nodes)
+func NewGroupByNodesConstructor(groupByNode bool) func() GraphiteFunc {
+	return func() GraphiteFunc {
+		return &FuncGroupByNodes{aggregator: "average", groupByNode: groupByNode}
+	}
+}
+
+func (s *FuncGroupByNodes) Signature() ([]Arg, []Arg) {
+	if s.groupByNode {
+		return []Arg{
+			ArgSeriesList{val: &s.in},
+			ArgInt{key: "nodeNum", val: &s.nodeNum},
+			ArgString{key: "callback", opt: true, val: &s.aggregator, validator: []Validator{IsAggFunc}},
+		}, []Arg{ArgSeriesList{}}
+	}
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgString{key: "callback", val: &s.aggregator, validator: []Validator{IsAggFunc}},
+		ArgStringsOrInts{key: "nodes", val: &s.nodes},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncGroupByNodes) Context(context Context) Context {
+	return context
+}
+
+func (s *FuncGroupByNodes) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	nodes := s.nodes
+	if s.groupByNode {
+		nodes = []expr{{etype: etInt, int: s.nodeNum}}
+	}
+
+	// in accordance with graphite, the groups are returned in the order in which they were first seen
+	var keys []string
+	groups := make(map[string][]models.Series)
+	for _, serie := range series {
+		key := aggKey(serie, nodes)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], serie)
+	}
+
+	aggFunc := getCrossSeriesAggFunc(s.aggregator)
+	outputs := make([]models.Series, 0, len(keys))
+	for _, key := range keys {
+		groupSeries := groups[key]
+		cons, queryCons := summarizeCons(groupSeries)
+		output := models.Series{
+			Target:       key,
+			QueryPatt:    key,
+			Interval:     groupSeries[0].Interval,
+			Consolidator: cons,
+			QueryCons:    queryCons,
+		}
+		output.SetTags()
+
+		output.Datapoints = pointSlicePool.Get().([]schema.Point)
+		aggFunc(groupSeries, &output.Datapoints)
+		cache[Req{}] = append(cache[Req{}], output)
+
+		outputs = append(outputs, output)
+	}
+	return outputs, nil
+}
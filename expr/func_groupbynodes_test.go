@@ -0,0 +1,186 @@
+package expr
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/test"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func getGroupByNodesInput() []models.Series {
+	return []models.Series{
+		getModel("app.b.cpu;dc=west", c),
+		getModel("app.a.cpu;dc=east", c),
+		getModel("app.a.mem;dc=east", d),
+	}
+}
+
+func TestGroupByNodeSum(t *testing.T) {
+	testGroupByNodes(
+		"groupByNode-sum",
+		NewGroupByNodesConstructor(true),
+		"sum",
+		[]expr{{etype: etInt, int: 1}},
+		getGroupByNodesInput(),
+		[]models.Series{
+			getModel("b", c),
+			getModel("a", sumcd),
+		},
+		t,
+	)
+}
+
+func TestGroupByNodeDefaultAverage(t *testing.T) {
+	testGroupByNodes(
+		"groupByNode-average",
+		NewGroupByNodesConstructor(true),
+		"",
+		[]expr{{etype: etInt, int: -1}},
+		getGroupByNodesInput(),
+		[]models.Series{
+			getModel("cpu", c),
+			getModel("mem", d),
+		},
+		t,
+	)
+}
+
+func TestGroupByNodesMultiple(t *testing.T) {
+	testGroupByNodes(
+		"groupByNodes-multiple",
+		NewGroupByNodesConstructor(false),
+		"max",
+		[]expr{{etype: etInt, int: 0}, {etype: etInt, int: 2}},
+		getGroupByNodesInput(),
+		[]models.Series{
+			getModel("app.cpu", c),
+			getModel("app.mem", d),
+		},
+		t,
+	)
+}
+
+func TestGroupByNodesTag(t *testing.T) {
+	testGroupByNodes(
+		"groupByNodes-tag",
+		NewGroupByNodesConstructor(false),
+		"sum",
+		[]expr{{etype: etString, str: "dc"}},
+		getGroupByNodesInput(),
+		[]models.Series{
+			getModel("west", c),
+			getModel("east", sumcd),
+		},
+		t,
+	)
+}
+
+func TestGroupByNodesMixed(t *testing.T) {
+	testGroupByNodes(
+		"groupByNodes-mixed",
+		NewGroupByNodesConstructor(false),
+		"sum",
+		[]expr{{etype: etString, str: "dc"}, {etype: etInt, int: 2}},
+		getGroupByNodesInput(),
+		[]models.Series{
+			getModel("west.cpu", c),
+			getModel("east.cpu", c),
+			getModel("east.mem", d),
+		},
+		t,
+	)
+}
+
+func testGroupByNodes(name string, constr func() GraphiteFunc, aggregator string, nodes []expr, in []models.Series, out []models.Series, t *testing.T) {
+	f := constr()
+	gbn := f.(*FuncGroupByNodes)
+	gbn.in = NewMock(in)
+	if aggregator != "" {
+		gbn.aggregator = aggregator
+	}
+	if gbn.groupByNode {
+		gbn.nodeNum = nodes[0].int
+	} else {
+		gbn.nodes = nodes
+	}
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("case %q: err should be nil. got %q", name, err)
+	}
+	checkSeriesPoints(name, out, got, t)
+	for i, g := range got {
+		if g.QueryPatt != out[i].QueryPatt {
+			t.Fatalf("case %q: expected querypatt %q, got %q", name, out[i].QueryPatt, g.QueryPatt)
+		}
+		if g.Tags["name"] != out[i].Target {
+			t.Fatalf("case %q: expected name tag %q, got %v", name, out[i].Target, g.Tags)
+		}
+	}
+}
+
+func BenchmarkGroupByNodes10k_1NoNulls(b *testing.B) {
+	benchmarkGroupByNodes(b, 1, test.RandFloats10k, test.RandFloats10k)
+}
+func BenchmarkGroupByNodes10k_10NoNulls(b *testing.B) {
+	benchmarkGroupByNodes(b, 10, test.RandFloats10k, test.RandFloats10k)
+}
+func BenchmarkGroupByNodes10k_100NoNulls(b *testing.B) {
+	benchmarkGroupByNodes(b, 100, test.RandFloats10k, test.RandFloats10k)
+}
+func BenchmarkGroupByNodes10k_1000NoNulls(b *testing.B) {
+	benchmarkGroupByNodes(b, 1000, test.RandFloats10k, test.RandFloats10k)
+}
+func BenchmarkGroupByNodes10k_1SomeSeriesHalfNulls(b *testing.B) {
+	benchmarkGroupByNodes(b, 1, test.RandFloats10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkGroupByNodes10k_10SomeSeriesHalfNulls(b *testing.B) {
+	benchmarkGroupByNodes(b, 10, test.RandFloats10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkGroupByNodes10k_100SomeSeriesHalfNulls(b *testing.B) {
+	benchmarkGroupByNodes(b, 100, test.RandFloats10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkGroupByNodes10k_1000SomeSeriesHalfNulls(b *testing.B) {
+	benchmarkGroupByNodes(b, 1000, test.RandFloats10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkGroupByNodes10k_1AllSeriesHalfNulls(b *testing.B) {
+	benchmarkGroupByNodes(b, 1, test.RandFloatsWithNulls10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkGroupByNodes10k_10AllSeriesHalfNulls(b *testing.B) {
+	benchmarkGroupByNodes(b, 10, test.RandFloatsWithNulls10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkGroupByNodes10k_100AllSeriesHalfNulls(b *testing.B) {
+	benchmarkGroupByNodes(b, 100, test.RandFloatsWithNulls10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkGroupByNodes10k_1000AllSeriesHalfNulls(b *testing.B) {
+	benchmarkGroupByNodes(b, 1000, test.RandFloatsWithNulls10k, test.RandFloatsWithNulls10k)
+}
+
+func benchmarkGroupByNodes(b *testing.B, numSeries int, fn0, fn1 func() []schema.Point) {
+	var input []models.Series
+	for i := 0; i < numSeries; i++ {
+		series := models.Series{
+			Target: "app.host" + strconv.Itoa(i%10) + ".metric" + strconv.Itoa(i),
+		}
+		if i%2 == 0 {
+			series.Datapoints = fn0()
+		} else {
+			series.Datapoints = fn1()
+		}
+		input = append(input, series)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f := NewGroupByNodesConstructor(false)()
+		gbn := f.(*FuncGroupByNodes)
+		gbn.in = NewMock(input)
+		gbn.aggregator = "sum"
+		gbn.nodes = []expr{{etype: etInt, int: 1}}
+		got, err := f.Exec(make(map[Req][]models.Series))
+		if err != nil {
+			b.Fatalf("%s", err)
+		}
+		results = got
+	}
+}
@@ -0,0 +1,92 @@
+package expr
+
+import (
+	"math"
+	"sort"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+type FuncHighestLowest struct {
+	in      GraphiteFunc
+	n       int64
+	fn      string
+	generic bool // whether fn is specified by the user, as for highest() and lowest()
+	highest bool
+}
+
+// NewHighestLowestConstructor takes the name of a series aggregation function and whether to select the highest or lowest series,
+// and returns a constructor function. pass an empty fn to let the user specify the aggregation function.
+func NewHighestLowestConstructor(fn string, highest bool) func() GraphiteFunc {
+	return func() GraphiteFunc {
+		if fn == "" {
+			return &FuncHighestLowest{n: 1, fn: "average", generic: true, highest: highest}
+		}
+		return &FuncHighestLowest{fn: fn, highest: highest}
+	}
+}
+
+func (s *FuncHighestLowest) Signature() ([]Arg, []Arg) {
+	if s.generic {
+		return []Arg{
+			ArgSeriesList{val: &s.in},
+			ArgInt{key: "n", opt: true, validator: []Validator{IntPositive}, val: &s.n},
+			ArgString{key: "func", opt: true, validator: []Validator{IsSeriesAggFunc}, val: &s.fn},
+		}, []Arg{ArgSeriesList{}}
+	}
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgInt{key: "n", validator: []Validator{IntPositive}, val: &s.n},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncHighestLowest) Context(context Context) Context {
+	return context
+}
+
+func (s *FuncHighestLowest) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	aggFunc := getSeriesAggFunc(s.fn)
+	sorted := seriesValueSort{
+		series: make([]models.Series, len(series)),
+		values: make([]float64, len(series)),
+	}
+	for i, serie := range series {
+		sorted.series[i] = serie
+		// in accordance with graphite, series without a value sort as if their value is -inf
+		sorted.values[i] = aggFunc(serie.Datapoints)
+		if math.IsNaN(sorted.values[i]) {
+			sorted.values[i] = math.Inf(-1)
+		}
+	}
+
+	if s.highest {
+		sort.Stable(sort.Reverse(sorted))
+	} else {
+		sort.Stable(sorted)
+	}
+
+	if int(s.n) < len(sorted.series) {
+		return sorted.series[:s.n], nil
+	}
+	return sorted.series, nil
+}
+
+// seriesValueSort sorts series by a value associated with each series
+type seriesValueSort struct {
+	series []models.Series
+	values []float64
+}
+
+func (ss seriesValueSort) Len() int { return len(ss.series) }
+
+func (ss seriesValueSort) Less(i, j int) bool { return ss.values[i] < ss.values[j] }
+
+func (ss seriesValueSort) Swap(i, j int) {
+	ss.series[i], ss.series[j] = ss.series[j], ss.series[i]
+	ss.values[i], ss.values[j] = ss.values[j], ss.values[i]
+}
@@ -0,0 +1,123 @@
+package expr
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/test"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func TestHighestMax(t *testing.T) {
+	testHighestLowest("highestMax-2", NewHighestLowestConstructor("max", true), 2, "", getSeriesNamed(), []string{"b", "a"}, t)
+}
+
+func TestHighestAverage(t *testing.T) {
+	testHighestLowest("highestAverage-1", NewHighestLowestConstructor("average", true), 1, "", getSeriesNamed(), []string{"b"}, t)
+}
+
+func TestHighestCurrent(t *testing.T) {
+	// a and b have the same current value, so they should retain their input order
+	testHighestLowest("highestCurrent-3", NewHighestLowestConstructor("current", true), 3, "", getSeriesNamed(), []string{"a", "b", "d"}, t)
+}
+
+func TestHighestMoreThanAvailable(t *testing.T) {
+	testHighestLowest("highestMax-10", NewHighestLowestConstructor("max", true), 10, "", getSeriesNamed(), []string{"b", "a", "d", "c", "allNaN"}, t)
+}
+
+func TestLowestAverage(t *testing.T) {
+	// in accordance with graphite, series without values sort lowest
+	testHighestLowest("lowestAverage-2", NewHighestLowestConstructor("average", false), 2, "", getSeriesNamed(), []string{"allNaN", "c"}, t)
+}
+
+func TestLowestCurrent(t *testing.T) {
+	testHighestLowest("lowestCurrent-3", NewHighestLowestConstructor("current", false), 3, "", getSeriesNamed(), []string{"allNaN", "c", "d"}, t)
+}
+
+func TestHighestGeneric(t *testing.T) {
+	testHighestLowest("highest-default", NewHighestLowestConstructor("", true), 0, "", getSeriesNamed(), []string{"b"}, t)
+	testHighestLowest("highest-2-sum", NewHighestLowestConstructor("", true), 2, "sum", getSeriesNamed(), []string{"b", "a"}, t)
+	testHighestLowest("lowest-2-min", NewHighestLowestConstructor("", false), 2, "min", getSeriesNamed(), []string{"allNaN", "a"}, t)
+}
+
+func TestHighestLowestNoInput(t *testing.T) {
+	testHighestLowest("highestMax-empty", NewHighestLowestConstructor("max", true), 2, "", []models.Series{}, []string{}, t)
+}
+
+// testHighestLowest runs the given constructor with the given n and fn (if non-zero) and validates the targets of the output series
+func testHighestLowest(name string, constr func() GraphiteFunc, n int64, fn string, in []models.Series, out []string, t *testing.T) {
+	f := constr()
+	hl := f.(*FuncHighestLowest)
+	hl.in = NewMock(in)
+	if n != 0 {
+		hl.n = n
+	}
+	if fn != "" {
+		hl.fn = fn
+	}
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("case %q: err should be nil. got %q", name, err)
+	}
+	if len(got) != len(out) {
+		t.Fatalf("case %q: expected %d output series, got %d", name, len(out), len(got))
+	}
+	for i, o := range out {
+		if o != got[i].Target {
+			t.Fatalf("case %q: expected target %q at position %d, got %q", name, o, i, got[i].Target)
+		}
+	}
+}
+
+func BenchmarkHighestMax10k_1NoNulls(b *testing.B) {
+	benchmarkHighestLowest(b, 1, test.RandFloats10k, test.RandFloats10k)
+}
+func BenchmarkHighestMax10k_10NoNulls(b *testing.B) {
+	benchmarkHighestLowest(b, 10, test.RandFloats10k, test.RandFloats10k)
+}
+func BenchmarkHighestMax10k_100NoNulls(b *testing.B) {
+	benchmarkHighestLowest(b, 100, test.RandFloats10k, test.RandFloats10k)
+}
+func BenchmarkHighestMax10k_1000NoNulls(b *testing.B) {
+	benchmarkHighestLowest(b, 1000, test.RandFloats10k, test.RandFloats10k)
+}
+func BenchmarkHighestMax10k_1SomeSeriesHalfNulls(b *testing.B) {
+	benchmarkHighestLowest(b, 1, test.RandFloats10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkHighestMax10k_10SomeSeriesHalfNulls(b *testing.B) {
+	benchmarkHighestLowest(b, 10, test.RandFloats10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkHighestMax10k_100SomeSeriesHalfNulls(b *testing.B) {
+	benchmarkHighestLowest(b, 100, test.RandFloats10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkHighestMax10k_1000SomeSeriesHalfNulls(b *testing.B) {
+	benchmarkHighestLowest(b, 1000, test.RandFloats10k, test.RandFloatsWithNulls10k)
+}
+
+func benchmarkHighestLowest(b *testing.B, numSeries int, fn0, fn1 func() []schema.Point) {
+	var input []models.Series
+	for i := 0; i < numSeries; i++ {
+		series := models.Series{
+			QueryPatt: strconv.Itoa(i),
+		}
+		if i%2 == 0 {
+			series.Datapoints = fn0()
+		} else {
+			series.Datapoints = fn1()
+		}
+		input = append(input, series)
+	}
+	b.ResetTimer()
+	var err error
+	for i := 0; i < b.N; i++ {
+		f := NewHighestLowestConstructor("max", true)()
+		hl := f.(*FuncHighestLowest)
+		hl.in = NewMock(input)
+		hl.n = 5
+		results, err = f.Exec(make(map[Req][]models.Series))
+		if err != nil {
+			b.Fatalf("%s", err)
+		}
+	}
+}
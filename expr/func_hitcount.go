@@ -0,0 +1,166 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/dur"
+	"gopkg.in/raintank/schema.v1"
+)
+
+type FuncHitcount struct {
+	in              GraphiteFunc
+	intervalString  string
+	alignToInterval bool
+	from            uint32 // the requested from, before aligning to the interval
+	to              uint32 // the requested to
+}
+
+func NewHitcount() GraphiteFunc {
+	return &FuncHitcount{}
+}
+
+func (s *FuncHitcount) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgString{key: "intervalString", val: &s.intervalString, validator: []Validator{IsIntervalString}},
+		ArgBool{key: "alignToInterval", opt: true, val: &s.alignToInterval},
+	}, []Arg{ArgSeriesList{}}
+}
+
+// Context moves from back to the start of the day, hour or minute it falls in (depending on the size of the interval),
+// if the buckets should be aligned to the interval.
+func (s *FuncHitcount) Context(context Context) Context {
+	context.consol = 0
+	s.from = context.from
+	s.to = context.to
+	if s.alignToInterval {
+		interval, _ := dur.ParseDuration(s.intervalString)
+		context.from = alignFrom(context.from, interval, context.loc)
+	}
+	return context
+}
+
+// Exec estimates the number of hits in every bucket of the interval, with the values of the series being rates per second.
+// in accordance with graphite, the buckets are aligned to the end of the series, unless alignToInterval is set,
+// and the hits of a point spread over the buckets its step overlaps with.
+func (s *FuncHitcount) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	interval, _ := dur.ParseDuration(s.intervalString)
+	var alignToIntervalTarget string
+	if s.alignToInterval {
+		alignToIntervalTarget = ", true"
+	}
+
+	var outputs []models.Series
+	for _, serie := range series {
+		out := pointSlicePool.Get().([]schema.Point)
+		if len(serie.Datapoints) > 0 && serie.Interval > 0 {
+			start := serie.Datapoints[0].Ts
+			end := serie.Datapoints[len(serie.Datapoints)-1].Ts + serie.Interval
+			if s.alignToInterval {
+				// the series now starts at the aligned from, but the number of buckets is determined by the requested range
+				reqStart := ((s.from + serie.Interval - 1) / serie.Interval) * serie.Interval
+				reqEnd := ((s.to-1)/serie.Interval)*serie.Interval + serie.Interval
+				end = start + interval
+				if reqEnd > reqStart {
+					end += ((reqEnd - reqStart) / interval) * interval
+				}
+			}
+			out = hitcount(out, serie.Datapoints, serie.Interval, interval, end)
+		}
+		name := fmt.Sprintf("hitcount(%s, \"%s\"%s)", serie.Target, s.intervalString, alignToIntervalTarget)
+		tags := make(map[string]string, len(serie.Tags)+1)
+		for k, v := range serie.Tags {
+			tags[k] = v
+		}
+		tags["hitcount"] = s.intervalString
+		output := models.Series{
+			Target:     name,
+			QueryPatt:  name,
+			Tags:       tags,
+			Datapoints: out,
+			Interval:   interval,
+			QueryFrom:  s.from,
+			QueryTo:    s.to,
+		}
+		outputs = append(outputs, output)
+		cache[Req{}] = append(cache[Req{}], output)
+	}
+	return outputs, nil
+}
+
+// hitcount appends the hits of the given points, which are at the given step, to out, in buckets of the given interval
+// ending at end. this is a port of graphite's hitcount, see Exec.
+func hitcount(out, in []schema.Point, step, interval, end uint32) []schema.Point {
+	start := in[0].Ts
+	bucketCount := int64(math.Ceil(float64(end-start) / float64(interval)))
+	newStart := int64(end) - bucketCount*int64(interval)
+	buckets := make([]float64, bucketCount)
+	for i := range buckets {
+		buckets[i] = math.NaN()
+	}
+	add := func(bucket int64, hits float64) {
+		if math.IsNaN(buckets[bucket]) {
+			buckets[bucket] = 0
+		}
+		buckets[bucket] += hits
+	}
+
+	ival := int64(interval)
+	for _, p := range in {
+		if math.IsNaN(p.Val) {
+			continue
+		}
+		startTime := int64(p.Ts)
+		endTime := startTime + int64(step)
+		startBucket, startMod := floorDivMod(startTime-newStart, ival)
+		endBucket, endMod := floorDivMod(endTime-newStart, ival)
+		if startBucket >= bucketCount {
+			continue
+		}
+		if endBucket >= bucketCount {
+			endBucket = bucketCount - 1
+			endMod = ival
+		}
+		if startBucket == endBucket {
+			// all of the hits go to a single bucket
+			if startBucket >= 0 {
+				add(startBucket, p.Val*float64(endMod-startMod))
+			}
+			continue
+		}
+		// spread the hits among 2 or more buckets
+		if startBucket >= 0 {
+			add(startBucket, p.Val*float64(ival-startMod))
+		}
+		for j := startBucket + 1; j < endBucket; j++ {
+			if j >= 0 {
+				add(j, p.Val*float64(ival))
+			}
+		}
+		if endMod > 0 && endBucket >= 0 {
+			add(endBucket, p.Val*float64(endMod))
+		}
+	}
+
+	for i, val := range buckets {
+		out = append(out, schema.Point{Val: val, Ts: uint32(newStart + int64(i)*ival)})
+	}
+	return out
+}
+
+// floorDivMod returns the quotient rounded down and the (non-negative) remainder of a/b, like python's divmod
+func floorDivMod(a, b int64) (int64, int64) {
+	q, m := a/b, a%b
+	if m < 0 {
+		q--
+		m += b
+	}
+	return q, m
+}
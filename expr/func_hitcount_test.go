@@ -0,0 +1,104 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/dur"
+	"gopkg.in/raintank/schema.v1"
+)
+
+// the expected values were generated by running graphite's hitcount on graphiteParityInputs.
+func TestHitcountGraphiteParity(t *testing.T) {
+	NaN := math.NaN()
+	cases := []struct {
+		name     string
+		input    int // index in graphiteParityInputs
+		interval string
+		expStart uint32
+		exp      []float64
+	}{
+		{"rollup", 0, "20s", 3660, []float64{20, 20, 20, 40, 40, 40, NaN, NaN, NaN, 80, 80, 80, 100, 100, 100, 120, 120, 120, NaN, NaN, NaN, NaN, NaN, NaN, 180, 180, 180, 200, 200, 200}},
+		{"rollup", 0, "45s", 3630, []float64{15, 45, 90, 30, 60, 180, 225, 255, 180, NaN, NaN, 270, 420, 450}},
+		{"rollup", 0, "1min", 3660, []float64{60, 120, NaN, 240, 300, 360, NaN, NaN, 540, 600}},
+		{"rollup", 0, "2min", 3660, []float64{180, 240, 660, NaN, 1140}},
+		{"rollup", 0, "5min", 3660, []float64{720, 1500}},
+		{"raw", 1, "20s", 3730, []float64{30, 60, NaN, 100}},
+		{"raw", 1, "45s", 3720, []float64{85, 105}},
+		{"raw", 1, "1min", 3690, []float64{30, 160}},
+		{"raw", 1, "2min", 3690, []float64{190}},
+		{"raw", 1, "5min", 3510, []float64{190}},
+		{"nulls", 2, "20s", 3620, []float64{NaN, NaN}},
+		{"nulls", 2, "45s", 3615, []float64{NaN}},
+		{"nulls", 2, "1min", 3600, []float64{NaN}},
+		{"nulls", 2, "2min", 3540, []float64{NaN}},
+		{"nulls", 2, "5min", 3360, []float64{NaN}},
+		{"single", 3, "20s", 3900, []float64{140, 140, 140}},
+		{"single", 3, "45s", 3870, []float64{105, 315}},
+		{"single", 3, "1min", 3900, []float64{420}},
+		{"single", 3, "2min", 3840, []float64{420}},
+		{"single", 3, "5min", 3660, []float64{420}},
+	}
+	for _, c := range cases {
+		name := fmt.Sprintf("%s %s", c.name, c.interval)
+		f := NewHitcount().(*FuncHitcount)
+		f.in = NewMock(graphiteParityInputs()[c.input : c.input+1])
+		f.intervalString = c.interval
+
+		got, err := f.Exec(make(map[Req][]models.Series))
+		if err != nil {
+			t.Fatalf("case %q: unexpected error %s", name, err)
+		}
+		interval, _ := dur.ParseDuration(c.interval)
+		graphiteParityCheck(name, got, c.expStart, interval, c.exp, t)
+		exp := fmt.Sprintf("hitcount(%s, \"%s\")", c.name, c.interval)
+		if got[0].Target != exp || got[0].Tags["hitcount"] != c.interval {
+			t.Fatalf("case %q: expected target %q and tag hitcount, got %q and %v", name, exp, got[0].Target, got[0].Tags)
+		}
+	}
+}
+
+// for alignToInterval, the data is fetched from the start of the minute, but the number of buckets
+// depends on the requested range. the expected values were generated by graphite's hitcount.
+func TestHitcountAlignToIntervalGraphiteParity(t *testing.T) {
+	NaN := math.NaN()
+	vals := []float64{1, NaN, 2, 3, NaN, NaN, 4, 5, 6, NaN, 7, 8, NaN, 9, 10, NaN, NaN, 11}
+	cases := []struct {
+		interval string
+		expFrom  uint32
+		expStart uint32
+		exp      []float64
+	}{
+		{"30s", 3746, 3730, []float64{30, 30, 150, 150, 190, 110}},
+		{"1min", 3721, 3730, []float64{60, 300, 300}},
+		{"2min", 3721, 3730, []float64{360, 300}},
+	}
+	for _, c := range cases {
+		f := NewHitcount().(*FuncHitcount)
+		f.intervalString = c.interval
+		f.alignToInterval = true
+		// graphite from 3745 and until 3900. intervals of a minute or more align from to the minute: 3720
+		context := f.Context(Context{from: 3746, to: 3901, loc: time.UTC})
+		if context.from != c.expFrom || context.to != 3901 {
+			t.Fatalf("case %q: expected context %d-3901, got %d-%d", c.interval, c.expFrom, context.from, context.to)
+		}
+		points := make([]schema.Point, len(vals))
+		for i, v := range vals {
+			points[i] = schema.Point{Val: v, Ts: 3730 + uint32(i)*10}
+		}
+		f.in = NewMock([]models.Series{{Target: "a", QueryPatt: "a", Interval: 10, Datapoints: points}})
+
+		got, err := f.Exec(make(map[Req][]models.Series))
+		if err != nil {
+			t.Fatalf("case %q: unexpected error %s", c.interval, err)
+		}
+		interval, _ := dur.ParseDuration(c.interval)
+		graphiteParityCheck(c.interval, got, c.expStart, interval, c.exp, t)
+		if exp := fmt.Sprintf("hitcount(a, \"%s\", true)", c.interval); got[0].Target != exp {
+			t.Fatalf("case %q: expected target %q, got %q", c.interval, exp, got[0].Target)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/dur"
+	"gopkg.in/raintank/schema.v1"
+)
+
+type FuncHoltWintersAberration struct {
+	in                GraphiteFunc
+	delta             float64
+	bootstrapInterval string
+	seasonality       string
+	from              uint32
+}
+
+func NewHoltWintersAberration() GraphiteFunc {
+	return &FuncHoltWintersAberration{delta: 3, bootstrapInterval: "7d", seasonality: "1d"}
+}
+
+func (s *FuncHoltWintersAberration) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgFloat{key: "delta", opt: true, val: &s.delta},
+		ArgString{key: "bootstrapInterval", opt: true, val: &s.bootstrapInterval, validator: []Validator{IsIntervalString}},
+		ArgString{key: "seasonality", opt: true, val: &s.seasonality, validator: []Validator{IsIntervalString}},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncHoltWintersAberration) Context(context Context) Context {
+	s.from = context.from
+	context.from = holtWintersBootstrapFrom(context.from, s.bootstrapInterval)
+	return context
+}
+
+func (s *FuncHoltWintersAberration) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	seasonality, _ := dur.ParseDuration(s.seasonality)
+
+	var outputs []models.Series
+	for _, serie := range series {
+		predictions, deviations := holtWintersAnalysis(serie.Datapoints, serie.Interval, seasonality)
+		out := pointSlicePool.Get().([]schema.Point)
+		for i, p := range serie.Datapoints {
+			if p.Ts < s.from {
+				continue
+			}
+			// aberration is how far the actual value falls outside of the confidence bands (if at all)
+			aberration := schema.Point{Val: 0, Ts: p.Ts}
+			if !math.IsNaN(p.Val) && !math.IsNaN(predictions[i]) {
+				scaledDeviation := s.delta * deviations[i]
+				upper := predictions[i] + scaledDeviation
+				lower := predictions[i] - scaledDeviation
+				if p.Val > upper {
+					aberration.Val = p.Val - upper
+				} else if p.Val < lower {
+					aberration.Val = p.Val - lower
+				}
+			}
+			out = append(out, aberration)
+		}
+		name := fmt.Sprintf("holtWintersAberration(%s)", serie.Target)
+		output := models.Series{
+			Target:       name,
+			QueryPatt:    name,
+			Tags:         holtWintersTags(serie.Tags, "holtWintersAberration"),
+			Datapoints:   out,
+			Interval:     serie.Interval,
+			QueryFrom:    s.from,
+			QueryTo:      serie.QueryTo,
+			Consolidator: serie.Consolidator,
+			QueryCons:    serie.QueryCons,
+		}
+		outputs = append(outputs, output)
+		cache[Req{}] = append(cache[Req{}], output)
+	}
+	return outputs, nil
+}
@@ -0,0 +1,46 @@
+package expr
+
+import (
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func TestHoltWintersAberration(t *testing.T) {
+	in := []models.Series{
+		{
+			Target:     "a",
+			QueryPatt:  "a",
+			Interval:   10,
+			Datapoints: getCopy(holtWintersInput),
+		},
+	}
+	out := []models.Series{
+		{
+			Target:    "holtWintersAberration(a)",
+			QueryPatt: "holtWintersAberration(a)",
+			Interval:  10,
+			Datapoints: []schema.Point{
+				{Val: 1.2368731715, Ts: 40},
+				{Val: 0, Ts: 50},
+				{Val: 0, Ts: 60},
+				{Val: -1.3713080699515974, Ts: 70},
+				{Val: 0, Ts: 80},
+				{Val: 0, Ts: 90},
+				{Val: 7.941346896628033, Ts: 100},
+				{Val: -0.06000991090824925, Ts: 110},
+				{Val: 0, Ts: 120},
+			},
+		},
+	}
+
+	f := NewHoltWintersAberration()
+	hw := f.(*FuncHoltWintersAberration)
+	hw.in = NewMock(in)
+	hw.bootstrapInterval = "30s"
+	hw.seasonality = "20s"
+	hw.from = 40
+	got, err := f.Exec(make(map[Req][]models.Series))
+	testHoltWinters("aberration", out, got, err, t)
+}
@@ -0,0 +1,101 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/dur"
+	"gopkg.in/raintank/schema.v1"
+)
+
+type FuncHoltWintersConfidenceBands struct {
+	in                GraphiteFunc
+	delta             float64
+	bootstrapInterval string
+	seasonality       string
+	from              uint32
+}
+
+func NewHoltWintersConfidenceBands() GraphiteFunc {
+	return &FuncHoltWintersConfidenceBands{delta: 3, bootstrapInterval: "7d", seasonality: "1d"}
+}
+
+func (s *FuncHoltWintersConfidenceBands) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgFloat{key: "delta", opt: true, val: &s.delta},
+		ArgString{key: "bootstrapInterval", opt: true, val: &s.bootstrapInterval, validator: []Validator{IsIntervalString}},
+		ArgString{key: "seasonality", opt: true, val: &s.seasonality, validator: []Validator{IsIntervalString}},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncHoltWintersConfidenceBands) Context(context Context) Context {
+	s.from = context.from
+	context.from = holtWintersBootstrapFrom(context.from, s.bootstrapInterval)
+	return context
+}
+
+func (s *FuncHoltWintersConfidenceBands) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	seasonality, _ := dur.ParseDuration(s.seasonality)
+
+	var outputs []models.Series
+	for _, serie := range series {
+		lower, upper := holtWintersBands(serie, seasonality, s.delta, s.from)
+		lowerName := fmt.Sprintf("holtWintersConfidenceLower(%s)", serie.Target)
+		lowerSeries := models.Series{
+			Target:       lowerName,
+			QueryPatt:    lowerName,
+			Tags:         holtWintersTags(serie.Tags, "holtWintersConfidenceLower"),
+			Datapoints:   lower,
+			Interval:     serie.Interval,
+			QueryFrom:    s.from,
+			QueryTo:      serie.QueryTo,
+			Consolidator: serie.Consolidator,
+			QueryCons:    serie.QueryCons,
+		}
+		upperName := fmt.Sprintf("holtWintersConfidenceUpper(%s)", serie.Target)
+		upperSeries := models.Series{
+			Target:       upperName,
+			QueryPatt:    upperName,
+			Tags:         holtWintersTags(serie.Tags, "holtWintersConfidenceUpper"),
+			Datapoints:   upper,
+			Interval:     serie.Interval,
+			QueryFrom:    s.from,
+			QueryTo:      serie.QueryTo,
+			Consolidator: serie.Consolidator,
+			QueryCons:    serie.QueryCons,
+		}
+		outputs = append(outputs, lowerSeries, upperSeries)
+		cache[Req{}] = append(cache[Req{}], lowerSeries, upperSeries)
+	}
+	return outputs, nil
+}
+
+// holtWintersBands returns the lower and upper confidence bands for the given series,
+// for all points from the given ts onwards.
+// the returned slices come from the pool. it's up to the caller to add them to the cache.
+func holtWintersBands(serie models.Series, seasonality uint32, delta float64, from uint32) ([]schema.Point, []schema.Point) {
+	predictions, deviations := holtWintersAnalysis(serie.Datapoints, serie.Interval, seasonality)
+	lower := pointSlicePool.Get().([]schema.Point)
+	upper := pointSlicePool.Get().([]schema.Point)
+	for i, p := range serie.Datapoints {
+		if p.Ts < from {
+			continue
+		}
+		lowerPoint := schema.Point{Val: math.NaN(), Ts: p.Ts}
+		upperPoint := schema.Point{Val: math.NaN(), Ts: p.Ts}
+		if !math.IsNaN(predictions[i]) {
+			scaledDeviation := delta * deviations[i]
+			lowerPoint.Val = predictions[i] - scaledDeviation
+			upperPoint.Val = predictions[i] + scaledDeviation
+		}
+		lower = append(lower, lowerPoint)
+		upper = append(upper, upperPoint)
+	}
+	return lower, upper
+}
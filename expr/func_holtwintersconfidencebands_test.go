@@ -0,0 +1,63 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func TestHoltWintersConfidenceBands(t *testing.T) {
+	in := []models.Series{
+		{
+			Target:     "a",
+			QueryPatt:  "a",
+			Interval:   10,
+			Datapoints: getCopy(holtWintersInput),
+		},
+	}
+	out := []models.Series{
+		{
+			Target:    "holtWintersConfidenceLower(a)",
+			QueryPatt: "holtWintersConfidenceLower(a)",
+			Interval:  10,
+			Datapoints: []schema.Point{
+				{Val: 0.1600926815000001, Ts: 40},
+				{Val: 1.60925397108575, Ts: 50},
+				{Val: math.NaN(), Ts: 60},
+				{Val: 4.371308069951597, Ts: 70},
+				{Val: 2.469939870871819, Ts: 80},
+				{Val: 3.9415002265678867, Ts: 90},
+				{Val: -1.5985940859270302, Ts: 100},
+				{Val: 5.060009910908249, Ts: 110},
+				{Val: 7.942282319587258, Ts: 120},
+			},
+		},
+		{
+			Target:    "holtWintersConfidenceUpper(a)",
+			QueryPatt: "holtWintersConfidenceUpper(a)",
+			Interval:  10,
+			Datapoints: []schema.Point{
+				{Val: 2.7631268285, Ts: 40},
+				{Val: 1.60925397108575, Ts: 50},
+				{Val: math.NaN(), Ts: 60},
+				{Val: 5.546714987052967, Ts: 70},
+				{Val: 7.798011975907903, Ts: 80},
+				{Val: 5.402595028470693, Ts: 90},
+				{Val: 12.058653103371967, Ts: 100},
+				{Val: 6.989997436990356, Ts: 110},
+				{Val: 7.942282319587258, Ts: 120},
+			},
+		},
+	}
+
+	f := NewHoltWintersConfidenceBands()
+	hw := f.(*FuncHoltWintersConfidenceBands)
+	hw.in = NewMock(in)
+	hw.bootstrapInterval = "30s"
+	hw.seasonality = "20s"
+	hw.from = 40
+	got, err := f.Exec(make(map[Req][]models.Series))
+	testHoltWinters("confidenceBands", out, got, err, t)
+}
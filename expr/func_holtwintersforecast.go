@@ -0,0 +1,166 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/dur"
+	"gopkg.in/raintank/schema.v1"
+)
+
+type FuncHoltWintersForecast struct {
+	in                GraphiteFunc
+	bootstrapInterval string
+	seasonality       string
+	from              uint32
+}
+
+func NewHoltWintersForecast() GraphiteFunc {
+	return &FuncHoltWintersForecast{bootstrapInterval: "7d", seasonality: "1d"}
+}
+
+func (s *FuncHoltWintersForecast) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgString{key: "bootstrapInterval", opt: true, val: &s.bootstrapInterval, validator: []Validator{IsIntervalString}},
+		ArgString{key: "seasonality", opt: true, val: &s.seasonality, validator: []Validator{IsIntervalString}},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncHoltWintersForecast) Context(context Context) Context {
+	s.from = context.from
+	context.from = holtWintersBootstrapFrom(context.from, s.bootstrapInterval)
+	return context
+}
+
+func (s *FuncHoltWintersForecast) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	seasonality, _ := dur.ParseDuration(s.seasonality)
+
+	var outputs []models.Series
+	for _, serie := range series {
+		predictions, _ := holtWintersAnalysis(serie.Datapoints, serie.Interval, seasonality)
+		out := pointSlicePool.Get().([]schema.Point)
+		for i, p := range serie.Datapoints {
+			if p.Ts < s.from {
+				continue
+			}
+			out = append(out, schema.Point{Val: predictions[i], Ts: p.Ts})
+		}
+		name := fmt.Sprintf("holtWintersForecast(%s)", serie.Target)
+		output := models.Series{
+			Target:       name,
+			QueryPatt:    name,
+			Tags:         holtWintersTags(serie.Tags, "holtWintersForecast"),
+			Datapoints:   out,
+			Interval:     serie.Interval,
+			QueryFrom:    s.from,
+			QueryTo:      serie.QueryTo,
+			Consolidator: serie.Consolidator,
+			QueryCons:    serie.QueryCons,
+		}
+		outputs = append(outputs, output)
+		cache[Req{}] = append(cache[Req{}], output)
+	}
+	return outputs, nil
+}
+
+// holtWintersBootstrapFrom returns the from that should be requested
+// in order to have the given bootstrap interval of data available before from.
+func holtWintersBootstrapFrom(from uint32, bootstrapInterval string) uint32 {
+	bootstrap, _ := dur.ParseDuration(bootstrapInterval)
+	if bootstrap >= from {
+		return 0
+	}
+	return from - bootstrap
+}
+
+// holtWintersTags returns a copy of the given tags with the given function marker tag added
+func holtWintersTags(in map[string]string, fn string) map[string]string {
+	tags := make(map[string]string, len(in)+1)
+	for k, v := range in {
+		tags[k] = v
+	}
+	tags[fn] = "1"
+	return tags
+}
+
+// holtWintersAnalysis performs Holt-Winters triple exponential smoothing over the given points
+// and returns a prediction and a deviation for every input point.
+// this is a port of graphite's holtWintersAnalysis, including its handling of null values:
+// a null input resets the trend and yields a null prediction for the next point.
+func holtWintersAnalysis(points []schema.Point, interval, seasonality uint32) ([]float64, []float64) {
+	const alpha = 0.1
+	const beta = 0.0035
+	const gamma = 0.1
+
+	var seasonLength int
+	if interval > 0 {
+		seasonLength = int(seasonality / interval)
+	}
+
+	seasonals := make([]float64, len(points))
+	deviations := make([]float64, len(points))
+	predictions := make([]float64, len(points))
+
+	lastSeasonal := func(i int) float64 {
+		j := i - seasonLength
+		if j >= 0 && j < len(seasonals) {
+			return seasonals[j]
+		}
+		return 0
+	}
+	lastDeviation := func(i int) float64 {
+		j := i - seasonLength
+		if j >= 0 && j < len(deviations) {
+			return deviations[j]
+		}
+		return 0
+	}
+
+	intercept := math.NaN()
+	slope := float64(0)
+	nextPred := math.NaN()
+
+	for i, p := range points {
+		actual := p.Val
+		if math.IsNaN(actual) {
+			// missing input values break all the math
+			// do the best we can and move on
+			predictions[i] = nextPred
+			intercept = math.NaN()
+			slope = 0
+			nextPred = math.NaN()
+			continue
+		}
+
+		lastIntercept := intercept
+		lastSlope := slope
+		prediction := nextPred
+		if i == 0 {
+			// seed the first prediction as the first actual
+			prediction = actual
+		}
+		if math.IsNaN(lastIntercept) {
+			lastIntercept = actual
+		}
+
+		season := lastSeasonal(i)
+		intercept = alpha*(actual-season) + (1-alpha)*(lastIntercept+lastSlope)
+		slope = beta*(intercept-lastIntercept) + (1-beta)*lastSlope
+		seasonals[i] = gamma*(actual-intercept) + (1-gamma)*season
+		nextPred = intercept + slope + lastSeasonal(i+1)
+
+		predictionForDev := prediction
+		if math.IsNaN(predictionForDev) {
+			predictionForDev = 0
+		}
+		deviations[i] = gamma*math.Abs(actual-predictionForDev) + (1-gamma)*lastDeviation(i)
+		predictions[i] = prediction
+	}
+	return predictions, deviations
+}
@@ -0,0 +1,132 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+// input for the holtWinters tests. the first 3 points are the bootstrap window.
+var holtWintersInput = []schema.Point{
+	{Val: 1, Ts: 10},
+	{Val: 3, Ts: 20},
+	{Val: 2, Ts: 30},
+	{Val: 4, Ts: 40},
+	{Val: math.NaN(), Ts: 50},
+	{Val: 5, Ts: 60},
+	{Val: 3, Ts: 70},
+	{Val: 6, Ts: 80},
+	{Val: 4, Ts: 90},
+	{Val: 20, Ts: 100},
+	{Val: 5, Ts: 110},
+	{Val: math.NaN(), Ts: 120},
+}
+
+// expected values were obtained by running graphite's holtWintersAnalysis over holtWintersInput
+// with a season length of 2 points
+var holtWintersForecastOutput = []schema.Point{
+	{Val: 1.461609755, Ts: 40},
+	{Val: 1.60925397108575, Ts: 50},
+	{Val: math.NaN(), Ts: 60},
+	{Val: 4.959011528502282, Ts: 70},
+	{Val: 5.133975923389861, Ts: 80},
+	{Val: 4.67204762751929, Ts: 90},
+	{Val: 5.230029508722468, Ts: 100},
+	{Val: 6.0250036739493025, Ts: 110},
+	{Val: 7.942282319587258, Ts: 120},
+}
+
+func TestHoltWintersForecast(t *testing.T) {
+	in := []models.Series{
+		{
+			Target:     "a",
+			QueryPatt:  "a",
+			Interval:   10,
+			Datapoints: getCopy(holtWintersInput),
+		},
+	}
+	out := []models.Series{
+		{
+			Target:     "holtWintersForecast(a)",
+			QueryPatt:  "holtWintersForecast(a)",
+			Interval:   10,
+			Datapoints: holtWintersForecastOutput,
+		},
+	}
+
+	f := NewHoltWintersForecast()
+	hw := f.(*FuncHoltWintersForecast)
+	hw.in = NewMock(in)
+	hw.bootstrapInterval = "30s"
+	hw.seasonality = "20s"
+	hw.from = 40
+	got, err := f.Exec(make(map[Req][]models.Series))
+	testHoltWinters("forecast", out, got, err, t)
+	if got[0].Tags["holtWintersForecast"] != "1" {
+		t.Fatalf("expected tag holtWintersForecast=1, got %v", got[0].Tags)
+	}
+	if _, ok := in[0].Tags["holtWintersForecast"]; ok {
+		t.Fatalf("input tags should not be modified, got %v", in[0].Tags)
+	}
+}
+
+func TestHoltWintersBootstrapContext(t *testing.T) {
+	from := uint32(1000000)
+	to := uint32(1003600)
+	cases := []struct {
+		target  string
+		expFrom uint32
+	}{
+		{"holtWintersForecast(a)", from - 7*24*3600},
+		{"holtWintersForecast(a, '1d')", from - 24*3600},
+		{"holtWintersConfidenceBands(a, 2, '1h')", from - 3600},
+		{"holtWintersAberration(a, bootstrapInterval='2w')", 0},
+	}
+	for _, c := range cases {
+		exprs, err := ParseMany([]string{c.target})
+		if err != nil {
+			t.Fatalf("case %q: unexpected parse error %s", c.target, err)
+		}
+		plan, err := NewPlan(exprs, from, to, 800, true, nil, nil)
+		if err != nil {
+			t.Fatalf("case %q: unexpected plan error %s", c.target, err)
+		}
+		exp := []Req{NewReq("a", c.expFrom, to, 0)}
+		if len(plan.Reqs) != 1 || plan.Reqs[0] != exp[0] {
+			t.Fatalf("case %q: expected reqs %v, got %v", c.target, exp, plan.Reqs)
+		}
+	}
+}
+
+func testHoltWinters(name string, exp, got []models.Series, err error, t *testing.T) {
+	if err != nil {
+		t.Fatalf("case %q: err should be nil. got %q", name, err)
+	}
+	if len(got) != len(exp) {
+		t.Fatalf("case %q: expected %d output series, got %d", name, len(exp), len(got))
+	}
+	for i, o := range exp {
+		g := got[i]
+		if o.Target != g.Target {
+			t.Fatalf("case %q: expected target %q, got %q", name, o.Target, g.Target)
+		}
+		if o.QueryPatt != g.QueryPatt {
+			t.Fatalf("case %q: expected querypatt %q, got %q", name, o.QueryPatt, g.QueryPatt)
+		}
+		if o.Interval != g.Interval {
+			t.Fatalf("case %q: expected interval %d, got %d", name, o.Interval, g.Interval)
+		}
+		if len(o.Datapoints) != len(g.Datapoints) {
+			t.Fatalf("case %q: len output expected %d, got %d", name, len(o.Datapoints), len(g.Datapoints))
+		}
+		for j, p := range o.Datapoints {
+			bothNaN := math.IsNaN(p.Val) && math.IsNaN(g.Datapoints[j].Val)
+			if (bothNaN || p.Val == g.Datapoints[j].Val) && p.Ts == g.Datapoints[j].Ts {
+				continue
+			}
+			t.Fatalf("case %q: output point %d - expected %v got %v", name, j, p, g.Datapoints[j])
+		}
+	}
+}
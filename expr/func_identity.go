@@ -0,0 +1,36 @@
+package expr
+
+import (
+	"github.com/grafana/metrictank/api/models"
+)
+
+type FuncIdentity struct {
+	name string
+	from uint32
+	to   uint32
+}
+
+func NewIdentity() GraphiteFunc {
+	return &FuncIdentity{}
+}
+
+func (s *FuncIdentity) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgString{key: "name", val: &s.name},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncIdentity) Context(context Context) Context {
+	s.from = context.from
+	s.to = context.to
+	return context
+}
+
+// Exec returns a series with a point every minute, whose value is its timestamp.
+func (s *FuncIdentity) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	start, end := syntheticRange(s.from, s.to)
+	out := syntheticPoints(start, end, 60, func(ts uint32) float64 {
+		return float64(ts)
+	})
+	return []models.Series{syntheticOutput(cache, s.name, s.name, s.from, s.to, 60, out)}, nil
+}
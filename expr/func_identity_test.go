@@ -0,0 +1,28 @@
+package expr
+
+import (
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func TestIdentity(t *testing.T) {
+	// graphite from 1000 until 1200
+	out := runSynthetic([]string{`identity("the.time")`}, 1001, 1201, true, t)
+	exp := []models.Series{
+		{
+			Target: "the.time",
+			Datapoints: []schema.Point{
+				{Val: 1000, Ts: 1000},
+				{Val: 1060, Ts: 1060},
+				{Val: 1120, Ts: 1120},
+				{Val: 1180, Ts: 1180},
+			},
+		},
+	}
+	checkSeriesPoints("identity", exp, out, t)
+	if out[0].Interval != 60 || out[0].QueryFrom != 1001 || out[0].QueryTo != 1201 || out[0].Tags["name"] != "the.time" {
+		t.Fatalf("expected interval 60, query range 1001-1201 and name tag, got %d, %d-%d and %v", out[0].Interval, out[0].QueryFrom, out[0].QueryTo, out[0].Tags)
+	}
+}
@@ -0,0 +1,65 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+type FuncIntegral struct {
+	in GraphiteFunc
+}
+
+func NewIntegral() GraphiteFunc {
+	return &FuncIntegral{}
+}
+
+func (s *FuncIntegral) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncIntegral) Context(context Context) Context {
+	context.consol = 0
+	return context
+}
+
+func (s *FuncIntegral) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs []models.Series
+	for _, serie := range series {
+		out := pointSlicePool.Get().([]schema.Point)
+		current := 0.0
+		for _, p := range serie.Datapoints {
+			if math.IsNaN(p.Val) {
+				out = append(out, p)
+				continue
+			}
+			current += p.Val
+			out = append(out, schema.Point{Val: current, Ts: p.Ts})
+		}
+		name := fmt.Sprintf("integral(%s)", serie.Target)
+		tags := make(map[string]string, len(serie.Tags)+1)
+		for k, v := range serie.Tags {
+			tags[k] = v
+		}
+		tags["integral"] = "1"
+		output := models.Series{
+			Target:     name,
+			QueryPatt:  name,
+			Tags:       tags,
+			Datapoints: out,
+			Interval:   serie.Interval,
+		}
+		outputs = append(outputs, output)
+		cache[Req{}] = append(cache[Req{}], output)
+	}
+	return outputs, nil
+}
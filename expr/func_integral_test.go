@@ -0,0 +1,76 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func TestIntegral(t *testing.T) {
+	testIntegral(
+		"integral",
+		[]models.Series{
+			getModel("a", a),
+			getModel("c", c),
+		},
+		[]models.Series{
+			getModel("integral(a)", []schema.Point{
+				{Val: 0, Ts: 10},
+				{Val: 0, Ts: 20},
+				{Val: 5.5, Ts: 30},
+				{Val: math.NaN(), Ts: 40},
+				{Val: math.NaN(), Ts: 50},
+				{Val: 1234567895.5, Ts: 60},
+			}),
+			getModel("integral(c)", []schema.Point{
+				{Val: 0, Ts: 10},
+				{Val: 0, Ts: 20},
+				{Val: 1, Ts: 30},
+				{Val: 3, Ts: 40},
+				{Val: 6, Ts: 50},
+				{Val: 10, Ts: 60},
+			}),
+		},
+		t,
+	)
+}
+
+func TestIntegralAllNaN(t *testing.T) {
+	testIntegral(
+		"integral-allnan",
+		[]models.Series{
+			getModel("allNaN", allNaN),
+		},
+		[]models.Series{
+			getModel("integral(allNaN)", allNaN),
+		},
+		t,
+	)
+}
+
+func testIntegral(name string, in []models.Series, out []models.Series, t *testing.T) {
+	inCopy := make([]models.Series, len(in))
+	for i, serie := range in {
+		inCopy[i] = serie
+		inCopy[i].Datapoints = getCopy(serie.Datapoints)
+	}
+	f := NewIntegral()
+	f.(*FuncIntegral).in = NewMock(in)
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("case %q: err should be nil. got %q", name, err)
+	}
+	checkSeriesPoints(name, out, got, t)
+	for i, g := range got {
+		if g.QueryPatt != out[i].QueryPatt {
+			t.Fatalf("case %q: expected querypatt %q, got %q", name, out[i].QueryPatt, g.QueryPatt)
+		}
+		if g.Tags["integral"] != "1" {
+			t.Fatalf("case %q: expected tag integral=1, got %v", name, g.Tags)
+		}
+	}
+	// the input should not have been modified
+	checkSeriesPoints(name, inCopy, in, t)
+}
@@ -0,0 +1,92 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/dur"
+	"gopkg.in/raintank/schema.v1"
+)
+
+type FuncIntegralByInterval struct {
+	in           GraphiteFunc
+	intervalUnit string
+	from         uint32
+}
+
+func NewIntegralByInterval() GraphiteFunc {
+	return &FuncIntegralByInterval{}
+}
+
+func (s *FuncIntegralByInterval) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgString{key: "intervalUnit", val: &s.intervalUnit, validator: []Validator{IsIntervalString}},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncIntegralByInterval) Context(context Context) Context {
+	context.consol = 0
+	s.from = context.from
+	return context
+}
+
+func (s *FuncIntegralByInterval) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	interval, _ := dur.ParseDuration(s.intervalUnit)
+
+	var outputs []models.Series
+	for _, serie := range series {
+		out := pointSlicePool.Get().([]schema.Point)
+		current := 0.0
+		for _, p := range serie.Datapoints {
+			// like graphite, the intervals are relative to the start of the request,
+			// and the sum is reset whenever a point is the first one of a new interval.
+			ts := int64(p.Ts)
+			if integralBucket(ts, s.from, interval) != integralBucket(ts-int64(serie.Interval), s.from, interval) {
+				current = 0
+			}
+			// like graphite, a null point keeps the current sum
+			if !math.IsNaN(p.Val) {
+				current += p.Val
+			}
+			out = append(out, schema.Point{Val: current, Ts: p.Ts})
+		}
+		name := fmt.Sprintf("integralByInterval(%s,'%s')", serie.Target, s.intervalUnit)
+		tags := make(map[string]string, len(serie.Tags)+1)
+		for k, v := range serie.Tags {
+			tags[k] = v
+		}
+		tags["integralByInterval"] = s.intervalUnit
+		output := models.Series{
+			Target:       name,
+			QueryPatt:    name,
+			Tags:         tags,
+			Datapoints:   out,
+			Interval:     serie.Interval,
+			Consolidator: serie.Consolidator,
+			QueryCons:    serie.QueryCons,
+		}
+		outputs = append(outputs, output)
+		cache[Req{}] = append(cache[Req{}], output)
+	}
+	return outputs, nil
+}
+
+// integralBucket returns the number of the interval that ts falls into, counting from the given start.
+// timestamps before start result in negative buckets
+func integralBucket(ts int64, start, interval uint32) int64 {
+	if interval == 0 {
+		return 0
+	}
+	diff := ts - int64(start)
+	bucket := diff / int64(interval)
+	if diff < 0 && diff%int64(interval) != 0 {
+		bucket--
+	}
+	return bucket
+}
@@ -0,0 +1,75 @@
+package expr
+
+import (
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/consolidation"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func TestIntegralByInterval(t *testing.T) {
+	cases := []struct {
+		name         string
+		in           []schema.Point
+		intervalUnit string
+		from         uint32
+		exp          []float64
+	}{
+		{"interval aligned to first point", c, "20s", 10, []float64{0, 0, 1, 3, 3, 7}},
+		{"from before first point", c, "20s", 5, []float64{0, 0, 1, 3, 3, 7}},
+		{"interval not aligned to first point", c, "20s", 0, []float64{0, 0, 1, 2, 5, 4}},
+		{"interval larger than the data", c, "1h", 10, []float64{0, 0, 1, 3, 6, 10}},
+		{"with nulls", a, "30s", 10, []float64{0, 0, 5.5, 0, 0, 1234567890}},
+	}
+	for _, c := range cases {
+		exp := make([]schema.Point, len(c.exp))
+		for i, v := range c.exp {
+			exp[i] = schema.Point{Val: v, Ts: c.in[i].Ts}
+		}
+		name := "integralByInterval(a,'" + c.intervalUnit + "')"
+		in := getModel("a", c.in)
+		in.Interval = 10
+		in.Consolidator = consolidation.Max
+		in.QueryCons = consolidation.Max
+		testIntegralByInterval(
+			c.name,
+			[]models.Series{in},
+			[]models.Series{getModel(name, exp)},
+			c.intervalUnit,
+			c.from,
+			t,
+		)
+	}
+}
+
+func testIntegralByInterval(name string, in []models.Series, out []models.Series, intervalUnit string, from uint32, t *testing.T) {
+	inCopy := make([]models.Series, len(in))
+	for i, serie := range in {
+		inCopy[i] = serie
+		inCopy[i].Datapoints = getCopy(serie.Datapoints)
+	}
+	f := NewIntegralByInterval()
+	ibi := f.(*FuncIntegralByInterval)
+	ibi.intervalUnit = intervalUnit
+	ibi.Context(Context{from: from, to: from + 3600})
+	ibi.in = NewMock(in)
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("case %q: err should be nil. got %q", name, err)
+	}
+	checkSeriesPoints(name, out, got, t)
+	for i, g := range got {
+		if g.QueryPatt != out[i].QueryPatt {
+			t.Fatalf("case %q: expected querypatt %q, got %q", name, out[i].QueryPatt, g.QueryPatt)
+		}
+		if g.Tags["integralByInterval"] != intervalUnit {
+			t.Fatalf("case %q: expected tag integralByInterval=%s, got %v", name, intervalUnit, g.Tags)
+		}
+		if g.Consolidator != in[i].Consolidator || g.QueryCons != in[i].QueryCons {
+			t.Fatalf("case %q: expected consolidators %v/%v, got %v/%v", name, in[i].Consolidator, in[i].QueryCons, g.Consolidator, g.QueryCons)
+		}
+	}
+	// the input should not have been modified
+	checkSeriesPoints(name, inCopy, in, t)
+}
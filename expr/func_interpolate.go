@@ -0,0 +1,83 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+type FuncInterpolate struct {
+	in    GraphiteFunc
+	limit int64
+}
+
+func NewInterpolate() GraphiteFunc {
+	return &FuncInterpolate{limit: math.MaxInt64}
+}
+
+func (s *FuncInterpolate) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgInt{key: "limit", opt: true, val: &s.limit},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncInterpolate) Context(context Context) Context {
+	return context
+}
+
+func (s *FuncInterpolate) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := make([]models.Series, 0, len(series))
+	for _, serie := range series {
+		out := pointSlicePool.Get().([]schema.Point)
+		out = append(out, serie.Datapoints...)
+		interpolate(out, int(s.limit))
+
+		tags := make(map[string]string, len(serie.Tags)+1)
+		for k, v := range serie.Tags {
+			tags[k] = v
+		}
+		tags["interpolate"] = limitTag(s.limit)
+
+		output := models.Series{
+			Target:       fmt.Sprintf("interpolate(%s)", serie.Target),
+			QueryPatt:    fmt.Sprintf("interpolate(%s)", serie.QueryPatt),
+			Tags:         tags,
+			Datapoints:   out,
+			Interval:     serie.Interval,
+			Consolidator: serie.Consolidator,
+			QueryCons:    serie.QueryCons,
+		}
+		outputs = append(outputs, output)
+		cache[Req{}] = append(cache[Req{}], output)
+	}
+	return outputs, nil
+}
+
+// interpolate replaces, in place, every run of at most limit nulls that has a non-null value on both sides
+// by values on the straight line between those two values.
+// runs of nulls at the start or the end are left alone.
+func interpolate(points []schema.Point, limit int) {
+	gap := 0 // number of nulls since the last non-null value
+	for i := range points {
+		if math.IsNaN(points[i].Val) {
+			gap++
+			continue
+		}
+		if gap > 0 && gap <= limit && i-gap > 0 {
+			start := points[i-gap-1].Val
+			step := (points[i].Val - start) / float64(gap+1)
+			for j := 1; j <= gap; j++ {
+				points[i-gap-1+j].Val = start + step*float64(j)
+			}
+		}
+		gap = 0
+	}
+}
@@ -0,0 +1,56 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func TestInterpolatePoints(t *testing.T) {
+	NaN := math.NaN()
+	cases := []struct {
+		name  string
+		in    []schema.Point
+		limit int
+		exp   []schema.Point
+	}{
+		{"no nulls", nullsPoints(1, 2, 3), math.MaxInt64, nullsPoints(1, 2, 3)},
+		{"leading nulls", nullsPoints(NaN, NaN, 3), math.MaxInt64, nullsPoints(NaN, NaN, 3)},
+		{"trailing nulls", nullsPoints(1, 2, NaN, NaN), math.MaxInt64, nullsPoints(1, 2, NaN, NaN)},
+		{"gap", nullsPoints(1, NaN, NaN, 4), math.MaxInt64, nullsPoints(1, 2, 3, 4)},
+		{"decreasing gap", nullsPoints(10, NaN, 0), math.MaxInt64, nullsPoints(10, 5, 0)},
+		{"gap within limit", nullsPoints(1, NaN, NaN, 4), 2, nullsPoints(1, 2, 3, 4)},
+		{"gap over limit", nullsPoints(1, NaN, NaN, NaN, 5, NaN, 7), 2, nullsPoints(1, NaN, NaN, NaN, 5, 6, 7)},
+		{"all nulls", nullsPoints(NaN, NaN), math.MaxInt64, nullsPoints(NaN, NaN)},
+	}
+	for _, c := range cases {
+		got := getCopy(c.in)
+		interpolate(got, c.limit)
+		checkSeriesPoints(c.name, []models.Series{{Datapoints: c.exp}}, []models.Series{{Datapoints: got}}, t)
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	in := []models.Series{getModel("a", a), getModel("c", c)}
+	f := NewInterpolate().(*FuncInterpolate)
+	f.in = NewMock(in)
+
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	expA := getCopy(a)
+	expA[3].Val = 5.5 + (1234567890-5.5)/3
+	expA[4].Val = 5.5 + (1234567890-5.5)/3*2
+	exp := []models.Series{
+		getModel("interpolate(a)", expA),
+		getModel("interpolate(c)", c),
+	}
+	checkSeriesPoints("interpolate", exp, got, t)
+	if got[0].Tags["interpolate"] != "inf" {
+		t.Fatalf("expected tag interpolate=inf, got %v", got[0].Tags)
+	}
+	checkSeriesPoints("interpolate input", []models.Series{getModel("a", a), getModel("c", c)}, in, t)
+}
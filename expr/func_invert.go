@@ -0,0 +1,41 @@
+package expr
+
+import (
+	"fmt"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+type FuncInvert struct {
+	in GraphiteFunc
+}
+
+func NewInvert() GraphiteFunc {
+	return &FuncInvert{}
+}
+
+func (s *FuncInvert) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncInvert) Context(context Context) Context {
+	return context
+}
+
+// Exec computes 1/x for every value x. the inverse of 0 is null.
+func (s *FuncInvert) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	var outputs []models.Series
+	for _, serie := range series {
+		name := fmt.Sprintf("invert(%s)", serie.Target)
+		outputs = append(outputs, transformSeries(cache, serie, name, "invert", "1", func(v float64) float64 {
+			return safePow(v, -1)
+		}))
+	}
+	return outputs, nil
+}
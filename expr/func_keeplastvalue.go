@@ -0,0 +1,96 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+type FuncKeepLastValue struct {
+	in    GraphiteFunc
+	limit int64
+}
+
+func NewKeepLastValue() GraphiteFunc {
+	return &FuncKeepLastValue{limit: math.MaxInt64}
+}
+
+func (s *FuncKeepLastValue) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgInt{key: "limit", opt: true, val: &s.limit},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncKeepLastValue) Context(context Context) Context {
+	return context
+}
+
+func (s *FuncKeepLastValue) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := make([]models.Series, 0, len(series))
+	for _, serie := range series {
+		out := pointSlicePool.Get().([]schema.Point)
+		out = append(out, serie.Datapoints...)
+		keepLastValue(out, int(s.limit))
+
+		tags := make(map[string]string, len(serie.Tags)+1)
+		for k, v := range serie.Tags {
+			tags[k] = v
+		}
+		tags["keepLastValue"] = limitTag(s.limit)
+
+		output := models.Series{
+			Target:       fmt.Sprintf("keepLastValue(%s)", serie.Target),
+			QueryPatt:    fmt.Sprintf("keepLastValue(%s)", serie.QueryPatt),
+			Tags:         tags,
+			Datapoints:   out,
+			Interval:     serie.Interval,
+			Consolidator: serie.Consolidator,
+			QueryCons:    serie.QueryCons,
+		}
+		outputs = append(outputs, output)
+		cache[Req{}] = append(cache[Req{}], output)
+	}
+	return outputs, nil
+}
+
+// keepLastValue replaces, in place, every run of at most limit nulls by the value preceding it.
+// like graphite, a run of nulls at the start is left alone, as there is no value to keep.
+func keepLastValue(points []schema.Point, limit int) {
+	gap := 0 // number of nulls since the last non-null value
+	for i := range points {
+		if math.IsNaN(points[i].Val) {
+			gap++
+			continue
+		}
+		if gap > 0 && gap <= limit && i-gap > 0 {
+			fillGap(points[i-gap:i], points[i-gap-1].Val)
+		}
+		gap = 0
+	}
+	if gap > 0 && gap <= limit && len(points)-gap > 0 {
+		fillGap(points[len(points)-gap:], points[len(points)-gap-1].Val)
+	}
+}
+
+func fillGap(points []schema.Point, val float64) {
+	for i := range points {
+		points[i].Val = val
+	}
+}
+
+// limitTag returns the tag value for the given limit, where the default limit is graphite's infinity
+func limitTag(limit int64) string {
+	if limit == math.MaxInt64 {
+		return "inf"
+	}
+	return strconv.FormatInt(limit, 10)
+}
@@ -0,0 +1,83 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+// nullsPoints returns points with timestamps 10, 20, ... for the given values
+func nullsPoints(vals ...float64) []schema.Point {
+	out := make([]schema.Point, len(vals))
+	for i, v := range vals {
+		out[i] = schema.Point{Val: v, Ts: uint32(i+1) * 10}
+	}
+	return out
+}
+
+func TestKeepLastValuePoints(t *testing.T) {
+	NaN := math.NaN()
+	cases := []struct {
+		name  string
+		in    []schema.Point
+		limit int
+		exp   []schema.Point
+	}{
+		{"no nulls", nullsPoints(1, 2, 3), math.MaxInt64, nullsPoints(1, 2, 3)},
+		{"leading nulls", nullsPoints(NaN, NaN, 3), math.MaxInt64, nullsPoints(NaN, NaN, 3)},
+		{"gap", nullsPoints(1, NaN, NaN, 4), math.MaxInt64, nullsPoints(1, 1, 1, 4)},
+		{"trailing nulls", nullsPoints(1, 2, NaN, NaN), math.MaxInt64, nullsPoints(1, 2, 2, 2)},
+		{"gap within limit", nullsPoints(1, NaN, NaN, 4), 2, nullsPoints(1, 1, 1, 4)},
+		{"gap over limit", nullsPoints(1, NaN, NaN, NaN, 5, NaN, 7), 2, nullsPoints(1, NaN, NaN, NaN, 5, 5, 7)},
+		{"trailing nulls over limit", nullsPoints(1, NaN, NaN), 1, nullsPoints(1, NaN, NaN)},
+		{"zero limit", nullsPoints(1, NaN, 3), 0, nullsPoints(1, NaN, 3)},
+		{"all nulls", nullsPoints(NaN, NaN), math.MaxInt64, nullsPoints(NaN, NaN)},
+	}
+	for _, c := range cases {
+		got := getCopy(c.in)
+		keepLastValue(got, c.limit)
+		checkSeriesPoints(c.name, []models.Series{{Datapoints: c.exp}}, []models.Series{{Datapoints: got}}, t)
+	}
+}
+
+func TestKeepLastValue(t *testing.T) {
+	in := []models.Series{getModel("a", a), getModel("b;foo=bar", b)}
+	f := NewKeepLastValue().(*FuncKeepLastValue)
+	f.in = NewMock(in)
+	f.limit = 1
+
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	expA := getCopy(a)
+	expB := getCopy(b)
+	expB[3].Val = expB[2].Val
+	expB[5].Val = expB[4].Val
+	exp := []models.Series{
+		getModel("keepLastValue(a)", expA),
+		getModel("keepLastValue(b;foo=bar)", expB),
+	}
+	checkSeriesPoints("keepLastValue", exp, got, t)
+	if got[1].Tags["keepLastValue"] != "1" || got[1].Tags["foo"] != "bar" {
+		t.Fatalf("expected tags keepLastValue=1 and foo=bar, got %v", got[1].Tags)
+	}
+	if _, ok := in[1].Tags["keepLastValue"]; ok {
+		t.Fatalf("input tags were modified: %v", in[1].Tags)
+	}
+	checkSeriesPoints("keepLastValue input", []models.Series{getModel("a", a), getModel("b;foo=bar", b)}, in, t)
+}
+
+func TestKeepLastValueDefaultLimitTag(t *testing.T) {
+	f := NewKeepLastValue().(*FuncKeepLastValue)
+	f.in = NewMock([]models.Series{getModel("a", a)})
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if got[0].Tags["keepLastValue"] != "inf" {
+		t.Fatalf("expected tag keepLastValue=inf, got %v", got[0].Tags)
+	}
+}
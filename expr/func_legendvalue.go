@@ -0,0 +1,119 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+var errLegendValueSystem = errors.New("the unit system (si or binary) can only be the last value type")
+
+type FuncLegendValue struct {
+	in         GraphiteFunc
+	valueTypes []string
+}
+
+func NewLegendValue() GraphiteFunc {
+	return &FuncLegendValue{}
+}
+
+func (s *FuncLegendValue) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgStrings{key: "valueTypes", validator: []Validator{IsLegendValueType}, val: &s.valueTypes},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncLegendValue) Context(context Context) Context {
+	return context
+}
+
+func (s *FuncLegendValue) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	valueTypes := s.valueTypes
+	var system string
+	if len(valueTypes) > 0 && isUnitSystem(valueTypes[len(valueTypes)-1]) {
+		system = valueTypes[len(valueTypes)-1]
+		valueTypes = valueTypes[:len(valueTypes)-1]
+	}
+	for _, valueType := range valueTypes {
+		if isUnitSystem(valueType) {
+			return nil, errLegendValueSystem
+		}
+	}
+
+	outputs := make([]models.Series, 0, len(series))
+	for _, serie := range series {
+		name := serie.Target
+		for _, valueType := range valueTypes {
+			value := getSeriesAggFunc(valueType)(serie.Datapoints)
+			var formatted string
+			switch {
+			case math.IsNaN(value):
+				formatted = "None"
+			case system != "":
+				v, prefix := formatUnits(value, system)
+				formatted = fmt.Sprintf("%.2f%s", v, prefix)
+			default:
+				formatted = fmt.Sprintf("%.2f", value)
+			}
+			name = fmt.Sprintf("%-20s%-5s%-10s", name, valueType, formatted)
+		}
+		serie.Target = name
+		serie.QueryPatt = name
+		outputs = append(outputs, serie)
+	}
+	return outputs, nil
+}
+
+type unitPrefix struct {
+	prefix string
+	size   float64
+}
+
+var unitSystems = map[string][]unitPrefix{
+	"binary": {
+		{"Pi", math.Pow(1024, 5)},
+		{"Ti", math.Pow(1024, 4)},
+		{"Gi", math.Pow(1024, 3)},
+		{"Mi", math.Pow(1024, 2)},
+		{"Ki", 1024},
+	},
+	"si": {
+		{"P", math.Pow(1000, 5)},
+		{"T", math.Pow(1000, 4)},
+		{"G", math.Pow(1000, 3)},
+		{"M", math.Pow(1000, 2)},
+		{"K", 1000},
+	},
+}
+
+func isUnitSystem(s string) bool {
+	_, ok := unitSystems[s]
+	return ok
+}
+
+// formatUnits scales the value down by the largest prefix of the given unit system that fits,
+// and returns the scaled value along with that prefix. this is a port of graphite's format_units.
+func formatUnits(v float64, system string) (float64, string) {
+	for _, p := range unitSystems[system] {
+		if math.Abs(v) >= p.size {
+			return unitsFloor(v / p.size), p.prefix
+		}
+	}
+	return unitsFloor(v), ""
+}
+
+// unitsFloor removes floating point noise from values that are nearly whole numbers
+func unitsFloor(v float64) float64 {
+	if v-math.Floor(v) < 0.00000000001 && v > 1 {
+		return math.Floor(v)
+	}
+	return v
+}
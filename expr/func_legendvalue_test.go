@@ -0,0 +1,71 @@
+package expr
+
+import (
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func TestFormatUnits(t *testing.T) {
+	cases := []struct {
+		v         float64
+		system    string
+		expV      float64
+		expPrefix string
+	}{
+		{0, "si", 0, ""},
+		{999, "si", 999, ""},
+		{1000, "si", 1, "K"},
+		{2500000, "si", 2.5, "M"},
+		{-2500000, "si", -2.5, "M"},
+		{3e15, "si", 3, "P"},
+		{1000, "binary", 1000, ""},
+		{1024, "binary", 1, "Ki"},
+		{1536 * 1024, "binary", 1.5, "Mi"},
+		{0.5, "si", 0.5, ""},
+	}
+	for _, c := range cases {
+		v, prefix := formatUnits(c.v, c.system)
+		if v != c.expV || prefix != c.expPrefix {
+			t.Fatalf("case %g %s: expected %g%s, got %g%s", c.v, c.system, c.expV, c.expPrefix, v, prefix)
+		}
+	}
+}
+
+func TestLegendValue(t *testing.T) {
+	big := []schema.Point{{Val: 1500, Ts: 10}, {Val: 2500, Ts: 20}}
+	cases := []struct {
+		name       string
+		in         models.Series
+		valueTypes []string
+		exp        string
+	}{
+		{"avg", getModel("c", c), []string{"avg"}, "c                   avg  1.67      "},
+		{"avg and total", getModel("c", c), []string{"avg", "total"}, "c                   avg  1.67      total10.00     "},
+		{"si", getModel("big", big), []string{"avg", "si"}, "big                 avg  2.00K     "},
+		{"no values", getModel("allNaN", allNaN), []string{"max"}, "allNaN              max  None      "},
+	}
+	for _, c := range cases {
+		f := NewLegendValue().(*FuncLegendValue)
+		f.in = NewMock([]models.Series{c.in})
+		f.valueTypes = c.valueTypes
+		got, err := f.Exec(make(map[Req][]models.Series))
+		if err != nil {
+			t.Fatalf("case %q: unexpected error %s", c.name, err)
+		}
+		if len(got) != 1 || got[0].Target != c.exp {
+			t.Fatalf("case %q: expected %q, got %v", c.name, c.exp, got)
+		}
+	}
+}
+
+func TestLegendValueMisplacedSystem(t *testing.T) {
+	f := NewLegendValue().(*FuncLegendValue)
+	f.in = NewMock([]models.Series{getModel("c", c)})
+	f.valueTypes = []string{"si", "avg"}
+	_, err := f.Exec(make(map[Req][]models.Series))
+	if err != errLegendValueSystem {
+		t.Fatalf("expected error %q, got %v", errLegendValueSystem, err)
+	}
+}
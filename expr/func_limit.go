@@ -0,0 +1,36 @@
+package expr
+
+import (
+	"github.com/grafana/metrictank/api/models"
+)
+
+type FuncLimit struct {
+	in GraphiteFunc
+	n  int64
+}
+
+func NewLimit() GraphiteFunc {
+	return &FuncLimit{}
+}
+
+func (s *FuncLimit) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgInt{key: "n", validator: []Validator{IntPositive}, val: &s.n},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncLimit) Context(context Context) Context {
+	return context
+}
+
+func (s *FuncLimit) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	if int(s.n) < len(series) {
+		return series[:s.n], nil
+	}
+	return series, nil
+}
@@ -0,0 +1,37 @@
+package expr
+
+import (
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+func TestLimit(t *testing.T) {
+	cases := []struct {
+		n   int64
+		out []string
+	}{
+		{1, []string{"a"}},
+		{3, []string{"a", "b", "c"}},
+		{5, []string{"a", "b", "c", "d", "allNaN"}},
+		{10, []string{"a", "b", "c", "d", "allNaN"}},
+	}
+	for i, c := range cases {
+		f := NewLimit()
+		limit := f.(*FuncLimit)
+		limit.in = NewMock(getSeriesNamed())
+		limit.n = c.n
+		got, err := f.Exec(make(map[Req][]models.Series))
+		if err != nil {
+			t.Fatalf("case %d: err should be nil. got %q", i, err)
+		}
+		if len(got) != len(c.out) {
+			t.Fatalf("case %d: expected %d output series, got %d", i, len(c.out), len(got))
+		}
+		for j, o := range c.out {
+			if o != got[j].Target {
+				t.Fatalf("case %d: expected target %q at position %d, got %q", i, o, j, got[j].Target)
+			}
+		}
+	}
+}
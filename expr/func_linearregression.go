@@ -0,0 +1,118 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/dur"
+	"gopkg.in/raintank/schema.v1"
+)
+
+type FuncLinearRegression struct {
+	in            GraphiteFunc
+	startSourceAt string
+	endSourceAt   string
+	start         uint32 // start of the source range, as a graphite from
+	end           uint32 // end of the source range, as a graphite until
+	from          uint32 // the requested from (inclusive)
+	to            uint32 // the requested to (exclusive)
+}
+
+func NewLinearRegression() GraphiteFunc {
+	return &FuncLinearRegression{}
+}
+
+func (s *FuncLinearRegression) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgString{key: "startSourceAt", opt: true, val: &s.startSourceAt, validator: []Validator{IsDateTimeString}},
+		ArgString{key: "endSourceAt", opt: true, val: &s.endSourceAt, validator: []Validator{IsDateTimeString}},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncLinearRegression) Context(context Context) Context {
+	return context
+}
+
+// DataRange returns the source range, which defaults to the requested range.
+// like for the requested range, from and to are one second past the graphite from and until.
+func (s *FuncLinearRegression) DataRange(from, to uint32) (uint32, uint32, error) {
+	s.from = from
+	s.to = to
+	s.start = from - 1
+	s.end = to - 1
+	now := time.Now()
+	var err error
+	if s.startSourceAt != "" {
+		s.start, err = dur.ParseDateTime(s.startSourceAt, time.Local, now, 0)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if s.endSourceAt != "" {
+		s.end, err = dur.ParseDateTime(s.endSourceAt, time.Local, now, 0)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return s.start + 1, s.end + 1, nil
+}
+
+// Exec fits a line through the source data of every series using the least squares method,
+// and returns the values of that line over the requested range.
+// series without at least 2 non-null values in the source range can't be fitted and are left out.
+func (s *FuncLinearRegression) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	var outputs []models.Series
+	for _, serie := range series {
+		factor, offset, ok := linearRegressionAnalysis(serie.Datapoints, serie.Interval)
+		if !ok {
+			continue
+		}
+		out := pointSlicePool.Get().([]schema.Point)
+		if serie.Interval > 0 {
+			for ts := ((s.from + serie.Interval - 1) / serie.Interval) * serie.Interval; ts < s.to; ts += serie.Interval {
+				out = append(out, schema.Point{Val: offset + float64(ts)*factor, Ts: ts})
+			}
+		}
+		name := fmt.Sprintf("linearRegression(%s, %d, %d)", serie.Target, s.start, s.end)
+		// the output covers the requested range, not the source range
+		serie.QueryFrom = s.from
+		serie.QueryTo = s.to
+		outputs = append(outputs, transformOutput(cache, serie, name, "linearRegressions", fmt.Sprintf("%d, %d", s.start, s.end), out))
+	}
+	return outputs, nil
+}
+
+// linearRegressionAnalysis returns the factor and offset of the line value = offset + ts * factor
+// that fits the given points best, in accordance with graphite's linearRegressionAnalysis.
+// ok is false if no line can be fitted, which is the case with less than 2 non-null points.
+func linearRegressionAnalysis(points []schema.Point, interval uint32) (factor, offset float64, ok bool) {
+	if len(points) == 0 || interval == 0 {
+		return 0, 0, false
+	}
+	var n, sumI, sumV, sumII, sumIV float64
+	for i, p := range points {
+		if math.IsNaN(p.Val) {
+			continue
+		}
+		fi := float64(i)
+		n++
+		sumI += fi
+		sumV += p.Val
+		sumII += fi * fi
+		sumIV += fi * p.Val
+	}
+	denominator := n*sumII - sumI*sumI
+	if denominator == 0 {
+		return 0, 0, false
+	}
+	factor = (n*sumIV - sumI*sumV) / denominator / float64(interval)
+	offset = (sumII*sumV-sumIV*sumI)/denominator - factor*float64(points[0].Ts)
+	return factor, offset, true
+}
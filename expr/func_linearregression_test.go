@@ -0,0 +1,98 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func TestLinearRegressionPlan(t *testing.T) {
+	from := uint32(1001)
+	to := uint32(2001)
+	cases := []struct {
+		target  string
+		expFrom uint32
+		expTo   uint32
+		expErr  error
+	}{
+		{"linearRegression(a)", from, to, nil},
+		{"linearRegression(a, '500')", 501, to, nil},
+		{"linearRegression(a, '100', '500')", 101, 501, nil},
+		{"linearRegression(a, endSourceAt='3000')", from, 3001, nil},
+		{"linearRegression(a, '500', '100')", 0, 0, ErrInvalidRange},
+		{"linearRegression(a, '3000')", 0, 0, ErrInvalidRange},
+	}
+	for _, c := range cases {
+		exprs, err := ParseMany([]string{c.target})
+		if err != nil {
+			t.Fatalf("case %q: unexpected parse error %s", c.target, err)
+		}
+		plan, err := NewPlan(exprs, from, to, 800, true, nil, nil)
+		if err != c.expErr {
+			t.Fatalf("case %q: expected plan error %v, got %v", c.target, c.expErr, err)
+		}
+		if err != nil {
+			continue
+		}
+		exp := NewReq("a", c.expFrom, c.expTo, 0)
+		if len(plan.Reqs) != 1 || plan.Reqs[0] != exp {
+			t.Fatalf("case %q: expected reqs %v, got %v", c.target, []Req{exp}, plan.Reqs)
+		}
+	}
+}
+
+func TestLinearRegression(t *testing.T) {
+	NaN := math.NaN()
+	// the line 5 + ts/10, with a null
+	source := []schema.Point{
+		{Val: 6, Ts: 10},
+		{Val: NaN, Ts: 20},
+		{Val: 8, Ts: 30},
+		{Val: 9, Ts: 40},
+		{Val: 10, Ts: 50},
+	}
+	in := []models.Series{
+		getModel("a", source),
+		getModel("b", []schema.Point{{Val: 1, Ts: 10}, {Val: NaN, Ts: 20}}),
+	}
+	for i := range in {
+		in[i].Interval = 10
+		in[i].QueryFrom = 1
+		in[i].QueryTo = 61
+	}
+	f := NewLinearRegression().(*FuncLinearRegression)
+	f.in = NewMock(in)
+	f.startSourceAt = "0"
+	f.endSourceAt = "60"
+	from, to, err := f.DataRange(101, 141)
+	if err != nil || from != 1 || to != 61 {
+		t.Fatalf("expected source range 1-61, got %d-%d, err %v", from, to, err)
+	}
+
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected series b to be left out as it has only 1 value, got %d series", len(got))
+	}
+	out := got[0]
+	if out.Target != "linearRegression(a, 0, 60)" || out.Tags["linearRegressions"] != "0, 60" {
+		t.Fatalf("unexpected target %q or tags %v", out.Target, out.Tags)
+	}
+	if out.QueryFrom != 101 || out.QueryTo != 141 || out.Interval != 10 {
+		t.Fatalf("expected range 101-141 with interval 10, got %d-%d with interval %d", out.QueryFrom, out.QueryTo, out.Interval)
+	}
+	exp := []schema.Point{{Val: 16, Ts: 110}, {Val: 17, Ts: 120}, {Val: 18, Ts: 130}, {Val: 19, Ts: 140}}
+	if len(out.Datapoints) != len(exp) {
+		t.Fatalf("expected %d points, got %v", len(exp), out.Datapoints)
+	}
+	for i, p := range exp {
+		g := out.Datapoints[i]
+		if g.Ts != p.Ts || math.Abs(g.Val-p.Val) > 1e-9 {
+			t.Fatalf("point %d: expected %v, got %v", i, p, g)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+type FuncLog struct {
+	in   GraphiteFunc
+	base float64
+}
+
+func NewLog() GraphiteFunc {
+	return &FuncLog{base: 10}
+}
+
+func (s *FuncLog) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgFloat{key: "base", opt: true, val: &s.base},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncLog) Context(context Context) Context {
+	return context
+}
+
+// Exec computes the logarithm of every value in the given base.
+// the logarithm of a value that is not positive is null, and so is any value for an invalid base.
+func (s *FuncLog) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	logBase := math.Log(s.base)
+	var outputs []models.Series
+	for _, serie := range series {
+		name := fmt.Sprintf("log(%s, %g)", serie.Target, s.base)
+		outputs = append(outputs, transformSeries(cache, serie, name, "log", fmt.Sprintf("%g", s.base), func(v float64) float64 {
+			if v <= 0 {
+				return math.NaN()
+			}
+			res := math.Log(v) / logBase
+			if math.IsInf(res, 0) {
+				return math.NaN()
+			}
+			return res
+		}))
+	}
+	return outputs, nil
+}
@@ -0,0 +1,45 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+func TestLog(t *testing.T) {
+	NaN := math.NaN()
+	cases := []struct {
+		base   float64
+		name   string
+		in     []float64
+		expect []float64
+	}{
+		{10, "log(a, 10)", []float64{1, 1000, 0.01, 0, -10, NaN}, []float64{0, 3, -2, NaN, NaN, NaN}},
+		{2, "log(a, 2)", []float64{1, 8, 0.5}, []float64{0, 3, -1}},
+		{1, "log(a, 1)", []float64{1, 8}, []float64{NaN, NaN}},
+	}
+	for _, c := range cases {
+		f := NewLog().(*FuncLog)
+		f.in = NewMock([]models.Series{getModel("a", nullsPoints(c.in...))})
+		f.base = c.base
+
+		got, err := f.Exec(make(map[Req][]models.Series))
+		if err != nil {
+			t.Fatalf("case %q: unexpected error %s", c.name, err)
+		}
+		if len(got) != 1 || len(got[0].Datapoints) != len(c.expect) {
+			t.Fatalf("case %q: unexpected output %v", c.name, got)
+		}
+		if got[0].Target != c.name {
+			t.Fatalf("case %q: got target %q", c.name, got[0].Target)
+		}
+		// the results of math.Log are not always exact, so we compare with a tolerance
+		for i, exp := range c.expect {
+			val := got[0].Datapoints[i].Val
+			if math.IsNaN(exp) != math.IsNaN(val) || math.Abs(exp-val) > 1e-9 {
+				t.Fatalf("case %q: point %d: expected %f, got %f", c.name, i, exp, val)
+			}
+		}
+	}
+}
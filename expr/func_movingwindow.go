@@ -0,0 +1,146 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/batch"
+	"gopkg.in/raintank/schema.v1"
+)
+
+type FuncMovingWindow struct {
+	in           GraphiteFunc
+	windowPoints int64  // size of the window in points, if specified as an int
+	windowSize   string // size of the window as a time interval, if specified as a string
+	fn           string
+	xFilesFactor float64
+	generic      bool   // whether fn is an argument (movingWindow) rather than fixed (movingAverage etc)
+	shift        uint32 // seconds of data prefetched before from, to fill the window
+	from         uint32 // the original from, before prefetching
+}
+
+// NewMovingWindowConstructor returns a constructor for a moving window function with the given aggregation function,
+// or for movingWindow, which takes the aggregation function as an argument, if fn is empty.
+func NewMovingWindowConstructor(fn string) func() GraphiteFunc {
+	return func() GraphiteFunc {
+		if fn == "" {
+			return &FuncMovingWindow{fn: "average", generic: true}
+		}
+		return &FuncMovingWindow{fn: fn}
+	}
+}
+
+func (s *FuncMovingWindow) Signature() ([]Arg, []Arg) {
+	args := []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgIn{
+			key: "windowSize",
+			args: []Arg{
+				ArgInt{val: &s.windowPoints, validator: []Validator{IntPositive}},
+				ArgString{val: &s.windowSize, validator: []Validator{IsTimeShiftString}},
+			},
+		},
+	}
+	if s.generic {
+		args = append(args, ArgString{key: "func", opt: true, val: &s.fn, validator: []Validator{IsSeriesAggFunc}})
+	}
+	args = append(args, ArgFloat{key: "xFilesFactor", opt: true, val: &s.xFilesFactor, validator: []Validator{IsXFilesFactor}})
+	return args, []Arg{ArgSeriesList{}}
+}
+
+// Context prefetches a window worth of data before from, so that the first points have a full window.
+// for windows specified as a number of points, the interval of the data is not known yet, see Bootstrap.
+func (s *FuncMovingWindow) Context(context Context) Context {
+	s.from = context.from
+	if s.windowSize != "" {
+		shift, _ := parseTimeShift(s.windowSize)
+		if shift < 0 {
+			shift = -shift
+		}
+		s.shift = uint32(shift)
+		if s.shift >= context.from {
+			context.from = 0
+		} else {
+			context.from -= s.shift
+		}
+	}
+	return context
+}
+
+// Bootstrap prefetches the data for windows specified as a number of points, once the interval of the data is known.
+// like graphite, it needs the window size times the largest interval of the input series.
+func (s *FuncMovingWindow) Bootstrap(cache map[Req][]models.Series) (uint32, error) {
+	if s.windowSize != "" {
+		return 0, nil
+	}
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return 0, err
+	}
+	var interval uint32
+	for _, serie := range series {
+		if serie.Interval > interval {
+			interval = serie.Interval
+		}
+	}
+	s.shift = interval * uint32(s.windowPoints)
+	return s.shift, nil
+}
+
+// Exec computes the aggregation of the window of every point. in accordance with graphite,
+// the window of a point covers the points before it, excluding the point itself.
+func (s *FuncMovingWindow) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	aggFunc := getSeriesAggFunc(s.fn)
+	tagName := "moving" + strings.ToUpper(s.fn[:1]) + s.fn[1:]
+
+	var outputs []models.Series
+	for _, serie := range series {
+		windowPoints := int(s.windowPoints)
+		windowSize := fmt.Sprintf("%d", s.windowPoints)
+		name := fmt.Sprintf("%s(%s,%d)", tagName, serie.Target, s.windowPoints)
+		if s.windowSize != "" {
+			windowPoints = 0
+			if serie.Interval > 0 {
+				windowPoints = int(s.shift / serie.Interval)
+			}
+			windowSize = s.windowSize
+			name = fmt.Sprintf("%s(%s,\"%s\")", tagName, serie.Target, s.windowSize)
+		}
+
+		out := pointSlicePool.Get().([]schema.Point)
+		for i, p := range serie.Datapoints {
+			if p.Ts < s.from {
+				continue
+			}
+			start := i - windowPoints
+			if start < 0 {
+				start = 0
+			}
+			out = append(out, schema.Point{Val: s.aggregate(serie.Datapoints[start:i], windowPoints, aggFunc), Ts: p.Ts})
+		}
+		serie.QueryFrom = s.from
+		outputs = append(outputs, transformOutput(cache, serie, name, tagName, windowSize, out))
+	}
+	return outputs, nil
+}
+
+// aggregate returns the aggregation of the given window of points, which may be shorter than windowPoints,
+// or null if its ratio of non-null values to windowPoints does not meet the xFilesFactor.
+func (s *FuncMovingWindow) aggregate(window []schema.Point, windowPoints int, aggFunc batch.AggFunc) float64 {
+	var nonNull int
+	for _, p := range window {
+		if !math.IsNaN(p.Val) {
+			nonNull++
+		}
+	}
+	if nonNull == 0 || float64(nonNull)/float64(windowPoints) < s.xFilesFactor {
+		return math.NaN()
+	}
+	return aggFunc(window)
+}
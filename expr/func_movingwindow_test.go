@@ -0,0 +1,167 @@
+package expr
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+func TestMovingWindowPlan(t *testing.T) {
+	from := uint32(1000)
+	to := uint32(2000)
+	cases := []struct {
+		target  string
+		expFrom uint32
+		expErr  bool
+	}{
+		{"movingAverage(a, 5)", from, false},
+		{"movingAverage(a, '1min')", from - 60, false},
+		{"movingSum(a, '-2min', 0.5)", from - 120, false},
+		{"movingWindow(a, '1h')", 0, false},
+		{"movingWindow(a, 3, 'median', xFilesFactor=0.3)", from, false},
+		{"movingWindow(a, 3, 'bogus')", 0, true},
+		{"movingMax(a, 0)", 0, true},
+		{"movingMin(a, 3, 1.5)", 0, true},
+		{"movingMin(a, 3, 'max')", 0, true},
+	}
+	for _, c := range cases {
+		exprs, err := ParseMany([]string{c.target})
+		if err != nil {
+			t.Fatalf("case %q: unexpected parse error %s", c.target, err)
+		}
+		plan, err := NewPlan(exprs, from, to, 800, true, nil, nil)
+		if c.expErr {
+			if err == nil {
+				t.Fatalf("case %q: expected plan error, got none", c.target)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("case %q: unexpected plan error %s", c.target, err)
+		}
+		exp := NewReq("a", c.expFrom, to, 0)
+		if len(plan.Reqs) != 1 || plan.Reqs[0] != exp {
+			t.Fatalf("case %q: expected reqs %v, got %v", c.target, []Req{exp}, plan.Reqs)
+		}
+	}
+}
+
+func TestMovingWindowBootstrap(t *testing.T) {
+	from := uint32(41)
+	to := uint32(91)
+	exprs, err := ParseMany([]string{"movingSum(a, 3)"})
+	if err != nil {
+		t.Fatalf("unexpected parse error %s", err)
+	}
+	plan, err := NewPlan(exprs, from, to, 800, true, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected plan error %s", err)
+	}
+
+	// once the interval is known, the 3 points before from are fetched
+	in := getModel("a", nullsPoints(5, 6, 7, 8, 9))
+	in.Interval = 10
+	for i := range in.Datapoints {
+		in.Datapoints[i].Ts += 40
+	}
+	data := map[Req][]models.Series{NewReq("a", from, to, 0): {in}}
+	reqs, err := plan.DynamicReqs(data)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	exp := []Req{NewReq("a", from-30, to, 0)}
+	if !reflect.DeepEqual(reqs, exp) {
+		t.Fatalf("expected reqs %v, got %v", exp, reqs)
+	}
+
+	in = getModel("a", nullsPoints(1, 2, 3, 4, 5, 6, 7, 8, 9))
+	in.Interval = 10
+	data[exp[0]] = []models.Series{in}
+	reqs, err = plan.DynamicReqs(data)
+	if err != nil || len(reqs) != 0 {
+		t.Fatalf("expected no more reqs, got %v and error %v", reqs, err)
+	}
+	got, err := plan.Run(data)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	checkSeriesPoints("movingSum", []models.Series{getModel("movingSum(a,3)", nullsPoints(0, 0, 0, 0, 9, 12, 15, 18, 21)[4:])}, got, t)
+}
+
+func TestMovingWindowTime(t *testing.T) {
+	NaN := math.NaN()
+	// the data was prefetched from 11, with points at 10s interval. the requested from is 31
+	in := []models.Series{getModel("a", nullsPoints(1, 2, NaN, 4, 5, NaN, NaN))}
+	in[0].Interval = 10
+	f := NewMovingWindowConstructor("sum")().(*FuncMovingWindow)
+	f.in = NewMock(in)
+	f.windowSize = "20s"
+	f.Context(Context{from: 31, to: 71})
+
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	exp := []models.Series{getModel(`movingSum(a,"20s")`, nullsPoints(0, 0, 0, 2, 4, 9, 5)[3:])}
+	checkSeriesPoints("movingSum", exp, got, t)
+	if got[0].QueryFrom != 31 || got[0].Tags["movingSum"] != "20s" {
+		t.Fatalf("expected QueryFrom 31 and tag movingSum=20s, got %d and %v", got[0].QueryFrom, got[0].Tags)
+	}
+}
+
+func TestMovingWindowPoints(t *testing.T) {
+	NaN := math.NaN()
+	cases := []struct {
+		fn   string
+		xff  float64
+		name string
+		exp  []float64
+	}{
+		{"average", 0, "movingAverage(a,3)", []float64{NaN, 1, 1.5, 1.5, 3, 5, 6, 7}},
+		{"median", 0, "movingMedian(a,3)", []float64{NaN, 1, 1.5, 1.5, 3, 5, 6, 7}},
+		{"max", 0, "movingMax(a,3)", []float64{NaN, 1, 2, 2, 4, 6, 8, 8}},
+		{"min", 0.5, "movingMin(a,3)", []float64{NaN, NaN, 1, 1, 2, 4, 4, 6}},
+		{"sum", 1, "movingSum(a,3)", []float64{NaN, NaN, NaN, NaN, NaN, NaN, 18, NaN}},
+	}
+	for _, c := range cases {
+		in := []models.Series{getModel("a", nullsPoints(1, 2, NaN, 4, 6, 8, NaN, NaN))}
+		in[0].Interval = 10
+		f := NewMovingWindowConstructor(c.fn)().(*FuncMovingWindow)
+		f.in = NewMock(in)
+		f.windowPoints = 3
+		f.xFilesFactor = c.xff
+		f.Context(Context{from: 10, to: 90})
+
+		got, err := f.Exec(make(map[Req][]models.Series))
+		if err != nil {
+			t.Fatalf("case %q: unexpected error %s", c.name, err)
+		}
+		checkSeriesPoints(c.name, []models.Series{getModel(c.name, nullsPoints(c.exp...))}, got, t)
+	}
+}
+
+func TestMovingWindowGenericName(t *testing.T) {
+	f := NewMovingWindowConstructor("")().(*FuncMovingWindow)
+	f.in = NewMock([]models.Series{getModel("a", nullsPoints(1, 2))})
+	f.windowPoints = 2
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if got[0].Target != "movingAverage(a,2)" {
+		t.Fatalf("expected target movingAverage(a,2), got %q", got[0].Target)
+	}
+	f = NewMovingWindowConstructor("")().(*FuncMovingWindow)
+	f.in = NewMock([]models.Series{getModel("a", nullsPoints(1, 2))})
+	f.windowPoints = 2
+	f.fn = "diff"
+	got, err = f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if got[0].Target != "movingDiff(a,2)" {
+		t.Fatalf("expected target movingDiff(a,2), got %q", got[0].Target)
+	}
+}
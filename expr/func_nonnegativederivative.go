@@ -0,0 +1,99 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+type FuncNonNegativeDerivative struct {
+	in       GraphiteFunc
+	maxValue float64
+	minValue float64
+}
+
+func NewNonNegativeDerivative() GraphiteFunc {
+	return &FuncNonNegativeDerivative{maxValue: math.NaN(), minValue: math.NaN()}
+}
+
+func (s *FuncNonNegativeDerivative) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgFloat{key: "maxValue", opt: true, val: &s.maxValue},
+		ArgFloat{key: "minValue", opt: true, val: &s.minValue},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncNonNegativeDerivative) Context(context Context) Context {
+	context.consol = 0
+	return context
+}
+
+func (s *FuncNonNegativeDerivative) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs []models.Series
+	for _, serie := range series {
+		out := pointSlicePool.Get().([]schema.Point)
+		prev := math.NaN()
+		for _, p := range serie.Datapoints {
+			var delta float64
+			delta, prev = nonNegativeDelta(p.Val, prev, s.maxValue, s.minValue)
+			out = append(out, schema.Point{Val: delta, Ts: p.Ts})
+		}
+		name := fmt.Sprintf("nonNegativeDerivative(%s)", serie.Target)
+		tags := make(map[string]string, len(serie.Tags)+1)
+		for k, v := range serie.Tags {
+			tags[k] = v
+		}
+		tags["nonNegativeDerivative"] = "1"
+		output := models.Series{
+			Target:     name,
+			QueryPatt:  name,
+			Tags:       tags,
+			Datapoints: out,
+			Interval:   serie.Interval,
+		}
+		outputs = append(outputs, output)
+		cache[Req{}] = append(cache[Req{}], output)
+	}
+	return outputs, nil
+}
+
+// nonNegativeDelta returns the delta between the given value and the previous one,
+// along with the value to use as previous value for the next point.
+// maxValue and minValue are optional (NaN when not set).
+// this is a port of graphite's _nonNegativeDelta:
+// * values above maxValue or below minValue are ignored, and the next value will have no previous value.
+// * if the counter decreased and maxValue is set, we assume the counter wrapped: delta = maxValue + 1 + val - prev - minValue
+// * if the counter decreased and only minValue is set, we assume the counter was reset: delta = val - minValue
+// * otherwise, a decrease results in a null.
+func nonNegativeDelta(val, prev, maxValue, minValue float64) (float64, float64) {
+	if !math.IsNaN(maxValue) && val > maxValue {
+		return math.NaN(), math.NaN()
+	}
+	if !math.IsNaN(minValue) && val < minValue {
+		return math.NaN(), math.NaN()
+	}
+	if math.IsNaN(prev) || math.IsNaN(val) {
+		return math.NaN(), val
+	}
+	if val >= prev {
+		return val - prev, val
+	}
+	if !math.IsNaN(maxValue) {
+		if math.IsNaN(minValue) {
+			return maxValue + 1 + val - prev, val
+		}
+		return maxValue + 1 + val - prev - minValue, val
+	}
+	if !math.IsNaN(minValue) {
+		return val - minValue, val
+	}
+	return math.NaN(), val
+}
@@ -0,0 +1,134 @@
+package expr
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/test"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func TestNonNegativeDerivative(t *testing.T) {
+	nan := math.NaN()
+	cases := []struct {
+		name     string
+		in       []schema.Point
+		maxValue float64
+		minValue float64
+		exp      []float64
+	}{
+		{"no wrap handling", a, nan, nan, []float64{nan, 0, 5.5, nan, nan, nan}},
+		{"increasing", c, nan, nan, []float64{nan, 0, 1, 1, 1, 1}},
+		{"counter wrap without max", d, nan, nan, []float64{nan, 33, 166, nan, 51, 170}},
+		{"counter wrap with max", d, 255, nan, []float64{nan, 33, 166, 86, 51, 170}},
+		{"counter wrap with max and min", d, 255, 10, []float64{nan, nan, 166, 76, 51, 170}},
+		{"counter reset with min", d, nan, 10, []float64{nan, nan, 166, 19, 51, 170}},
+		{"values above max are ignored", d, 100, nan, []float64{nan, 33, nan, nan, 51, nan}},
+	}
+	for _, c := range cases {
+		exp := make([]schema.Point, len(c.exp))
+		for i, v := range c.exp {
+			exp[i] = schema.Point{Val: v, Ts: c.in[i].Ts}
+		}
+		testNonNegativeDerivative(
+			c.name,
+			[]models.Series{getModel("a", c.in)},
+			[]models.Series{getModel("nonNegativeDerivative(a)", exp)},
+			c.maxValue,
+			c.minValue,
+			t,
+		)
+	}
+}
+
+func testNonNegativeDerivative(name string, in []models.Series, out []models.Series, maxValue, minValue float64, t *testing.T) {
+	inCopy := make([]models.Series, len(in))
+	for i, serie := range in {
+		inCopy[i] = serie
+		inCopy[i].Datapoints = getCopy(serie.Datapoints)
+	}
+	f := NewNonNegativeDerivative()
+	nnd := f.(*FuncNonNegativeDerivative)
+	nnd.in = NewMock(in)
+	nnd.maxValue = maxValue
+	nnd.minValue = minValue
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("case %q: err should be nil. got %q", name, err)
+	}
+	checkSeriesPoints(name, out, got, t)
+	for i, g := range got {
+		if g.QueryPatt != out[i].QueryPatt {
+			t.Fatalf("case %q: expected querypatt %q, got %q", name, out[i].QueryPatt, g.QueryPatt)
+		}
+		if g.Tags["nonNegativeDerivative"] != "1" {
+			t.Fatalf("case %q: expected tag nonNegativeDerivative=1, got %v", name, g.Tags)
+		}
+	}
+	// the input should not have been modified
+	checkSeriesPoints(name, inCopy, in, t)
+}
+
+func BenchmarkNonNegativeDerivative10k_1NoNulls(b *testing.B) {
+	benchmarkNonNegativeDerivative(b, 1, test.RandFloats10k, test.RandFloats10k)
+}
+func BenchmarkNonNegativeDerivative10k_10NoNulls(b *testing.B) {
+	benchmarkNonNegativeDerivative(b, 10, test.RandFloats10k, test.RandFloats10k)
+}
+func BenchmarkNonNegativeDerivative10k_100NoNulls(b *testing.B) {
+	benchmarkNonNegativeDerivative(b, 100, test.RandFloats10k, test.RandFloats10k)
+}
+func BenchmarkNonNegativeDerivative10k_1000NoNulls(b *testing.B) {
+	benchmarkNonNegativeDerivative(b, 1000, test.RandFloats10k, test.RandFloats10k)
+}
+func BenchmarkNonNegativeDerivative10k_1SomeSeriesHalfNulls(b *testing.B) {
+	benchmarkNonNegativeDerivative(b, 1, test.RandFloats10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkNonNegativeDerivative10k_10SomeSeriesHalfNulls(b *testing.B) {
+	benchmarkNonNegativeDerivative(b, 10, test.RandFloats10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkNonNegativeDerivative10k_100SomeSeriesHalfNulls(b *testing.B) {
+	benchmarkNonNegativeDerivative(b, 100, test.RandFloats10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkNonNegativeDerivative10k_1000SomeSeriesHalfNulls(b *testing.B) {
+	benchmarkNonNegativeDerivative(b, 1000, test.RandFloats10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkNonNegativeDerivative10k_1AllSeriesHalfNulls(b *testing.B) {
+	benchmarkNonNegativeDerivative(b, 1, test.RandFloatsWithNulls10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkNonNegativeDerivative10k_10AllSeriesHalfNulls(b *testing.B) {
+	benchmarkNonNegativeDerivative(b, 10, test.RandFloatsWithNulls10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkNonNegativeDerivative10k_100AllSeriesHalfNulls(b *testing.B) {
+	benchmarkNonNegativeDerivative(b, 100, test.RandFloatsWithNulls10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkNonNegativeDerivative10k_1000AllSeriesHalfNulls(b *testing.B) {
+	benchmarkNonNegativeDerivative(b, 1000, test.RandFloatsWithNulls10k, test.RandFloatsWithNulls10k)
+}
+
+func benchmarkNonNegativeDerivative(b *testing.B, numSeries int, fn0, fn1 func() []schema.Point) {
+	var input []models.Series
+	for i := 0; i < numSeries; i++ {
+		series := models.Series{
+			QueryPatt: strconv.Itoa(i),
+		}
+		if i%2 == 0 {
+			series.Datapoints = fn0()
+		} else {
+			series.Datapoints = fn1()
+		}
+		input = append(input, series)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f := NewNonNegativeDerivative()
+		f.(*FuncNonNegativeDerivative).in = NewMock(input)
+		got, err := f.Exec(make(map[Req][]models.Series))
+		if err != nil {
+			b.Fatalf("%s", err)
+		}
+		results = got
+	}
+}
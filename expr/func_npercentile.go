@@ -0,0 +1,70 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+type FuncNPercentile struct {
+	in GraphiteFunc
+	n  float64
+}
+
+func NewNPercentile() GraphiteFunc {
+	return &FuncNPercentile{}
+}
+
+func (s *FuncNPercentile) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgFloat{key: "n", validator: []Validator{IsPercent}, val: &s.n},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncNPercentile) Context(context Context) Context {
+	return context
+}
+
+func (s *FuncNPercentile) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs []models.Series
+	for _, serie := range series {
+		// in accordance with graphite, series without any values are dropped
+		percentile := pointsPercentile(serie.Datapoints, s.n, false)
+		if math.IsNaN(percentile) {
+			continue
+		}
+		out := pointSlicePool.Get().([]schema.Point)
+		for _, p := range serie.Datapoints {
+			out = append(out, schema.Point{Val: percentile, Ts: p.Ts})
+		}
+
+		tags := make(map[string]string, len(serie.Tags)+1)
+		for k, v := range serie.Tags {
+			tags[k] = v
+		}
+		tags["nPercentile"] = strconv.FormatFloat(s.n, 'g', -1, 64)
+
+		name := fmt.Sprintf("nPercentile(%s, %g)", serie.Target, s.n)
+		output := models.Series{
+			Target:       name,
+			QueryPatt:    fmt.Sprintf("nPercentile(%s, %g)", serie.QueryPatt, s.n),
+			Tags:         tags,
+			Datapoints:   out,
+			Interval:     serie.Interval,
+			Consolidator: serie.Consolidator,
+			QueryCons:    serie.QueryCons,
+		}
+		outputs = append(outputs, output)
+		cache[Req{}] = append(cache[Req{}], output)
+	}
+	return outputs, nil
+}
@@ -0,0 +1,42 @@
+package expr
+
+import (
+	"fmt"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+type FuncOffset struct {
+	in     GraphiteFunc
+	factor float64
+}
+
+func NewOffset() GraphiteFunc {
+	return &FuncOffset{}
+}
+
+func (s *FuncOffset) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgFloat{key: "factor", val: &s.factor},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncOffset) Context(context Context) Context {
+	return context
+}
+
+func (s *FuncOffset) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	var outputs []models.Series
+	for _, serie := range series {
+		name := fmt.Sprintf("offset(%s,%g)", serie.Target, s.factor)
+		outputs = append(outputs, transformSeries(cache, serie, name, "offset", fmt.Sprintf("%g", s.factor), func(v float64) float64 {
+			return v + s.factor
+		}))
+	}
+	return outputs, nil
+}
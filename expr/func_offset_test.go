@@ -0,0 +1,46 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+func TestOffset(t *testing.T) {
+	NaN := math.NaN()
+	in := []models.Series{getModel("a", nullsPoints(1, NaN, -3))}
+	f := NewOffset().(*FuncOffset)
+	f.in = NewMock(in)
+	f.factor = 1.5
+
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	exp := []models.Series{getModel("offset(a,1.5)", nullsPoints(2.5, NaN, -1.5))}
+	checkSeriesPoints("offset", exp, got, t)
+	checkSeriesPoints("offset input", []models.Series{getModel("a", nullsPoints(1, NaN, -3))}, in, t)
+}
+
+func TestOffsetToZero(t *testing.T) {
+	NaN := math.NaN()
+	f := NewOffsetToZero().(*FuncOffsetToZero)
+	f.in = NewMock([]models.Series{
+		getModel("a", nullsPoints(5, NaN, 3, 8)),
+		getModel("b", nullsPoints(NaN, NaN)),
+	})
+
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	exp := []models.Series{
+		getModel("offsetToZero(a)", nullsPoints(2, NaN, 0, 5)),
+		getModel("offsetToZero(b)", nullsPoints(NaN, NaN)),
+	}
+	checkSeriesPoints("offsetToZero", exp, got, t)
+	if got[0].Tags["offsetToZero"] != "3" {
+		t.Fatalf("expected tag offsetToZero=3, got %v", got[0].Tags)
+	}
+}
@@ -0,0 +1,43 @@
+package expr
+
+import (
+	"fmt"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/batch"
+)
+
+type FuncOffsetToZero struct {
+	in GraphiteFunc
+}
+
+func NewOffsetToZero() GraphiteFunc {
+	return &FuncOffsetToZero{}
+}
+
+func (s *FuncOffsetToZero) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncOffsetToZero) Context(context Context) Context {
+	return context
+}
+
+// Exec offsets every series by its minimum, so that its lowest value becomes zero.
+func (s *FuncOffsetToZero) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	var outputs []models.Series
+	for _, serie := range series {
+		min := batch.Min(serie.Datapoints)
+		name := fmt.Sprintf("offsetToZero(%s)", serie.Target)
+		outputs = append(outputs, transformSeries(cache, serie, name, "offsetToZero", fmt.Sprintf("%g", min), func(v float64) float64 {
+			return v - min
+		}))
+	}
+	return outputs, nil
+}
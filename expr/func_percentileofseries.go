@@ -0,0 +1,69 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+type FuncPercentileOfSeries struct {
+	in          GraphiteFunc
+	n           float64
+	interpolate bool
+}
+
+func NewPercentileOfSeries() GraphiteFunc {
+	return &FuncPercentileOfSeries{}
+}
+
+func (s *FuncPercentileOfSeries) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgFloat{key: "n", validator: []Validator{IsPercent}, val: &s.n},
+		ArgBool{key: "interpolate", opt: true, val: &s.interpolate},
+	}, []Arg{ArgSeries{}}
+}
+
+func (s *FuncPercentileOfSeries) Context(context Context) Context {
+	return context
+}
+
+func (s *FuncPercentileOfSeries) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	if len(series) == 0 {
+		return series, nil
+	}
+
+	out := pointSlicePool.Get().([]schema.Point)
+	vals := make([]float64, 0, len(series))
+	for i, p := range series[0].Datapoints {
+		vals = vals[:0]
+		for _, serie := range series {
+			if i < len(serie.Datapoints) && !math.IsNaN(serie.Datapoints[i].Val) {
+				vals = append(vals, serie.Datapoints[i].Val)
+			}
+		}
+		sort.Float64s(vals)
+		out = append(out, schema.Point{Val: percentile(vals, s.n, s.interpolate), Ts: p.Ts})
+	}
+
+	cons, queryCons := summarizeCons(series)
+	name := fmt.Sprintf("percentileOfSeries(%s,%g)", series[0].QueryPatt, s.n)
+	output := models.Series{
+		Target:       name,
+		QueryPatt:    name,
+		Tags:         map[string]string{"name": name},
+		Datapoints:   out,
+		Interval:     series[0].Interval,
+		Consolidator: cons,
+		QueryCons:    queryCons,
+	}
+	cache[Req{}] = append(cache[Req{}], output)
+	return []models.Series{output}, nil
+}
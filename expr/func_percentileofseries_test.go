@@ -0,0 +1,177 @@
+package expr
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/test"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func TestPercentileOfSeries(t *testing.T) {
+	in := []models.Series{
+		getModel("a", a),
+		getModel("c", c),
+		getModel("d", d),
+		getModel("allNaN", allNaN),
+	}
+	cases := []struct {
+		n           float64
+		interpolate bool
+		exp         []schema.Point
+	}{
+		{
+			50,
+			false,
+			[]schema.Point{
+				{Val: 0, Ts: 10},
+				{Val: 0, Ts: 20},
+				{Val: 5.5, Ts: 30},
+				{Val: 29, Ts: 40},
+				{Val: 80, Ts: 50},
+				{Val: 250, Ts: 60},
+			},
+		},
+		{
+			50,
+			true,
+			[]schema.Point{
+				{Val: 0, Ts: 10},
+				{Val: 0, Ts: 20},
+				{Val: 5.5, Ts: 30},
+				{Val: 15.5, Ts: 40},
+				{Val: 41.5, Ts: 50},
+				{Val: 250, Ts: 60},
+			},
+		},
+		{
+			100,
+			false,
+			[]schema.Point{
+				{Val: 0, Ts: 10},
+				{Val: 33, Ts: 20},
+				{Val: 199, Ts: 30},
+				{Val: 29, Ts: 40},
+				{Val: 80, Ts: 50},
+				{Val: 1234567890, Ts: 60},
+			},
+		},
+	}
+	for _, c := range cases {
+		f := NewPercentileOfSeries().(*FuncPercentileOfSeries)
+		f.in = NewMock(in)
+		f.n = c.n
+		f.interpolate = c.interpolate
+		got, err := f.Exec(make(map[Req][]models.Series))
+		if err != nil {
+			t.Fatalf("case n=%g interpolate=%t: unexpected error %s", c.n, c.interpolate, err)
+		}
+		name := "percentileOfSeries(a," + strconv.FormatFloat(c.n, 'g', -1, 64) + ")"
+		checkSeriesPoints(name, []models.Series{getModel(name, c.exp)}, got, t)
+	}
+}
+
+func TestPercentileOfSeriesAllNaN(t *testing.T) {
+	f := NewPercentileOfSeries().(*FuncPercentileOfSeries)
+	f.in = NewMock([]models.Series{getModel("allNaN", allNaN)})
+	f.n = 50
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	checkSeriesPoints("allNaN", []models.Series{getModel("percentileOfSeries(allNaN,50)", allNaN)}, got, t)
+}
+
+func TestPercentileOfSeriesNoInput(t *testing.T) {
+	f := NewPercentileOfSeries().(*FuncPercentileOfSeries)
+	f.in = NewMock(nil)
+	f.n = 50
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("expected no output, got %v", got)
+	}
+}
+
+func BenchmarkPercentileOfSeries10k_1NoNulls(b *testing.B) {
+	benchmarkPercentileOfSeries(b, 1, test.RandFloats10k, test.RandFloats10k)
+}
+func BenchmarkPercentileOfSeries10k_10NoNulls(b *testing.B) {
+	benchmarkPercentileOfSeries(b, 10, test.RandFloats10k, test.RandFloats10k)
+}
+func BenchmarkPercentileOfSeries10k_100NoNulls(b *testing.B) {
+	benchmarkPercentileOfSeries(b, 100, test.RandFloats10k, test.RandFloats10k)
+}
+func BenchmarkPercentileOfSeries10k_1SomeSeriesHalfNulls(b *testing.B) {
+	benchmarkPercentileOfSeries(b, 1, test.RandFloats10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkPercentileOfSeries10k_10SomeSeriesHalfNulls(b *testing.B) {
+	benchmarkPercentileOfSeries(b, 10, test.RandFloats10k, test.RandFloatsWithNulls10k)
+}
+func BenchmarkPercentileOfSeries10k_100SomeSeriesHalfNulls(b *testing.B) {
+	benchmarkPercentileOfSeries(b, 100, test.RandFloats10k, test.RandFloatsWithNulls10k)
+}
+func benchmarkPercentileOfSeries(b *testing.B, numSeries int, fn0, fn1 func() []schema.Point) {
+	var input []models.Series
+	for i := 0; i < numSeries; i++ {
+		series := models.Series{
+			QueryPatt: strconv.Itoa(i),
+		}
+		if i%2 == 0 {
+			series.Datapoints = fn0()
+		} else {
+			series.Datapoints = fn1()
+		}
+		input = append(input, series)
+	}
+	b.ResetTimer()
+	var err error
+	for i := 0; i < b.N; i++ {
+		f := NewPercentileOfSeries().(*FuncPercentileOfSeries)
+		f.in = NewMock(input)
+		f.n = 95
+		results, err = f.Exec(make(map[Req][]models.Series))
+		if err != nil {
+			b.Fatalf("%s", err)
+		}
+	}
+	b.SetBytes(int64(numSeries * len(results[0].Datapoints) * 12))
+}
+
+func TestNPercentile(t *testing.T) {
+	f := NewNPercentile().(*FuncNPercentile)
+	f.in = NewMock([]models.Series{
+		getModel("a", a),
+		getModel("allNaN", allNaN),
+		getModel("d", d),
+	})
+	f.n = 50
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	constant := func(v float64) []schema.Point {
+		out := getCopy(allNaN)
+		for i := range out {
+			out[i].Val = v
+		}
+		return out
+	}
+	exp := []models.Series{
+		getModel("nPercentile(a, 50)", constant(5.5)),
+		getModel("nPercentile(d, 50)", constant(80)),
+	}
+	checkSeriesPoints("nPercentile", exp, got, t)
+	for _, g := range got {
+		if g.Tags["nPercentile"] != "50" {
+			t.Fatalf("expected tag nPercentile=50, got %v", g.Tags)
+		}
+	}
+	if math.IsNaN(a[0].Val) {
+		t.Fatalf("input was modified")
+	}
+}
@@ -0,0 +1,42 @@
+package expr
+
+import (
+	"fmt"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+type FuncPow struct {
+	in     GraphiteFunc
+	factor float64
+}
+
+func NewPow() GraphiteFunc {
+	return &FuncPow{}
+}
+
+func (s *FuncPow) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgFloat{key: "factor", val: &s.factor},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncPow) Context(context Context) Context {
+	return context
+}
+
+func (s *FuncPow) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	var outputs []models.Series
+	for _, serie := range series {
+		name := fmt.Sprintf("pow(%s,%g)", serie.Target, s.factor)
+		outputs = append(outputs, transformSeries(cache, serie, name, "pow", fmt.Sprintf("%g", s.factor), func(v float64) float64 {
+			return safePow(v, s.factor)
+		}))
+	}
+	return outputs, nil
+}
@@ -0,0 +1,55 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+func TestPow(t *testing.T) {
+	NaN := math.NaN()
+	in := []models.Series{getModel("a;foo=bar", nullsPoints(2, -3, NaN, 0, 0.5))}
+	f := NewPow().(*FuncPow)
+	f.in = NewMock(in)
+	f.factor = 2
+
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	exp := []models.Series{getModel("pow(a;foo=bar,2)", nullsPoints(4, 9, NaN, 0, 0.25))}
+	checkSeriesPoints("pow", exp, got, t)
+	if got[0].Tags["pow"] != "2" || got[0].Tags["foo"] != "bar" {
+		t.Fatalf("expected tags pow=2 and foo=bar, got %v", got[0].Tags)
+	}
+	if _, ok := in[0].Tags["pow"]; ok {
+		t.Fatalf("input tags were modified: %v", in[0].Tags)
+	}
+}
+
+func TestSquareRoot(t *testing.T) {
+	NaN := math.NaN()
+	f := NewSquareRoot().(*FuncSquareRoot)
+	f.in = NewMock([]models.Series{getModel("a", nullsPoints(4, -4, NaN, 0, 2.25))})
+
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	exp := []models.Series{getModel("squareRoot(a)", nullsPoints(2, NaN, NaN, 0, 1.5))}
+	checkSeriesPoints("squareRoot", exp, got, t)
+}
+
+func TestInvert(t *testing.T) {
+	NaN := math.NaN()
+	f := NewInvert().(*FuncInvert)
+	f.in = NewMock([]models.Series{getModel("a", nullsPoints(4, -0.5, NaN, 0))})
+
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	exp := []models.Series{getModel("invert(a)", nullsPoints(0.25, -2, NaN, NaN))}
+	checkSeriesPoints("invert", exp, got, t)
+}
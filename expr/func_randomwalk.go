@@ -0,0 +1,43 @@
+package expr
+
+import (
+	"math/rand"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+type FuncRandomWalk struct {
+	name string
+	step int64
+	from uint32
+	to   uint32
+}
+
+func NewRandomWalk() GraphiteFunc {
+	return &FuncRandomWalk{step: 60}
+}
+
+func (s *FuncRandomWalk) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgString{key: "name", val: &s.name},
+		ArgInt{key: "step", opt: true, val: &s.step, validator: []Validator{IntStep}},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncRandomWalk) Context(context Context) Context {
+	s.from = context.from
+	s.to = context.to
+	return context
+}
+
+// Exec returns a series that starts at 0, and for every step moves by a random amount between -0.5 and 0.5.
+func (s *FuncRandomWalk) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	start, end := syntheticRange(s.from, s.to)
+	var current float64
+	out := syntheticPoints(start, end, uint32(s.step), func(ts uint32) float64 {
+		val := current
+		current += rand.Float64() - 0.5
+		return val
+	})
+	return []models.Series{syntheticOutput(cache, s.name, s.name, s.from, s.to, uint32(s.step), out)}, nil
+}
@@ -0,0 +1,53 @@
+package expr
+
+import (
+	"testing"
+)
+
+func TestRandomWalk(t *testing.T) {
+	cases := []struct {
+		target   string
+		expLen   int
+		interval uint32
+	}{
+		{`randomWalk("walk")`, 60, 60},
+		{`randomWalk("walk", 10)`, 360, 10},
+		{`randomWalk("walk", step=7)`, 515, 7},
+		{`randomWalkFunction("walk")`, 60, 60},
+	}
+	for _, c := range cases {
+		// graphite from 3600 until 7200. the output is random, so it can't match graphite's and is not stable
+		out := runSynthetic([]string{c.target}, 3601, 7201, false, t)
+		if len(out) != 1 || out[0].Target != "walk" || out[0].Interval != c.interval {
+			t.Fatalf("case %q: expected 1 series walk with interval %d, got %v", c.target, c.interval, out)
+		}
+		points := out[0].Datapoints
+		if len(points) != c.expLen {
+			t.Fatalf("case %q: expected %d points, got %d", c.target, c.expLen, len(points))
+		}
+		if points[0].Val != 0 || points[0].Ts != 3600 {
+			t.Fatalf("case %q: expected the walk to start at 3600 with value 0, got %v", c.target, points[0])
+		}
+		for i := 1; i < len(points); i++ {
+			if points[i].Ts != points[i-1].Ts+c.interval {
+				t.Fatalf("case %q: point %d: expected ts %d, got %d", c.target, i, points[i-1].Ts+c.interval, points[i].Ts)
+			}
+			if diff := points[i].Val - points[i-1].Val; diff < -0.5 || diff >= 0.5 {
+				t.Fatalf("case %q: point %d: expected a step between -0.5 and 0.5, got %f", c.target, i, diff)
+			}
+		}
+	}
+}
+
+func TestRandomWalkUnstable(t *testing.T) {
+	for _, target := range []string{`randomWalk("walk")`, `randomWalkFunction("walk")`} {
+		exprs, err := ParseMany([]string{target})
+		if err != nil {
+			t.Fatalf("case %q: unexpected parse error %s", target, err)
+		}
+		_, err = NewPlan(exprs, 3601, 7201, 800, true, nil, nil)
+		if _, ok := err.(ErrUnknownFunction); !ok {
+			t.Fatalf("case %q: expected ErrUnknownFunction for a stable plan, got %v", target, err)
+		}
+	}
+}
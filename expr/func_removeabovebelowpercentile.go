@@ -0,0 +1,73 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+type FuncRemoveAboveBelowPercentile struct {
+	in    GraphiteFunc
+	n     float64
+	above bool
+}
+
+func NewRemoveAbovePercentile() GraphiteFunc {
+	return &FuncRemoveAboveBelowPercentile{above: true}
+}
+
+func NewRemoveBelowPercentile() GraphiteFunc {
+	return &FuncRemoveAboveBelowPercentile{above: false}
+}
+
+func (s *FuncRemoveAboveBelowPercentile) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgFloat{key: "n", validator: []Validator{IsPercent}, val: &s.n},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncRemoveAboveBelowPercentile) Context(context Context) Context {
+	return context
+}
+
+func (s *FuncRemoveAboveBelowPercentile) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	fn := "removeBelowPercentile"
+	if s.above {
+		fn = "removeAbovePercentile"
+	}
+
+	var outputs []models.Series
+	for _, serie := range series {
+		// if the series has no values, percentile is NaN and all comparisons are false,
+		// so the series is returned as is.
+		percentile := pointsPercentile(serie.Datapoints, s.n, false)
+		out := pointSlicePool.Get().([]schema.Point)
+		for _, p := range serie.Datapoints {
+			if (s.above && p.Val > percentile) || (!s.above && p.Val < percentile) {
+				p.Val = math.NaN()
+			}
+			out = append(out, p)
+		}
+		name := fmt.Sprintf("%s(%s, %g)", fn, serie.Target, s.n)
+		output := models.Series{
+			Target:       name,
+			QueryPatt:    name,
+			Tags:         serie.Tags,
+			Datapoints:   out,
+			Interval:     serie.Interval,
+			Consolidator: serie.Consolidator,
+			QueryCons:    serie.QueryCons,
+		}
+		outputs = append(outputs, output)
+		cache[Req{}] = append(cache[Req{}], output)
+	}
+	return outputs, nil
+}
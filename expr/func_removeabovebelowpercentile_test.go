@@ -0,0 +1,118 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func TestRemoveAbovePercentile(t *testing.T) {
+	testRemoveAboveBelowPercentile(
+		"removeAbovePercentile",
+		true,
+		50,
+		[]models.Series{
+			{
+				Target:     "c",
+				QueryPatt:  "c",
+				Datapoints: getCopy(c),
+			},
+			{
+				Target:     "allNaN",
+				QueryPatt:  "allNaN",
+				Datapoints: getCopy(allNaN),
+			},
+		},
+		[]models.Series{
+			{
+				Target: "removeAbovePercentile(c, 50)",
+				Datapoints: []schema.Point{
+					{Val: 0, Ts: 10},
+					{Val: 0, Ts: 20},
+					{Val: 1, Ts: 30},
+					{Val: 2, Ts: 40},
+					{Val: math.NaN(), Ts: 50},
+					{Val: math.NaN(), Ts: 60},
+				},
+			},
+			{
+				Target:     "removeAbovePercentile(allNaN, 50)",
+				Datapoints: getCopy(allNaN),
+			},
+		},
+		t,
+	)
+}
+
+func TestRemoveBelowPercentile(t *testing.T) {
+	testRemoveAboveBelowPercentile(
+		"removeBelowPercentile",
+		false,
+		50,
+		[]models.Series{
+			{
+				Target:     "c",
+				QueryPatt:  "c",
+				Datapoints: getCopy(c),
+			},
+		},
+		[]models.Series{
+			{
+				Target: "removeBelowPercentile(c, 50)",
+				Datapoints: []schema.Point{
+					{Val: math.NaN(), Ts: 10},
+					{Val: math.NaN(), Ts: 20},
+					{Val: math.NaN(), Ts: 30},
+					{Val: 2, Ts: 40},
+					{Val: 3, Ts: 50},
+					{Val: 4, Ts: 60},
+				},
+			},
+		},
+		t,
+	)
+}
+
+func TestPercentile(t *testing.T) {
+	cases := []struct {
+		in          []float64
+		n           float64
+		interpolate bool
+		exp         float64
+	}{
+		{[]float64{}, 50, false, math.NaN()},
+		{[]float64{0, 0, 1, 2, 3, 4}, 50, false, 2},
+		{[]float64{0, 0, 1, 2, 3, 4}, 50, true, 1.5},
+		{[]float64{0, 0, 1, 2, 3, 4}, 0, false, 0},
+		{[]float64{0, 0, 1, 2, 3, 4}, 100, false, 4},
+		{[]float64{0, 0, 1, 2, 3, 4}, 100, true, 4},
+		{[]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 95, false, 10},
+		{[]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 95, true, 10},
+		{[]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 25, false, 3},
+		{[]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 25, true, 2.75},
+	}
+	for i, c := range cases {
+		got := percentile(c.in, c.n, c.interpolate)
+		if got != c.exp && !(math.IsNaN(got) && math.IsNaN(c.exp)) {
+			t.Fatalf("case %d: expected %v, got %v", i, c.exp, got)
+		}
+	}
+}
+
+func testRemoveAboveBelowPercentile(name string, above bool, n float64, in []models.Series, out []models.Series, t *testing.T) {
+	f := NewRemoveBelowPercentile()
+	if above {
+		f = NewRemoveAbovePercentile()
+	}
+	rm := f.(*FuncRemoveAboveBelowPercentile)
+	rm.in = NewMock(in)
+	rm.n = n
+
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("case %q: err should be nil. got %q", name, err)
+	}
+	checkSeriesPoints(name, out, got, t)
+}
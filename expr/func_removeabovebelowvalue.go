@@ -0,0 +1,70 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+type FuncRemoveAboveBelowValue struct {
+	in    GraphiteFunc
+	n     float64
+	above bool
+}
+
+func NewRemoveAboveValue() GraphiteFunc {
+	return &FuncRemoveAboveBelowValue{above: true}
+}
+
+func NewRemoveBelowValue() GraphiteFunc {
+	return &FuncRemoveAboveBelowValue{above: false}
+}
+
+func (s *FuncRemoveAboveBelowValue) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgFloat{key: "n", val: &s.n},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncRemoveAboveBelowValue) Context(context Context) Context {
+	return context
+}
+
+func (s *FuncRemoveAboveBelowValue) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	fn := "removeBelowValue"
+	if s.above {
+		fn = "removeAboveValue"
+	}
+
+	var outputs []models.Series
+	for _, serie := range series {
+		out := pointSlicePool.Get().([]schema.Point)
+		for _, p := range serie.Datapoints {
+			if (s.above && p.Val > s.n) || (!s.above && p.Val < s.n) {
+				p.Val = math.NaN()
+			}
+			out = append(out, p)
+		}
+		name := fmt.Sprintf("%s(%s, %g)", fn, serie.Target, s.n)
+		output := models.Series{
+			Target:       name,
+			QueryPatt:    name,
+			Tags:         serie.Tags,
+			Datapoints:   out,
+			Interval:     serie.Interval,
+			Consolidator: serie.Consolidator,
+			QueryCons:    serie.QueryCons,
+		}
+		outputs = append(outputs, output)
+		cache[Req{}] = append(cache[Req{}], output)
+	}
+	return outputs, nil
+}
@@ -0,0 +1,114 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func TestRemoveAboveValue(t *testing.T) {
+	testRemoveAboveBelowValue(
+		"removeAboveValue",
+		true,
+		2,
+		[]models.Series{
+			{
+				Target:     "c",
+				QueryPatt:  "c",
+				Datapoints: getCopy(c),
+			},
+		},
+		[]models.Series{
+			{
+				Target: "removeAboveValue(c, 2)",
+				Datapoints: []schema.Point{
+					{Val: 0, Ts: 10},
+					{Val: 0, Ts: 20},
+					{Val: 1, Ts: 30},
+					{Val: 2, Ts: 40},
+					{Val: math.NaN(), Ts: 50},
+					{Val: math.NaN(), Ts: 60},
+				},
+			},
+		},
+		t,
+	)
+}
+
+func TestRemoveBelowValue(t *testing.T) {
+	testRemoveAboveBelowValue(
+		"removeBelowValue",
+		false,
+		5.5,
+		[]models.Series{
+			{
+				Target:     "a",
+				QueryPatt:  "a",
+				Datapoints: getCopy(a),
+			},
+			{
+				Target:     "c",
+				QueryPatt:  "c",
+				Datapoints: getCopy(c),
+			},
+		},
+		[]models.Series{
+			{
+				Target: "removeBelowValue(a, 5.5)",
+				Datapoints: []schema.Point{
+					{Val: math.NaN(), Ts: 10},
+					{Val: math.NaN(), Ts: 20},
+					{Val: 5.5, Ts: 30},
+					{Val: math.NaN(), Ts: 40},
+					{Val: math.NaN(), Ts: 50},
+					{Val: 1234567890, Ts: 60},
+				},
+			},
+			{
+				Target: "removeBelowValue(c, 5.5)",
+				Datapoints: []schema.Point{
+					{Val: math.NaN(), Ts: 10},
+					{Val: math.NaN(), Ts: 20},
+					{Val: math.NaN(), Ts: 30},
+					{Val: math.NaN(), Ts: 40},
+					{Val: math.NaN(), Ts: 50},
+					{Val: math.NaN(), Ts: 60},
+				},
+			},
+		},
+		t,
+	)
+}
+
+func testRemoveAboveBelowValue(name string, above bool, n float64, in []models.Series, out []models.Series, t *testing.T) {
+	f := NewRemoveBelowValue()
+	if above {
+		f = NewRemoveAboveValue()
+	}
+	rm := f.(*FuncRemoveAboveBelowValue)
+	rm.in = NewMock(in)
+	rm.n = n
+	inputCopy := make([]models.Series, len(in))
+	for i := range in {
+		inputCopy[i] = in[i]
+		inputCopy[i].Datapoints = getCopy(in[i].Datapoints)
+	}
+
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("case %q: err should be nil. got %q", name, err)
+	}
+	checkSeriesPoints(name, out, got, t)
+
+	// input should be unchanged
+	for i, serie := range in {
+		for j, p := range serie.Datapoints {
+			exp := inputCopy[i].Datapoints[j]
+			if p.Ts != exp.Ts || (p.Val != exp.Val && !(math.IsNaN(p.Val) && math.IsNaN(exp.Val))) {
+				t.Fatalf("case %q: input point %d of series %d was modified", name, j, i)
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package expr
+
+import (
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+type FuncRemoveEmptySeries struct {
+	in           GraphiteFunc
+	xFilesFactor float64
+	zero         bool // whether series with only zeroes are also removed
+}
+
+func NewRemoveEmptySeries() GraphiteFunc {
+	return &FuncRemoveEmptySeries{zero: false}
+}
+
+func NewRemoveZeroSeries() GraphiteFunc {
+	return &FuncRemoveEmptySeries{zero: true}
+}
+
+func (s *FuncRemoveEmptySeries) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgFloat{key: "xFilesFactor", opt: true, validator: []Validator{IsXFilesFactor}, val: &s.xFilesFactor},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncRemoveEmptySeries) Context(context Context) Context {
+	return context
+}
+
+func (s *FuncRemoveEmptySeries) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs []models.Series
+	for _, serie := range series {
+		if !xFilesFactor(serie.Datapoints, s.xFilesFactor) {
+			continue
+		}
+		if s.zero && !hasNonZero(serie) {
+			continue
+		}
+		outputs = append(outputs, serie)
+	}
+	return outputs, nil
+}
+
+// hasNonZero returns whether the series has any value that is neither null nor zero
+func hasNonZero(serie models.Series) bool {
+	for _, p := range serie.Datapoints {
+		if !math.IsNaN(p.Val) && p.Val != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+func TestRemoveEmptySeries(t *testing.T) {
+	NaN := math.NaN()
+	in := []models.Series{
+		getModel("a", a),           // 4 out of 6 non-null
+		getModel("allNaN", allNaN), // no values
+		getModel("c", c),           // all non-null
+		getModel("zeroes", nullsPoints(0, 0, NaN, 0, NaN, NaN)),
+	}
+	cases := []struct {
+		name         string
+		zero         bool
+		xFilesFactor float64
+		exp          []models.Series
+	}{
+		{"removeEmptySeries", false, 0, []models.Series{in[0], in[2], in[3]}},
+		{"removeEmptySeries xff 0.5", false, 0.5, []models.Series{in[0], in[2], in[3]}},
+		{"removeEmptySeries xff 0.6", false, 0.6, []models.Series{in[0], in[2]}},
+		{"removeEmptySeries xff 1", false, 1, []models.Series{in[2]}},
+		{"removeZeroSeries", true, 0, []models.Series{in[0], in[2]}},
+		{"removeZeroSeries xff 1", true, 1, []models.Series{in[2]}},
+	}
+	for _, c := range cases {
+		var f *FuncRemoveEmptySeries
+		if c.zero {
+			f = NewRemoveZeroSeries().(*FuncRemoveEmptySeries)
+		} else {
+			f = NewRemoveEmptySeries().(*FuncRemoveEmptySeries)
+		}
+		f.in = NewMock(in)
+		f.xFilesFactor = c.xFilesFactor
+		got, err := f.Exec(make(map[Req][]models.Series))
+		if err != nil {
+			t.Fatalf("case %q: unexpected error %s", c.name, err)
+		}
+		checkSeriesPoints(c.name, c.exp, got, t)
+	}
+}
+
+func TestRemoveEmptySeriesInvalidXFilesFactor(t *testing.T) {
+	exprs, err := ParseMany([]string{"removeEmptySeries(a, 1.5)"})
+	if err != nil {
+		t.Fatalf("unexpected parse error %s", err)
+	}
+	_, err = NewPlan(exprs, 1000, 2000, 800, true, nil, nil)
+	if err == nil {
+		t.Fatalf("expected plan error, got none")
+	}
+}
@@ -0,0 +1,41 @@
+package expr
+
+import (
+	"github.com/grafana/metrictank/api/models"
+)
+
+type FuncRemoveTag struct {
+	in   GraphiteFunc
+	tags []string
+}
+
+func NewRemoveTag() GraphiteFunc {
+	return &FuncRemoveTag{}
+}
+
+func (s *FuncRemoveTag) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgStrings{key: "tags", validator: []Validator{IsTagKey, IsNotNameTag}, val: &s.tags},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncRemoveTag) Context(context Context) Context {
+	return context
+}
+
+func (s *FuncRemoveTag) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	outputs := make([]models.Series, 0, len(series))
+	for _, serie := range series {
+		tags := copyTags(serie)
+		for _, tag := range s.tags {
+			delete(tags, tag)
+		}
+		outputs = append(outputs, withTags(serie, tags))
+	}
+	return outputs, nil
+}
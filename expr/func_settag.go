@@ -0,0 +1,82 @@
+package expr
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+type FuncSetTag struct {
+	in    GraphiteFunc
+	tag   string
+	value string
+}
+
+func NewSetTag() GraphiteFunc {
+	return &FuncSetTag{}
+}
+
+func (s *FuncSetTag) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgString{key: "tag", validator: []Validator{IsTagKey}, val: &s.tag},
+		ArgString{key: "value", validator: []Validator{IsTagValue}, val: &s.value},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncSetTag) Context(context Context) Context {
+	return context
+}
+
+func (s *FuncSetTag) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	outputs := make([]models.Series, 0, len(series))
+	for _, serie := range series {
+		tags := copyTags(serie)
+		tags[s.tag] = s.value
+		outputs = append(outputs, withTags(serie, tags))
+	}
+	return outputs, nil
+}
+
+// copyTags returns a copy of the tags of the given series, which always includes the name tag
+func copyTags(serie models.Series) map[string]string {
+	tags := make(map[string]string, len(serie.Tags)+1)
+	for k, v := range serie.Tags {
+		tags[k] = v
+	}
+	if _, ok := tags["name"]; !ok {
+		tags["name"] = strings.SplitN(serie.Target, ";", 2)[0]
+	}
+	return tags
+}
+
+// withTags returns the series with the given tags, named by them in the graphite format name;tag1=val1;tag2=val2
+// with the tags sorted by key
+func withTags(serie models.Series, tags map[string]string) models.Series {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		if k != "name" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	buf := make([]byte, 0, 64)
+	buf = append(buf, tags["name"]...)
+	for _, k := range keys {
+		buf = append(buf, ';')
+		buf = append(buf, k...)
+		buf = append(buf, '=')
+		buf = append(buf, tags[k]...)
+	}
+
+	serie.Target = string(buf)
+	serie.QueryPatt = serie.Target
+	serie.Tags = tags
+	return serie
+}
@@ -0,0 +1,89 @@
+package expr
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+func TestSetTag(t *testing.T) {
+	in := []models.Series{
+		getModel("cpu.idle;host=web1", a),
+		getModel("cpu.idle;dc=us;host=web2", a),
+		{Target: "sumSeries(cpu.*)", QueryPatt: "sumSeries(cpu.*)"},
+	}
+	f := NewSetTag().(*FuncSetTag)
+	f.in = NewMock(in)
+	f.tag = "dc"
+	f.value = "eu"
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	exp := []models.Series{
+		{Target: "cpu.idle;dc=eu;host=web1", Tags: map[string]string{"name": "cpu.idle", "dc": "eu", "host": "web1"}},
+		{Target: "cpu.idle;dc=eu;host=web2", Tags: map[string]string{"name": "cpu.idle", "dc": "eu", "host": "web2"}},
+		{Target: "sumSeries(cpu.*);dc=eu", Tags: map[string]string{"name": "sumSeries(cpu.*)", "dc": "eu"}},
+	}
+	checkTagged("setTag", exp, got, t)
+	if in[1].Tags["dc"] != "us" || in[1].Target != "cpu.idle;dc=us;host=web2" {
+		t.Fatalf("input was modified: %v", in[1])
+	}
+}
+
+func TestRemoveTag(t *testing.T) {
+	in := []models.Series{
+		getModel("cpu.idle;dc=us;host=web1;rack=r1", a),
+		getModel("cpu.idle", a),
+	}
+	f := NewRemoveTag().(*FuncRemoveTag)
+	f.in = NewMock(in)
+	f.tags = []string{"host", "rack"}
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	exp := []models.Series{
+		{Target: "cpu.idle;dc=us", Tags: map[string]string{"name": "cpu.idle", "dc": "us"}},
+		{Target: "cpu.idle", Tags: map[string]string{"name": "cpu.idle"}},
+	}
+	checkTagged("removeTag", exp, got, t)
+	if in[0].Tags["host"] != "web1" {
+		t.Fatalf("input was modified: %v", in[0])
+	}
+}
+
+func TestTagFuncsInvalid(t *testing.T) {
+	for _, target := range []string{
+		`setTag(a, '', 'val')`,
+		`setTag(a, 'dc=us', 'val')`,
+		`setTag(a, 'dc', '')`,
+		`setTag(a, 'dc', 'us;eu')`,
+		`removeTag(a, 'name')`,
+		`removeTag(a)`,
+	} {
+		exprs, err := ParseMany([]string{target})
+		if err != nil {
+			t.Fatalf("case %q: unexpected parse error %s", target, err)
+		}
+		_, err = NewPlan(exprs, 1000, 2000, 800, true, nil, nil)
+		if err == nil {
+			t.Fatalf("case %q: expected plan error, got none", target)
+		}
+	}
+}
+
+func checkTagged(name string, exp, got []models.Series, t *testing.T) {
+	if len(got) != len(exp) {
+		t.Fatalf("case %q: expected %d output series, got %d", name, len(exp), len(got))
+	}
+	for i := range exp {
+		if got[i].Target != exp[i].Target || got[i].QueryPatt != exp[i].Target {
+			t.Fatalf("case %q: expected target %q, got %q (QueryPatt %q)", name, exp[i].Target, got[i].Target, got[i].QueryPatt)
+		}
+		if !reflect.DeepEqual(got[i].Tags, exp[i].Tags) {
+			t.Fatalf("case %q: expected tags %v, got %v", name, exp[i].Tags, got[i].Tags)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package expr
+
+import (
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+type FuncSinFunction struct {
+	name      string
+	amplitude float64
+	step      int64
+	from      uint32
+	to        uint32
+}
+
+func NewSinFunction() GraphiteFunc {
+	return &FuncSinFunction{amplitude: 1, step: 60}
+}
+
+func (s *FuncSinFunction) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgString{key: "name", val: &s.name},
+		ArgFloat{key: "amplitude", opt: true, val: &s.amplitude},
+		ArgInt{key: "step", opt: true, val: &s.step, validator: []Validator{IntStep}},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncSinFunction) Context(context Context) Context {
+	s.from = context.from
+	s.to = context.to
+	return context
+}
+
+// Exec returns a series with, for every step, the sine of its timestamp times the amplitude.
+func (s *FuncSinFunction) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	start, end := syntheticRange(s.from, s.to)
+	out := syntheticPoints(start, end, uint32(s.step), func(ts uint32) float64 {
+		return math.Sin(float64(ts)) * s.amplitude
+	})
+	return []models.Series{syntheticOutput(cache, s.name, s.name, s.from, s.to, uint32(s.step), out)}, nil
+}
@@ -0,0 +1,57 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func sinPoints(amplitude float64, ts ...uint32) []schema.Point {
+	out := make([]schema.Point, 0, len(ts))
+	for _, t := range ts {
+		out = append(out, schema.Point{Val: math.Sin(float64(t)) * amplitude, Ts: t})
+	}
+	return out
+}
+
+func TestSinFunction(t *testing.T) {
+	// graphite from 1000 until 1180: the end is not included
+	cases := []struct {
+		target   string
+		exp      []schema.Point
+		interval uint32
+	}{
+		{`sinFunction("wave")`, sinPoints(1, 1000, 1060, 1120), 60},
+		{`sinFunction("wave", 2.5)`, sinPoints(2.5, 1000, 1060, 1120), 60},
+		{`sinFunction("wave", 2, 50)`, sinPoints(2, 1000, 1050, 1100, 1150), 50},
+		{`sinFunction("wave", step=100)`, sinPoints(1, 1000, 1100), 100},
+	}
+	for _, c := range cases {
+		out := runSynthetic([]string{c.target}, 1001, 1181, true, t)
+		checkSeriesPoints(c.target, []models.Series{{Target: "wave", Datapoints: c.exp}}, out, t)
+		if out[0].Interval != c.interval || out[0].QueryPatt != "wave" || out[0].Tags["name"] != "wave" {
+			t.Fatalf("case %q: expected interval %d, query pattern and name tag wave, got %d, %q and %v", c.target, c.interval, out[0].Interval, out[0].QueryPatt, out[0].Tags)
+		}
+	}
+}
+
+func TestSinFunctionLargeStep(t *testing.T) {
+	// the step must not make the timestamps wrap around
+	out := runSynthetic([]string{`sinFunction("wave", 1, 4294967295)`}, 1001, 1181, true, t)
+	checkSeriesPoints("large step", []models.Series{{Target: "wave", Datapoints: sinPoints(1, 1000)}}, out, t)
+
+	// the start of the range can't go before 0
+	out = runSynthetic([]string{`sinFunction("wave", 1, 100)`}, 0, 181, true, t)
+	checkSeriesPoints("from 0", []models.Series{{Target: "wave", Datapoints: sinPoints(1, 0, 100)}}, out, t)
+
+	exprs, err := ParseMany([]string{`sinFunction("wave", 1, 4294967296)`})
+	if err != nil {
+		t.Fatalf("unexpected parse error %s", err)
+	}
+	_, err = NewPlan(exprs, 1001, 1181, 800, true, nil, nil)
+	if err == nil {
+		t.Fatalf("expected plan error for a step that does not fit in a uint32, got none")
+	}
+}
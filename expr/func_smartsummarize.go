@@ -1,12 +1,22 @@
 package expr
 
-import "github.com/grafana/metrictank/api/models"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/consolidation"
+	"github.com/raintank/dur"
+)
 
 type FuncSmartSummarize struct {
-	in          GraphiteFunc
-	interval    string
-	fn          string
-	alignToFrom bool
+	in             GraphiteFunc
+	intervalString string
+	fn             string
+	alignTo        string
+	alignToFrom    bool // deprecated by graphite in favor of alignTo, and ignored
 }
 
 func NewSmartSummarize() GraphiteFunc {
@@ -16,18 +26,128 @@ func NewSmartSummarize() GraphiteFunc {
 func (s *FuncSmartSummarize) Signature() ([]Arg, []Arg) {
 	return []Arg{
 		ArgSeriesList{val: &s.in},
-		ArgString{key: "interval", val: &s.interval},
-		ArgString{key: "func", opt: true, val: &s.fn},
-		ArgBool{key: "alignToFrom", opt: true, val: &s.alignToFrom},
-	}, []Arg{ArgSeries{}}
+		ArgString{key: "intervalString", val: &s.intervalString, validator: []Validator{IsIntervalString}},
+		ArgString{key: "func", opt: true, val: &s.fn, validator: []Validator{IsConsolFunc}},
+		ArgIn{
+			key: "alignTo",
+			opt: true,
+			args: []Arg{
+				ArgString{val: &s.alignTo, validator: []Validator{IsAlignToString}},
+				ArgBool{val: &s.alignToFrom},
+			},
+		},
+	}, []Arg{ArgSeriesList{}}
 }
 
+// Context moves from back to the start of the year, month, week, day, hour or minute (depending on the unit of alignTo)
+// it falls in, so that the buckets are aligned to it.
 func (s *FuncSmartSummarize) Context(context Context) Context {
 	context.consol = 0
+	if s.alignTo != "" {
+		unit, _ := alignToUnit(s.alignTo)
+		context.from = alignFromUnit(context.from, unit, context.loc)
+	}
 	return context
 }
 
+// Exec aggregates every series into buckets of the interval, which are aligned to its first point,
+// like summarize with alignToFrom.
 func (s *FuncSmartSummarize) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
 	series, err := s.in.Exec(cache)
-	return series, err
+	if err != nil {
+		return nil, err
+	}
+
+	interval, _ := dur.ParseDuration(s.intervalString)
+	aggFunc := consolidation.GetAggFunc(consolidation.FromConsolidateBy(s.fn))
+
+	var outputs []models.Series
+	for _, serie := range series {
+		var start, end uint32 = serie.QueryFrom, serie.QueryTo
+		if len(serie.Datapoints) > 0 {
+			start = serie.Datapoints[0].Ts
+			end = serie.Datapoints[len(serie.Datapoints)-1].Ts + serie.Interval
+		}
+		name := fmt.Sprintf("smartSummarize(%s, \"%s\", \"%s\")", serie.Target, s.intervalString, s.fn)
+		output := models.Series{
+			Target:     name,
+			QueryPatt:  name,
+			Tags:       summarizeTags(serie.Tags, "smartSummarize", s.intervalString, s.fn),
+			Datapoints: summarizeValues(serie, aggFunc, interval, start, end),
+			Interval:   interval,
+			QueryFrom:  serie.QueryFrom,
+			QueryTo:    serie.QueryTo,
+		}
+		outputs = append(outputs, output)
+		cache[Req{}] = append(cache[Req{}], output)
+	}
+	return outputs, nil
+}
+
+var errInvalidAlignTo = errors.New("invalid alignTo unit")
+
+// alignToUnit returns the unit of the given alignTo string, such as "1d", "90min" or "hours".
+// like graphite, any number is ignored, and the unit is recognized by its prefix, where "m" means minutes.
+func alignToUnit(s string) (string, error) {
+	s = strings.TrimLeft(s, "+-0123456789")
+	switch {
+	case strings.HasPrefix(s, "s"):
+		return "s", nil
+	case strings.HasPrefix(s, "min"):
+		return "min", nil
+	case strings.HasPrefix(s, "h"):
+		return "h", nil
+	case strings.HasPrefix(s, "d"):
+		return "d", nil
+	case strings.HasPrefix(s, "w"):
+		return "w", nil
+	case strings.HasPrefix(s, "mon"):
+		return "mon", nil
+	case strings.HasPrefix(s, "m"):
+		return "min", nil
+	case strings.HasPrefix(s, "y"):
+		return "y", nil
+	}
+	return "", errInvalidAlignTo
+}
+
+// alignFromUnit returns from moved back to the start of the year, month, week (starting on monday), day, hour or minute
+// it falls in, in the given timezone. aligning to seconds leaves from as is.
+// like the from of a request, both the given and the returned from are one second past the graphite from.
+func alignFromUnit(from uint32, unit string, loc *time.Location) uint32 {
+	if from == 0 || unit == "s" {
+		return from
+	}
+	t := time.Unix(int64(from)-1, 0).In(loc)
+	year, month, day := t.Date()
+	switch unit {
+	case "y":
+		t = time.Date(year, 1, 1, 0, 0, 0, 0, loc)
+	case "mon":
+		t = time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	case "w":
+		t = time.Date(year, month, day-(int(t.Weekday())+6)%7, 0, 0, 0, 0, loc)
+	case "d":
+		t = time.Date(year, month, day, 0, 0, 0, 0, loc)
+	case "h":
+		t = time.Date(year, month, day, t.Hour(), 0, 0, 0, loc)
+	case "min":
+		t = time.Date(year, month, day, t.Hour(), t.Minute(), 0, 0, loc)
+	}
+	return uint32(t.Unix()) + 1
+}
+
+// alignFrom returns from moved back to the start of the day, hour or minute (in the given timezone) it falls in,
+// depending on whether the given interval is at least a day, an hour or a minute. shorter intervals leave from as is.
+// like the from of a request, both the given and the returned from are one second past the graphite from.
+func alignFrom(from, interval uint32, loc *time.Location) uint32 {
+	switch {
+	case interval >= 24*3600:
+		return alignFromUnit(from, "d", loc)
+	case interval >= 3600:
+		return alignFromUnit(from, "h", loc)
+	case interval >= 60:
+		return alignFromUnit(from, "min", loc)
+	}
+	return from
 }
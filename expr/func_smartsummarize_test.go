@@ -0,0 +1,106 @@
+package expr
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/dur"
+)
+
+func TestSmartSummarizeGraphiteParity(t *testing.T) {
+	for _, c := range graphiteSummarizeCases() {
+		if !c.alignToFrom {
+			continue
+		}
+		name := fmt.Sprintf("%s %s %s", c.name, c.interval, c.fn)
+		f := NewSmartSummarize().(*FuncSmartSummarize)
+		f.in = NewMock(graphiteParityInputs()[c.input : c.input+1])
+		f.intervalString = c.interval
+		f.fn = c.fn
+
+		got, err := f.Exec(make(map[Req][]models.Series))
+		if err != nil {
+			t.Fatalf("case %q: unexpected error %s", name, err)
+		}
+		interval, _ := dur.ParseDuration(c.interval)
+		graphiteParityCheck(name, got, c.expStart, interval, c.exp, t)
+		exp := fmt.Sprintf("smartSummarize(%s, \"%s\", \"%s\")", c.name, c.interval, c.fn)
+		if got[0].Target != exp || got[0].Tags["smartSummarize"] != c.interval || got[0].Tags["smartSummarizeFunction"] != c.fn {
+			t.Fatalf("case %q: expected target %q and smartSummarize tags, got %q and %v", name, exp, got[0].Target, got[0].Tags)
+		}
+	}
+}
+
+func TestSmartSummarizeAlignTo(t *testing.T) {
+	// 2018-03-14 15:09:26 in the local timezone. like for requests, the from is one second past the graphite from.
+	from := uint32(time.Date(2018, 3, 14, 15, 9, 26, 0, time.Local).Unix()) + 1
+	cases := []struct {
+		target  string
+		expFrom time.Time
+	}{
+		{`smartSummarize(a, "1h")`, time.Date(2018, 3, 14, 15, 9, 26, 0, time.Local)},
+		{`smartSummarize(a, "1h", "sum", true)`, time.Date(2018, 3, 14, 15, 9, 26, 0, time.Local)},
+		{`smartSummarize(a, "1h", "sum", "30s")`, time.Date(2018, 3, 14, 15, 9, 26, 0, time.Local)},
+		{`smartSummarize(a, "1h", "sum", "5min")`, time.Date(2018, 3, 14, 15, 9, 0, 0, time.Local)},
+		{`smartSummarize(a, "1h", alignTo="1h")`, time.Date(2018, 3, 14, 15, 0, 0, 0, time.Local)},
+		{`smartSummarize(a, "1d", "max", "1d")`, time.Date(2018, 3, 14, 0, 0, 0, 0, time.Local)},
+		// like graphite, the unit determines the alignment, not the size of the interval
+		{`smartSummarize(a, "1h", "sum", "90min")`, time.Date(2018, 3, 14, 15, 9, 0, 0, time.Local)},
+		{`smartSummarize(a, "1d", "sum", "36h")`, time.Date(2018, 3, 14, 15, 0, 0, 0, time.Local)},
+		{`smartSummarize(a, "1h", "sum", "hours")`, time.Date(2018, 3, 14, 15, 0, 0, 0, time.Local)},
+		{`smartSummarize(a, "1h", "sum", "5m")`, time.Date(2018, 3, 14, 15, 9, 0, 0, time.Local)},
+		// weeks start on monday
+		{`smartSummarize(a, "1w", "sum", "1w")`, time.Date(2018, 3, 12, 0, 0, 0, 0, time.Local)},
+		{`smartSummarize(a, "1d", "sum", "2weeks")`, time.Date(2018, 3, 12, 0, 0, 0, 0, time.Local)},
+		{`smartSummarize(a, "1d", "sum", "1mon")`, time.Date(2018, 3, 1, 0, 0, 0, 0, time.Local)},
+		{`smartSummarize(a, "30d", "sum", "3months")`, time.Date(2018, 3, 1, 0, 0, 0, 0, time.Local)},
+		{`smartSummarize(a, "1d", "sum", "1y")`, time.Date(2018, 1, 1, 0, 0, 0, 0, time.Local)},
+	}
+	for _, c := range cases {
+		exprs, err := ParseMany([]string{c.target})
+		if err != nil {
+			t.Fatalf("case %q: unexpected parse error %s", c.target, err)
+		}
+		plan, err := NewPlan(exprs, from, from+3600, 800, true, nil, nil)
+		if err != nil {
+			t.Fatalf("case %q: unexpected plan error %s", c.target, err)
+		}
+		exp := NewReq("a", uint32(c.expFrom.Unix())+1, from+3600, 0)
+		if len(plan.Reqs) != 1 || plan.Reqs[0] != exp {
+			t.Fatalf("case %q: expected reqs %v, got %v", c.target, []Req{exp}, plan.Reqs)
+		}
+	}
+}
+
+func TestSmartSummarizeAlignToTimezone(t *testing.T) {
+	loc := time.FixedZone("UTC+5", 5*3600)
+	// 2018-03-14 02:09:26 UTC, which is 07:09:26 in the timezone of the request
+	from := uint32(time.Date(2018, 3, 14, 2, 9, 26, 0, time.UTC).Unix()) + 1
+	exprs, err := ParseMany([]string{`smartSummarize(a, "1d", "sum", "1d")`})
+	if err != nil {
+		t.Fatalf("unexpected parse error %s", err)
+	}
+	plan, err := NewPlan(exprs, from, from+3600, 800, true, loc, nil)
+	if err != nil {
+		t.Fatalf("unexpected plan error %s", err)
+	}
+	exp := NewReq("a", uint32(time.Date(2018, 3, 14, 0, 0, 0, 0, loc).Unix())+1, from+3600, 0)
+	if len(plan.Reqs) != 1 || plan.Reqs[0] != exp {
+		t.Fatalf("expected reqs %v, got %v", []Req{exp}, plan.Reqs)
+	}
+}
+
+func TestSmartSummarizeAlignToInvalid(t *testing.T) {
+	for _, alignTo := range []string{"1x", "", "10"} {
+		exprs, err := ParseMany([]string{fmt.Sprintf(`smartSummarize(a, "1h", "sum", "%s")`, alignTo)})
+		if err != nil {
+			t.Fatalf("case %q: unexpected parse error %s", alignTo, err)
+		}
+		_, err = NewPlan(exprs, 1000, 2000, 800, true, nil, nil)
+		if err == nil {
+			t.Fatalf("case %q: expected plan error, got none", alignTo)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package expr
+
+import (
+	"math"
+	"sort"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+type FuncSortBy struct {
+	in      GraphiteFunc
+	fn      string
+	reverse bool
+	generic bool // whether fn and reverse are specified by the user, as for sortBy()
+}
+
+// NewSortByConstructor takes the name of a series aggregation function and whether to sort in descending order,
+// and returns a constructor function. pass an empty fn to let the user specify the aggregation function and order.
+func NewSortByConstructor(fn string, reverse bool) func() GraphiteFunc {
+	return func() GraphiteFunc {
+		if fn == "" {
+			return &FuncSortBy{fn: "average", generic: true}
+		}
+		return &FuncSortBy{fn: fn, reverse: reverse}
+	}
+}
+
+func (s *FuncSortBy) Signature() ([]Arg, []Arg) {
+	if s.generic {
+		return []Arg{
+			ArgSeriesList{val: &s.in},
+			ArgString{key: "func", opt: true, validator: []Validator{IsSeriesAggFunc}, val: &s.fn},
+			ArgBool{key: "reverse", opt: true, val: &s.reverse},
+		}, []Arg{ArgSeriesList{}}
+	}
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncSortBy) Context(context Context) Context {
+	return context
+}
+
+func (s *FuncSortBy) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	// in accordance with graphite, sortByMinima drops the series that don't have any value above 0
+	minima := !s.generic && s.fn == "min"
+	maxFunc := getSeriesAggFunc("max")
+
+	aggFunc := getSeriesAggFunc(s.fn)
+	sorted := seriesValueSort{
+		series: make([]models.Series, 0, len(series)),
+		values: make([]float64, 0, len(series)),
+	}
+	for _, serie := range series {
+		if minima && !(maxFunc(serie.Datapoints) > 0) {
+			continue
+		}
+		// in accordance with graphite, series without a value sort as if their value is -inf
+		val := aggFunc(serie.Datapoints)
+		if math.IsNaN(val) {
+			val = math.Inf(-1)
+		}
+		sorted.series = append(sorted.series, serie)
+		sorted.values = append(sorted.values, val)
+	}
+
+	if s.reverse {
+		sort.Stable(sort.Reverse(sorted))
+	} else {
+		sort.Stable(sorted)
+	}
+	return sorted.series, nil
+}
@@ -0,0 +1,68 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+func TestSortBy(t *testing.T) {
+	NaN := math.NaN()
+	in := []models.Series{
+		getModel("a;dc=a", a),                       // max 1234567890, min 0, sum 1234567895.5
+		getModel("c;dc=c", c),                       // max 4, min 0, sum 10
+		getModel("negative", nullsPoints(-1, -2)),   // max -1, min -2, sum -3
+		getModel("allNaN", allNaN),                  // no values
+		getModel("small", nullsPoints(1, NaN, 3.5)), // max 3.5, min 1, sum 4.5
+	}
+	in[0].QueryPatt = "a*"
+	cases := []struct {
+		name string
+		f    GraphiteFunc
+		exp  []string
+	}{
+		{"sortByMaxima", NewSortByConstructor("max", true)(), []string{"a;dc=a", "c;dc=c", "small", "negative", "allNaN"}},
+		{"sortByMinima", NewSortByConstructor("min", false)(), []string{"a;dc=a", "c;dc=c", "small"}},
+		{"sortByTotal", NewSortByConstructor("sum", true)(), []string{"a;dc=a", "c;dc=c", "small", "negative", "allNaN"}},
+		{"sortBy default", NewSortByConstructor("", false)(), []string{"allNaN", "negative", "c;dc=c", "small", "a;dc=a"}},
+		{"sortBy last reverse", &FuncSortBy{fn: "last", reverse: true, generic: true}, []string{"a;dc=a", "c;dc=c", "small", "negative", "allNaN"}},
+		{"sortBy count", &FuncSortBy{fn: "count", generic: true}, []string{"allNaN", "negative", "small", "a;dc=a", "c;dc=c"}},
+	}
+	for _, c := range cases {
+		f := c.f.(*FuncSortBy)
+		f.in = NewMock(in)
+		got, err := f.Exec(make(map[Req][]models.Series))
+		if err != nil {
+			t.Fatalf("case %q: unexpected error %s", c.name, err)
+		}
+		if len(got) != len(c.exp) {
+			t.Fatalf("case %q: expected %d series, got %d", c.name, len(c.exp), len(got))
+		}
+		for i, target := range c.exp {
+			if got[i].Target != target {
+				t.Fatalf("case %q: expected series %d to be %q, got %q", c.name, i, target, got[i].Target)
+			}
+			if target == "a;dc=a" && (got[i].QueryPatt != "a*" || got[i].Tags["dc"] != "a") {
+				t.Fatalf("case %q: expected QueryPatt and tags to be kept, got %q and %v", c.name, got[i].QueryPatt, got[i].Tags)
+			}
+		}
+		// make sure the input order hasn't been modified
+		for i, target := range []string{"a;dc=a", "c;dc=c", "negative", "allNaN", "small"} {
+			if in[i].Target != target {
+				t.Fatalf("case %q: input was modified: expected series %d to be %q, got %q", c.name, i, target, in[i].Target)
+			}
+		}
+	}
+}
+
+func TestSortByInvalidFunc(t *testing.T) {
+	exprs, err := ParseMany([]string{"sortBy(a, 'bogus')"})
+	if err != nil {
+		t.Fatalf("unexpected parse error %s", err)
+	}
+	_, err = NewPlan(exprs, 1000, 2000, 800, true, nil, nil)
+	if err == nil {
+		t.Fatalf("expected plan error, got none")
+	}
+}
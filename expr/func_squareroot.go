@@ -0,0 +1,41 @@
+package expr
+
+import (
+	"fmt"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+type FuncSquareRoot struct {
+	in GraphiteFunc
+}
+
+func NewSquareRoot() GraphiteFunc {
+	return &FuncSquareRoot{}
+}
+
+func (s *FuncSquareRoot) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncSquareRoot) Context(context Context) Context {
+	return context
+}
+
+// Exec computes the square root of every value. the square root of a negative value is null.
+func (s *FuncSquareRoot) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	var outputs []models.Series
+	for _, serie := range series {
+		name := fmt.Sprintf("squareRoot(%s)", serie.Target)
+		outputs = append(outputs, transformSeries(cache, serie, name, "squareRoot", "1", func(v float64) float64 {
+			return safePow(v, 0.5)
+		}))
+	}
+	return outputs, nil
+}
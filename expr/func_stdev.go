@@ -0,0 +1,72 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+type FuncStdev struct {
+	in              GraphiteFunc
+	points          int64
+	windowTolerance float64
+}
+
+func NewStdev() GraphiteFunc {
+	return &FuncStdev{windowTolerance: 0.1}
+}
+
+func (s *FuncStdev) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgInt{key: "points", val: &s.points, validator: []Validator{IntPositive}},
+		ArgFloat{key: "windowTolerance", opt: true, val: &s.windowTolerance, validator: []Validator{IsXFilesFactor}},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncStdev) Context(context Context) Context {
+	return context
+}
+
+// Exec computes the standard deviation of every series over a moving window of the given number of points.
+// in accordance with graphite, the window is not prefetched: the first points are computed over a partial window.
+// a window is only computed if the ratio of its non-null values to its size is at least windowTolerance.
+func (s *FuncStdev) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+	var outputs []models.Series
+	for _, serie := range series {
+		out := pointSlicePool.Get().([]schema.Point)
+		var valid int64
+		var sum, sumSquares float64
+		for i, p := range serie.Datapoints {
+			if i >= int(s.points) {
+				// remove the value that drops out of the window
+				if dropped := serie.Datapoints[i-int(s.points)].Val; !math.IsNaN(dropped) {
+					valid--
+					sum -= dropped
+					sumSquares -= dropped * dropped
+				}
+			}
+			if !math.IsNaN(p.Val) {
+				valid++
+				sum += p.Val
+				sumSquares += p.Val * p.Val
+			}
+			val := math.NaN()
+			if valid > 0 && float64(valid)/float64(s.points) >= s.windowTolerance {
+				// the square root of a (slightly) negative number due to rounding errors yields null, like in graphite
+				val = math.Sqrt(float64(valid)*sumSquares-sum*sum) / float64(valid)
+			}
+			out = append(out, schema.Point{Val: val, Ts: p.Ts})
+		}
+		name := fmt.Sprintf("stddev(%s,%d)", serie.Target, s.points)
+		outputs = append(outputs, transformOutput(cache, serie, name, "stddev", strconv.FormatInt(s.points, 10), out))
+	}
+	return outputs, nil
+}
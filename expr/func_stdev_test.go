@@ -0,0 +1,76 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+// stdevOf returns the population standard deviation of the given values
+func stdevOf(vals ...float64) float64 {
+	var sum float64
+	for _, v := range vals {
+		sum += v
+	}
+	mean := sum / float64(len(vals))
+	var sumSq float64
+	for _, v := range vals {
+		sumSq += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(sumSq / float64(len(vals)))
+}
+
+func TestStdev(t *testing.T) {
+	NaN := math.NaN()
+	cases := []struct {
+		name      string
+		points    int64
+		tolerance float64
+		in        []float64
+		exp       []float64
+	}{
+		{
+			"stddev(a,3)",
+			3,
+			0.1,
+			[]float64{1, 2, 4, 8, 16},
+			[]float64{0, stdevOf(1, 2), stdevOf(1, 2, 4), stdevOf(2, 4, 8), stdevOf(4, 8, 16)},
+		},
+		{
+			"stddev(a,2)",
+			2,
+			0.1,
+			[]float64{1, NaN, NaN, 3, 5},
+			[]float64{0, 0, NaN, 0, stdevOf(3, 5)},
+		},
+		{
+			"stddev(a,4)",
+			4,
+			0.5,
+			[]float64{1, 3, NaN, 7, NaN, NaN},
+			[]float64{NaN, stdevOf(1, 3), stdevOf(1, 3), stdevOf(1, 3, 7), stdevOf(3, 7), NaN},
+		},
+	}
+	for _, c := range cases {
+		f := NewStdev().(*FuncStdev)
+		f.in = NewMock([]models.Series{getModel("a", nullsPoints(c.in...))})
+		f.points = c.points
+		f.windowTolerance = c.tolerance
+
+		got, err := f.Exec(make(map[Req][]models.Series))
+		if err != nil {
+			t.Fatalf("case %q: unexpected error %s", c.name, err)
+		}
+		if len(got) != 1 || got[0].Target != c.name || len(got[0].Datapoints) != len(c.exp) {
+			t.Fatalf("case %q: unexpected output %v", c.name, got)
+		}
+		// the running sums make the results differ slightly from the direct computation
+		for i, exp := range c.exp {
+			val := got[0].Datapoints[i].Val
+			if math.IsNaN(exp) != math.IsNaN(val) || math.Abs(exp-val) > 1e-9 {
+				t.Fatalf("case %q: point %d: expected %f, got %f", c.name, i, exp, val)
+			}
+		}
+	}
+}
@@ -70,12 +70,10 @@ func (s *FuncSummarize) Exec(cache map[Req][]models.Series) ([]models.Series, er
 		output := models.Series{
 			Target:     newName(serie.Target),
 			QueryPatt:  newName(serie.QueryPatt),
-			Tags:       serie.Tags,
+			Tags:       summarizeTags(serie.Tags, "summarize", s.intervalString, s.fn),
 			Datapoints: out,
 			Interval:   interval,
 		}
-		output.Tags["summarize"] = s.intervalString
-		output.Tags["summarizeFunction"] = s.fn
 
 		outputs = append(outputs, output)
 		cache[Req{}] = append(cache[Req{}], output)
@@ -83,12 +81,25 @@ func (s *FuncSummarize) Exec(cache map[Req][]models.Series) ([]models.Series, er
 	return outputs, nil
 }
 
+// summarizeTags returns a copy of the given tags, with the tags for the given summarize function added
+func summarizeTags(in map[string]string, fn, intervalString, aggFn string) map[string]string {
+	tags := make(map[string]string, len(in)+2)
+	for k, v := range in {
+		tags[k] = v
+	}
+	tags[fn] = intervalString
+	tags[fn+"Function"] = aggFn
+	return tags
+}
+
+// summarizeValues aggregates the points of the given series into buckets of the given interval, from start up to end.
+// in accordance with graphite, buckets without any points (including those beyond the last point) are null.
 func summarizeValues(serie models.Series, aggFunc batch.AggFunc, interval, start, end uint32) []schema.Point {
 	out := pointSlicePool.Get().([]schema.Point)
 
 	numPoints := len(serie.Datapoints)
 
-	for ts, i := start, 0; i < numPoints && ts < end; ts += interval {
+	for ts, i := start, 0; ts < end; ts += interval {
 		s := i
 		for ; i < numPoints && serie.Datapoints[i].Ts < ts+interval; i++ {
 			if serie.Datapoints[i].Ts <= ts {
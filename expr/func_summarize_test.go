@@ -1,12 +1,14 @@
 package expr
 
 import (
+	"fmt"
 	"math"
 	"strconv"
 	"testing"
 
 	"github.com/grafana/metrictank/api/models"
 	"github.com/grafana/metrictank/test"
+	"github.com/raintank/dur"
 	schema "gopkg.in/raintank/schema.v1"
 )
 
@@ -75,7 +77,7 @@ func TestSummarizeDefaultInterval(t *testing.T) {
 				QueryFrom:  10,
 				QueryTo:    60,
 				Interval:   10,
-				Datapoints: getCopy(a),
+				Datapoints: summarizeNulls(getCopy(a), 70),
 			},
 		},
 		{
@@ -97,7 +99,7 @@ func TestSummarizeDefaultInterval(t *testing.T) {
 				QueryFrom:  10,
 				QueryTo:    60,
 				Interval:   10,
-				Datapoints: getCopy(a),
+				Datapoints: summarizeNulls(getCopy(a), 70),
 			},
 		},
 		{
@@ -152,7 +154,7 @@ func TestSummarizeOversampled(t *testing.T) {
 				QueryFrom:  10,
 				QueryTo:    60,
 				Interval:   5,
-				Datapoints: getCopy(aOversampled),
+				Datapoints: summarizeNulls(getCopy(aOversampled), 65, 70),
 			},
 		},
 		{
@@ -162,7 +164,7 @@ func TestSummarizeOversampled(t *testing.T) {
 				QueryFrom:  10,
 				QueryTo:    60,
 				Interval:   5,
-				Datapoints: getCopy(aOversampled),
+				Datapoints: summarizeNulls(getCopy(aOversampled), 65),
 			},
 		},
 	}
@@ -174,7 +176,7 @@ func TestSummarizeOversampled(t *testing.T) {
 				QueryFrom:  10,
 				QueryTo:    60,
 				Interval:   5,
-				Datapoints: getCopy(aOversampled),
+				Datapoints: summarizeNulls(getCopy(aOversampled), 65, 70),
 			},
 		},
 		{
@@ -184,7 +186,7 @@ func TestSummarizeOversampled(t *testing.T) {
 				QueryFrom:  10,
 				QueryTo:    60,
 				Interval:   5,
-				Datapoints: getCopy(aOversampled),
+				Datapoints: summarizeNulls(getCopy(aOversampled), 65),
 			},
 		},
 	}
@@ -214,7 +216,7 @@ func TestSummarizeNyquistSingleIdentity(t *testing.T) {
 				QueryFrom:  10,
 				QueryTo:    60,
 				Interval:   10,
-				Datapoints: getCopy(a),
+				Datapoints: summarizeNulls(getCopy(a), 70),
 			},
 		},
 		{
@@ -236,7 +238,7 @@ func TestSummarizeNyquistSingleIdentity(t *testing.T) {
 				QueryFrom:  10,
 				QueryTo:    60,
 				Interval:   10,
-				Datapoints: getCopy(a),
+				Datapoints: summarizeNulls(getCopy(a), 70),
 			},
 		},
 		{
@@ -283,7 +285,7 @@ func TestSummarizeMultipleIdentity(t *testing.T) {
 				QueryFrom:  10,
 				QueryTo:    60,
 				Interval:   10,
-				Datapoints: getCopy(a),
+				Datapoints: summarizeNulls(getCopy(a), 70),
 			},
 			{
 				Target:     "summarize(b, \"10s\", \"sum\")",
@@ -291,7 +293,7 @@ func TestSummarizeMultipleIdentity(t *testing.T) {
 				QueryFrom:  10,
 				QueryTo:    60,
 				Interval:   10,
-				Datapoints: getCopy(b),
+				Datapoints: summarizeNulls(getCopy(b), 70),
 			},
 		},
 		{
@@ -321,7 +323,7 @@ func TestSummarizeMultipleIdentity(t *testing.T) {
 				QueryFrom:  10,
 				QueryTo:    60,
 				Interval:   10,
-				Datapoints: getCopy(a),
+				Datapoints: summarizeNulls(getCopy(a), 70),
 			},
 			{
 				Target:     "summarize(b, \"10s\", \"max\")",
@@ -329,7 +331,7 @@ func TestSummarizeMultipleIdentity(t *testing.T) {
 				QueryFrom:  10,
 				QueryTo:    60,
 				Interval:   10,
-				Datapoints: getCopy(b),
+				Datapoints: summarizeNulls(getCopy(b), 70),
 			},
 		},
 		{
@@ -385,7 +387,7 @@ func TestSummarizeConsolidated(t *testing.T) {
 				QueryFrom:  10,
 				QueryTo:    65,
 				Interval:   10,
-				Datapoints: getCopy(sumab),
+				Datapoints: summarizeNulls(getCopy(sumab), 70),
 			},
 			{
 				Target:     "summarize(abc, \"10s\", \"sum\")",
@@ -423,7 +425,7 @@ func TestSummarizeConsolidated(t *testing.T) {
 				QueryFrom:  10,
 				QueryTo:    65,
 				Interval:   10,
-				Datapoints: getCopy(maxab),
+				Datapoints: summarizeNulls(getCopy(maxab), 70),
 			},
 			{
 				Target:     "summarize(abc, \"10s\", \"max\")",
@@ -487,7 +489,7 @@ func TestSummarizeMisAligned(t *testing.T) {
 				QueryFrom:  7,
 				QueryTo:    67,
 				Interval:   10,
-				Datapoints: getCopy(sumab),
+				Datapoints: summarizeNulls(getCopy(sumab), 70),
 			},
 			{
 				Target:     "summarize(abc, \"10s\", \"sum\")",
@@ -525,7 +527,7 @@ func TestSummarizeMisAligned(t *testing.T) {
 				QueryFrom:  7,
 				QueryTo:    67,
 				Interval:   10,
-				Datapoints: getCopy(maxab),
+				Datapoints: summarizeNulls(getCopy(maxab), 70),
 			},
 			{
 				Target:     "summarize(abc, \"10s\", \"max\")",
@@ -622,7 +624,7 @@ func TestSummarizeAlignToFrom(t *testing.T) {
 				QueryFrom:  30,
 				QueryTo:    240,
 				Interval:   45,
-				Datapoints: getCopy(unaligned45sum),
+				Datapoints: summarizeNulls(getCopy(unaligned45sum), 270),
 			},
 		},
 		{
@@ -632,7 +634,7 @@ func TestSummarizeAlignToFrom(t *testing.T) {
 				QueryFrom:  30,
 				QueryTo:    240,
 				Interval:   45,
-				Datapoints: getCopy(aligned45sum),
+				Datapoints: summarizeNulls(getCopy(aligned45sum), 255),
 			},
 		},
 	}
@@ -644,7 +646,7 @@ func TestSummarizeAlignToFrom(t *testing.T) {
 				QueryFrom:  30,
 				QueryTo:    240,
 				Interval:   45,
-				Datapoints: getCopy(unaligned45max),
+				Datapoints: summarizeNulls(getCopy(unaligned45max), 270),
 			},
 		},
 		{
@@ -654,7 +656,7 @@ func TestSummarizeAlignToFrom(t *testing.T) {
 				QueryFrom:  30,
 				QueryTo:    240,
 				Interval:   45,
-				Datapoints: getCopy(aligned45max),
+				Datapoints: summarizeNulls(getCopy(aligned45max), 255),
 			},
 		},
 	}
@@ -840,3 +842,135 @@ func benchmarkSummarize(b *testing.B, numSeries int, fn0, fn1 func() []schema.Po
 	}
 	b.SetBytes(int64(numSeries * len(results[0].Datapoints) * 12))
 }
+
+// summarizeNulls returns the given points followed by nulls at the given timestamps.
+// like graphite, summarize without alignToFrom emits null buckets up to the end aligned to its interval.
+func summarizeNulls(points []schema.Point, ts ...uint32) []schema.Point {
+	for _, t := range ts {
+		points = append(points, schema.Point{Val: math.NaN(), Ts: t})
+	}
+	return points
+}
+
+// graphiteParityInputs are the inputs of the graphite parity tests of summarize, smartSummarize and hitcount.
+// they cover rollup data, raw data, series without values and series with a single point.
+func graphiteParityInputs() []models.Series {
+	NaN := math.NaN()
+	series := func(name string, start, interval uint32, vals ...float64) models.Series {
+		points := make([]schema.Point, len(vals))
+		for i, v := range vals {
+			points[i] = schema.Point{Val: v, Ts: start + uint32(i)*interval}
+		}
+		return models.Series{
+			Target:     name,
+			QueryPatt:  name,
+			QueryFrom:  start,
+			QueryTo:    start + uint32(len(vals))*interval,
+			Interval:   interval,
+			Datapoints: points,
+		}
+	}
+	return []models.Series{
+		series("rollup", 3660, 60, 1, 2, NaN, 4, 5, 6, NaN, NaN, 9, 10),
+		series("raw", 3730, 10, 3, NaN, 5, 1, NaN, NaN, 2, 8),
+		series("nulls", 3620, 10, NaN, NaN, NaN, NaN),
+		series("single", 3900, 60, 7),
+	}
+}
+
+// graphiteParityCheck validates that the given output series has the given values, at the given interval from start
+func graphiteParityCheck(name string, got []models.Series, start, interval uint32, exp []float64, t *testing.T) {
+	if len(got) != 1 {
+		t.Fatalf("case %q: expected 1 output series, got %d", name, len(got))
+	}
+	if got[0].Interval != interval {
+		t.Fatalf("case %q: expected interval %d, got %d", name, interval, got[0].Interval)
+	}
+	points := got[0].Datapoints
+	if len(points) != len(exp) {
+		t.Fatalf("case %q: expected %d points, got %v", name, len(exp), points)
+	}
+	for i, val := range exp {
+		p := points[i]
+		ts := start + uint32(i)*interval
+		if p.Ts != ts || !(p.Val == val || math.IsNaN(p.Val) && math.IsNaN(val)) {
+			t.Fatalf("case %q: point %d: expected {%v %d}, got %v", name, i, val, ts, p)
+		}
+	}
+}
+
+type graphiteSummarizeCase struct {
+	name        string
+	input       int // index in graphiteParityInputs
+	interval    string
+	fn          string
+	alignToFrom bool
+	expStart    uint32
+	exp         []float64
+}
+
+// graphiteSummarizeCases were generated by running graphite's summarize on graphiteParityInputs.
+// smartSummarize must match the cases that align to from.
+func graphiteSummarizeCases() []graphiteSummarizeCase {
+	NaN := math.NaN()
+	return []graphiteSummarizeCase{
+		{"rollup", 0, "20s", "sum", false, 3660, []float64{1, NaN, NaN, 2, NaN, NaN, NaN, NaN, NaN, 4, NaN, NaN, 5, NaN, NaN, 6, NaN, NaN, NaN, NaN, NaN, NaN, NaN, NaN, 9, NaN, NaN, 10, NaN, NaN, NaN}},
+		{"rollup", 0, "20s", "avg", true, 3660, []float64{1, NaN, NaN, 2, NaN, NaN, NaN, NaN, NaN, 4, NaN, NaN, 5, NaN, NaN, 6, NaN, NaN, NaN, NaN, NaN, NaN, NaN, NaN, 9, NaN, NaN, 10, NaN, NaN}},
+		{"rollup", 0, "45s", "max", false, 3645, []float64{1, 2, NaN, NaN, 4, 5, NaN, 6, NaN, NaN, NaN, 9, 10, NaN}},
+		{"rollup", 0, "45s", "min", true, 3660, []float64{1, 2, NaN, NaN, 4, 5, 6, NaN, NaN, NaN, 9, NaN, 10, NaN}},
+		{"rollup", 0, "1min", "last", false, 3660, []float64{1, 2, NaN, 4, 5, 6, NaN, NaN, 9, 10, NaN}},
+		{"rollup", 0, "1min", "sum", true, 3660, []float64{1, 2, NaN, 4, 5, 6, NaN, NaN, 9, 10}},
+		{"rollup", 0, "2min", "avg", false, 3600, []float64{1, 2, 4.5, 6, 9, 10}},
+		{"rollup", 0, "2min", "max", true, 3660, []float64{2, 4, 6, NaN, 10}},
+		{"rollup", 0, "5min", "min", false, 3600, []float64{1, 5, 10}},
+		{"rollup", 0, "5min", "last", true, 3660, []float64{5, 10}},
+		{"raw", 1, "20s", "sum", false, 3720, []float64{3, 5, 1, 2, 8}},
+		{"raw", 1, "20s", "avg", true, 3730, []float64{3, 3, NaN, 5}},
+		{"raw", 1, "45s", "max", false, 3690, []float64{3, 5, 8}},
+		{"raw", 1, "45s", "min", true, 3730, []float64{1, 2}},
+		{"raw", 1, "1min", "last", false, 3720, []float64{1, 8}},
+		{"raw", 1, "1min", "sum", true, 3730, []float64{9, 10}},
+		{"raw", 1, "2min", "avg", false, 3720, []float64{3.8}},
+		{"raw", 1, "2min", "max", true, 3730, []float64{8}},
+		{"raw", 1, "5min", "min", false, 3600, []float64{1}},
+		{"raw", 1, "5min", "last", true, 3730, []float64{8}},
+		{"nulls", 2, "20s", "sum", false, 3620, []float64{NaN, NaN, NaN}},
+		{"nulls", 2, "20s", "avg", true, 3620, []float64{NaN, NaN}},
+		{"nulls", 2, "45s", "max", false, 3600, []float64{NaN, NaN}},
+		{"nulls", 2, "45s", "min", true, 3620, []float64{NaN}},
+		{"nulls", 2, "1min", "last", false, 3600, []float64{NaN, NaN}},
+		{"nulls", 2, "1min", "sum", true, 3620, []float64{NaN}},
+		{"nulls", 2, "2min", "avg", false, 3600, []float64{NaN}},
+		{"nulls", 2, "2min", "max", true, 3620, []float64{NaN}},
+		{"nulls", 2, "5min", "min", false, 3600, []float64{NaN}},
+		{"nulls", 2, "5min", "last", true, 3620, []float64{NaN}},
+		{"single", 3, "20s", "sum", false, 3900, []float64{7, NaN, NaN, NaN}},
+		{"single", 3, "20s", "avg", true, 3900, []float64{7, NaN, NaN}},
+		{"single", 3, "45s", "max", false, 3870, []float64{7, NaN, NaN}},
+		{"single", 3, "45s", "min", true, 3900, []float64{7, NaN}},
+		{"single", 3, "1min", "last", false, 3900, []float64{7, NaN}},
+		{"single", 3, "1min", "sum", true, 3900, []float64{7}},
+		{"single", 3, "2min", "avg", false, 3840, []float64{7, NaN}},
+		{"single", 3, "2min", "max", true, 3900, []float64{7}},
+		{"single", 3, "5min", "min", false, 3900, []float64{7}},
+		{"single", 3, "5min", "last", true, 3900, []float64{7}},
+	}
+}
+
+func TestSummarizeGraphiteParity(t *testing.T) {
+	for _, c := range graphiteSummarizeCases() {
+		name := fmt.Sprintf("%s %s %s %t", c.name, c.interval, c.fn, c.alignToFrom)
+		f := NewSummarize().(*FuncSummarize)
+		f.in = NewMock(graphiteParityInputs()[c.input : c.input+1])
+		f.intervalString = c.interval
+		f.fn = c.fn
+		f.alignToFrom = c.alignToFrom
+
+		got, err := f.Exec(make(map[Req][]models.Series))
+		if err != nil {
+			t.Fatalf("case %q: unexpected error %s", name, err)
+		}
+		interval, _ := dur.ParseDuration(c.interval)
+		graphiteParityCheck(name, got, c.expStart, interval, c.exp, t)
+	}
+}
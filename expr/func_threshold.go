@@ -0,0 +1,42 @@
+package expr
+
+import (
+	"fmt"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+type FuncThreshold struct {
+	value float64
+	label string
+	color string
+	from  uint32
+	to    uint32
+}
+
+func NewThreshold() GraphiteFunc {
+	return &FuncThreshold{}
+}
+
+// Signature accepts the color for compatibility with graphite, but as metrictank does not render graphs, it is ignored.
+func (s *FuncThreshold) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgFloat{key: "value", val: &s.value},
+		ArgString{key: "label", opt: true, val: &s.label},
+		ArgString{key: "color", opt: true, val: &s.color},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncThreshold) Context(context Context) Context {
+	s.from = context.from
+	s.to = context.to
+	return context
+}
+
+func (s *FuncThreshold) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	name := s.label
+	if name == "" {
+		name = fmt.Sprintf("%g", s.value)
+	}
+	return []models.Series{constantLine(cache, name, fmt.Sprintf("constantLine(%g)", s.value), s.value, s.from, s.to)}, nil
+}
@@ -0,0 +1,131 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/dur"
+	"gopkg.in/raintank/schema.v1"
+)
+
+type FuncTimeShift struct {
+	in        GraphiteFunc
+	timeShift string
+	resetEnd  bool
+	shift     int64  // shift in seconds, negative means into the past
+	from      uint32 // the original from, before shifting
+	to        uint32 // the original to, before shifting
+}
+
+func NewTimeShift() GraphiteFunc {
+	return &FuncTimeShift{resetEnd: true}
+}
+
+func (s *FuncTimeShift) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgString{key: "timeShift", val: &s.timeShift, validator: []Validator{IsTimeShiftString}},
+		ArgBool{key: "resetEnd", opt: true, val: &s.resetEnd},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncTimeShift) Context(context Context) Context {
+	// like graphite, an unsigned shift goes back in time, and is named as such
+	if s.timeShift[0] != '+' && s.timeShift[0] != '-' {
+		s.timeShift = "-" + s.timeShift
+	}
+	s.shift, _ = parseTimeShift(s.timeShift)
+	s.from = context.from
+	s.to = context.to
+	return shiftContext(context, s.shift)
+}
+
+func (s *FuncTimeShift) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs []models.Series
+	for _, serie := range series {
+		name := fmt.Sprintf("timeShift(%s, \"%s\")", serie.Target, s.timeShift)
+		tags := make(map[string]string, len(serie.Tags)+1)
+		for k, v := range serie.Tags {
+			tags[k] = v
+		}
+		tags["timeShift"] = s.timeShift
+		output := models.Series{
+			Target:       name,
+			QueryPatt:    name,
+			Tags:         tags,
+			Datapoints:   shiftPoints(serie.Datapoints, s.shift, s.to, s.resetEnd),
+			Interval:     serie.Interval,
+			QueryFrom:    s.from,
+			QueryTo:      s.to,
+			Consolidator: serie.Consolidator,
+			QueryCons:    serie.QueryCons,
+		}
+		outputs = append(outputs, output)
+		cache[Req{}] = append(cache[Req{}], output)
+	}
+	return outputs, nil
+}
+
+var errInvalidTimeShift = errors.New("invalid time shift")
+
+// parseTimeShift parses a graphite time shift such as "1d", "-1d" or "+1h" into a number of seconds.
+// in accordance with graphite, shifts without a sign are into the past (negative)
+func parseTimeShift(s string) (int64, error) {
+	if len(s) == 0 {
+		return 0, errInvalidTimeShift
+	}
+	sign := int64(-1)
+	switch s[0] {
+	case '+':
+		sign = 1
+		s = s[1:]
+	case '-':
+		s = s[1:]
+	}
+	shift, err := dur.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	return sign * int64(shift), nil
+}
+
+// shiftContext moves the time window of the context by the given number of seconds
+func shiftContext(context Context, shift int64) Context {
+	context.from = shiftTs(context.from, shift)
+	context.to = shiftTs(context.to, shift)
+	return context
+}
+
+func shiftTs(ts uint32, shift int64) uint32 {
+	shifted := int64(ts) + shift
+	if shifted < 0 {
+		return 0
+	}
+	if shifted > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(shifted)
+}
+
+// shiftPoints returns a copy of the given points, that were fetched for a time window moved by shift,
+// moved back into the original time window.
+// if resetEnd is set, points that would end up at or beyond to are dropped.
+// the returned slice comes from the pool. it's up to the caller to add it to the cache.
+func shiftPoints(in []schema.Point, shift int64, to uint32, resetEnd bool) []schema.Point {
+	out := pointSlicePool.Get().([]schema.Point)
+	for _, p := range in {
+		ts := shiftTs(p.Ts, -shift)
+		if resetEnd && ts >= to {
+			break
+		}
+		out = append(out, schema.Point{Val: p.Val, Ts: ts})
+	}
+	return out
+}
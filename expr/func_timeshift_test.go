@@ -0,0 +1,183 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func TestParseTimeShift(t *testing.T) {
+	cases := []struct {
+		in     string
+		exp    int64
+		expErr bool
+	}{
+		{"1h", -3600, false},
+		{"-1h", -3600, false},
+		{"+1h", 3600, false},
+		{"1d", -24 * 3600, false},
+		{"+2w", 2 * 7 * 24 * 3600, false},
+		{"", 0, true},
+		{"+", 0, true},
+		{"foo", 0, true},
+	}
+	for _, c := range cases {
+		got, err := parseTimeShift(c.in)
+		if (err != nil) != c.expErr {
+			t.Fatalf("case %q: expected error %t, got %v", c.in, c.expErr, err)
+		}
+		if got != c.exp {
+			t.Fatalf("case %q: expected %d, got %d", c.in, c.exp, got)
+		}
+	}
+}
+
+func TestTimeShiftPlan(t *testing.T) {
+	from := uint32(100000)
+	to := uint32(100060)
+	cases := []struct {
+		target  string
+		expFrom uint32
+		expTo   uint32
+	}{
+		{"timeShift(a, '1h')", from - 3600, to - 3600},
+		{"timeShift(a, '-1h')", from - 3600, to - 3600},
+		{"timeShift(a, '+1h')", from + 3600, to + 3600},
+		{"timeShift(a, '1y')", 0, 0},
+	}
+	for _, c := range cases {
+		exprs, err := ParseMany([]string{c.target})
+		if err != nil {
+			t.Fatalf("case %q: unexpected parse error %s", c.target, err)
+		}
+		plan, err := NewPlan(exprs, from, to, 800, true, nil, nil)
+		if err != nil {
+			t.Fatalf("case %q: unexpected plan error %s", c.target, err)
+		}
+		exp := NewReq("a", c.expFrom, c.expTo, 0)
+		if len(plan.Reqs) != 1 || plan.Reqs[0] != exp {
+			t.Fatalf("case %q: expected reqs %v, got %v", c.target, []Req{exp}, plan.Reqs)
+		}
+	}
+}
+
+func TestTimeShiftPlanInvalid(t *testing.T) {
+	exprs, err := ParseMany([]string{"timeShift(a, 'foo')"})
+	if err != nil {
+		t.Fatalf("unexpected parse error %s", err)
+	}
+	_, err = NewPlan(exprs, 1000, 2000, 800, true, nil, nil)
+	if err == nil {
+		t.Fatalf("expected plan error for invalid time shift, got nil")
+	}
+}
+
+func TestTimeShift(t *testing.T) {
+	from := uint32(100000)
+	to := uint32(100040)
+	exprs, err := ParseMany([]string{"timeShift(a, '1h')"})
+	if err != nil {
+		t.Fatalf("unexpected parse error %s", err)
+	}
+	plan, err := NewPlan(exprs, from, to, 800, true, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected plan error %s", err)
+	}
+
+	in := []models.Series{
+		{
+			Target:    "a",
+			QueryPatt: "a",
+			Interval:  10,
+			Tags:      map[string]string{"name": "a"},
+			Datapoints: []schema.Point{
+				{Val: 1, Ts: from - 3600},
+				{Val: math.NaN(), Ts: from - 3600 + 10},
+				{Val: 3, Ts: from - 3600 + 20},
+				{Val: 4, Ts: from - 3600 + 30},
+			},
+		},
+	}
+	input := map[Req][]models.Series{
+		NewReq("a", from-3600, to-3600, 0): in,
+	}
+	got, err := plan.Run(input)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	exp := []models.Series{
+		{
+			Target: "timeShift(a, \"-1h\")",
+			Datapoints: []schema.Point{
+				{Val: 1, Ts: from},
+				{Val: math.NaN(), Ts: from + 10},
+				{Val: 3, Ts: from + 20},
+				{Val: 4, Ts: from + 30},
+			},
+		},
+	}
+	checkSeriesPoints("timeShift", exp, got, t)
+	if got[0].QueryFrom != from || got[0].QueryTo != to {
+		t.Fatalf("expected query window %d-%d, got %d-%d", from, to, got[0].QueryFrom, got[0].QueryTo)
+	}
+	if got[0].Tags["timeShift"] != "-1h" {
+		t.Fatalf("expected tag timeShift=-1h, got %v", got[0].Tags)
+	}
+	if _, ok := in[0].Tags["timeShift"]; ok {
+		t.Fatalf("input tags should not be modified, got %v", in[0].Tags)
+	}
+	if in[0].Datapoints[0].Ts != from-3600 {
+		t.Fatalf("input points should not be modified, got %v", in[0].Datapoints)
+	}
+}
+
+func TestShiftPoints(t *testing.T) {
+	in := []schema.Point{
+		{Val: 1, Ts: 10},
+		{Val: 2, Ts: 20},
+		{Val: 3, Ts: 30},
+		{Val: 4, Ts: 40},
+	}
+	cases := []struct {
+		name     string
+		shift    int64
+		to       uint32
+		resetEnd bool
+		exp      []schema.Point
+	}{
+		{
+			"into the past",
+			-100,
+			150,
+			true,
+			[]schema.Point{{Val: 1, Ts: 110}, {Val: 2, Ts: 120}, {Val: 3, Ts: 130}, {Val: 4, Ts: 140}},
+		},
+		{
+			"into the past, beyond to, resetEnd",
+			-100,
+			130,
+			true,
+			[]schema.Point{{Val: 1, Ts: 110}, {Val: 2, Ts: 120}},
+		},
+		{
+			"into the past, beyond to, no resetEnd",
+			-100,
+			130,
+			false,
+			[]schema.Point{{Val: 1, Ts: 110}, {Val: 2, Ts: 120}, {Val: 3, Ts: 130}, {Val: 4, Ts: 140}},
+		},
+		{
+			"into the future",
+			5,
+			100,
+			true,
+			[]schema.Point{{Val: 1, Ts: 5}, {Val: 2, Ts: 15}, {Val: 3, Ts: 25}, {Val: 4, Ts: 35}},
+		},
+	}
+	for _, c := range cases {
+		got := shiftPoints(in, c.shift, c.to, c.resetEnd)
+		checkSeriesPoints(c.name, []models.Series{{Datapoints: c.exp}}, []models.Series{{Datapoints: got}}, t)
+	}
+}
@@ -0,0 +1,106 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/grafana/metrictank/api/models"
+	"github.com/raintank/dur"
+	"gopkg.in/raintank/schema.v1"
+)
+
+type FuncTimeSlice struct {
+	in           GraphiteFunc
+	startSliceAt string
+	endSliceAt   string
+	start        uint32 // start of the slice (inclusive)
+	end          uint32 // end of the slice (inclusive)
+	from         uint32 // the original from, before narrowing to the slice
+	to           uint32 // the original to, before narrowing to the slice
+}
+
+func NewTimeSlice() GraphiteFunc {
+	return &FuncTimeSlice{endSliceAt: "now"}
+}
+
+func (s *FuncTimeSlice) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgString{key: "startSliceAt", val: &s.startSliceAt, validator: []Validator{IsDateTimeString}},
+		ArgString{key: "endSliceAt", opt: true, val: &s.endSliceAt, validator: []Validator{IsDateTimeString}},
+	}, []Arg{ArgSeriesList{}}
+}
+
+// Context narrows the requested time window down to the slice, as data outside of it will not be used.
+func (s *FuncTimeSlice) Context(context Context) Context {
+	now := time.Now()
+	s.start, _ = dur.ParseDateTime(s.startSliceAt, time.Local, now, 0)
+	s.end, _ = dur.ParseDateTime(s.endSliceAt, time.Local, now, 0)
+	s.from = context.from
+	s.to = context.to
+
+	// from is inclusive, to is exclusive
+	from := context.from
+	if s.start > from {
+		from = s.start
+	}
+	to := context.to
+	if s.end < math.MaxUint32 && s.end+1 < to {
+		to = s.end + 1
+	}
+	// if the slice does not overlap the window, there is nothing to narrow down to.
+	// we keep the window as is, all output values will be null anyway.
+	if from < to {
+		context.from = from
+		context.to = to
+	}
+	return context
+}
+
+func (s *FuncTimeSlice) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	series, err := s.in.Exec(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs []models.Series
+	for _, serie := range series {
+		name := fmt.Sprintf("timeSlice(%s, %d, %d)", serie.Target, s.start, s.end)
+		output := models.Series{
+			Target:       name,
+			QueryPatt:    name,
+			Tags:         serie.Tags,
+			Datapoints:   s.slice(serie.Datapoints, serie.Interval),
+			Interval:     serie.Interval,
+			QueryFrom:    s.from,
+			QueryTo:      s.to,
+			Consolidator: serie.Consolidator,
+			QueryCons:    serie.QueryCons,
+		}
+		outputs = append(outputs, output)
+		cache[Req{}] = append(cache[Req{}], output)
+	}
+	return outputs, nil
+}
+
+// slice returns points covering the original window, of which all values outside the slice are null.
+// the input points may only cover the slice, if the window was narrowed down.
+func (s *FuncTimeSlice) slice(in []schema.Point, interval uint32) []schema.Point {
+	out := pointSlicePool.Get().([]schema.Point)
+	if interval == 0 {
+		return append(out, in...)
+	}
+	i := 0
+	for ts := ((s.from + interval - 1) / interval) * interval; ts < s.to; ts += interval {
+		p := schema.Point{Val: math.NaN(), Ts: ts}
+		for i < len(in) && in[i].Ts < ts {
+			i++
+		}
+		if i < len(in) && in[i].Ts == ts && ts >= s.start && ts <= s.end {
+			p.Val = in[i].Val
+		}
+		out = append(out, p)
+	}
+	return out
+}
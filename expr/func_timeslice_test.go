@@ -0,0 +1,82 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func TestTimeSliceContext(t *testing.T) {
+	from := uint32(1000)
+	to := uint32(2000)
+	cases := []struct {
+		name    string
+		start   string
+		end     string
+		expFrom uint32
+		expTo   uint32
+	}{
+		{"slice within window", "1200", "1500", 1200, 1501},
+		{"slice starts before window", "500", "1500", 1000, 1501},
+		{"slice ends after window", "1200", "5000", 1200, 2000},
+		{"slice covers window", "500", "5000", 1000, 2000},
+		{"slice before window", "100", "500", 1000, 2000},
+		{"slice after window", "3000", "5000", 1000, 2000},
+	}
+	for _, c := range cases {
+		f := NewTimeSlice().(*FuncTimeSlice)
+		f.startSliceAt = c.start
+		f.endSliceAt = c.end
+		got := f.Context(Context{from: from, to: to})
+		if got.from != c.expFrom || got.to != c.expTo {
+			t.Fatalf("case %q: expected window %d-%d, got %d-%d", c.name, c.expFrom, c.expTo, got.from, got.to)
+		}
+	}
+}
+
+func TestTimeSlice(t *testing.T) {
+	in := []models.Series{
+		{
+			Target:    "a",
+			QueryPatt: "a",
+			Interval:  10,
+			Datapoints: []schema.Point{
+				{Val: 3, Ts: 30},
+				{Val: math.NaN(), Ts: 40},
+				{Val: 5, Ts: 50},
+			},
+		},
+	}
+	f := NewTimeSlice().(*FuncTimeSlice)
+	f.startSliceAt = "30"
+	f.endSliceAt = "50"
+	context := f.Context(Context{from: 5, to: 70})
+	if context.from != 30 || context.to != 51 {
+		t.Fatalf("expected window 30-51, got %d-%d", context.from, context.to)
+	}
+	f.in = NewMock(in)
+
+	got, err := f.Exec(make(map[Req][]models.Series))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	exp := []models.Series{
+		{
+			Target: "timeSlice(a, 30, 50)",
+			Datapoints: []schema.Point{
+				{Val: math.NaN(), Ts: 10},
+				{Val: math.NaN(), Ts: 20},
+				{Val: 3, Ts: 30},
+				{Val: math.NaN(), Ts: 40},
+				{Val: 5, Ts: 50},
+				{Val: math.NaN(), Ts: 60},
+			},
+		},
+	}
+	checkSeriesPoints("timeSlice", exp, got, t)
+	if got[0].QueryFrom != 5 || got[0].QueryTo != 70 {
+		t.Fatalf("expected query window 5-70, got %d-%d", got[0].QueryFrom, got[0].QueryTo)
+	}
+}
@@ -0,0 +1,92 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/grafana/metrictank/api/models"
+)
+
+type FuncTimeStack struct {
+	in             GraphiteFunc   // input as set up by the planner for the current context
+	ins            []GraphiteFunc // inputs for each shift
+	shifts         []int64        // shift in seconds, for each input
+	timeShiftUnit  string
+	timeShiftStart int64
+	timeShiftEnd   int64
+	from           uint32 // the original from, before shifting
+	to             uint32 // the original to, before shifting
+}
+
+func NewTimeStack() GraphiteFunc {
+	return &FuncTimeStack{timeShiftUnit: "1d", timeShiftStart: 0, timeShiftEnd: 7}
+}
+
+func (s *FuncTimeStack) Signature() ([]Arg, []Arg) {
+	return []Arg{
+		ArgSeriesList{val: &s.in},
+		ArgString{key: "timeShiftUnit", opt: true, val: &s.timeShiftUnit, validator: []Validator{IsTimeShiftString}},
+		ArgInt{key: "timeShiftStart", opt: true, val: &s.timeShiftStart},
+		ArgInt{key: "timeShiftEnd", opt: true, val: &s.timeShiftEnd},
+	}, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncTimeStack) Context(context Context) Context {
+	return context
+}
+
+// Contexts returns a shifted context for every shift from timeShiftStart (inclusive) until timeShiftEnd (exclusive)
+func (s *FuncTimeStack) Contexts(context Context) []Context {
+	// in accordance with graphite, a unit without sign is into the past
+	if s.timeShiftUnit[0] != '+' && s.timeShiftUnit[0] != '-' {
+		s.timeShiftUnit = "-" + s.timeShiftUnit
+	}
+	unit, _ := parseTimeShift(s.timeShiftUnit)
+	s.from = context.from
+	s.to = context.to
+
+	var contexts []Context
+	for i := s.timeShiftStart; i < s.timeShiftEnd; i++ {
+		s.shifts = append(s.shifts, unit*i)
+		contexts = append(contexts, shiftContext(context, unit*i))
+	}
+	return contexts
+}
+
+func (s *FuncTimeStack) InputsDone() {
+	s.ins = append(s.ins, s.in)
+}
+
+func (s *FuncTimeStack) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	var outputs []models.Series
+	for i, in := range s.ins {
+		series, err := in.Exec(cache)
+		if err != nil {
+			return nil, err
+		}
+		shift := s.timeShiftStart + int64(i)
+		for _, serie := range series {
+			name := fmt.Sprintf("timeShift(%s, %s, %d)", serie.Target, s.timeShiftUnit, shift)
+			tags := make(map[string]string, len(serie.Tags)+2)
+			for k, v := range serie.Tags {
+				tags[k] = v
+			}
+			tags["timeShiftUnit"] = s.timeShiftUnit
+			tags["timeShift"] = strconv.FormatInt(shift, 10)
+			output := models.Series{
+				Target:       name,
+				QueryPatt:    name,
+				Tags:         tags,
+				Datapoints:   shiftPoints(serie.Datapoints, s.shifts[i], s.to, true),
+				Interval:     serie.Interval,
+				QueryFrom:    s.from,
+				QueryTo:      s.to,
+				Consolidator: serie.Consolidator,
+				QueryCons:    serie.QueryCons,
+			}
+			outputs = append(outputs, output)
+			cache[Req{}] = append(cache[Req{}], output)
+		}
+	}
+	return outputs, nil
+}
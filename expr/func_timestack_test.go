@@ -0,0 +1,120 @@
+package expr
+
+import (
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func TestTimeStackPlan(t *testing.T) {
+	from := uint32(1000000)
+	to := uint32(1000060)
+	day := uint32(24 * 3600)
+	cases := []struct {
+		target string
+		exp    []Req
+	}{
+		{
+			"timeStack(a)",
+			[]Req{
+				NewReq("a", from, to, 0),
+				NewReq("a", from-day, to-day, 0),
+				NewReq("a", from-2*day, to-2*day, 0),
+				NewReq("a", from-3*day, to-3*day, 0),
+				NewReq("a", from-4*day, to-4*day, 0),
+				NewReq("a", from-5*day, to-5*day, 0),
+				NewReq("a", from-6*day, to-6*day, 0),
+			},
+		},
+		{
+			"timeStack(a, '1h', 1, 3)",
+			[]Req{
+				NewReq("a", from-3600, to-3600, 0),
+				NewReq("a", from-2*3600, to-2*3600, 0),
+			},
+		},
+		{
+			"timeStack(a, '+1h', 0, 2)",
+			[]Req{
+				NewReq("a", from, to, 0),
+				NewReq("a", from+3600, to+3600, 0),
+			},
+		},
+		{
+			"sumSeries(timeStack(a, '1h', 0, 2))",
+			[]Req{
+				NewReq("a", from, to, 0),
+				NewReq("a", from-3600, to-3600, 0),
+			},
+		},
+	}
+	for _, c := range cases {
+		exprs, err := ParseMany([]string{c.target})
+		if err != nil {
+			t.Fatalf("case %q: unexpected parse error %s", c.target, err)
+		}
+		plan, err := NewPlan(exprs, from, to, 800, true, nil, nil)
+		if err != nil {
+			t.Fatalf("case %q: unexpected plan error %s", c.target, err)
+		}
+		if len(plan.Reqs) != len(c.exp) {
+			t.Fatalf("case %q: expected reqs %v, got %v", c.target, c.exp, plan.Reqs)
+		}
+		for i := range c.exp {
+			if plan.Reqs[i] != c.exp[i] {
+				t.Fatalf("case %q: expected reqs %v, got %v", c.target, c.exp, plan.Reqs)
+			}
+		}
+	}
+}
+
+func TestTimeStack(t *testing.T) {
+	from := uint32(100000)
+	to := uint32(100020)
+	exprs, err := ParseMany([]string{"timeStack(a, '1h', 0, 2)"})
+	if err != nil {
+		t.Fatalf("unexpected parse error %s", err)
+	}
+	plan, err := NewPlan(exprs, from, to, 800, true, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected plan error %s", err)
+	}
+
+	input := map[Req][]models.Series{
+		NewReq("a", from, to, 0): {
+			{
+				Target:     "a",
+				QueryPatt:  "a",
+				Interval:   10,
+				Datapoints: []schema.Point{{Val: 1, Ts: from}, {Val: 2, Ts: from + 10}},
+			},
+		},
+		NewReq("a", from-3600, to-3600, 0): {
+			{
+				Target:     "a",
+				QueryPatt:  "a",
+				Interval:   10,
+				Datapoints: []schema.Point{{Val: 3, Ts: from - 3600}, {Val: 4, Ts: from - 3600 + 10}},
+			},
+		},
+	}
+	got, err := plan.Run(input)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	exp := []models.Series{
+		{
+			Target:     "timeShift(a, -1h, 0)",
+			Datapoints: []schema.Point{{Val: 1, Ts: from}, {Val: 2, Ts: from + 10}},
+		},
+		{
+			Target:     "timeShift(a, -1h, 1)",
+			Datapoints: []schema.Point{{Val: 3, Ts: from}, {Val: 4, Ts: from + 10}},
+		},
+	}
+	checkSeriesPoints("timeStack", exp, got, t)
+	if got[1].Tags["timeShiftUnit"] != "-1h" || got[1].Tags["timeShift"] != "1" {
+		t.Fatalf("expected tags timeShiftUnit=-1h and timeShift=1, got %v", got[1].Tags)
+	}
+}
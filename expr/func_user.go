@@ -0,0 +1,110 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+// FuncUser executes a user-defined function, see UserFunc
+type FuncUser struct {
+	def    *UserFunc
+	in     []GraphiteFunc // for every parameter, its input if it is a seriesList
+	values []float64      // for every parameter, its value if it is a float
+}
+
+func NewUserFuncConstructor(def *UserFunc) func() GraphiteFunc {
+	return func() GraphiteFunc {
+		s := &FuncUser{
+			def:    def,
+			in:     make([]GraphiteFunc, len(def.Params)),
+			values: make([]float64, len(def.Params)),
+		}
+		for i, p := range def.Params {
+			if p.Default != nil {
+				s.values[i] = *p.Default
+			}
+		}
+		return s
+	}
+}
+
+func (s *FuncUser) Signature() ([]Arg, []Arg) {
+	args := make([]Arg, 0, len(s.def.Params))
+	for i, p := range s.def.Params {
+		if p.Type == "seriesList" {
+			args = append(args, ArgSeriesList{key: p.Name, val: &s.in[i]})
+		} else {
+			args = append(args, ArgFloat{key: p.Name, opt: p.Default != nil, val: &s.values[i]})
+		}
+	}
+	return args, []Arg{ArgSeriesList{}}
+}
+
+func (s *FuncUser) Context(context Context) Context {
+	return context
+}
+
+// Exec evaluates the expression for every point of every series of the first parameter.
+// the series of other seriesList parameters are matched by position, or if there is only one, it is used for all of them.
+func (s *FuncUser) Exec(cache map[Req][]models.Series) ([]models.Series, error) {
+	inputs := make([][]models.Series, len(s.in))
+	for i, in := range s.in {
+		if in == nil {
+			continue
+		}
+		series, err := in.Exec(cache)
+		if err != nil {
+			return nil, err
+		}
+		inputs[i] = series
+	}
+	for i, series := range inputs[1:] {
+		if s.in[i+1] != nil && len(series) != 1 && len(series) != len(inputs[0]) {
+			return nil, fmt.Errorf("%s: %s must reference exactly 1 series or as many as %s (%d), not %d", s.def.Name, s.def.Params[i+1].Name, s.def.Params[0].Name, len(inputs[0]), len(series))
+		}
+	}
+
+	var outputs []models.Series
+	params := make([]float64, len(s.def.Params))
+	for i, serie := range inputs[0] {
+		args := make([]string, len(s.def.Params))
+		args[0] = serie.Target
+		// the series to use for every other seriesList parameter
+		matched := make([]models.Series, len(s.def.Params))
+		for j := 1; j < len(s.def.Params); j++ {
+			if s.in[j] == nil {
+				params[j] = s.values[j]
+				args[j] = fmt.Sprintf("%g", s.values[j])
+				continue
+			}
+			matched[j] = inputs[j][0]
+			if len(inputs[j]) > 1 {
+				matched[j] = inputs[j][i]
+			}
+			args[j] = matched[j].Target
+		}
+
+		out := pointSlicePool.Get().([]schema.Point)
+		for k, p := range serie.Datapoints {
+			params[0] = p.Val
+			for j := 1; j < len(s.def.Params); j++ {
+				if s.in[j] == nil {
+					continue
+				}
+				// points are matched by position. a series that is too short is treated as null
+				params[j] = math.NaN()
+				if k < len(matched[j].Datapoints) {
+					params[j] = matched[j].Datapoints[k].Val
+				}
+			}
+			out = append(out, schema.Point{Val: s.def.expr.eval(params), Ts: p.Ts})
+		}
+		name := fmt.Sprintf("%s(%s)", s.def.Name, strings.Join(args, ","))
+		outputs = append(outputs, transformOutput(cache, serie, name, s.def.Name, s.def.Expression, out))
+	}
+	return outputs, nil
+}
@@ -1,15 +1,20 @@
 package expr
 
 import (
+	"errors"
+	"time"
+
 	"github.com/grafana/metrictank/api/models"
 	"github.com/grafana/metrictank/consolidation"
 )
 
 // Context describes a series timeframe and consolidator
 type Context struct {
-	from   uint32
-	to     uint32
-	consol consolidation.Consolidator // can be 0 to mean undefined
+	from    uint32
+	to      uint32
+	consol  consolidation.Consolidator // can be 0 to mean undefined
+	dynamic *[]dynamicPlan             // functions that can only be planned at runtime. shared by the whole plan, see dynamicFunc
+	loc     *time.Location             // timezone of the request, for functions that align to days, weeks etc
 }
 
 // GraphiteFunc defines a graphite processing function
@@ -36,6 +41,60 @@ type GraphiteFunc interface {
 	Exec(map[Req][]models.Series) ([]models.Series, error)
 }
 
+// multiContextFunc can optionally be implemented by a GraphiteFunc that needs the data of its series inputs
+// for more than one context. e.g. timeStack(foo, "1d", 0, 7) needs the data of foo for 7 different time windows.
+// for such functions, the planner calls Contexts() instead of Context(), and then for each returned context
+// sets up the series inputs, followed by a call to InputsDone().
+// InputsDone should save the series inputs that were just set up, as the next round will overwrite them.
+type multiContextFunc interface {
+	Contexts(c Context) []Context
+	InputsDone()
+}
+
+// rangeFunc can optionally be implemented by a GraphiteFunc whose output covers a different time range than the data it consumes.
+// e.g. linearRegression(foo, "-7d") outputs the requested range, but it is computed from the data of foo over the last 7 days,
+// which may not even overlap with it.
+// for such functions, the planner calls DataRange() with the requested range (after calling Context), and sets up the series inputs
+// for the returned range instead. DataRange should save the requested range, as the output series must cover it,
+// and have their QueryFrom and QueryTo set to it.
+type rangeFunc interface {
+	DataRange(from, to uint32) (uint32, uint32, error)
+}
+
+// dynamicFunc can optionally be implemented by a GraphiteFunc that can only determine which data it needs
+// once the data of its series inputs is known. e.g. applyByNode generates new expressions based on the names of its input series.
+// PlanDynamic is called once the data for all requests planned so far is available in the cache.
+// it can use the given plan function to plan any expressions it comes up with, which returns the function to execute.
+// the data needed by those functions will be fetched and added to the cache before Exec is called.
+type dynamicFunc interface {
+	PlanDynamic(cache map[Req][]models.Series, plan func(target string) (GraphiteFunc, error)) error
+}
+
+// errNotPlanned is returned by a dynamicFunc that is executed before it was planned
+var errNotPlanned = errors.New("function can not be executed before its dynamic inputs are planned")
+
+// bootstrapFunc can optionally be implemented by a GraphiteFunc that needs data from before the requested range,
+// but can only determine how much once the data of its series inputs is known. e.g. movingSum(foo, 5) needs the
+// 5 points before from, but the interval of foo is only known once it has been fetched.
+// Bootstrap is called once the data of the series inputs is available, and returns how many seconds of data are needed
+// before from. like graphite's _fetchWithBootstrap, the planner then sets up the series inputs again for the extended range,
+// and their data will be fetched and added to the cache before Exec is called. Bootstrap may return 0 if no data is needed.
+type bootstrapFunc interface {
+	Bootstrap(cache map[Req][]models.Series) (uint32, error)
+}
+
+// dynamicPlan is a dynamicFunc or bootstrapFunc along with what's needed to plan it
+type dynamicPlan struct {
+	fn      dynamicFunc
+	context Context
+	stable  bool
+
+	// for a bootstrapFunc: the expression and its series args, to set up again for the extended range
+	bootstrap  bootstrapFunc
+	e          *expr
+	seriesArgs []seriesArg
+}
+
 type funcConstructor func() GraphiteFunc
 
 type funcDef struct {
@@ -48,37 +107,109 @@ var funcs map[string]funcDef
 func init() {
 	// keys must be sorted alphabetically. but functions with aliases can go together, in which case they are sorted by the first of their aliases
 	funcs = map[string]funcDef{
-		"alias":             {NewAlias, true},
-		"aliasByTags":       {NewAliasByNode, true},
-		"aliasByNode":       {NewAliasByNode, true},
-		"aliasSub":          {NewAliasSub, true},
-		"avg":               {NewAggregateConstructor("average", crossSeriesAvg), true},
-		"averageSeries":     {NewAggregateConstructor("average", crossSeriesAvg), true},
-		"consolidateBy":     {NewConsolidateBy, true},
-		"diffSeries":        {NewAggregateConstructor("diff", crossSeriesDiff), true},
-		"divideSeries":      {NewDivideSeries, true},
-		"divideSeriesLists": {NewDivideSeriesLists, true},
-		"exclude":           {NewExclude, true},
-		"grep":              {NewGrep, true},
-		"groupByTags":       {NewGroupByTags, true},
-		"isNonNull":         {NewIsNonNull, true},
-		"max":               {NewAggregateConstructor("max", crossSeriesMax), true},
-		"maxSeries":         {NewAggregateConstructor("max", crossSeriesMax), true},
-		"min":               {NewAggregateConstructor("min", crossSeriesMin), true},
-		"minSeries":         {NewAggregateConstructor("min", crossSeriesMin), true},
-		"multiplySeries":    {NewAggregateConstructor("multiply", crossSeriesMultiply), true},
-		"movingAverage":     {NewMovingAverage, false},
-		"perSecond":         {NewPerSecond, true},
-		"rangeOfSeries":     {NewAggregateConstructor("rangeOf", crossSeriesRange), true},
-		"scale":             {NewScale, true},
-		"scaleToSeconds":    {NewScaleToSeconds, true},
-		"smartSummarize":    {NewSmartSummarize, false},
-		"sortByName":        {NewSortByName, true},
-		"stddevSeries":      {NewAggregateConstructor("stddev", crossSeriesStddev), true},
-		"sum":               {NewAggregateConstructor("sum", crossSeriesSum), true},
-		"sumSeries":         {NewAggregateConstructor("sum", crossSeriesSum), true},
-		"summarize":         {NewSummarize, true},
-		"transformNull":     {NewTransformNull, true},
+		"absolute":                   {NewAbsolute, true},
+		"alias":                      {NewAlias, true},
+		"aliasByMetric":              {NewAliasByMetric, true},
+		"aliasByTags":                {NewAliasByNode, true},
+		"aliasByNode":                {NewAliasByNode, true},
+		"aliasSub":                   {NewAliasSub, true},
+		"applyByNode":                {NewApplyByNode, true},
+		"asPercent":                  {NewAsPercent, true},
+		"averageAbove":               {NewFilterSeriesConstructor("average", ">"), true},
+		"averageBelow":               {NewFilterSeriesConstructor("average", "<="), true},
+		"avg":                        {NewAggregateConstructor("average", crossSeriesAvg), true},
+		"averageSeries":              {NewAggregateConstructor("average", crossSeriesAvg), true},
+		"cactiStyle":                 {NewCactiStyle, true},
+		"consolidateBy":              {NewConsolidateBy, true},
+		"constantLine":               {NewConstantLine, true},
+		"currentAbove":               {NewFilterSeriesConstructor("last", ">"), true},
+		"currentBelow":               {NewFilterSeriesConstructor("last", "<="), true},
+		"delay":                      {NewDelay, true},
+		"derivative":                 {NewDerivative, true},
+		"diffSeries":                 {NewAggregateConstructor("diff", crossSeriesDiff), true},
+		"divideSeries":               {NewDivideSeries, true},
+		"divideSeriesLists":          {NewDivideSeriesLists, true},
+		"exclude":                    {NewExclude, true},
+		"filterSeries":               {NewFilterSeries, true},
+		"grep":                       {NewGrep, true},
+		"groupByTags":                {NewGroupByTags, true},
+		"groupByNode":                {NewGroupByNodesConstructor(true), true},
+		"groupByNodes":               {NewGroupByNodesConstructor(false), true},
+		"highest":                    {NewHighestLowestConstructor("", true), true},
+		"highestAverage":             {NewHighestLowestConstructor("average", true), true},
+		"highestCurrent":             {NewHighestLowestConstructor("current", true), true},
+		"highestMax":                 {NewHighestLowestConstructor("max", true), true},
+		"hitcount":                   {NewHitcount, true},
+		"holtWintersAberration":      {NewHoltWintersAberration, true},
+		"holtWintersConfidenceBands": {NewHoltWintersConfidenceBands, true},
+		"holtWintersForecast":        {NewHoltWintersForecast, true},
+		"identity":                   {NewIdentity, true},
+		"integral":                   {NewIntegral, true},
+		"integralByInterval":         {NewIntegralByInterval, true},
+		"interpolate":                {NewInterpolate, true},
+		"invert":                     {NewInvert, true},
+		"isNonNull":                  {NewIsNonNull, true},
+		"keepLastValue":              {NewKeepLastValue, true},
+		"legendValue":                {NewLegendValue, true},
+		"limit":                      {NewLimit, true},
+		"linearRegression":           {NewLinearRegression, true},
+		"log":                        {NewLog, true},
+		"lowest":                     {NewHighestLowestConstructor("", false), true},
+		"lowestAverage":              {NewHighestLowestConstructor("average", false), true},
+		"lowestCurrent":              {NewHighestLowestConstructor("current", false), true},
+		"max":                        {NewAggregateConstructor("max", crossSeriesMax), true},
+		"maxSeries":                  {NewAggregateConstructor("max", crossSeriesMax), true},
+		"maximumAbove":               {NewFilterSeriesConstructor("max", ">"), true},
+		"maximumBelow":               {NewFilterSeriesConstructor("max", "<="), true},
+		"min":                        {NewAggregateConstructor("min", crossSeriesMin), true},
+		"minSeries":                  {NewAggregateConstructor("min", crossSeriesMin), true},
+		"minimumAbove":               {NewFilterSeriesConstructor("min", ">"), true},
+		"minimumBelow":               {NewFilterSeriesConstructor("min", "<="), true},
+		"multiplySeries":             {NewAggregateConstructor("multiply", crossSeriesMultiply), true},
+		"movingAverage":              {NewMovingWindowConstructor("average"), true},
+		"movingMax":                  {NewMovingWindowConstructor("max"), true},
+		"movingMedian":               {NewMovingWindowConstructor("median"), true},
+		"movingMin":                  {NewMovingWindowConstructor("min"), true},
+		"movingSum":                  {NewMovingWindowConstructor("sum"), true},
+		"movingWindow":               {NewMovingWindowConstructor(""), true},
+		"nonNegativeDerivative":      {NewNonNegativeDerivative, true},
+		"nPercentile":                {NewNPercentile, true},
+		"offset":                     {NewOffset, true},
+		"offsetToZero":               {NewOffsetToZero, true},
+		"perSecond":                  {NewPerSecond, true},
+		"percentileOfSeries":         {NewPercentileOfSeries, true},
+		"pow":                        {NewPow, true},
+		"randomWalk":                 {NewRandomWalk, false},
+		"randomWalkFunction":         {NewRandomWalk, false},
+		"rangeOfSeries":              {NewAggregateConstructor("rangeOf", crossSeriesRange), true},
+		"removeAbovePercentile":      {NewRemoveAbovePercentile, true},
+		"removeAboveValue":           {NewRemoveAboveValue, true},
+		"removeBelowPercentile":      {NewRemoveBelowPercentile, true},
+		"removeBelowValue":           {NewRemoveBelowValue, true},
+		"removeEmptySeries":          {NewRemoveEmptySeries, true},
+		"removeTag":                  {NewRemoveTag, true},
+		"removeZeroSeries":           {NewRemoveZeroSeries, true},
+		"scale":                      {NewScale, true},
+		"scaleToSeconds":             {NewScaleToSeconds, true},
+		"setTag":                     {NewSetTag, true},
+		"sinFunction":                {NewSinFunction, true},
+		"smartSummarize":             {NewSmartSummarize, true},
+		"sortBy":                     {NewSortByConstructor("", false), true},
+		"sortByMaxima":               {NewSortByConstructor("max", true), true},
+		"sortByMinima":               {NewSortByConstructor("min", false), true},
+		"sortByName":                 {NewSortByName, true},
+		"sortByTotal":                {NewSortByConstructor("sum", true), true},
+		"squareRoot":                 {NewSquareRoot, true},
+		"stdev":                      {NewStdev, true},
+		"stddevSeries":               {NewAggregateConstructor("stddev", crossSeriesStddev), true},
+		"sum":                        {NewAggregateConstructor("sum", crossSeriesSum), true},
+		"sumSeries":                  {NewAggregateConstructor("sum", crossSeriesSum), true},
+		"summarize":                  {NewSummarize, true},
+		"threshold":                  {NewThreshold, true},
+		"timeShift":                  {NewTimeShift, true},
+		"timeSlice":                  {NewTimeSlice, true},
+		"timeStack":                  {NewTimeStack, true},
+		"transformNull":              {NewTransformNull, true},
 	}
 }
 
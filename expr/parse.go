@@ -21,6 +21,8 @@ var (
 	ErrMissingQuote        = errors.New("missing quote")
 	ErrUnexpectedCharacter = errors.New("unexpected character")
 	ErrIllegalCharacter    = errors.New("illegal character for function name")
+	ErrMissingTagExpr      = errors.New("seriesByTag requires at least one tag expression")
+	ErrInvalidRange        = errors.New("the time range of the data to fetch is empty")
 )
 
 type ErrBadArgument struct {
@@ -206,8 +208,8 @@ func parseArgList(e string) (string, []*expr, map[string]*expr, string, error) {
 				return "", nil, nil, "", ErrMissingComma
 			}
 
-			if argCont.etype != etInt && argCont.etype != etFloat && argCont.etype != etName && argCont.etype != etString && argCont.etype != etBool {
-				return "", nil, nil, eCont, ErrBadArgumentStr{"int, float, name, bool or string", string(argCont.etype)}
+			if argCont.etype != etInt && argCont.etype != etFloat && argCont.etype != etName && argCont.etype != etString && argCont.etype != etBool && argCont.etype != etFunc {
+				return "", nil, nil, eCont, ErrBadArgumentStr{"int, float, name, bool, string or func", string(argCont.etype)}
 			}
 
 			if namedArgs == nil {
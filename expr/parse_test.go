@@ -197,6 +197,26 @@ func TestParse(t *testing.T) {
 			},
 			nil,
 		},
+		{
+			"func(metric, key=sum(other))",
+			&expr{
+				str:   "func",
+				etype: etFunc,
+				args: []*expr{
+					{str: "metric"},
+				},
+				namedArgs: map[string]*expr{
+					"key": {
+						str:     "sum",
+						etype:   etFunc,
+						args:    []*expr{{str: "other"}},
+						argsStr: "other",
+					},
+				},
+				argsStr: "metric, key=sum(other)",
+			},
+			nil,
+		},
 		{
 			"func(metric, False)",
 			&expr{
@@ -0,0 +1,53 @@
+package expr
+
+import (
+	"math"
+	"sort"
+
+	"gopkg.in/raintank/schema.v1"
+)
+
+// pointsPercentile returns the n-th percentile of the non-null values of the given points,
+// or NaN if there are none.
+func pointsPercentile(points []schema.Point, n float64, interpolate bool) float64 {
+	vals := make([]float64, 0, len(points))
+	for _, p := range points {
+		if !math.IsNaN(p.Val) {
+			vals = append(vals, p.Val)
+		}
+	}
+	sort.Float64s(vals)
+	return percentile(vals, n, interpolate)
+}
+
+// percentile returns the n-th percentile of the given sorted values, or NaN if there are none.
+// this is a port of graphite's _getPercentile:
+// without interpolation the nearest rank is used, with interpolation
+// we interpolate linearly between the value at the rank and the next one.
+func percentile(sorted []float64, n float64, interpolate bool) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	fractionalRank := (n / 100.0) * float64(len(sorted)+1)
+	rank := int(fractionalRank)
+	rankFraction := fractionalRank - float64(rank)
+
+	if !interpolate {
+		rank += int(math.Ceil(rankFraction))
+	}
+
+	var val float64
+	if rank == 0 {
+		val = sorted[0]
+	} else if rank-1 >= len(sorted) {
+		val = sorted[len(sorted)-1]
+	} else {
+		val = sorted[rank-1] // adjust for 0-index
+	}
+
+	if interpolate && rank < len(sorted) {
+		// a next value exists
+		val = val + rankFraction*(sorted[rank]-val)
+	}
+	return val
+}
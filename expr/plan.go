@@ -1,9 +1,11 @@
 package expr
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/grafana/metrictank/api/models"
 	"github.com/grafana/metrictank/consolidation"
@@ -39,6 +41,7 @@ type Plan struct {
 	// new data generated by processing funcs. useful for two reasons:
 	// 1) reuse partial calculations e.g. queries like target=movingAvg(sum(foo), 10)&target=sum(foo) (TODO)
 	// 2) central place to return data back to pool when we're done.
+	dynamic *[]dynamicPlan // functions that still need to plan their requests at runtime. see DynamicReqs()
 }
 
 func (p Plan) Dump(w io.Writer) {
@@ -56,6 +59,19 @@ func (p Plan) Dump(w io.Writer) {
 	fmt.Fprintf(w, "To: %d\n", p.To)
 }
 
+// Key returns the normalized expressions of the plan. plans with the same key, time range, MaxDataPoints and timezone
+// return the same output for the same input data, regardless of how their targets were written.
+func (p Plan) Key() string {
+	var buf bytes.Buffer
+	for i, e := range p.exprs {
+		if i > 0 {
+			buf.WriteByte('&')
+		}
+		e.normalize(&buf)
+	}
+	return buf.String()
+}
+
 // Plan validates the expressions and comes up with the initial (potentially non-optimal) execution plan
 // which is just a list of requests and the expressions.
 // traverse tree and as we go down:
@@ -63,14 +79,21 @@ func (p Plan) Dump(w io.Writer) {
 // * validation of arguments
 // * allow functions to modify the Context (change data range or consolidation)
 // * future version: allow functions to mark safe to pre-aggregate using consolidateBy or not
-func NewPlan(exprs []*expr, from, to, mdp uint32, stable bool, reqs []Req) (Plan, error) {
+// loc is the timezone of the request. nil means the local timezone.
+func NewPlan(exprs []*expr, from, to, mdp uint32, stable bool, loc *time.Location, reqs []Req) (Plan, error) {
 	var err error
+	if loc == nil {
+		loc = time.Local
+	}
 	var funcs []GraphiteFunc
+	dynamic := new([]dynamicPlan)
 	for _, e := range exprs {
 		var fn GraphiteFunc
 		context := Context{
-			from: from,
-			to:   to,
+			from:    from,
+			to:      to,
+			dynamic: dynamic,
+			loc:     loc,
 		}
 		fn, reqs, err = newplan(e, context, stable, reqs)
 		if err != nil {
@@ -85,9 +108,71 @@ func NewPlan(exprs []*expr, from, to, mdp uint32, stable bool, reqs []Req) (Plan
 		MaxDataPoints: mdp,
 		From:          from,
 		To:            to,
+		dynamic:       dynamic,
 	}, nil
 }
 
+// DynamicReqs returns the requests for data needed by functions that could only be planned once
+// the data of their inputs is known (e.g. applyByNode). input is all data fetched so far.
+// the caller should fetch the returned requests, add the data to the input, and call DynamicReqs again,
+// until no more requests are returned. after that, the plan can be Run.
+// dynamic functions are planned one by one, in the order they depend on each other (inputs first).
+// as soon as one of them needs data that is not in the input yet, its requests are returned, because
+// the next ones may depend on that data. this means independent dynamic functions may need separate rounds.
+func (p Plan) DynamicReqs(input map[Req][]models.Series) ([]Req, error) {
+	if p.dynamic == nil {
+		return nil, nil
+	}
+	for len(*p.dynamic) != 0 {
+		d := (*p.dynamic)[0]
+		rest := (*p.dynamic)[1:]
+
+		// planning may register new dynamic functions (e.g. nested applyByNode calls).
+		// they are used by d, and are planned before the remaining ones, which may depend on d.
+		*p.dynamic = nil
+		var dynReqs []Req
+		plan := func(target string) (GraphiteFunc, error) {
+			e, leftover, err := Parse(target)
+			if err != nil {
+				return nil, err
+			}
+			if leftover != "" {
+				return nil, fmt.Errorf("failed to parse %q fully. got leftover %q", target, leftover)
+			}
+			var fn GraphiteFunc
+			fn, dynReqs, err = newplan(e, d.context, d.stable, dynReqs)
+			return fn, err
+		}
+		var err error
+		if d.bootstrap != nil {
+			dynReqs, err = d.planBootstrap(input)
+		} else {
+			err = d.fn.PlanDynamic(input, plan)
+		}
+		if err != nil {
+			return nil, err
+		}
+		*p.dynamic = append(*p.dynamic, rest...)
+
+		var reqs []Req
+		seen := make(map[Req]struct{})
+		for _, r := range dynReqs {
+			if _, ok := input[r]; ok {
+				continue
+			}
+			if _, ok := seen[r]; ok {
+				continue
+			}
+			seen[r] = struct{}{}
+			reqs = append(reqs, r)
+		}
+		if len(reqs) != 0 {
+			return reqs, nil
+		}
+	}
+	return nil, nil
+}
+
 // newplan adds requests as needed for the given expr, resolving function calls as needed
 func newplan(e *expr, context Context, stable bool, reqs []Req) (GraphiteFunc, []Req, error) {
 	if e.etype != etFunc && e.etype != etName {
@@ -101,8 +186,13 @@ func newplan(e *expr, context Context, stable bool, reqs []Req) (GraphiteFunc, [
 		// `seriesByTag` function requires resolving expressions to series
 		// (similar to path expressions handled above). Since we need the
 		// arguments of seriesByTag to do the resolution, we store the function
-		// string back into the Query member of a new request to be parsed later.
+		// string back into the Query member of a new request to be parsed later
+		// (see ParseSeriesByTag). we validate the arguments now, so that invalid
+		// queries are rejected before anything gets looked up.
 		// TODO - find a way to prevent this parse/encode/parse/encode loop
+		if _, err := seriesByTagExpressions(e); err != nil {
+			return nil, nil, err
+		}
 		expressionStr := "seriesByTag(" + e.argsStr + ")"
 		req := NewReq(expressionStr, context.from, context.to, context.consol)
 		reqs = append(reqs, req)
@@ -137,6 +227,9 @@ func newplanFunc(e *expr, fn GraphiteFunc, context Context, stable bool, reqs []
 	// * we can't do extensive, accurate validation of the type here because what the output from a function we depend on
 	//   might be dynamically typed. e.g. movingAvg returns 1..N series depending on how many it got as input
 
+	// series args can only be set up once we know the context, so we track where they were given
+	var seriesArgs []seriesArg
+
 	// first validate the mandatory args
 	pos := 0    // pos in args of next given arg to process
 	cutoff := 0 // marks the index of the first optional point (if any)
@@ -148,6 +241,9 @@ func newplanFunc(e *expr, fn GraphiteFunc, context Context, stable bool, reqs []
 		if len(e.args) <= pos {
 			return nil, ErrMissingArg
 		}
+		if arg, ok := asSeriesArg(e.args[pos], argExp); ok {
+			seriesArgs = append(seriesArgs, seriesArg{pos: pos, arg: arg})
+		}
 		pos, err = e.consumeBasicArg(pos, argExp)
 		if err != nil {
 			return nil, err
@@ -167,6 +263,9 @@ func newplanFunc(e *expr, fn GraphiteFunc, context Context, stable bool, reqs []
 		if len(e.args) <= pos {
 			break // no more args specified. we're done.
 		}
+		if arg, ok := asSeriesArg(e.args[pos], argOpt); ok {
+			seriesArgs = append(seriesArgs, seriesArg{pos: pos, arg: arg})
+		}
 		pos, err = e.consumeBasicArg(pos, argOpt)
 		if err != nil {
 			return nil, err
@@ -184,37 +283,106 @@ func newplanFunc(e *expr, fn GraphiteFunc, context Context, stable bool, reqs []
 		if ok {
 			return nil, ErrKwargSpecifiedTwice{key}
 		}
-		err = e.consumeKwarg(key, argsExp[cutoff:])
+		arg, err := e.consumeKwarg(key, argsExp[cutoff:])
 		if err != nil {
 			return nil, err
 		}
+		if arg != nil {
+			seriesArgs = append(seriesArgs, seriesArg{key: key, arg: arg})
+		}
 		seenKwargs[key] = struct{}{}
 	}
 
 	// functions now have their non-series input args set,
 	// so they should now be able to specify any context alterations
-	context = fn.Context(context)
-	// now that we know the needed context for the data coming into
-	// this function, we can set up the input arguments for the function
-	// that are series
-	pos = 0
-	for _, argExp = range argsExp[:cutoff] {
-		switch argExp.(type) {
-		case ArgSeries, ArgSeriesList, ArgSeriesLists:
-			pos, reqs, err = e.consumeSeriesArg(pos, argExp, context, stable, reqs)
+	mcFn, multi := fn.(multiContextFunc)
+	if !multi {
+		context = fn.Context(context)
+		if rangeFn, ok := fn.(rangeFunc); ok {
+			context.from, context.to, err = rangeFn.DataRange(context.from, context.to)
 			if err != nil {
 				return nil, err
 			}
-		default:
-			return reqs, err
+			if context.from >= context.to {
+				return nil, ErrInvalidRange
+			}
+		}
+		reqs, err = e.consumeSeriesArgs(seriesArgs, context, stable, reqs)
+		if err != nil {
+			return nil, err
+		}
+		// dynamic functions are registered after their inputs, so that any dynamic inputs get planned first
+		if dynFn, ok := fn.(dynamicFunc); ok {
+			if context.dynamic == nil {
+				return nil, fmt.Errorf("%s can not be used in this context", e.str)
+			}
+			*context.dynamic = append(*context.dynamic, dynamicPlan{fn: dynFn, context: context, stable: stable})
+		}
+		// without dynamic planning, a bootstrapFunc just doesn't get the extra data
+		if bsFn, ok := fn.(bootstrapFunc); ok && context.dynamic != nil {
+			*context.dynamic = append(*context.dynamic, dynamicPlan{
+				context:    context,
+				stable:     stable,
+				bootstrap:  bsFn,
+				e:          e,
+				seriesArgs: seriesArgs,
+			})
+		}
+		return reqs, nil
+	}
+	// the function needs its series inputs for several contexts,
+	// so we set them up once for each of them.
+	for _, context := range mcFn.Contexts(context) {
+		reqs, err = e.consumeSeriesArgs(seriesArgs, context, stable, reqs)
+		if err != nil {
+			return nil, err
 		}
+		mcFn.InputsDone()
 	}
-	return reqs, err
+	return reqs, nil
 }
 
-// Run invokes all processing as specified in the plan (expressions, from/to) with the input as input
+// planBootstrap sets up the series inputs of a bootstrapFunc again, for the range extended by the data it needs before from,
+// and returns the requests for them
+func (d dynamicPlan) planBootstrap(input map[Req][]models.Series) ([]Req, error) {
+	shift, err := d.bootstrap.Bootstrap(input)
+	if err != nil || shift == 0 {
+		return nil, err
+	}
+	context := d.context
+	if shift >= context.from {
+		context.from = 0
+	} else {
+		context.from -= shift
+	}
+	return d.e.consumeSeriesArgs(d.seriesArgs, context, d.stable, nil)
+}
+
+// consumeSeriesArgs sets up the input arguments for the function that are series,
+// now that we know the needed context for the data coming into the function.
+func (e expr) consumeSeriesArgs(seriesArgs []seriesArg, context Context, stable bool, reqs []Req) ([]Req, error) {
+	var err error
+	for _, sa := range seriesArgs {
+		if sa.key != "" {
+			kwarg := expr{args: []*expr{e.namedArgs[sa.key]}}
+			_, reqs, err = kwarg.consumeSeriesArg(0, sa.arg, context, stable, reqs)
+		} else {
+			_, reqs, err = e.consumeSeriesArg(sa.pos, sa.arg, context, stable, reqs)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return reqs, nil
+}
+
+// Run invokes all processing as specified in the plan (expressions, from/to) with the input as input.
+// the input may be nil, e.g. if the plan has no requests because all of its expressions generate their own series.
 func (p Plan) Run(input map[Req][]models.Series) ([]models.Series, error) {
 	var out []models.Series
+	if input == nil {
+		input = make(map[Req][]models.Series)
+	}
 	p.data = input
 	for _, fn := range p.funcs {
 		series, err := fn.Exec(p.data)
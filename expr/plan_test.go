@@ -8,7 +8,7 @@ import (
 	"github.com/grafana/metrictank/consolidation"
 )
 
-// here we use smartSummarize because it has multiple optional arguments which allows us to test some interesting things
+// here we use summarize because it has multiple optional arguments which allows us to test some interesting things
 func TestArgs(t *testing.T) {
 
 	from := uint32(1000)
@@ -174,11 +174,11 @@ func TestArgs(t *testing.T) {
 		},
 	}
 
-	fn := NewSmartSummarize()
+	fn := NewSummarize()
 	for i, c := range cases {
 		e := &expr{
 			etype:     etFunc,
-			str:       "smartSummarize",
+			str:       "summarize",
 			args:      c.args,
 			namedArgs: c.namedArgs,
 		}
@@ -299,7 +299,7 @@ func TestConsolidateBy(t *testing.T) {
 	for i, c := range cases {
 		// for the purpose of this test, we assume ParseMany works fine.
 		exprs, _ := ParseMany([]string{c.in})
-		plan, err := NewPlan(exprs, from, to, 800, stable, nil)
+		plan, err := NewPlan(exprs, from, to, 800, stable, nil, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -469,7 +469,7 @@ func TestNamingChains(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		plan, err := NewPlan(exprs, from, to, 800, stable, nil)
+		plan, err := NewPlan(exprs, from, to, 800, stable, nil, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -567,6 +567,24 @@ func TestTargetErrors(t *testing.T) {
 			nil,
 			ErrTooManyArg,
 		},
+		{
+			"groupByNode - default callback",
+			`groupByNode(foo.*.bar, 1)`,
+			nil,
+			nil,
+		},
+		{
+			"groupByNodes - mixed nodes and tags",
+			`groupByNodes(foo.*.bar, "sum", 1, "dc", -1)`,
+			nil,
+			nil,
+		},
+		{
+			"groupByNodes - missing callback",
+			`groupByNodes(foo.*.bar, 1)`,
+			nil,
+			ErrBadArgumentStr{"string", "etInt"},
+		},
 	}
 
 	for _, c := range cases {
@@ -574,9 +592,84 @@ func TestTargetErrors(t *testing.T) {
 		if err != c.expectedParseError {
 			t.Fatalf("case %q: expected parse error %q but got %q", c.testDescription, c.expectedParseError, err)
 		}
-		_, err = NewPlan(exprs, from, to, 800, stable, nil)
+		_, err = NewPlan(exprs, from, to, 800, stable, nil, nil)
 		if err != c.expectedPlanError {
 			t.Fatalf("case %q: expected plan error %q but got %q", c.testDescription, c.expectedPlanError, err)
 		}
 	}
 }
+
+// runSynthetic plans and runs the given targets, which must not need any data to be fetched.
+func runSynthetic(targets []string, from, to uint32, stable bool, t *testing.T) []models.Series {
+	exprs, err := ParseMany(targets)
+	if err != nil {
+		t.Fatalf("targets %q: unexpected parse error %s", targets, err)
+	}
+	plan, err := NewPlan(exprs, from, to, 800, stable, nil, nil)
+	if err != nil {
+		t.Fatalf("targets %q: unexpected plan error %s", targets, err)
+	}
+	if len(plan.Reqs) != 0 {
+		t.Fatalf("targets %q: expected no requests, got %v", targets, plan.Reqs)
+	}
+	out, err := plan.Run(nil)
+	if err != nil {
+		t.Fatalf("targets %q: unexpected error %s", targets, err)
+	}
+	return out
+}
+
+func TestPlanSynthetic(t *testing.T) {
+	// synthetic series need no data, so only the other branches lead to requests
+	exprs, err := ParseMany([]string{`threshold(90, "slo")`, `sumSeries(a, constantLine(1))`, `timeShift(identity("x"), "1h")`})
+	if err != nil {
+		t.Fatalf("unexpected parse error %s", err)
+	}
+	plan, err := NewPlan(exprs, 3601, 7201, 800, true, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected plan error %s", err)
+	}
+	exp := []Req{NewReq("a", 3601, 7201, 0)}
+	if !reflect.DeepEqual(plan.Reqs, exp) {
+		t.Fatalf("expected reqs %v, got %v", exp, plan.Reqs)
+	}
+
+	out := runSynthetic([]string{`threshold(90, "slo")`, `constantLine(1)`, `timeShift(identity("x"), "1h")`}, 3601, 7201, true, t)
+	if len(out) != 3 {
+		t.Fatalf("expected 3 series, got %d", len(out))
+	}
+	if out[0].Target != "slo" || out[1].Target != "1" || out[2].Target != `timeShift(x, "-1h")` {
+		t.Fatalf("unexpected targets %q, %q and %q", out[0].Target, out[1].Target, out[2].Target)
+	}
+	// the identity series was generated for the hour before, and then shifted
+	if out[2].Datapoints[0].Ts != 3600 || out[2].Datapoints[0].Val != 0 {
+		t.Fatalf("expected the shifted identity to start at 3600 with value 0, got %v", out[2].Datapoints[0])
+	}
+}
+
+func TestPlanKey(t *testing.T) {
+	cases := []struct {
+		targets []string
+		exp     string
+	}{
+		{[]string{`a.b.*`}, `a.b.*`},
+		{[]string{`sum( a.b.* , c )`, `alias(d,'foo')`}, `sum(a.b.*,c)&alias(d,"foo")`},
+		{[]string{`summarize(a, "1h", alignToFrom=True, func='max')`}, `summarize(a,"1h",alignToFrom=true,func="max")`},
+		{[]string{`summarize(a, "1h", func="max", alignToFrom=true)`}, `summarize(a,"1h",alignToFrom=true,func="max")`},
+		{[]string{`scale(a, 1.50)`, `scale(a, 2)`}, `scale(a,1.5)&scale(a,2)`},
+		{[]string{`seriesByTag('name=a', "dc=x")`}, `seriesByTag("name=a","dc=x")`},
+	}
+	for _, c := range cases {
+		exprs, err := ParseMany(c.targets)
+		if err != nil {
+			t.Fatalf("case %q: unexpected parse error %s", c.targets, err)
+		}
+		plan, err := NewPlan(exprs, 10, 20, 800, true, nil, nil)
+		if err != nil {
+			t.Fatalf("case %q: unexpected plan error %s", c.targets, err)
+		}
+		if got := plan.Key(); got != c.exp {
+			t.Fatalf("case %q: expected key %q, got %q", c.targets, c.exp, got)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"sort"
 
 	"github.com/grafana/metrictank/api/models"
+	"github.com/grafana/metrictank/batch"
 	"gopkg.in/raintank/schema.v1"
 )
 
@@ -40,6 +41,36 @@ func getCrossSeriesAggFunc(c string) crossSeriesAggFunc {
 	return nil
 }
 
+// getSeriesAggFunc returns the function to reduce the points of a single series
+// to a single value, as used by functions that select or filter series based on such value.
+func getSeriesAggFunc(c string) batch.AggFunc {
+	switch c {
+	case "avg", "average":
+		return batch.Avg
+	case "count":
+		return batch.Cnt
+	case "current", "last":
+		return batch.Lst
+	case "diff":
+		return batch.Diff
+	case "max":
+		return batch.Max
+	case "median":
+		return batch.Med
+	case "min":
+		return batch.Min
+	case "multiply":
+		return batch.Mult
+	case "range", "rangeOf":
+		return batch.Range
+	case "stddev":
+		return batch.StdDev
+	case "sum", "total":
+		return batch.Sum
+	}
+	return nil
+}
+
 func crossSeriesAvg(in []models.Series, out *[]schema.Point) {
 	for i := 0; i < len(in[0].Datapoints); i++ {
 		num := 0
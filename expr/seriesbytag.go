@@ -0,0 +1,45 @@
+package expr
+
+import (
+	"strings"
+)
+
+const seriesByTagPrefix = "seriesByTag("
+
+// IsSeriesByTag returns whether the query of a Req is a seriesByTag call,
+// which must be resolved via the tag index rather than as a path pattern
+func IsSeriesByTag(query string) bool {
+	return strings.HasPrefix(query, seriesByTagPrefix)
+}
+
+// ParseSeriesByTag returns the tag expressions of the given seriesByTag query,
+// as found in the Query of a Req, such that they can be passed to the index's FindByTag.
+func ParseSeriesByTag(query string) ([]string, error) {
+	e, leftover, err := Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	if leftover != "" || e.etype != etFunc || e.str != "seriesByTag" {
+		return nil, ErrUnexpectedCharacter
+	}
+	return seriesByTagExpressions(e)
+}
+
+// seriesByTagExpressions returns the tag expressions given as arguments to the seriesByTag call e.
+// all arguments must be strings. their syntax is validated by the index.
+func seriesByTagExpressions(e *expr) ([]string, error) {
+	if len(e.namedArgs) > 0 {
+		return nil, ErrTooManyArg
+	}
+	if len(e.args) == 0 {
+		return nil, ErrMissingTagExpr
+	}
+	expressions := make([]string, 0, len(e.args))
+	for _, arg := range e.args {
+		if arg.etype != etString {
+			return nil, ErrBadArgumentStr{"string", arg.etype.String()}
+		}
+		expressions = append(expressions, arg.str)
+	}
+	return expressions, nil
+}
@@ -0,0 +1,76 @@
+package expr
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSeriesByTag(t *testing.T) {
+	cases := []struct {
+		query  string
+		exp    []string
+		expErr bool
+	}{
+		{`seriesByTag('name=cpu.idle')`, []string{"name=cpu.idle"}, false},
+		{`seriesByTag('name=~cpu.*', 'dc=us')`, []string{"name=~cpu.*", "dc=us"}, false},
+		{`seriesByTag("name=~cpu.*","dc!=eu")`, []string{"name=~cpu.*", "dc!=eu"}, false},
+		{`seriesByTag('name=~cpu.(idle|user)', 'host=~web{1,3}')`, []string{"name=~cpu.(idle|user)", "host=~web{1,3}"}, false},
+		{`seriesByTag('name=a,b')`, []string{"name=a,b"}, false},
+		{`seriesByTag()`, nil, true},
+		{`seriesByTag(1)`, nil, true},
+		{`seriesByTag('name=a', key='dc=us')`, nil, true},
+		{`sumSeries('name=a')`, nil, true},
+		{`seriesByTag('name=a'`, nil, true},
+	}
+	for _, c := range cases {
+		got, err := ParseSeriesByTag(c.query)
+		if (err != nil) != c.expErr {
+			t.Fatalf("case %q: expected error %t, got %v", c.query, c.expErr, err)
+		}
+		if !reflect.DeepEqual(got, c.exp) {
+			t.Fatalf("case %q: expected %v, got %v", c.query, c.exp, got)
+		}
+	}
+}
+
+func TestSeriesByTagPlan(t *testing.T) {
+	cases := []struct {
+		target string
+		exp    []Req
+		expErr error
+	}{
+		{
+			`sumSeries(seriesByTag('name=~cpu.*', 'dc=us'))`,
+			[]Req{NewReq(`seriesByTag('name=~cpu.*', 'dc=us')`, 1000, 2000, 0)},
+			nil,
+		},
+		{
+			`seriesByTag('name=cpu', key='dc=us')`,
+			nil,
+			ErrTooManyArg,
+		},
+		{
+			`seriesByTag('name=cpu', 1)`,
+			nil,
+			ErrBadArgumentStr{"string", "etInt"},
+		},
+	}
+	for _, c := range cases {
+		exprs, err := ParseMany([]string{c.target})
+		if err != nil {
+			t.Fatalf("case %q: unexpected parse error %s", c.target, err)
+		}
+		plan, err := NewPlan(exprs, 1000, 2000, 800, true, nil, nil)
+		if err != c.expErr {
+			t.Fatalf("case %q: expected error %v, got %v", c.target, c.expErr, err)
+		}
+		if !reflect.DeepEqual(plan.Reqs, c.exp) {
+			t.Fatalf("case %q: expected reqs %v, got %v", c.target, c.exp, plan.Reqs)
+		}
+		for _, r := range plan.Reqs {
+			if !IsSeriesByTag(r.Query) {
+				t.Fatalf("case %q: expected %q to be recognized as seriesByTag query", c.target, r.Query)
+			}
+		}
+	}
+}
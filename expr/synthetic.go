@@ -0,0 +1,43 @@
+package expr
+
+import (
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+// syntheticRange returns the start and end of the series generated for the given requested range.
+// in accordance with graphite, generated series start at the start of the range (which graphite treats as exclusive)
+// and may include its end. those are one second before the (inclusive) from and (exclusive) to used by metrictank.
+func syntheticRange(from, to uint32) (uint32, uint32) {
+	if from == 0 {
+		return 0, to - 1
+	}
+	return from - 1, to - 1
+}
+
+// syntheticPoints returns the points for every step between start (inclusive) and end (exclusive),
+// with their values set by fn, which gets the timestamp of the point.
+func syntheticPoints(start, end, step uint32, fn func(ts uint32) float64) []schema.Point {
+	out := pointSlicePool.Get().([]schema.Point)
+	// a large step could make a uint32 wrap around
+	for ts := uint64(start); ts < uint64(end); ts += uint64(step) {
+		out = append(out, schema.Point{Val: fn(uint32(ts)), Ts: uint32(ts)})
+	}
+	return out
+}
+
+// syntheticOutput returns the series with the given name and points, which were not derived from any input series.
+// like in graphite, its only tag is the name tag. it is also added to the cache.
+func syntheticOutput(cache map[Req][]models.Series, name, queryPatt string, from, to, interval uint32, points []schema.Point) models.Series {
+	output := models.Series{
+		Target:     name,
+		QueryPatt:  queryPatt,
+		Tags:       map[string]string{"name": name},
+		Datapoints: points,
+		Interval:   interval,
+		QueryFrom:  from,
+		QueryTo:    to,
+	}
+	cache[Req{}] = append(cache[Req{}], output)
+	return output
+}
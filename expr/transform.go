@@ -0,0 +1,51 @@
+package expr
+
+import (
+	"math"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+// transformSeries returns the series that results from applying fn to every value of the given series,
+// with the given name and a copy of its tags to which the given tag is added. it is also added to the cache.
+func transformSeries(cache map[Req][]models.Series, serie models.Series, name, tagKey, tagVal string, fn func(float64) float64) models.Series {
+	out := pointSlicePool.Get().([]schema.Point)
+	for _, p := range serie.Datapoints {
+		out = append(out, schema.Point{Val: fn(p.Val), Ts: p.Ts})
+	}
+	return transformOutput(cache, serie, name, tagKey, tagVal, out)
+}
+
+// transformOutput returns the series with the given name and points, derived from the given series.
+// a copy of its tags is used, to which the given tag is added. it is also added to the cache.
+func transformOutput(cache map[Req][]models.Series, serie models.Series, name, tagKey, tagVal string, points []schema.Point) models.Series {
+	tags := make(map[string]string, len(serie.Tags)+1)
+	for k, v := range serie.Tags {
+		tags[k] = v
+	}
+	tags[tagKey] = tagVal
+	output := models.Series{
+		Target:       name,
+		QueryPatt:    name,
+		Tags:         tags,
+		Datapoints:   points,
+		Interval:     serie.Interval,
+		QueryFrom:    serie.QueryFrom,
+		QueryTo:      serie.QueryTo,
+		Consolidator: serie.Consolidator,
+		QueryCons:    serie.QueryCons,
+	}
+	cache[Req{}] = append(cache[Req{}], output)
+	return output
+}
+
+// safePow returns val to the power of factor, or null if the result is not a real number,
+// such as for the square root of a negative number or the inverse of 0.
+func safePow(val, factor float64) float64 {
+	res := math.Pow(val, factor)
+	if math.IsInf(res, 0) {
+		return math.NaN()
+	}
+	return res
+}
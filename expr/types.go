@@ -133,3 +133,16 @@ type ArgStringsOrInts struct {
 
 func (a ArgStringsOrInts) Key() string    { return a.key }
 func (a ArgStringsOrInts) Optional() bool { return a.opt }
+
+// ArgIn is an argument that can be of one of several types
+// e.g. asPercent's total can be a number or a seriesList.
+// the type of the given value determines which of args gets set.
+// in accordance with graphite, None leaves the argument unset.
+type ArgIn struct {
+	key  string
+	opt  bool
+	args []Arg
+}
+
+func (a ArgIn) Key() string    { return a.key }
+func (a ArgIn) Optional() bool { return a.opt }
@@ -0,0 +1,202 @@
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/alyu/configparser"
+)
+
+// UserFunc is a function defined by the operator, rather than built into metrictank.
+// it computes its output by evaluating an expression for every point of the series of its first parameter,
+// see compileUserExpr.
+type UserFunc struct {
+	Name        string
+	Description string
+	Params      []UserFuncParam
+	Expression  string
+	expr        userExpr
+}
+
+// UserFuncParam is a parameter of a user-defined function
+type UserFuncParam struct {
+	Name    string
+	Type    string   // either "seriesList" or "float"
+	Default *float64 // if set, the parameter is optional. only for floats
+}
+
+var userFuncNameRe = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+// userFuncs holds the registered user-defined functions, by name
+var userFuncs = make(map[string]*UserFunc)
+
+// ReadUserFuncs reads the user-defined functions from the given file. each section defines one function:
+//
+//	[percentOfCapacity]
+//	params = used:seriesList, capacity:seriesList, factor:float=100
+//	expression = used / capacity * factor
+//	description = the used fraction of the capacity, in percent
+//
+// the first parameter must be a seriesList, and the function returns one series for each of its series.
+// any other seriesList parameter must reference either one series, or as many as the first one.
+// parameters with a default value are optional, they must come after the mandatory ones.
+func ReadUserFuncs(file string) ([]*UserFunc, error) {
+	config, err := configparser.Read(file)
+	if err != nil {
+		return nil, err
+	}
+	sections, err := config.AllSections()
+	if err != nil {
+		return nil, err
+	}
+
+	var fns []*UserFunc
+	for _, sec := range sections {
+		name := strings.Trim(strings.SplitN(sec.String(), "\n", 2)[0], " []")
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+		fn, err := NewUserFunc(name, sec.ValueOf("params"), sec.ValueOf("expression"), sec.ValueOf("description"))
+		if err != nil {
+			return nil, fmt.Errorf("[%s]: %s", name, err)
+		}
+		fns = append(fns, fn)
+	}
+	return fns, nil
+}
+
+// NewUserFunc validates and compiles a user-defined function.
+// params is a comma separated list of parameters, formatted as name:type or name:float=default
+func NewUserFunc(name, params, expression, description string) (*UserFunc, error) {
+	if !userFuncNameRe.MatchString(name) {
+		return nil, fmt.Errorf("invalid function name %q", name)
+	}
+	fn := &UserFunc{
+		Name:        name,
+		Description: description,
+		Expression:  expression,
+	}
+	var names []string
+	seen := make(map[string]struct{})
+	for _, str := range strings.Split(params, ",") {
+		param, err := parseUserFuncParam(strings.TrimSpace(str))
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[param.Name]; ok {
+			return nil, fmt.Errorf("parameter %q specified twice", param.Name)
+		}
+		if len(fn.Params) == 0 && param.Type != "seriesList" {
+			return nil, fmt.Errorf("the first parameter must be a seriesList")
+		}
+		if param.Default == nil && len(fn.Params) > 0 && fn.Params[len(fn.Params)-1].Default != nil {
+			return nil, fmt.Errorf("mandatory parameter %q can't come after optional parameters", param.Name)
+		}
+		seen[param.Name] = struct{}{}
+		names = append(names, param.Name)
+		fn.Params = append(fn.Params, param)
+	}
+	var err error
+	fn.expr, err = compileUserExpr(expression, names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile expression %q: %s", expression, err)
+	}
+	return fn, nil
+}
+
+func parseUserFuncParam(str string) (UserFuncParam, error) {
+	parts := strings.SplitN(str, ":", 2)
+	if len(parts) != 2 {
+		return UserFuncParam{}, fmt.Errorf("parameter %q must be formatted as name:type", str)
+	}
+	param := UserFuncParam{
+		Name: strings.TrimSpace(parts[0]),
+		Type: strings.TrimSpace(parts[1]),
+	}
+	if !userFuncNameRe.MatchString(param.Name) {
+		return UserFuncParam{}, fmt.Errorf("invalid parameter name %q", param.Name)
+	}
+	if strings.HasPrefix(param.Type, "float") {
+		typ := strings.SplitN(param.Type, "=", 2)
+		param.Type = strings.TrimSpace(typ[0])
+		if len(typ) == 2 {
+			def, err := strconv.ParseFloat(strings.TrimSpace(typ[1]), 64)
+			if err != nil {
+				return UserFuncParam{}, fmt.Errorf("invalid default value for parameter %q: %s", param.Name, err)
+			}
+			param.Default = &def
+		}
+	}
+	if param.Type != "seriesList" && param.Type != "float" {
+		return UserFuncParam{}, fmt.Errorf("parameter %q has unsupported type %q. must be seriesList or float", param.Name, param.Type)
+	}
+	return param, nil
+}
+
+// RegisterUserFuncs makes the given user-defined functions available to queries.
+// they can't replace built-in functions, nor each other.
+// it should be called at startup, before any queries are planned.
+func RegisterUserFuncs(fns []*UserFunc) error {
+	for _, fn := range fns {
+		if _, ok := funcs[fn.Name]; ok {
+			return fmt.Errorf("function %q is already defined", fn.Name)
+		}
+	}
+	for _, fn := range fns {
+		userFuncs[fn.Name] = fn
+		funcs[fn.Name] = funcDef{NewUserFuncConstructor(fn), true}
+	}
+	return nil
+}
+
+// FuncDescription describes a function, like graphite's /functions endpoint does
+type FuncDescription struct {
+	Name        string                 `json:"name"`
+	Function    string                 `json:"function"`
+	Description string                 `json:"description"`
+	Module      string                 `json:"module"`
+	Group       string                 `json:"group"`
+	Params      []FuncParamDescription `json:"params"`
+}
+
+type FuncParamDescription struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Required bool     `json:"required"`
+	Default  *float64 `json:"default,omitempty"`
+}
+
+// UserFuncGroup is the group under which user-defined functions are listed in their descriptions
+const UserFuncGroup = "User"
+
+// UserFuncDescriptions returns the descriptions of all registered user-defined functions, by name
+func UserFuncDescriptions() map[string]FuncDescription {
+	descs := make(map[string]FuncDescription, len(userFuncs))
+	for name, fn := range userFuncs {
+		desc := FuncDescription{
+			Name:        name,
+			Description: fn.Description,
+			Module:      "metrictank.userfuncs",
+			Group:       UserFuncGroup,
+		}
+		var sig []string
+		for _, p := range fn.Params {
+			desc.Params = append(desc.Params, FuncParamDescription{
+				Name:     p.Name,
+				Type:     p.Type,
+				Required: p.Default == nil,
+				Default:  p.Default,
+			})
+			if p.Default == nil {
+				sig = append(sig, p.Name)
+			} else {
+				sig = append(sig, fmt.Sprintf("%s=%g", p.Name, *p.Default))
+			}
+		}
+		desc.Function = fmt.Sprintf("%s(%s)", name, strings.Join(sig, ", "))
+		descs[name] = desc
+	}
+	return descs
+}
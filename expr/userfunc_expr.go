@@ -0,0 +1,291 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"unicode"
+)
+
+// userExpr is a compiled expression of a user-defined function.
+// it computes one output value out of the values of the parameters, which are
+// the values of the points at the same position for the series parameters, and the given numbers for the others.
+type userExpr interface {
+	eval(params []float64) float64
+}
+
+type userConst float64
+
+func (e userConst) eval(params []float64) float64 {
+	return float64(e)
+}
+
+// userParam refers to the parameter at the given position
+type userParam int
+
+func (e userParam) eval(params []float64) float64 {
+	return params[e]
+}
+
+type userNeg struct {
+	in userExpr
+}
+
+func (e userNeg) eval(params []float64) float64 {
+	return -e.in.eval(params)
+}
+
+type userBinary struct {
+	op          byte
+	left, right userExpr
+}
+
+// eval applies the operator. like in divideSeries, dividing by zero results in null.
+func (e userBinary) eval(params []float64) float64 {
+	l, r := e.left.eval(params), e.right.eval(params)
+	switch e.op {
+	case '+':
+		return l + r
+	case '-':
+		return l - r
+	case '*':
+		return l * r
+	case '/':
+		if r == 0 {
+			return math.NaN()
+		}
+		return l / r
+	case '%':
+		if r == 0 {
+			return math.NaN()
+		}
+		return math.Mod(l, r)
+	}
+	// '^'
+	return safePow(l, r)
+}
+
+type userCall struct {
+	fn   userMathFunc
+	args []userExpr
+}
+
+func (e userCall) eval(params []float64) float64 {
+	vals := make([]float64, len(e.args))
+	for i, arg := range e.args {
+		vals[i] = arg.eval(params)
+	}
+	return e.fn.fn(vals)
+}
+
+type userMathFunc struct {
+	args int // number of arguments, or -1 for one or more
+	fn   func(vals []float64) float64
+}
+
+// userMathFuncs are the functions that can be called from the expression of a user-defined function.
+// min and max ignore nulls, the others return null if any of their arguments is null.
+var userMathFuncs = map[string]userMathFunc{
+	"abs":   {1, func(v []float64) float64 { return math.Abs(v[0]) }},
+	"ceil":  {1, func(v []float64) float64 { return math.Ceil(v[0]) }},
+	"floor": {1, func(v []float64) float64 { return math.Floor(v[0]) }},
+	"log": {2, func(v []float64) float64 {
+		if v[0] <= 0 || v[1] <= 0 || v[1] == 1 {
+			return math.NaN()
+		}
+		return math.Log(v[0]) / math.Log(v[1])
+	}},
+	"max": {-1, func(v []float64) float64 {
+		res := math.NaN()
+		for _, val := range v {
+			if !math.IsNaN(val) && (math.IsNaN(res) || val > res) {
+				res = val
+			}
+		}
+		return res
+	}},
+	"min": {-1, func(v []float64) float64 {
+		res := math.NaN()
+		for _, val := range v {
+			if !math.IsNaN(val) && (math.IsNaN(res) || val < res) {
+				res = val
+			}
+		}
+		return res
+	}},
+	"sqrt": {1, func(v []float64) float64 { return safePow(v[0], 0.5) }},
+}
+
+// compileUserExpr compiles the expression of a user-defined function.
+// the expression is made up of numbers, references to the given parameters, the operators + - * / % and ^ (power),
+// parentheses and calls to the functions in userMathFuncs. e.g. "(a - b) / max(a, b) * 100"
+func compileUserExpr(str string, params []string) (userExpr, error) {
+	p := userExprParser{str: str, params: params}
+	e, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos != len(p.str) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.str[p.pos:], p.pos)
+	}
+	return e, nil
+}
+
+// userExprParser is a recursive descent parser for user expressions.
+// from lowest to highest precedence, it parses sums, products, negations, powers and operands.
+type userExprParser struct {
+	str    string
+	pos    int
+	params []string
+}
+
+func (p *userExprParser) skipSpace() {
+	for p.pos < len(p.str) && p.str[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// next returns the next non space character, or 0 if there are none left
+func (p *userExprParser) next() byte {
+	p.skipSpace()
+	if p.pos == len(p.str) {
+		return 0
+	}
+	return p.str[p.pos]
+}
+
+func (p *userExprParser) parseSum() (userExpr, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.next(); op == '+' || op == '-'; op = p.next() {
+		p.pos++
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = userBinary{op, left, right}
+	}
+	return left, nil
+}
+
+func (p *userExprParser) parseProduct() (userExpr, error) {
+	left, err := p.parseNeg()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.next(); op == '*' || op == '/' || op == '%'; op = p.next() {
+		p.pos++
+		right, err := p.parseNeg()
+		if err != nil {
+			return nil, err
+		}
+		left = userBinary{op, left, right}
+	}
+	return left, nil
+}
+
+func (p *userExprParser) parseNeg() (userExpr, error) {
+	if p.next() == '-' {
+		p.pos++
+		in, err := p.parseNeg()
+		if err != nil {
+			return nil, err
+		}
+		return userNeg{in}, nil
+	}
+	return p.parsePow()
+}
+
+// parsePow parses a power, which is right associative, and binds stronger than a negation on its left: -2^2 is -4.
+func (p *userExprParser) parsePow() (userExpr, error) {
+	base, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.next() != '^' {
+		return base, nil
+	}
+	p.pos++
+	exp, err := p.parseNeg()
+	if err != nil {
+		return nil, err
+	}
+	return userBinary{'^', base, exp}, nil
+}
+
+func (p *userExprParser) parseOperand() (userExpr, error) {
+	c := p.next()
+	switch {
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+	case c == '(':
+		p.pos++
+		e, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ')' {
+			return nil, fmt.Errorf("missing ')' at position %d", p.pos)
+		}
+		p.pos++
+		return e, nil
+	case c == '.' || (c >= '0' && c <= '9'):
+		start := p.pos
+		for p.pos < len(p.str) && (p.str[p.pos] == '.' || (p.str[p.pos] >= '0' && p.str[p.pos] <= '9')) {
+			p.pos++
+		}
+		val, err := strconv.ParseFloat(p.str[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", p.str[start:p.pos], start)
+		}
+		return userConst(val), nil
+	case c == '_' || unicode.IsLetter(rune(c)):
+		start := p.pos
+		for p.pos < len(p.str) && (p.str[p.pos] == '_' || unicode.IsLetter(rune(p.str[p.pos])) || unicode.IsDigit(rune(p.str[p.pos]))) {
+			p.pos++
+		}
+		name := p.str[start:p.pos]
+		if p.next() == '(' {
+			return p.parseCall(name, start)
+		}
+		for i, param := range p.params {
+			if param == name {
+				return userParam(i), nil
+			}
+		}
+		return nil, fmt.Errorf("unknown parameter %q at position %d", name, start)
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", c, p.pos)
+}
+
+func (p *userExprParser) parseCall(name string, start int) (userExpr, error) {
+	fn, ok := userMathFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at position %d", name, start)
+	}
+	p.pos++ // the opening parenthesis
+	var args []userExpr
+	for {
+		arg, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		c := p.next()
+		if c == ')' {
+			p.pos++
+			break
+		}
+		if c != ',' {
+			return nil, fmt.Errorf("expected ',' or ')' at position %d", p.pos)
+		}
+		p.pos++
+	}
+	if fn.args != -1 && len(args) != fn.args {
+		return nil, fmt.Errorf("function %q takes %d arguments, not %d", name, fn.args, len(args))
+	}
+	return userCall{fn, args}, nil
+}
@@ -0,0 +1,249 @@
+package expr
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/grafana/metrictank/api/models"
+	"gopkg.in/raintank/schema.v1"
+)
+
+func TestCompileUserExpr(t *testing.T) {
+	params := []float64{6, 3, math.NaN()}
+	cases := []struct {
+		expr string
+		exp  float64
+	}{
+		{"a", 6},
+		{"1.5", 1.5},
+		{"a + b * 2", 12},
+		{"(a + b) * 2", 18},
+		{"a - b - 1", 2},
+		{"a / b / 2", 1},
+		{"a / 0", math.NaN()},
+		{"a % 4", 2},
+		{"a % 0", math.NaN()},
+		{"-a + b", -3},
+		{"- -a", 6},
+		{"2 ^ 3 ^ 2", 512},
+		{"-b ^ 2", -9},
+		{"b ^ -1 * 3", 1},
+		{"(0-a) ^ 0.5", math.NaN()},
+		{"a * n", math.NaN()},
+		{"abs(b - a)", 3},
+		{"sqrt(a * 6)", 6},
+		{"floor(a / 4) + ceil(a / 4)", 3},
+		{"log(a * 16 / 6, 2)", 4},
+		{"log(0, 2)", math.NaN()},
+		{"max(a, n, b)", 6},
+		{"min(a, n, b)", 3},
+		{"max(n)", math.NaN()},
+		{" ( a+b )*( a-b ) ", 27},
+	}
+	for _, c := range cases {
+		e, err := compileUserExpr(c.expr, []string{"a", "b", "n"})
+		if err != nil {
+			t.Fatalf("case %q: unexpected error %s", c.expr, err)
+		}
+		got := e.eval(params)
+		if got != c.exp && !(math.IsNaN(got) && math.IsNaN(c.exp)) {
+			t.Fatalf("case %q: expected %f, got %f", c.expr, c.exp, got)
+		}
+	}
+}
+
+func TestCompileUserExprErrors(t *testing.T) {
+	cases := []string{
+		"",
+		"a +",
+		"(a + b",
+		"a + b)",
+		"a b",
+		"c",
+		"a ** 2",
+		"1.2.3",
+		"foo(a)",
+		"abs(a, b)",
+		"log(a)",
+		"max()",
+		"max(a b)",
+		"a $ b",
+	}
+	for _, c := range cases {
+		if _, err := compileUserExpr(c, []string{"a", "b"}); err == nil {
+			t.Fatalf("case %q: expected an error, got none", c)
+		}
+	}
+}
+
+func TestNewUserFuncErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		params string
+		expr   string
+	}{
+		{"bad-name", "a:seriesList", "a"},
+		{"f", "", "1"},
+		{"f", "a", "a"},
+		{"f", "a:float", "a"},
+		{"f", "a:seriesList, b:int", "a"},
+		{"f", "a:seriesList, a:float", "a"},
+		{"f", "a:seriesList, b:seriesList=1", "a"},
+		{"f", "a:seriesList, b:float=x", "a"},
+		{"f", "a:seriesList, b:float=1, c:seriesList", "a"},
+		{"f", "a:seriesList, 1b:float", "a"},
+		{"f", "a:seriesList", "b"},
+	}
+	for _, c := range cases {
+		if _, err := NewUserFunc(c.name, c.params, c.expr, ""); err == nil {
+			t.Fatalf("case %q %q %q: expected an error, got none", c.name, c.params, c.expr)
+		}
+	}
+}
+
+func TestReadUserFuncs(t *testing.T) {
+	file, err := ioutil.TempFile("", "userfuncs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(`# conversions
+[fahrenheitToCelsius]
+params = series:seriesList
+expression = (series - 32) * 5 / 9
+description = converts degrees fahrenheit to celsius
+
+[percentOfCapacity]
+params = used:seriesList, capacity:seriesList, factor:float=100
+expression = used / capacity * factor
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	fns, err := ReadUserFuncs(file.Name())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(fns) != 2 || fns[0].Name != "fahrenheitToCelsius" || fns[1].Name != "percentOfCapacity" {
+		t.Fatalf("expected fahrenheitToCelsius and percentOfCapacity, got %v", fns)
+	}
+	if fns[0].Description != "converts degrees fahrenheit to celsius" || len(fns[0].Params) != 1 {
+		t.Fatalf("unexpected definition of fahrenheitToCelsius: %v", fns[0])
+	}
+	factor := fns[1].Params[2]
+	if len(fns[1].Params) != 3 || factor.Name != "factor" || factor.Type != "float" || factor.Default == nil || *factor.Default != 100 {
+		t.Fatalf("unexpected parameters of percentOfCapacity: %v", fns[1].Params)
+	}
+}
+
+// registerUserFuncs registers the given functions, and returns a function to unregister them
+func registerUserFuncs(t *testing.T, fns ...*UserFunc) func() {
+	err := RegisterUserFuncs(fns)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	return func() {
+		for _, fn := range fns {
+			delete(funcs, fn.Name)
+			delete(userFuncs, fn.Name)
+		}
+	}
+}
+
+func TestRegisterUserFuncs(t *testing.T) {
+	builtin, _ := NewUserFunc("sum", "a:seriesList", "a", "")
+	if err := RegisterUserFuncs([]*UserFunc{builtin}); err == nil {
+		t.Fatal("expected an error for redefining sum, got none")
+	}
+	fn, _ := NewUserFunc("percentOf", "used:seriesList, capacity:seriesList, factor:float=100", "used / capacity * factor", "used, in percent")
+	defer registerUserFuncs(t, fn)()
+	if err := RegisterUserFuncs([]*UserFunc{fn}); err == nil {
+		t.Fatal("expected an error for registering percentOf twice, got none")
+	}
+
+	descs := UserFuncDescriptions()
+	desc := descs["percentOf"]
+	if len(descs) != 1 || desc.Function != "percentOf(used, capacity, factor=100)" || desc.Group != UserFuncGroup || desc.Description != "used, in percent" {
+		t.Fatalf("unexpected descriptions %v", descs)
+	}
+	if len(desc.Params) != 3 || !desc.Params[1].Required || desc.Params[2].Required || desc.Params[2].Type != "float" {
+		t.Fatalf("unexpected parameter descriptions %v", desc.Params)
+	}
+}
+
+func TestUserFunc(t *testing.T) {
+	fn, _ := NewUserFunc("percentOf", "used:seriesList, capacity:seriesList, factor:float=100", "used / capacity * factor", "")
+	defer registerUserFuncs(t, fn)()
+
+	used := []models.Series{
+		{Target: "used.a", QueryPatt: "used.*", Interval: 10, Datapoints: []schema.Point{{Val: 1, Ts: 10}, {Val: math.NaN(), Ts: 20}, {Val: 3, Ts: 30}}},
+		{Target: "used.b", QueryPatt: "used.*", Interval: 10, Datapoints: []schema.Point{{Val: 2, Ts: 10}, {Val: 4, Ts: 20}, {Val: 6, Ts: 30}}},
+	}
+	capacity := []models.Series{
+		{Target: "capacity.a", QueryPatt: "capacity.*", Interval: 10, Datapoints: []schema.Point{{Val: 4, Ts: 10}, {Val: 4, Ts: 20}, {Val: 0, Ts: 30}}},
+		{Target: "capacity.b", QueryPatt: "capacity.*", Interval: 10, Datapoints: []schema.Point{{Val: 8, Ts: 10}, {Val: 8, Ts: 20}}},
+	}
+	total := []models.Series{
+		{Target: "capacity", QueryPatt: "capacity", Interval: 10, Datapoints: []schema.Point{{Val: 10, Ts: 10}, {Val: 10, Ts: 20}, {Val: 10, Ts: 30}}},
+	}
+
+	cases := []struct {
+		target string
+		inputs map[string][]models.Series
+		exp    []models.Series
+	}{
+		{
+			`percentOf(used.*, capacity.*)`,
+			map[string][]models.Series{"used.*": used, "capacity.*": capacity},
+			[]models.Series{
+				{Target: "percentOf(used.a,capacity.a,100)", Datapoints: []schema.Point{{Val: 25, Ts: 10}, {Val: math.NaN(), Ts: 20}, {Val: math.NaN(), Ts: 30}}},
+				{Target: "percentOf(used.b,capacity.b,100)", Datapoints: []schema.Point{{Val: 25, Ts: 10}, {Val: 50, Ts: 20}, {Val: math.NaN(), Ts: 30}}},
+			},
+		},
+		{
+			`percentOf(used.*, capacity, factor=1)`,
+			map[string][]models.Series{"used.*": used, "capacity": total},
+			[]models.Series{
+				{Target: "percentOf(used.a,capacity,1)", Datapoints: []schema.Point{{Val: 0.1, Ts: 10}, {Val: math.NaN(), Ts: 20}, {Val: 0.3, Ts: 30}}},
+				{Target: "percentOf(used.b,capacity,1)", Datapoints: []schema.Point{{Val: 0.2, Ts: 10}, {Val: 0.4, Ts: 20}, {Val: 0.6, Ts: 30}}},
+			},
+		},
+	}
+	for _, c := range cases {
+		exprs, err := ParseMany([]string{c.target})
+		if err != nil {
+			t.Fatalf("case %q: unexpected parse error %s", c.target, err)
+		}
+		plan, err := NewPlan(exprs, 10, 40, 800, true, nil, nil)
+		if err != nil {
+			t.Fatalf("case %q: unexpected plan error %s", c.target, err)
+		}
+		input := make(map[Req][]models.Series)
+		for _, r := range plan.Reqs {
+			input[r] = c.inputs[r.Query]
+		}
+		got, err := plan.Run(input)
+		if err != nil {
+			t.Fatalf("case %q: unexpected error %s", c.target, err)
+		}
+		checkSeriesPoints(c.target, c.exp, got, t)
+		if got[0].Interval != 10 || got[0].Tags["percentOf"] != "used / capacity * factor" {
+			t.Fatalf("case %q: expected interval 10 and the percentOf tag, got %d and %v", c.target, got[0].Interval, got[0].Tags)
+		}
+	}
+
+	// the capacity must be a single series, or match the used series
+	exprs, _ := ParseMany([]string{`percentOf(used.*, capacity.*)`})
+	plan, err := NewPlan(exprs, 10, 40, 800, true, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected plan error %s", err)
+	}
+	_, err = plan.Run(map[Req][]models.Series{plan.Reqs[0]: used, plan.Reqs[1]: append(capacity, total...)})
+	if err == nil {
+		t.Fatal("expected an error for mismatched series lists, got none")
+	}
+}
@@ -2,13 +2,24 @@ package expr
 
 import (
 	"errors"
+	"math"
+	"strings"
+	"time"
 
 	"github.com/grafana/metrictank/consolidation"
 	"github.com/raintank/dur"
 )
 
 var ErrIntPositive = errors.New("integer must be positive")
+var ErrStepOutOfRange = errors.New("step must be between 1 and 4294967295")
 var ErrInvalidAggFunc = errors.New("Invalid aggregation func")
+var ErrInvalidOperator = errors.New("Invalid operator")
+var ErrPercentOutOfRange = errors.New("percentile must be between 0 and 100")
+var ErrXFilesFactorOutOfRange = errors.New("xFilesFactor must be between 0 and 1")
+var ErrInvalidUnitSystem = errors.New("Invalid unit system")
+var ErrInvalidTagKey = errors.New("tag key must not be empty or contain any of ;!^=~")
+var ErrInvalidTagValue = errors.New("tag value must not be empty or contain ;")
+var ErrNameTag = errors.New("the name tag can't be removed")
 
 // Validator is a function to validate an input
 type Validator func(e *expr) error
@@ -21,6 +32,14 @@ func IntPositive(e *expr) error {
 	return nil
 }
 
+// IntStep validates whether an int can be used as the interval of a series (greater than zero, and fits in a uint32)
+func IntStep(e *expr) error {
+	if e.int < 1 || e.int > math.MaxUint32 {
+		return ErrStepOutOfRange
+	}
+	return nil
+}
+
 func IsAggFunc(e *expr) error {
 	if getCrossSeriesAggFunc(e.str) == nil {
 		return ErrInvalidAggFunc
@@ -28,11 +47,110 @@ func IsAggFunc(e *expr) error {
 	return nil
 }
 
+// IsSeriesAggFunc validates whether a string is a function to reduce a series to a single value
+func IsSeriesAggFunc(e *expr) error {
+	if getSeriesAggFunc(e.str) == nil {
+		return ErrInvalidAggFunc
+	}
+	return nil
+}
+
+// IsLegendValueType validates whether a string is a function to reduce a series to a single value,
+// or a unit system to format that value with
+func IsLegendValueType(e *expr) error {
+	if getSeriesAggFunc(e.str) == nil && !isUnitSystem(e.str) {
+		return ErrInvalidAggFunc
+	}
+	return nil
+}
+
+// IsUnitSystem validates whether a string is a unit system (si or binary)
+func IsUnitSystem(e *expr) error {
+	if !isUnitSystem(e.str) {
+		return ErrInvalidUnitSystem
+	}
+	return nil
+}
+
+// IsTagKey validates whether a string is a valid tag key
+func IsTagKey(e *expr) error {
+	if len(e.str) == 0 || strings.ContainsAny(e.str, ";!^=~") {
+		return ErrInvalidTagKey
+	}
+	return nil
+}
+
+// IsTagValue validates whether a string is a valid tag value
+func IsTagValue(e *expr) error {
+	if len(e.str) == 0 || strings.Contains(e.str, ";") {
+		return ErrInvalidTagValue
+	}
+	return nil
+}
+
+// IsNotNameTag validates whether a string is a tag key other than name
+func IsNotNameTag(e *expr) error {
+	if e.str == "name" {
+		return ErrNameTag
+	}
+	return nil
+}
+
+// IsOperator validates whether a string is a comparison operator
+func IsOperator(e *expr) error {
+	if getOperatorFunc(e.str) == nil {
+		return ErrInvalidOperator
+	}
+	return nil
+}
+
+// IsPercent validates whether a number is a valid percentile (between 0 and 100)
+func IsPercent(e *expr) error {
+	val := e.float
+	if e.etype == etInt {
+		val = float64(e.int)
+	}
+	if val < 0 || val > 100 {
+		return ErrPercentOutOfRange
+	}
+	return nil
+}
+
+// IsXFilesFactor validates whether a number is a valid xFilesFactor (between 0 and 1)
+func IsXFilesFactor(e *expr) error {
+	val := e.float
+	if e.etype == etInt {
+		val = float64(e.int)
+	}
+	if val < 0 || val > 1 {
+		return ErrXFilesFactorOutOfRange
+	}
+	return nil
+}
+
 func IsConsolFunc(e *expr) error {
 	return consolidation.Validate(e.str)
 }
 
+// IsTimeShiftString validates whether a string is a time shift such as "1d", "-1d" or "+1h"
+func IsTimeShiftString(e *expr) error {
+	_, err := parseTimeShift(e.str)
+	return err
+}
+
+// IsDateTimeString validates whether a string is a graphite from/until specification such as "-1h" or "now"
+func IsDateTimeString(e *expr) error {
+	_, err := dur.ParseDateTime(e.str, time.Local, time.Now(), 0)
+	return err
+}
+
 func IsIntervalString(e *expr) error {
 	_, err := dur.ParseDuration(e.str)
 	return err
 }
+
+// IsAlignToString validates whether a string is a unit to align to, optionally preceded by a number, such as "1d", "90min" or "hours"
+func IsAlignToString(e *expr) error {
+	_, err := alignToUnit(e.str)
+	return err
+}
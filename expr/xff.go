@@ -0,0 +1,22 @@
+package expr
+
+import (
+	"math"
+
+	"gopkg.in/raintank/schema.v1"
+)
+
+// xFilesFactor returns whether the ratio of non-null points in the given points is at least xff.
+// in accordance with graphite, points without any non-null values never pass.
+func xFilesFactor(points []schema.Point, xff float64) bool {
+	var nonNull int
+	for _, p := range points {
+		if !math.IsNaN(p.Val) {
+			nonNull++
+		}
+	}
+	if nonNull == 0 {
+		return false
+	}
+	return float64(nonNull)/float64(len(points)) >= xff
+}
@@ -87,6 +87,10 @@ type MetricIndex interface {
 	// List returns all Archives for the passed OrgId and the public orgId
 	List(orgId uint32) []Archive
 
+	// ForEach calls fn for all Archives of all orgs.
+	// The index is locked while it does so, so fn must not call the index.
+	ForEach(fn func(Archive))
+
 	// Prune deletes all metrics that haven't been seen since the given timestamp.
 	// It returns all Archives deleted and any error encountered.
 	Prune(oldest time.Time) ([]Archive, error)
@@ -1043,6 +1043,15 @@ func (m *MemoryIdx) List(orgId uint32) []idx.Archive {
 	return defs
 }
 
+func (m *MemoryIdx) ForEach(fn func(idx.Archive)) {
+	m.RLock()
+	defer m.RUnlock()
+
+	for _, def := range m.defById {
+		fn(*def)
+	}
+}
+
 func (m *MemoryIdx) DeleteTagged(orgId uint32, paths []string) ([]idx.Archive, error) {
 	if !TagSupport {
 		log.Warn("memory-idx: received tag query, but tag support is disabled")
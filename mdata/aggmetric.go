@@ -43,6 +43,8 @@ type AggMetric struct {
 	lastSaveStart   uint32 // last chunk T0 that was added to the write Queue.
 	lastSaveFinish  uint32 // last chunk T0 successfully written to Cassandra.
 	lastWrite       uint32
+	schemaId        uint16 // only set for the raw series, to restore them from snapshots
+	aggId           uint16
 }
 
 // NewAggMetric creates a metric with given key, it retains the given number of chunks each chunkSpan seconds long
@@ -365,11 +367,7 @@ func (a *AggMetric) pushToCache(c *chunk.Chunk) {
 	go a.cachePusher.AddIfHot(
 		a.Key,
 		0,
-		*chunk.NewBareIterGen(
-			c.Bytes(),
-			c.T0,
-			a.ChunkSpan,
-		),
+		*c.IterGen(a.ChunkSpan),
 	)
 }
 
@@ -22,6 +22,9 @@ type AggMetrics struct {
 	chunkMaxStale  uint32
 	metricMaxStale uint32
 	gcInterval     time.Duration
+
+	snapshotShutdown chan struct{}
+	snapshotWg       sync.WaitGroup
 }
 
 func NewAggMetrics(store Store, cachePusher cache.CachePusher, dropFirstChunk bool, chunkMaxStale, metricMaxStale uint32, gcInterval time.Duration) *AggMetrics {
@@ -113,6 +116,7 @@ func (ms *AggMetrics) GetOrCreate(key schema.MKey, schemaId, aggId uint16) Metri
 		return m
 	}
 	m = NewAggMetric(ms.store, ms.cachePusher, k, schema.Retentions, schema.ReorderWindow, &agg, ms.dropFirstChunk)
+	m.schemaId, m.aggId = schemaId, aggId
 	ms.Metrics[key] = m
 	active := len(ms.Metrics)
 	ms.Unlock()
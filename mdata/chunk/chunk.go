@@ -7,8 +7,21 @@ import (
 	"github.com/grafana/metrictank/stats"
 )
 
-// metric tank.total_points is the number of points currently held in the in-memory ringbuffer
-var totalPoints = stats.NewGauge64("tank.total_points")
+// CompactFormats enables persisting finished chunks in a more compact format than tsz, if there is one for their data.
+// older versions of metrictank can't read those formats, so only enable it once all nodes can read them.
+var CompactFormats bool
+
+var (
+	// metric tank.total_points is the number of points currently held in the in-memory ringbuffer
+	totalPoints = stats.NewGauge64("tank.total_points")
+
+	// metric tank.chunk_format.tsz is the number of chunks that are persisted in the tsz format
+	chunkFormatTsz = stats.NewCounter32("tank.chunk_format.tsz")
+	// metric tank.chunk_format.delta_of_delta is the number of chunks that are persisted in the delta-of-delta format for integer values
+	chunkFormatDeltaOfDelta = stats.NewCounter32("tank.chunk_format.delta_of_delta")
+	// metric tank.chunk_format.run_length is the number of chunks that are persisted in the run-length format for (mostly) constant values
+	chunkFormatRunLength = stats.NewCounter32("tank.chunk_format.run_length")
+)
 
 // Chunk is a chunk of data. not concurrency safe.
 type Chunk struct {
@@ -50,3 +63,106 @@ func (c *Chunk) Finish() {
 	c.Closed = true
 	c.Series.Finish()
 }
+
+// choose returns the format and data of the smallest encoding of the chunk.
+// tsz is used unless another format is smaller, in which case the data is returned as well.
+func (c *Chunk) choose() (Format, []byte) {
+	if c.NumPoints == 0 {
+		return FormatStandardGoTsz, nil
+	}
+	ts := make([]uint32, 0, c.NumPoints)
+	vals := make([]float64, 0, c.NumPoints)
+	it := c.Series.Iter()
+	for it.Next() {
+		t, v := it.Values()
+		ts = append(ts, t)
+		vals = append(vals, v)
+	}
+	if it.Err() != nil {
+		return FormatStandardGoTsz, nil
+	}
+
+	format, size := FormatStandardGoTsz, len(c.Series.Bytes())
+	var encoded []byte
+	if b, ok := encodeDeltaOfDelta(c.T0, ts, vals); ok && len(b) < size {
+		format, size, encoded = FormatDeltaOfDeltaWithSpan, len(b), b
+	}
+	if b := encodeRunLength(c.T0, ts, vals); len(b) < size {
+		format, encoded = FormatRunLengthWithSpan, b
+	}
+	return format, encoded
+}
+
+// Encode returns the chunk as it is to be persisted: the format, the span code and the data.
+// with CompactFormats, finished chunks are encoded in the most compact format, otherwise as tsz.
+// as it is only called to persist the chunk, the format is chosen here rather than when the chunk is finished.
+func (c *Chunk) Encode(span uint32) []byte {
+	spanCode, ok := RevChunkSpans[span]
+	if !ok {
+		// it's probably better to panic than to persist the chunk with a wrong length
+		panic(fmt.Sprintf("Chunk span invalid: %d", span))
+	}
+	format, data := FormatStandardGoTsz, []byte(nil)
+	if CompactFormats && c.Closed {
+		format, data = c.choose()
+	}
+	switch format {
+	case FormatDeltaOfDeltaWithSpan:
+		chunkFormatDeltaOfDelta.Inc()
+	case FormatRunLengthWithSpan:
+		chunkFormatRunLength.Inc()
+	default:
+		chunkFormatTsz.Inc()
+		format, data = FormatStandardGoTszWithSpan, c.Series.Bytes()
+	}
+	buf := make([]byte, 2, 2+len(data))
+	buf[0] = byte(format)
+	buf[1] = byte(spanCode)
+	return append(buf, data...)
+}
+
+// IterGen returns an IterGen for the tsz data of the chunk
+func (c *Chunk) IterGen(span uint32) *IterGen {
+	return NewBareIterGen(c.Series.Bytes(), c.T0, span)
+}
+
+// Snapshot returns the points of the chunk as a finished tsz stream.
+// unlike Bytes, the data is complete for chunks that are still open.
+func (c *Chunk) Snapshot() []byte {
+	if c.Closed {
+		return c.Series.Bytes()
+	}
+	s := tsz.New(c.T0)
+	it := c.Series.Iter()
+	for it.Next() {
+		s.Push(it.Values())
+	}
+	s.Finish()
+	return s.Bytes()
+}
+
+// FromSnapshot recreates a chunk from the data returned by Snapshot
+func FromSnapshot(t0 uint32, data []byte, closed bool) (*Chunk, error) {
+	b := make([]byte, len(data))
+	copy(b, data)
+	it, err := tsz.NewIterator(b)
+	if err != nil {
+		return nil, err
+	}
+	c := New(t0)
+	for it.Next() {
+		err = c.Push(it.Values())
+		if err != nil {
+			c.Clear()
+			return nil, err
+		}
+	}
+	if it.Err() != nil {
+		c.Clear()
+		return nil, it.Err()
+	}
+	if closed {
+		c.Finish()
+	}
+	return c, nil
+}
@@ -0,0 +1,229 @@
+package chunk
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// besides tsz, which works well for any float series, chunks can be stored in formats that are
+// much more compact for the most common kinds of series: counters and other integer series, and
+// (mostly) constant series such as status gauges. the format is chosen per chunk, when it is finished.
+//
+// both formats start with the timestamps:
+//   t0 (uvarint), the number of points (uvarint), and the delta-of-delta sequence of the timestamps
+//   relative to t0, as runs of equal values: per run, the value (zigzag varint) and its length (uvarint).
+//   as most series have a regular interval, this typically takes just a few bytes.
+// FormatDeltaOfDeltaWithSpan then has the delta-of-delta sequence of the values, which must all be
+//   integers, as zigzag varints
+// FormatRunLengthWithSpan then has the values as runs of equal values:
+//   per run, the number of points (uvarint) and the value (float64 bits, little endian)
+//
+// the delta-of-delta sequence of n values consists of the first value relative to a given start,
+// the delta between the second and the first value, and for all next values, the difference
+// between their delta and the previous delta.
+
+// maxInt is the largest integer up to which all integers can be represented by a float64
+const maxInt = 1 << 53
+
+// isInt returns whether the value is an integer that survives a round trip via int64
+func isInt(v float64) bool {
+	return v == math.Trunc(v) && math.Abs(v) <= maxInt && !(v == 0 && math.Signbit(v))
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+// deltaOfDeltas returns the delta-of-delta sequence of the values
+func deltaOfDeltas(start int64, vals []int64) []int64 {
+	dods := make([]int64, len(vals))
+	prev, delta := start, int64(0)
+	for i, v := range vals {
+		d := v - prev
+		dods[i] = d - delta
+		prev = v
+		if i > 0 {
+			delta = d
+		}
+	}
+	return dods
+}
+
+// undoDeltaOfDeltas turns a delta-of-delta sequence back into the values, in place
+func undoDeltaOfDeltas(start int64, dods []int64) {
+	prev, delta := start, int64(0)
+	for i, dd := range dods {
+		d := delta + dd
+		dods[i] = prev + d
+		prev = dods[i]
+		if i > 0 {
+			delta = d
+		}
+	}
+}
+
+func appendTimestamps(b []byte, t0 uint32, ts []uint32) []byte {
+	b = appendUvarint(b, uint64(t0))
+	b = appendUvarint(b, uint64(len(ts)))
+	ints := make([]int64, len(ts))
+	for i, t := range ts {
+		ints[i] = int64(t)
+	}
+	dods := deltaOfDeltas(int64(t0), ints)
+	for i := 0; i < len(dods); {
+		run := 1
+		for i+run < len(dods) && dods[i+run] == dods[i] {
+			run++
+		}
+		b = appendVarint(b, dods[i])
+		b = appendUvarint(b, uint64(run))
+		i += run
+	}
+	return b
+}
+
+// readTimestamps reads the timestamps and returns the remaining data
+func readTimestamps(b []byte) (uint32, []uint32, []byte, error) {
+	t0, n := binary.Uvarint(b)
+	if n <= 0 || t0 > math.MaxUint32 {
+		return 0, nil, nil, errCorruptChunk
+	}
+	b = b[n:]
+	count, n := binary.Uvarint(b)
+	// timestamps are unique and within the span of the chunk. this protects us from huge allocations for corrupt data
+	if n <= 0 || count > uint64(ChunkSpans[len(ChunkSpans)-1]) {
+		return 0, nil, nil, errCorruptChunk
+	}
+	b = b[n:]
+	ints := make([]int64, count)
+	for i := 0; i < len(ints); {
+		dd, n := binary.Varint(b)
+		if n <= 0 {
+			return 0, nil, nil, errCorruptChunk
+		}
+		b = b[n:]
+		run, n := binary.Uvarint(b)
+		if n <= 0 || run == 0 || run > uint64(len(ints)-i) {
+			return 0, nil, nil, errCorruptChunk
+		}
+		b = b[n:]
+		for end := i + int(run); i < end; i++ {
+			ints[i] = dd
+		}
+	}
+	undoDeltaOfDeltas(int64(t0), ints)
+	ts := make([]uint32, count)
+	for i, t := range ints {
+		if t < 0 || t > math.MaxUint32 {
+			return 0, nil, nil, errCorruptChunk
+		}
+		ts[i] = uint32(t)
+	}
+	return uint32(t0), ts, b, nil
+}
+
+// encodeDeltaOfDelta returns the data of the points in FormatDeltaOfDeltaWithSpan,
+// or false if not all values are integers
+func encodeDeltaOfDelta(t0 uint32, ts []uint32, vals []float64) ([]byte, bool) {
+	ints := make([]int64, len(vals))
+	for i, v := range vals {
+		if !isInt(v) {
+			return nil, false
+		}
+		ints[i] = int64(v)
+	}
+	b := appendTimestamps(nil, t0, ts)
+	for _, dd := range deltaOfDeltas(0, ints) {
+		b = appendVarint(b, dd)
+	}
+	return b, true
+}
+
+// encodeRunLength returns the data of the points in FormatRunLengthWithSpan
+func encodeRunLength(t0 uint32, ts []uint32, vals []float64) []byte {
+	b := appendTimestamps(nil, t0, ts)
+	var buf [8]byte
+	for i := 0; i < len(vals); {
+		bits := math.Float64bits(vals[i])
+		run := 1
+		for i+run < len(vals) && math.Float64bits(vals[i+run]) == bits {
+			run++
+		}
+		b = appendUvarint(b, uint64(run))
+		binary.LittleEndian.PutUint64(buf[:], bits)
+		b = append(b, buf[:]...)
+		i += run
+	}
+	return b
+}
+
+// decode decodes the data of a chunk in FormatDeltaOfDeltaWithSpan or FormatRunLengthWithSpan
+func decode(format Format, b []byte) (uint32, []uint32, []float64, error) {
+	t0, ts, b, err := readTimestamps(b)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	vals := make([]float64, len(ts))
+	switch format {
+	case FormatDeltaOfDeltaWithSpan:
+		ints := make([]int64, len(ts))
+		for i := range ints {
+			dd, n := binary.Varint(b)
+			if n <= 0 {
+				return 0, nil, nil, errCorruptChunk
+			}
+			b = b[n:]
+			ints[i] = dd
+		}
+		undoDeltaOfDeltas(0, ints)
+		for i, v := range ints {
+			vals[i] = float64(v)
+		}
+	case FormatRunLengthWithSpan:
+		for i := 0; i < len(vals); {
+			run, n := binary.Uvarint(b)
+			if n <= 0 || run == 0 || run > uint64(len(vals)-i) || len(b) < n+8 {
+				return 0, nil, nil, errCorruptChunk
+			}
+			v := math.Float64frombits(binary.LittleEndian.Uint64(b[n:]))
+			b = b[n+8:]
+			for end := i + int(run); i < end; i++ {
+				vals[i] = v
+			}
+		}
+	default:
+		return 0, nil, nil, errUnknownChunkFormat
+	}
+	return t0, ts, vals, nil
+}
+
+// sliceIter iterates over decoded points
+type sliceIter struct {
+	ts   []uint32
+	vals []float64
+	pos  int
+}
+
+func (it *sliceIter) Next() bool {
+	if it.pos >= len(it.ts) {
+		return false
+	}
+	it.pos++
+	return true
+}
+
+func (it *sliceIter) Values() (uint32, float64) {
+	return it.ts[it.pos-1], it.vals[it.pos-1]
+}
+
+func (it *sliceIter) Err() error {
+	return nil
+}
@@ -0,0 +1,130 @@
+package chunk
+
+import (
+	"math"
+	"testing"
+)
+
+func newTestChunk(vals []float64) *Chunk {
+	c := New(1200)
+	ts := uint32(1200)
+	for i, v := range vals {
+		// a regular interval, but with some missing points
+		ts += 10
+		if i%25 == 24 {
+			ts += 10
+		}
+		c.Push(ts, v)
+	}
+	c.Finish()
+	return c
+}
+
+func TestEncodeChoosesFormat(t *testing.T) {
+	CompactFormats = true
+	defer func() { CompactFormats = false }()
+
+	var counter, status, mostlyConstant, floats, special []float64
+	specialVals := []float64{math.NaN(), math.Inf(1), math.Copysign(0, -1), maxInt * 2}
+	for i := 0; i < 720; i++ {
+		counter = append(counter, float64(1000000+i*i*17))
+		status = append(status, 1)
+		mostlyConstant = append(mostlyConstant, float64(i/250)+0.5)
+		floats = append(floats, math.Sin(float64(i))*1000)
+		special = append(special, specialVals[i/180])
+	}
+	cases := []struct {
+		name   string
+		vals   []float64
+		format Format
+	}{
+		{"counter", counter, FormatDeltaOfDeltaWithSpan},
+		{"status", status, FormatRunLengthWithSpan},
+		{"mostly constant", mostlyConstant, FormatRunLengthWithSpan},
+		{"floats", floats, FormatStandardGoTszWithSpan},
+		{"special values", special, FormatRunLengthWithSpan},
+	}
+	for _, c := range cases {
+		chunk := newTestChunk(c.vals)
+		data := chunk.Encode(600)
+		if Format(data[0]) != c.format {
+			t.Fatalf("case %q: expected format %s, got %s", c.name, c.format, Format(data[0]))
+		}
+
+		// the chunk must decode to the same points as the tsz series, both as read from the store and from the cache
+		stored, err := NewGen(data, chunk.T0)
+		if err != nil {
+			t.Fatalf("case %q: failed to create IterGen: %s", c.name, err)
+		}
+		if stored.Span != 600 {
+			t.Fatalf("case %q: expected span 600, got %d", c.name, stored.Span)
+		}
+		for _, itgen := range []*IterGen{stored, chunk.IterGen(600)} {
+			it, err := itgen.Get()
+			if err != nil {
+				t.Fatalf("case %q: failed to decode chunk: %s", c.name, err)
+			}
+			if it.T0 != chunk.T0 {
+				t.Fatalf("case %q: expected t0 %d, got %d", c.name, chunk.T0, it.T0)
+			}
+			exp := chunk.Series.Iter()
+			for exp.Next() {
+				if !it.Next() {
+					t.Fatalf("case %q: chunk has too few points", c.name)
+				}
+				expTs, expVal := exp.Values()
+				ts, val := it.Values()
+				if ts != expTs || math.Float64bits(val) != math.Float64bits(expVal) {
+					t.Fatalf("case %q: expected point %d:%v, got %d:%v", c.name, expTs, expVal, ts, val)
+				}
+			}
+			if it.Next() {
+				t.Fatalf("case %q: chunk has too many points", c.name)
+			}
+		}
+	}
+}
+
+func TestEncodeTsz(t *testing.T) {
+	// unless compact formats are enabled, chunks are persisted as tsz, which all versions can read
+	data := newTestChunk([]float64{1, 1, 1}).Encode(600)
+	if Format(data[0]) != FormatStandardGoTszWithSpan {
+		t.Fatalf("expected format %s, got %s", FormatStandardGoTszWithSpan, Format(data[0]))
+	}
+}
+
+func TestNewGenErrors(t *testing.T) {
+	valid := newTestChunk([]float64{1, 2, 3}).Encode(600)
+	cases := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"empty", []byte{}, errCorruptChunk},
+		{"no span code", valid[:1], errCorruptChunk},
+		{"unknown span code", []byte{byte(FormatDeltaOfDeltaWithSpan), 255}, errUnknownSpanCode},
+		{"unknown format", []byte{byte(FormatRunLengthWithSpan + 1), valid[1], 0}, errUnknownChunkFormat},
+	}
+	for _, c := range cases {
+		if _, err := NewGen(c.data, 1200); err != c.err {
+			t.Fatalf("case %q: expected error %v, got %v", c.name, c.err, err)
+		}
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	CompactFormats = true
+	defer func() { CompactFormats = false }()
+	for _, vals := range [][]float64{{1, 2, 3}, {1.5, 1.5, 1.5}} {
+		data := newTestChunk(vals).Encode(600)
+		for i := 2; i < len(data); i++ {
+			itgen, err := NewGen(data[:i], 1200)
+			if err != nil {
+				t.Fatalf("failed to create IterGen: %s", err)
+			}
+			if _, err := itgen.Get(); err != errCorruptChunk {
+				t.Fatalf("expected errCorruptChunk for data truncated to %d bytes, got %v", i, err)
+			}
+		}
+	}
+}
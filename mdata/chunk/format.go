@@ -8,7 +8,10 @@ package chunk
 type Format uint8
 
 // identifier of message format
+// the format byte is followed by a span code for all formats but FormatStandardGoTsz
 const (
 	FormatStandardGoTsz Format = iota
 	FormatStandardGoTszWithSpan
+	FormatDeltaOfDeltaWithSpan // integer values, see encoding.go
+	FormatRunLengthWithSpan    // runs of equal values, see encoding.go
 )
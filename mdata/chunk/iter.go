@@ -4,12 +4,21 @@ import (
 	"github.com/dgryski/go-tsz"
 )
 
+// Iter iterates over the points of a chunk, whatever its format
 type Iter struct {
-	*tsz.Iter
+	T0 uint32
+	iter
+}
+
+type iter interface {
+	Next() bool
+	Values() (uint32, float64)
+	Err() error
 }
 
 func NewIter(i *tsz.Iter) Iter {
 	return Iter{
+		i.T0,
 		i,
 	}
 }
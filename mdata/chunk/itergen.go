@@ -9,6 +9,7 @@ import (
 var (
 	errUnknownChunkFormat = errors.New("unrecognized chunk format in cassandra")
 	errUnknownSpanCode    = errors.New("corrupt data, chunk span code is not known")
+	errCorruptChunk       = errors.New("corrupt data, chunk could not be decoded")
 )
 
 //go:generate msgp
@@ -16,18 +17,31 @@ type IterGen struct {
 	B    []byte
 	Ts   uint32
 	Span uint32
+	// Format is the format of B. tsz data is always marked as FormatStandardGoTsz,
+	// as the span is not part of B
+	Format Format
 }
 
 func NewGen(b []byte, ts uint32) (*IterGen, error) {
 	var span uint32 = 0
+	format := FormatStandardGoTsz
 
+	if len(b) == 0 {
+		return nil, errCorruptChunk
+	}
 	switch Format(b[0]) {
 	case FormatStandardGoTsz:
 		b = b[1:]
-	case FormatStandardGoTszWithSpan:
+	case FormatStandardGoTszWithSpan, FormatDeltaOfDeltaWithSpan, FormatRunLengthWithSpan:
+		if len(b) < 2 {
+			return nil, errCorruptChunk
+		}
 		if int(b[1]) >= len(ChunkSpans) {
 			return nil, errUnknownSpanCode
 		}
+		if Format(b[0]) != FormatStandardGoTszWithSpan {
+			format = Format(b[0])
+		}
 		span = ChunkSpans[SpanCode(b[1])]
 		b = b[2:]
 	default:
@@ -38,14 +52,24 @@ func NewGen(b []byte, ts uint32) (*IterGen, error) {
 		b,
 		ts,
 		span,
+		format,
 	}, nil
 }
 
+// NewBareIterGen returns an IterGen for the given tsz data
 func NewBareIterGen(b []byte, ts uint32, span uint32) *IterGen {
-	return &IterGen{b, ts, span}
+	return &IterGen{b, ts, span, FormatStandardGoTsz}
 }
 
 func (ig *IterGen) Get() (*Iter, error) {
+	if ig.Format != FormatStandardGoTsz {
+		t0, ts, vals, err := decode(ig.Format, ig.B)
+		if err != nil {
+			return nil, err
+		}
+		return &Iter{t0, &sliceIter{ts: ts, vals: vals}}, nil
+	}
+
 	b := make([]byte, len(ig.B), len(ig.B))
 	copy(b, ig.B)
 	it, err := tsz.NewIterator(b)
@@ -53,7 +77,7 @@ func (ig *IterGen) Get() (*Iter, error) {
 		return nil, err
 	}
 
-	return &Iter{it}, nil
+	return &Iter{it.T0, it}, nil
 }
 
 func (ig *IterGen) Size() uint64 {
@@ -37,6 +37,15 @@ func (z *IterGen) DecodeMsg(dc *msgp.Reader) (err error) {
 			if err != nil {
 				return
 			}
+		case "Format":
+			{
+				var zb0002 uint8
+				zb0002, err = dc.ReadUint8()
+				if err != nil {
+					return
+				}
+				z.Format = Format(zb0002)
+			}
 		default:
 			err = dc.Skip()
 			if err != nil {
@@ -49,9 +58,9 @@ func (z *IterGen) DecodeMsg(dc *msgp.Reader) (err error) {
 
 // EncodeMsg implements msgp.Encodable
 func (z *IterGen) EncodeMsg(en *msgp.Writer) (err error) {
-	// map header, size 3
+	// map header, size 4
 	// write "B"
-	err = en.Append(0x83, 0xa1, 0x42)
+	err = en.Append(0x84, 0xa1, 0x42)
 	if err != nil {
 		return
 	}
@@ -77,15 +86,24 @@ func (z *IterGen) EncodeMsg(en *msgp.Writer) (err error) {
 	if err != nil {
 		return
 	}
+	// write "Format"
+	err = en.Append(0xa6, 0x46, 0x6f, 0x72, 0x6d, 0x61, 0x74)
+	if err != nil {
+		return
+	}
+	err = en.WriteUint8(uint8(z.Format))
+	if err != nil {
+		return
+	}
 	return
 }
 
 // MarshalMsg implements msgp.Marshaler
 func (z *IterGen) MarshalMsg(b []byte) (o []byte, err error) {
 	o = msgp.Require(b, z.Msgsize())
-	// map header, size 3
+	// map header, size 4
 	// string "B"
-	o = append(o, 0x83, 0xa1, 0x42)
+	o = append(o, 0x84, 0xa1, 0x42)
 	o = msgp.AppendBytes(o, z.B)
 	// string "Ts"
 	o = append(o, 0xa2, 0x54, 0x73)
@@ -93,6 +111,9 @@ func (z *IterGen) MarshalMsg(b []byte) (o []byte, err error) {
 	// string "Span"
 	o = append(o, 0xa4, 0x53, 0x70, 0x61, 0x6e)
 	o = msgp.AppendUint32(o, z.Span)
+	// string "Format"
+	o = append(o, 0xa6, 0x46, 0x6f, 0x72, 0x6d, 0x61, 0x74)
+	o = msgp.AppendUint8(o, uint8(z.Format))
 	return
 }
 
@@ -127,6 +148,15 @@ func (z *IterGen) UnmarshalMsg(bts []byte) (o []byte, err error) {
 			if err != nil {
 				return
 			}
+		case "Format":
+			{
+				var zb0002 uint8
+				zb0002, bts, err = msgp.ReadUint8Bytes(bts)
+				if err != nil {
+					return
+				}
+				z.Format = Format(zb0002)
+			}
 		default:
 			bts, err = msgp.Skip(bts)
 			if err != nil {
@@ -140,6 +170,6 @@ func (z *IterGen) UnmarshalMsg(bts []byte) (o []byte, err error) {
 
 // Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
 func (z *IterGen) Msgsize() (s int) {
-	s = 1 + 2 + msgp.BytesPrefixSize + len(z.B) + 3 + msgp.Uint32Size + 5 + msgp.Uint32Size
+	s = 1 + 2 + msgp.BytesPrefixSize + len(z.B) + 3 + msgp.Uint32Size + 5 + msgp.Uint32Size + 7 + msgp.Uint8Size
 	return
 }
@@ -6,6 +6,7 @@ package mdata
 import (
 	"flag"
 	"io/ioutil"
+	"time"
 
 	"github.com/grafana/metrictank/conf"
 	"github.com/grafana/metrictank/stats"
@@ -65,6 +66,10 @@ var (
 
 	schemasFile = "/etc/metrictank/storage-schemas.conf"
 	aggFile     = "/etc/metrictank/storage-aggregation.conf"
+
+	SnapshotEnabled  = false
+	SnapshotFile     = "/var/lib/metrictank/snapshot"
+	SnapshotInterval = time.Minute
 )
 
 func ConfigSetup() {
@@ -72,6 +77,12 @@ func ConfigSetup() {
 	retentionConf.StringVar(&schemasFile, "schemas-file", "/etc/metrictank/storage-schemas.conf", "path to storage-schemas.conf file")
 	retentionConf.StringVar(&aggFile, "aggregations-file", "/etc/metrictank/storage-aggregation.conf", "path to storage-aggregation.conf file")
 	globalconf.Register("retention", retentionConf)
+
+	snapshotConf := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	snapshotConf.BoolVar(&SnapshotEnabled, "enabled", false, "write the data that has not been saved yet to a local file periodically and at shutdown, and restore it at startup")
+	snapshotConf.StringVar(&SnapshotFile, "file", "/var/lib/metrictank/snapshot", "path of the snapshot file")
+	snapshotConf.DurationVar(&SnapshotInterval, "interval", time.Minute, "how often to write a snapshot. inputs that can't replay data lose the data received since the last snapshot when metrictank crashes. 0 to only write a snapshot at shutdown")
+	globalconf.Register("snapshot", snapshotConf)
 }
 
 func ConfigProcess() {
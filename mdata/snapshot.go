@@ -0,0 +1,525 @@
+package mdata
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/stats"
+	"github.com/raintank/worldping-api/pkg/log"
+	"github.com/tinylib/msgp/msgp"
+	"gopkg.in/raintank/schema.v1"
+)
+
+// a snapshot holds the in-memory state of all series that has not been saved yet, so that it
+// can be restored after a restart. this is most useful for inputs that can't replay data.
+// snapshots are written periodically, and when metrictank shuts down.
+//
+// the file starts with the magic bytes and the version (uint32), followed by a record per series:
+//   [length uint32][crc32 of the data uint32][data]
+// the data is msgpack encoded: the MKey, schemaId and aggId of the series and the state of its AggMetric.
+// the state of an AggMetric consists of its save state, its chunks that have not been saved yet,
+// the points in its reorder buffer, and for each of its aggregators, the current aggregation and
+// the state of its rollup AggMetrics.
+
+const (
+	snapshotMagic   = "MTSS"
+	snapshotVersion = 2
+)
+
+var (
+	errSnapshotCorrupt = errors.New("corrupt snapshot")
+
+	// metric tank.snapshot.write_fail is how many times writing a snapshot failed
+	snapshotWriteFail = stats.NewCounter32("tank.snapshot.write_fail")
+	// metric tank.snapshot.duration is how long it took to write the last snapshot, in ms
+	snapshotDuration = stats.NewGauge32("tank.snapshot.duration")
+	// metric tank.snapshot.series_restored is how many series have been restored from the snapshot at startup
+	snapshotSeriesRestored = stats.NewCounter32("tank.snapshot.series_restored")
+	// metric tank.snapshot.series_skipped is how many series in the snapshot could not be restored,
+	// e.g. because their storage-schemas or storage-aggregation settings have changed
+	snapshotSeriesSkipped = stats.NewCounter32("tank.snapshot.series_skipped")
+)
+
+type aggMetricSnapshot struct {
+	chunkSpan      uint32
+	firstChunkT0   uint32
+	lastWrite      uint32
+	lastSaveStart  uint32
+	lastSaveFinish uint32
+	chunks         []chunkSnapshot // oldest first
+	rob            []schema.Point
+	aggregators    []aggregatorSnapshot
+}
+
+type chunkSnapshot struct {
+	t0     uint32
+	closed bool
+	data   []byte
+}
+
+type aggregatorSnapshot struct {
+	span            uint32
+	currentBoundary uint32
+	agg             Aggregation
+	metrics         [5]*aggMetricSnapshot // min, max, sum, cnt, lst. nil if not configured
+}
+
+func (agg *Aggregator) rollups() [5]*AggMetric {
+	return [5]*AggMetric{agg.minMetric, agg.maxMetric, agg.sumMetric, agg.cntMetric, agg.lstMetric}
+}
+
+// snapshot returns the state of the AggMetric, including its chunks that have not been saved yet
+func (a *AggMetric) snapshot() *aggMetricSnapshot {
+	a.RLock()
+	defer a.RUnlock()
+	s := &aggMetricSnapshot{
+		chunkSpan:      a.ChunkSpan,
+		firstChunkT0:   a.firstChunkT0,
+		lastWrite:      a.lastWrite,
+		lastSaveStart:  a.lastSaveStart,
+		lastSaveFinish: a.lastSaveFinish,
+	}
+	for i := range a.Chunks {
+		pos := (a.CurrentChunkPos + 1 + i) % len(a.Chunks)
+		c := a.Chunks[pos]
+		// lastSaveFinish 0 means that nothing has been saved
+		if a.lastSaveFinish > 0 && c.T0 <= a.lastSaveFinish && pos != a.CurrentChunkPos {
+			continue
+		}
+		s.chunks = append(s.chunks, chunkSnapshot{
+			t0:     c.T0,
+			closed: c.Closed,
+			data:   c.Snapshot(),
+		})
+	}
+	if a.rob != nil {
+		s.rob = a.rob.Get()
+	}
+	for _, agg := range a.aggregators {
+		as := aggregatorSnapshot{
+			span:            agg.span,
+			currentBoundary: agg.currentBoundary,
+			agg:             *agg.agg,
+		}
+		for i, m := range agg.rollups() {
+			if m != nil {
+				as.metrics[i] = m.snapshot()
+			}
+		}
+		s.aggregators = append(s.aggregators, as)
+	}
+	return s
+}
+
+// compatible returns whether the snapshot can be restored into the AggMetric
+func (a *AggMetric) compatible(s *aggMetricSnapshot) bool {
+	if s.chunkSpan != a.ChunkSpan || len(s.chunks) > int(a.NumChunks) || len(s.aggregators) != len(a.aggregators) {
+		return false
+	}
+	for i, agg := range a.aggregators {
+		as := s.aggregators[i]
+		if as.span != agg.span {
+			return false
+		}
+		for j, m := range agg.rollups() {
+			if (m == nil) != (as.metrics[j] == nil) {
+				return false
+			}
+			if m != nil && !m.compatible(as.metrics[j]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// restore restores the state of a new AggMetric from the snapshot, which must be compatible
+func (a *AggMetric) restore(s *aggMetricSnapshot) {
+	a.Lock()
+	defer a.Unlock()
+	for _, cs := range s.chunks {
+		c, err := chunk.FromSnapshot(cs.t0, cs.data, cs.closed)
+		if err != nil {
+			log.Warn("AM %s: failed to restore chunk %d from the snapshot: %s", a.Key, cs.t0, err)
+			continue
+		}
+		a.Chunks = append(a.Chunks, c)
+	}
+	a.CurrentChunkPos = len(a.Chunks) - 1
+	if a.CurrentChunkPos < 0 {
+		a.CurrentChunkPos = 0
+	}
+	a.firstChunkT0 = s.firstChunkT0
+	a.lastSaveFinish = s.lastSaveFinish
+	// the chunks that were in the write queue may not have been saved, so they have to be saved again
+	a.lastSaveStart = s.lastSaveFinish
+	for i, agg := range a.aggregators {
+		as := s.aggregators[i]
+		agg.currentBoundary = as.currentBoundary
+		*agg.agg = as.agg
+		for j, m := range agg.rollups() {
+			if m != nil {
+				m.restore(as.metrics[j])
+			}
+		}
+	}
+	// the points in the reorder buffer have not been added to the chunks and aggregators yet.
+	// the reorder window may have changed, so any points that don't fit anymore are added right away.
+	for _, p := range s.rob {
+		if a.rob == nil {
+			a.add(p.Ts, p.Val)
+			continue
+		}
+		res, _ := a.rob.Add(p.Ts, p.Val)
+		for _, p := range res {
+			a.add(p.Ts, p.Val)
+		}
+	}
+	a.lastWrite = s.lastWrite
+}
+
+func appendAggMetricSnapshot(b []byte, s *aggMetricSnapshot) []byte {
+	b = msgp.AppendUint32(b, s.chunkSpan)
+	b = msgp.AppendUint32(b, s.firstChunkT0)
+	b = msgp.AppendUint32(b, s.lastWrite)
+	b = msgp.AppendUint32(b, s.lastSaveStart)
+	b = msgp.AppendUint32(b, s.lastSaveFinish)
+	b = msgp.AppendArrayHeader(b, uint32(len(s.chunks)))
+	for _, c := range s.chunks {
+		b = msgp.AppendUint32(b, c.t0)
+		b = msgp.AppendBool(b, c.closed)
+		b = msgp.AppendBytes(b, c.data)
+	}
+	b = msgp.AppendArrayHeader(b, uint32(len(s.rob)))
+	for _, p := range s.rob {
+		b = msgp.AppendUint32(b, p.Ts)
+		b = msgp.AppendFloat64(b, p.Val)
+	}
+	b = msgp.AppendArrayHeader(b, uint32(len(s.aggregators)))
+	for _, as := range s.aggregators {
+		b = msgp.AppendUint32(b, as.span)
+		b = msgp.AppendUint32(b, as.currentBoundary)
+		b = msgp.AppendFloat64(b, as.agg.Min)
+		b = msgp.AppendFloat64(b, as.agg.Max)
+		b = msgp.AppendFloat64(b, as.agg.Sum)
+		b = msgp.AppendFloat64(b, as.agg.Cnt)
+		b = msgp.AppendFloat64(b, as.agg.Lst)
+		for _, m := range as.metrics {
+			if m == nil {
+				b = msgp.AppendNil(b)
+				continue
+			}
+			b = appendAggMetricSnapshot(b, m)
+		}
+	}
+	return b
+}
+
+// snapshotDecoder decodes msgpack data. after an error, all reads return zero values,
+// so that the error only needs to be checked at the end.
+type snapshotDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *snapshotDecoder) uint16() uint16 {
+	var v uint16
+	if d.err == nil {
+		v, d.b, d.err = msgp.ReadUint16Bytes(d.b)
+	}
+	return v
+}
+
+func (d *snapshotDecoder) uint32() uint32 {
+	var v uint32
+	if d.err == nil {
+		v, d.b, d.err = msgp.ReadUint32Bytes(d.b)
+	}
+	return v
+}
+
+func (d *snapshotDecoder) float64() float64 {
+	var v float64
+	if d.err == nil {
+		v, d.b, d.err = msgp.ReadFloat64Bytes(d.b)
+	}
+	return v
+}
+
+func (d *snapshotDecoder) bool() bool {
+	var v bool
+	if d.err == nil {
+		v, d.b, d.err = msgp.ReadBoolBytes(d.b)
+	}
+	return v
+}
+
+func (d *snapshotDecoder) bytes() []byte {
+	var v []byte
+	if d.err == nil {
+		v, d.b, d.err = msgp.ReadBytesZC(d.b)
+	}
+	return v
+}
+
+func (d *snapshotDecoder) string() string {
+	var v string
+	if d.err == nil {
+		v, d.b, d.err = msgp.ReadStringBytes(d.b)
+	}
+	return v
+}
+
+func (d *snapshotDecoder) arrayHeader() uint32 {
+	var v uint32
+	if d.err == nil {
+		v, d.b, d.err = msgp.ReadArrayHeaderBytes(d.b)
+	}
+	// every element takes at least a byte, which protects us from huge allocations for corrupt data
+	if d.err == nil && int(v) > len(d.b) {
+		d.err = errSnapshotCorrupt
+	}
+	return v
+}
+
+// isNil consumes a nil value, if there is one
+func (d *snapshotDecoder) isNil() bool {
+	if d.err != nil || !msgp.IsNil(d.b) {
+		return false
+	}
+	d.b, d.err = msgp.ReadNilBytes(d.b)
+	return true
+}
+
+func (d *snapshotDecoder) aggMetricSnapshot() *aggMetricSnapshot {
+	s := &aggMetricSnapshot{
+		chunkSpan:      d.uint32(),
+		firstChunkT0:   d.uint32(),
+		lastWrite:      d.uint32(),
+		lastSaveStart:  d.uint32(),
+		lastSaveFinish: d.uint32(),
+	}
+	n := d.arrayHeader()
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		s.chunks = append(s.chunks, chunkSnapshot{
+			t0:     d.uint32(),
+			closed: d.bool(),
+			data:   d.bytes(),
+		})
+	}
+	n = d.arrayHeader()
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		s.rob = append(s.rob, schema.Point{
+			Ts:  d.uint32(),
+			Val: d.float64(),
+		})
+	}
+	n = d.arrayHeader()
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		as := aggregatorSnapshot{
+			span:            d.uint32(),
+			currentBoundary: d.uint32(),
+			agg: Aggregation{
+				Min: d.float64(),
+				Max: d.float64(),
+				Sum: d.float64(),
+				Cnt: d.float64(),
+				Lst: d.float64(),
+			},
+		}
+		for j := range as.metrics {
+			if !d.isNil() {
+				as.metrics[j] = d.aggMetricSnapshot()
+			}
+		}
+		s.aggregators = append(s.aggregators, as)
+	}
+	return s
+}
+
+// WriteSnapshot writes the state of all series to the given file
+func (ms *AggMetrics) WriteSnapshot(path string) error {
+	pre := time.Now()
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer file.Close()
+
+	ms.RLock()
+	metrics := make([]*AggMetric, 0, len(ms.Metrics))
+	for _, a := range ms.Metrics {
+		metrics = append(metrics, a)
+	}
+	ms.RUnlock()
+
+	w := bufio.NewWriter(file)
+	header := make([]byte, 8)
+	copy(header, snapshotMagic)
+	binary.LittleEndian.PutUint32(header[4:], snapshotVersion)
+	_, err = w.Write(header)
+	if err != nil {
+		return err
+	}
+	var buf []byte
+	for _, a := range metrics {
+		buf = msgp.AppendString(buf[:0], a.Key.MKey.String())
+		buf = msgp.AppendUint16(buf, a.schemaId)
+		buf = msgp.AppendUint16(buf, a.aggId)
+		buf = appendAggMetricSnapshot(buf, a.snapshot())
+		binary.LittleEndian.PutUint32(header, uint32(len(buf)))
+		binary.LittleEndian.PutUint32(header[4:], crc32.ChecksumIEEE(buf))
+		_, err = w.Write(header)
+		if err == nil {
+			_, err = w.Write(buf)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = w.Flush()
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return err
+	}
+	snapshotDuration.Set(int(time.Since(pre) / time.Millisecond))
+	log.Info("wrote snapshot of %d series to %s in %s", len(metrics), path, time.Since(pre))
+	return nil
+}
+
+// StartSnapshots writes a snapshot to the given file every interval, until StopSnapshots is called
+func (ms *AggMetrics) StartSnapshots(path string, interval time.Duration) {
+	ms.snapshotShutdown = make(chan struct{})
+	ms.snapshotWg.Add(1)
+	go func() {
+		defer ms.snapshotWg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ms.snapshotShutdown:
+				return
+			case <-ticker.C:
+				err := ms.WriteSnapshot(path)
+				if err != nil {
+					snapshotWriteFail.Inc()
+					log.Error(3, "failed to write snapshot to %s: %s", path, err)
+				}
+			}
+		}
+	}()
+}
+
+// StopSnapshots stops writing snapshots periodically, and waits for a snapshot that is being written
+func (ms *AggMetrics) StopSnapshots() {
+	if ms.snapshotShutdown == nil {
+		return
+	}
+	close(ms.snapshotShutdown)
+	ms.snapshotWg.Wait()
+}
+
+// LoadSnapshot restores the series in the given snapshot file, if it exists.
+// it must be called before any data is added.
+func (ms *AggMetrics) LoadSnapshot(path string) error {
+	pre := time.Now()
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		log.Info("no snapshot found at %s", path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	header := make([]byte, 8)
+	_, err = io.ReadFull(r, header)
+	if err != nil {
+		return err
+	}
+	if string(header[:4]) != snapshotMagic {
+		return errSnapshotCorrupt
+	}
+	if version := binary.LittleEndian.Uint32(header[4:]); version != snapshotVersion {
+		return fmt.Errorf("unknown snapshot version %d", version)
+	}
+
+	var restored, skipped int
+	var buf []byte
+	for {
+		_, err = io.ReadFull(r, header)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		length := binary.LittleEndian.Uint32(header)
+		if cap(buf) < int(length) {
+			buf = make([]byte, length)
+		}
+		buf = buf[:length]
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return err
+		}
+		if crc32.ChecksumIEEE(buf) != binary.LittleEndian.Uint32(header[4:]) {
+			return errSnapshotCorrupt
+		}
+		d := &snapshotDecoder{b: buf}
+		keyStr := d.string()
+		schemaId := d.uint16()
+		aggId := d.uint16()
+		s := d.aggMetricSnapshot()
+		if d.err != nil {
+			return fmt.Errorf("failed to decode snapshot of %s: %s", keyStr, d.err)
+		}
+		key, err := schema.MKeyFromString(keyStr)
+		if err != nil {
+			return fmt.Errorf("failed to decode snapshot of %s: %s", keyStr, err)
+		}
+
+		a := ms.GetOrCreate(key, schemaId, aggId).(*AggMetric)
+		a.RLock()
+		empty := len(a.Chunks) == 0
+		a.RUnlock()
+		if !empty {
+			log.Warn("can't restore %s from the snapshot, it already has data", keyStr)
+			skipped++
+			continue
+		}
+		if !a.compatible(s) {
+			log.Warn("can't restore %s from the snapshot, its storage-schemas or storage-aggregation settings have changed", keyStr)
+			skipped++
+			continue
+		}
+		a.restore(s)
+		restored++
+	}
+	snapshotSeriesRestored.Add(restored)
+	snapshotSeriesSkipped.Add(skipped)
+	log.Info("restored %d series from snapshot %s in %s, %d series could not be restored", restored, path, time.Since(pre), skipped)
+	return nil
+}
@@ -0,0 +1,226 @@
+package mdata
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/consolidation"
+	"github.com/grafana/metrictank/mdata/cache"
+	"github.com/grafana/metrictank/test"
+)
+
+// resultPoints returns the points of the result of a Get call, which must have succeeded
+func resultPoints(res Result, err error) []point {
+	if err != nil {
+		panic(err)
+	}
+	var points []point
+	for _, it := range res.Iters {
+		for it.Next() {
+			ts, val := it.Values()
+			points = append(points, point{ts, val})
+		}
+	}
+	for _, p := range res.Points {
+		points = append(points, point{p.Ts, p.Val})
+	}
+	return points
+}
+
+func setSnapshotTestConfig() {
+	SetSingleAgg(conf.Avg, conf.Lst)
+	SetSingleSchema(
+		conf.NewRetentionMT(10, 86400, 600, 5, true),
+		conf.NewRetentionMT(60, 30*86400, 3600, 2, true),
+	)
+}
+
+func newSnapshotTestMetrics() *AggMetrics {
+	return NewAggMetrics(mockstore, &cache.MockCache{}, false, 3600, 21600, 0)
+}
+
+func tempSnapshotFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir + "/snapshot", func() { os.RemoveAll(dir) }
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	cluster.Manager.SetPrimary(false)
+	setSnapshotTestConfig()
+	path, cleanup := tempSnapshotFile(t)
+	defer cleanup()
+
+	ms := newSnapshotTestMetrics()
+	for ts := uint32(10); ts <= 2000; ts += 10 {
+		ms.GetOrCreate(test.GetMKey(1), 0, 0).Add(ts, float64(ts))
+		ms.GetOrCreate(test.GetMKey(2), 0, 0).Add(ts, float64(ts)/3)
+	}
+	// the chunks up to 600 have been saved, and don't need to be in the snapshot
+	ms.Metrics[test.GetMKey(2)].SyncChunkSaveState(600)
+
+	err := ms.WriteSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := newSnapshotTestMetrics()
+	err = restored.LoadSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored.Metrics) != 2 {
+		t.Fatalf("expected 2 series to be restored, got %d", len(restored.Metrics))
+	}
+	if n := len(restored.Metrics[test.GetMKey(1)].Chunks); n != 4 {
+		t.Fatalf("expected all 4 chunks to be restored, got %d", n)
+	}
+	if n := len(restored.Metrics[test.GetMKey(2)].Chunks); n != 2 {
+		t.Fatalf("expected the 2 unsaved chunks to be restored, got %d", n)
+	}
+
+	// the aggregators must continue where they left off
+	for _, m := range []*AggMetrics{ms, restored} {
+		for ts := uint32(2010); ts <= 2400; ts += 10 {
+			m.GetOrCreate(test.GetMKey(1), 0, 0).Add(ts, float64(ts))
+			m.GetOrCreate(test.GetMKey(2), 0, 0).Add(ts, float64(ts)/3)
+		}
+	}
+	for _, key := range []int{1, 2} {
+		a, b := ms.Metrics[test.GetMKey(key)], restored.Metrics[test.GetMKey(key)]
+		exp, got := resultPoints(a.Get(1200, 2401)), resultPoints(b.Get(1200, 2401))
+		if !reflect.DeepEqual(exp, got) {
+			t.Fatalf("series %d: expected points %v, got %v", key, exp, got)
+		}
+		for _, cons := range []consolidation.Consolidator{consolidation.Sum, consolidation.Cnt, consolidation.Lst} {
+			exp, got := resultPoints(a.GetAggregated(cons, 60, 1, 2401)), resultPoints(b.GetAggregated(cons, 60, 1, 2401))
+			if len(exp) == 0 || !reflect.DeepEqual(exp, got) {
+				t.Fatalf("series %d: expected %s points %v, got %v", key, cons, exp, got)
+			}
+		}
+	}
+}
+
+func TestSnapshotIncompatible(t *testing.T) {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	setSnapshotTestConfig()
+	path, cleanup := tempSnapshotFile(t)
+	defer cleanup()
+
+	ms := newSnapshotTestMetrics()
+	ms.GetOrCreate(test.GetMKey(1), 0, 0).Add(10, 1)
+	err := ms.WriteSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the rollup archive has been removed
+	SetSingleSchema(conf.NewRetentionMT(10, 86400, 600, 5, true))
+	restored := newSnapshotTestMetrics()
+	err = restored.LoadSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(restored.Metrics[test.GetMKey(1)].Chunks); n != 0 {
+		t.Fatalf("expected the series not to be restored, got %d chunks", n)
+	}
+}
+
+func TestSnapshotCorrupt(t *testing.T) {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	setSnapshotTestConfig()
+	path, cleanup := tempSnapshotFile(t)
+	defer cleanup()
+
+	// a missing snapshot is not an error
+	err := newSnapshotTestMetrics().LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("expected no error for a missing snapshot, got %s", err)
+	}
+
+	ms := newSnapshotTestMetrics()
+	ms.GetOrCreate(test.GetMKey(1), 0, 0).Add(10, 1)
+	err = ms.WriteSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1]++
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = newSnapshotTestMetrics().LoadSnapshot(path)
+	if err != errSnapshotCorrupt {
+		t.Fatalf("expected errSnapshotCorrupt, got %v", err)
+	}
+}
+
+func TestSnapshotReorderBufferAndSaveState(t *testing.T) {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	cluster.Manager.SetPrimary(false)
+	setSnapshotTestConfig()
+	Schemas = conf.NewSchemas([]conf.Schema{{
+		Name:          "reorder",
+		Pattern:       regexp.MustCompile(".*"),
+		Retentions:    Schemas.DefaultSchema.Retentions,
+		ReorderWindow: 5,
+	}})
+	defer setSnapshotTestConfig()
+	path, cleanup := tempSnapshotFile(t)
+	defer cleanup()
+
+	ms := newSnapshotTestMetrics()
+	m := ms.GetOrCreate(test.GetMKey(1), 0, 0).(*AggMetric)
+	for ts := uint32(610); ts <= 1900; ts += 10 {
+		m.Add(ts, float64(ts))
+	}
+	// the chunk at 600 has been saved, the one at 1200 is still in the write queue
+	m.SyncChunkSaveState(600)
+	m.lastSaveStart = 1200
+	exp := m.rob.Get()
+	if len(exp) != 5 {
+		t.Fatalf("expected 5 points in the reorder buffer, got %d", len(exp))
+	}
+
+	err := ms.WriteSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := newSnapshotTestMetrics()
+	err = restored.LoadSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := restored.Metrics[test.GetMKey(1)]
+	if got := r.rob.Get(); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("expected reorder buffer %v, got %v", exp, got)
+	}
+	if r.lastSaveFinish != m.lastSaveFinish {
+		t.Fatalf("expected lastSaveFinish %d, got %d", m.lastSaveFinish, r.lastSaveFinish)
+	}
+	// the save of the chunk in the write queue was not confirmed, so it must be saved again
+	if r.lastSaveStart != m.lastSaveFinish {
+		t.Fatalf("expected lastSaveStart %d, got %d", m.lastSaveFinish, r.lastSaveStart)
+	}
+
+	// points that are out of order, but within the reorder window, are still accepted
+	for _, am := range []*AggMetric{m, r} {
+		am.Add(1870, 1)
+		am.Add(1910, 1910)
+	}
+	if exp, got := resultPoints(m.Get(1200, 1911)), resultPoints(r.Get(1200, 1911)); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("expected points %v, got %v", exp, got)
+	}
+}
@@ -52,6 +52,8 @@ var (
 	cassOmitOldRead = stats.NewCounter32("store.cassandra.omit_read.too_old")
 	// reads that could not be pushed into the queue because it was full
 	cassReadQueueFull = stats.NewCounter32("store.cassandra.omit_read.queue_full")
+	// metric store.cassandra.omit_read.canceled is how many reads were not executed, or aborted, because their request was canceled
+	cassOmitCanceledRead = stats.NewCounter32("store.cassandra.omit_read.canceled")
 
 	// metric store.cassandra.chunks_per_response is how many chunks are retrieved per response in get queries
 	cassChunksPerResponse = stats.NewMeter32("store.cassandra.chunks_per_response", false)
@@ -93,6 +95,8 @@ type CassandraStore struct {
 	timeout          time.Duration
 }
 
+// PrepareChunkData returns the given tsz data in the format it is stored in.
+// chunks of the tank are stored using Chunk.Encode, which may choose a more compact format.
 func PrepareChunkData(span uint32, data []byte) []byte {
 	chunkSizeAtSave.Value(len(data))
 	version := chunk.FormatStandardGoTszWithSpan
@@ -316,7 +320,8 @@ func (c *CassandraStore) processWriteQueue(queue chan *mdata.ChunkWriteRequest,
 			//log how long the chunk waited in the queue before we attempted to save to cassandra
 			cassPutWaitDuration.Value(time.Now().Sub(cwr.Timestamp))
 
-			buf := PrepareChunkData(cwr.Span, cwr.Chunk.Series.Bytes())
+			buf := cwr.Chunk.Encode(cwr.Span)
+			chunkSizeAtSave.Value(len(buf))
 			success := false
 			attempts := 0
 			keyStr := cwr.Key.String()
@@ -383,6 +388,7 @@ func (c *CassandraStore) processReadQueue() {
 		select {
 		case <-crr.ctx.Done():
 			//request canceled
+			cassOmitCanceledRead.Inc()
 			crr.out <- readResult{err: errCtxCanceled}
 			continue
 		default:
@@ -490,6 +496,7 @@ func (c *CassandraStore) SearchTable(ctx context.Context, key schema.AMKey, tabl
 	case <-ctx.Done():
 		// request has been canceled, so no need to continue queuing reads.
 		// reads already queued will be aborted when read from the queue.
+		cassOmitCanceledRead.Inc()
 		return nil, nil
 	case c.readQueue <- &crr:
 	default:
@@ -542,6 +549,7 @@ func (c *CassandraStore) SearchTable(ctx context.Context, key schema.AMKey, tabl
 	if err != nil {
 		if err == context.Canceled || err == context.DeadlineExceeded {
 			// query was aborted.
+			cassOmitCanceledRead.Inc()
 			return nil, nil
 		}
 		tracing.Failure(span)
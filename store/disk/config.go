@@ -0,0 +1,40 @@
+package disk
+
+import (
+	"flag"
+	"time"
+
+	"github.com/rakyll/globalconf"
+)
+
+type StoreConfig struct {
+	Enabled        bool
+	Path           string
+	WindowFactor   int
+	WriteQueueSize int
+	ExpireInterval time.Duration
+}
+
+// return StoreConfig with default values set.
+func NewStoreConfig() *StoreConfig {
+	return &StoreConfig{
+		Enabled:        false,
+		Path:           "/var/lib/metrictank/chunks",
+		WindowFactor:   20,
+		WriteQueueSize: 100000,
+		ExpireInterval: 10 * time.Minute,
+	}
+}
+
+var CliConfig = NewStoreConfig()
+
+func ConfigSetup() *flag.FlagSet {
+	ds := flag.NewFlagSet("disk-store", flag.ExitOnError)
+	ds.BoolVar(&CliConfig.Enabled, "enabled", CliConfig.Enabled, "store chunks on local disk instead of in cassandra")
+	ds.StringVar(&CliConfig.Path, "path", CliConfig.Path, "directory to store the chunks in")
+	ds.IntVar(&CliConfig.WindowFactor, "window-factor", CliConfig.WindowFactor, "size of the time windows, which are expired as a whole, relative to TTL")
+	ds.IntVar(&CliConfig.WriteQueueSize, "write-queue-size", CliConfig.WriteQueueSize, "max number of chunks waiting to be written")
+	ds.DurationVar(&CliConfig.ExpireInterval, "expire-interval", CliConfig.ExpireInterval, "how often to look for, and delete, expired time windows")
+	globalconf.Register("disk-store", ds)
+	return ds
+}
@@ -0,0 +1,629 @@
+package disk
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	schema "gopkg.in/raintank/schema.v1"
+
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/stats"
+	"github.com/grafana/metrictank/store/cassandra"
+	"github.com/grafana/metrictank/tracing"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/raintank/worldping-api/pkg/log"
+)
+
+// write aggregated data to local disk.
+//
+// like in cassandra, chunks are grouped in tables of similar TTLs (see cassandra.GetTable),
+// and each table is split into time windows of its WindowSize, based on the t0 of the chunks.
+// each window is a directory with a single append-only file of chunks, so that once
+// all data in a window has expired, the directory can be removed as a whole:
+//
+//   <path>/<table name>/<window start>/chunks
+//
+// the location of every chunk is kept in memory, and is rebuilt from the files on startup.
+
+const chunksFile = "chunks"
+
+// every record in a chunks file has a header with the length and the crc32 of its payload.
+// the payload is the t0 (uint32), the length of the key (uint16), the key and the chunk data.
+const recordHeaderLen = 8
+
+// the maximum number of chunks that are written before they are synced to disk
+const syncBatchSize = 1000
+
+var (
+	errInvalidRange  = errors.New("DiskStore: invalid range: from must be less than to")
+	errTableNotFound = errors.New("table for given TTL not found")
+	errKeyTooLong    = errors.New("key too long")
+	errCorruptRecord = errors.New("corrupt record")
+
+	// metric store.disk.get.exec is the duration of getting chunks from the disk store
+	diskGetExecDuration = stats.NewLatencyHistogram15s32("store.disk.get.exec")
+	// metric store.disk.put.exec is the duration of writing a chunk to the disk store
+	diskPutExecDuration = stats.NewLatencyHistogram15s32("store.disk.put.exec")
+	// metric store.disk.put.wait is the duration of a put in the wait queue
+	diskPutWaitDuration = stats.NewLatencyHistogram12h32("store.disk.put.wait")
+	// metric store.disk.chunks_per_response is how many chunks are retrieved per response in get queries
+	diskChunksPerResponse = stats.NewMeter32("store.disk.chunks_per_response", false)
+	// metric store.disk.chunk_operations.save_ok is counter of successful saves
+	diskChunkSaveOk = stats.NewCounter32("store.disk.chunk_operations.save_ok")
+	// metric store.disk.chunk_operations.save_fail is counter of failed saves
+	diskChunkSaveFail = stats.NewCounter32("store.disk.chunk_operations.save_fail")
+	// metric store.disk.sync is the duration of syncing the written chunks to disk
+	diskSyncDuration = stats.NewLatencyHistogram15s32("store.disk.sync")
+	// metric store.disk.sync_fail is how many times syncing the written chunks to disk failed. it is retried until it succeeds
+	diskSyncFail = stats.NewCounter32("store.disk.sync_fail")
+	// metric store.disk.windows_expired is how many time windows have been removed because all of their data expired
+	diskWindowsExpired = stats.NewCounter32("store.disk.windows_expired")
+)
+
+// location of a chunk within the chunks file of a window
+type entry struct {
+	t0     uint32
+	offset int64
+	length uint32
+}
+
+type window struct {
+	start  uint32
+	dir    string
+	file   *os.File
+	size   int64              // only used by the write goroutine, after the window has been loaded
+	chunks map[string][]entry // by AMKey
+}
+
+type table struct {
+	name       string
+	dir        string
+	windowSize uint32 // in seconds
+	ttl        uint32 // the highest TTL of the data in this table
+	windows    map[uint32]*window
+}
+
+type DiskStore struct {
+	sync.RWMutex // protects the tables and the windows and chunks in them
+	path         string
+	windowFactor int
+	tables       map[string]*table // by name
+	ttlTables    map[uint32]*table // by TTL
+
+	writeQueue      chan *mdata.ChunkWriteRequest
+	writeQueueMeter *stats.Range32
+	writeDone       chan struct{}
+	shutdown        chan struct{}
+	tracer          opentracing.Tracer
+}
+
+func NewDiskStore(config *StoreConfig, ttls []uint32) (*DiskStore, error) {
+	stats.NewGauge32("store.disk.write_queue.size").Set(config.WriteQueueSize)
+
+	err := os.MkdirAll(config.Path, 0755)
+	if err != nil {
+		return nil, err
+	}
+	d := &DiskStore{
+		path:            config.Path,
+		windowFactor:    config.WindowFactor,
+		tables:          make(map[string]*table),
+		ttlTables:       make(map[uint32]*table),
+		writeQueue:      make(chan *mdata.ChunkWriteRequest, config.WriteQueueSize),
+		writeQueueMeter: stats.NewRange32("store.disk.write_queue.items"),
+		writeDone:       make(chan struct{}),
+		shutdown:        make(chan struct{}),
+		tracer:          opentracing.NoopTracer{},
+	}
+	for _, ttl := range ttls {
+		d.tableFor(ttl)
+	}
+	err = d.load()
+	if err != nil {
+		return nil, err
+	}
+	d.expire(uint32(time.Now().Unix()))
+
+	go d.processWriteQueue()
+	if config.ExpireInterval > 0 {
+		go d.expireLoop(config.ExpireInterval)
+	}
+	return d, nil
+}
+
+// tableFor returns the table for the given TTL, creating it if needed.
+// the caller must hold the write lock.
+func (d *DiskStore) tableFor(ttl uint32) *table {
+	if t, ok := d.ttlTables[ttl]; ok {
+		return t
+	}
+	def := cassandra.GetTable(ttl, d.windowFactor, cassandra.Table_name_format)
+	t, ok := d.tables[def.Name]
+	if !ok {
+		t = &table{
+			name:       def.Name,
+			dir:        filepath.Join(d.path, def.Name),
+			windowSize: def.WindowSize * 60 * 60,
+			windows:    make(map[uint32]*window),
+		}
+		d.tables[def.Name] = t
+	}
+	if ttl > t.ttl {
+		t.ttl = ttl
+	}
+	d.ttlTables[ttl] = t
+	return t
+}
+
+// load opens all windows found on disk and indexes their chunks
+func (d *DiskStore) load() error {
+	tableDirs, err := ioutil.ReadDir(d.path)
+	if err != nil {
+		return err
+	}
+	for _, tableDir := range tableDirs {
+		var hours uint32
+		if !tableDir.IsDir() || !isTableName(tableDir.Name(), &hours) {
+			log.Warn("DS: ignoring unexpected file %s in %s", tableDir.Name(), d.path)
+			continue
+		}
+		t, ok := d.tables[tableDir.Name()]
+		if !ok {
+			// none of our TTLs use this table. we don't know the TTL of its data, so we assume the highest TTL
+			// that maps onto it, to make sure we don't remove data too early.
+			maxTTL := uint32(60*60 - 1)
+			if hours > 0 {
+				maxTTL = 2*hours*60*60 - 1
+			}
+			t = d.tableFor(maxTTL)
+		}
+		windowDirs, err := ioutil.ReadDir(t.dir)
+		if err != nil {
+			return err
+		}
+		for _, windowDir := range windowDirs {
+			start, err := strconv.ParseUint(windowDir.Name(), 10, 32)
+			if err != nil || !windowDir.IsDir() {
+				log.Warn("DS: ignoring unexpected file %s in %s", windowDir.Name(), t.dir)
+				continue
+			}
+			w, err := openWindow(filepath.Join(t.dir, windowDir.Name()), uint32(start))
+			if err != nil {
+				return err
+			}
+			t.windows[w.start] = w
+		}
+	}
+	return nil
+}
+
+func isTableName(name string, hours *uint32) bool {
+	n, err := fmt.Sscanf(name, cassandra.Table_name_format, hours)
+	return err == nil && n == 1 && fmt.Sprintf(cassandra.Table_name_format, *hours) == name
+}
+
+// openWindow opens, or creates, the window in the given directory, and indexes its chunks.
+// if the chunks file ends with an incomplete or corrupt record, e.g. because we crashed while writing it,
+// the file is truncated to the last good record.
+func openWindow(dir string, start uint32) (*window, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, chunksFile), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := &window{
+		start:  start,
+		dir:    dir,
+		file:   file,
+		chunks: make(map[string][]entry),
+	}
+	r := bufio.NewReader(file)
+	for {
+		key, e, n, err := readRecord(r, w.size)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Warn("DS: %s at offset %d of %s, truncating it", err, w.size, file.Name())
+			err = file.Truncate(w.size)
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+			break
+		}
+		w.add(key, e)
+		w.size += n
+	}
+	return w, nil
+}
+
+// readRecord reads the record at the given offset, returning the key and the location of its chunk,
+// as well as the size of the record.
+func readRecord(r io.Reader, offset int64) (string, entry, int64, error) {
+	header := make([]byte, recordHeaderLen)
+	n, err := io.ReadFull(r, header)
+	if err == io.EOF {
+		return "", entry{}, 0, io.EOF
+	}
+	if err != nil {
+		return "", entry{}, 0, errCorruptRecord
+	}
+	payload := make([]byte, binary.LittleEndian.Uint32(header))
+	_, err = io.ReadFull(r, payload)
+	if err != nil || len(payload) < 6 || crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:]) {
+		return "", entry{}, 0, errCorruptRecord
+	}
+	keyLen := int(binary.LittleEndian.Uint16(payload[4:]))
+	if 6+keyLen > len(payload) {
+		return "", entry{}, 0, errCorruptRecord
+	}
+	e := entry{
+		t0:     binary.LittleEndian.Uint32(payload),
+		offset: offset + int64(n) + 6 + int64(keyLen),
+		length: uint32(len(payload) - 6 - keyLen),
+	}
+	return string(payload[6 : 6+keyLen]), e, int64(n + len(payload)), nil
+}
+
+func encodeRecord(key string, t0 uint32, data []byte) []byte {
+	buf := make([]byte, recordHeaderLen+6+len(key)+len(data))
+	payload := buf[recordHeaderLen:]
+	binary.LittleEndian.PutUint32(payload, t0)
+	binary.LittleEndian.PutUint16(payload[4:], uint16(len(key)))
+	copy(payload[6:], key)
+	copy(payload[6+len(key):], data)
+	binary.LittleEndian.PutUint32(buf, uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(payload))
+	return buf
+}
+
+// add adds the chunk to the index of the window. like in cassandra, a chunk replaces
+// any chunk with the same key and t0 that was written before.
+func (w *window) add(key string, e entry) {
+	entries := w.chunks[key]
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].t0 == e.t0 {
+			entries[i] = e
+			return
+		}
+	}
+	w.chunks[key] = append(entries, e)
+}
+
+func (t *table) expired(w *window, now uint32) bool {
+	return uint64(w.start)+uint64(t.windowSize)+uint64(t.ttl) <= uint64(now)
+}
+
+func (d *DiskStore) SetTracer(t opentracing.Tracer) {
+	d.tracer = t
+}
+
+func (d *DiskStore) Add(cwr *mdata.ChunkWriteRequest) {
+	d.writeQueueMeter.Value(len(d.writeQueue))
+	d.writeQueue <- cwr
+}
+
+func (d *DiskStore) processWriteQueue() {
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			d.writeQueueMeter.Value(len(d.writeQueue))
+		case cwr, ok := <-d.writeQueue:
+			if !ok {
+				close(d.writeDone)
+				return
+			}
+			// write all the chunks that are queued up, and sync them to disk at once.
+			// a chunk is only reported as saved once it has been synced, so that it can't be lost on a power loss.
+			batch := []*mdata.ChunkWriteRequest{cwr}
+			dirty := make(map[*window]struct{})
+			for {
+				if w := d.saveChunk(cwr); w != nil {
+					dirty[w] = struct{}{}
+				}
+				if len(batch) == syncBatchSize {
+					break
+				}
+				select {
+				case cwr, ok = <-d.writeQueue:
+				default:
+					cwr, ok = nil, true
+				}
+				if cwr == nil {
+					break
+				}
+				batch = append(batch, cwr)
+			}
+			d.syncWindows(dirty)
+			for _, cwr := range batch {
+				keyStr := cwr.Key.String()
+				cwr.Metric.SyncChunkSaveState(cwr.Chunk.T0)
+				mdata.SendPersistMessage(keyStr, cwr.Chunk.T0)
+				log.Debug("DS: save complete. %s:%d %v", keyStr, cwr.Chunk.T0, cwr.Chunk)
+				diskChunkSaveOk.Inc()
+			}
+			if !ok {
+				close(d.writeDone)
+				return
+			}
+		}
+	}
+}
+
+// saveChunk writes the chunk, retrying until it succeeds. it returns the window it was written to, if any.
+func (d *DiskStore) saveChunk(cwr *mdata.ChunkWriteRequest) *window {
+	d.writeQueueMeter.Value(len(d.writeQueue))
+	log.Debug("DS: starting to save %s:%d %v", cwr.Key, cwr.Chunk.T0, cwr.Chunk)
+	diskPutWaitDuration.Value(time.Now().Sub(cwr.Timestamp))
+
+	data := cwr.Chunk.Encode(cwr.Span)
+	keyStr := cwr.Key.String()
+	attempts := 0
+	for {
+		w, err := d.writeChunk(keyStr, cwr.Chunk.T0, cwr.TTL, data)
+		if err == nil {
+			return w
+		}
+		if (attempts % 20) == 0 {
+			log.Warn("DS: failed to save chunk to disk after %d attempts. %v, %s", attempts+1, cwr.Chunk, err)
+		}
+		diskChunkSaveFail.Inc()
+		time.Sleep(retryDelay(attempts))
+		attempts++
+	}
+}
+
+// syncWindows syncs the chunks files of the given windows to disk, retrying until it succeeds
+func (d *DiskStore) syncWindows(windows map[*window]struct{}) {
+	pre := time.Now()
+	for w := range windows {
+		attempts := 0
+		for {
+			err := w.file.Sync()
+			if pe, ok := err.(*os.PathError); err == nil || ok && pe.Err == os.ErrClosed {
+				// a closed file belongs to a window that has expired in the meantime
+				break
+			}
+			if (attempts % 20) == 0 {
+				log.Warn("DS: failed to sync %s after %d attempts. %s", w.file.Name(), attempts+1, err)
+			}
+			diskSyncFail.Inc()
+			time.Sleep(retryDelay(attempts))
+			attempts++
+		}
+	}
+	diskSyncDuration.Value(time.Since(pre))
+}
+
+// retryDelay returns how long to wait before the next attempt of a failed write
+func retryDelay(attempts int) time.Duration {
+	sleepTime := 100 * attempts
+	if sleepTime > 2000 {
+		sleepTime = 2000
+	}
+	return time.Duration(sleepTime) * time.Millisecond
+}
+
+// writeChunk appends the chunk to the window it belongs to, and returns that window.
+// it returns no window if the chunk was not written because its data would expire right away.
+// it must only be called by the write goroutine.
+func (d *DiskStore) writeChunk(key string, t0, ttl uint32, data []byte) (*window, error) {
+	if len(key) > 0xffff {
+		return nil, errKeyTooLong
+	}
+	pre := time.Now()
+	d.Lock()
+	t := d.tableFor(ttl)
+	start := t0 - t0%t.windowSize
+	w, ok := t.windows[start]
+	if !ok {
+		if t.expired(&window{start: start}, uint32(pre.Unix())) {
+			// no point in writing data that would be removed right away
+			d.Unlock()
+			return nil, nil
+		}
+		var err error
+		w, err = openWindow(filepath.Join(t.dir, strconv.FormatUint(uint64(start), 10)), start)
+		if err == nil {
+			// syncing the file does not sync the directories it was created in
+			err = syncDirs(w.dir, t.dir, d.path)
+		}
+		if err != nil {
+			d.Unlock()
+			return nil, err
+		}
+		t.windows[start] = w
+	}
+	d.Unlock()
+
+	rec := encodeRecord(key, t0, data)
+	_, err := w.file.Write(rec)
+	if err != nil {
+		// don't leave a partial record behind, it would corrupt the records after it
+		w.file.Truncate(w.size)
+		return nil, err
+	}
+	d.Lock()
+	w.add(key, entry{
+		t0:     t0,
+		offset: w.size + int64(len(rec)-len(data)),
+		length: uint32(len(data)),
+	})
+	d.Unlock()
+	w.size += int64(len(rec))
+	diskPutExecDuration.Value(time.Since(pre))
+	return w, nil
+}
+
+// syncDirs syncs the given directories to disk, so that the files created in them are persisted
+func syncDirs(dirs ...string) error {
+	for _, dir := range dirs {
+		f, err := os.Open(dir)
+		if err != nil {
+			return err
+		}
+		err = f.Sync()
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DiskStore) expireLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.shutdown:
+			return
+		case now := <-ticker.C:
+			d.expire(uint32(now.Unix()))
+		}
+	}
+}
+
+// expire removes all windows of which all data has expired
+func (d *DiskStore) expire(now uint32) {
+	var expired []*window
+	d.Lock()
+	for _, t := range d.tables {
+		for start, w := range t.windows {
+			if t.expired(w, now) {
+				delete(t.windows, start)
+				expired = append(expired, w)
+			}
+		}
+	}
+	d.Unlock()
+
+	for _, w := range expired {
+		log.Info("DS: removing expired window %s", w.dir)
+		w.file.Close()
+		err := os.RemoveAll(w.dir)
+		if err != nil {
+			log.Error(3, "DS: failed to remove expired window %s: %s", w.dir, err)
+			continue
+		}
+		diskWindowsExpired.Inc()
+	}
+}
+
+type chunkRef struct {
+	w *window
+	e entry
+}
+
+type chunkRefsAsc []chunkRef
+
+func (c chunkRefsAsc) Len() int           { return len(c) }
+func (c chunkRefsAsc) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c chunkRefsAsc) Less(i, j int) bool { return c[i].e.t0 < c[j].e.t0 }
+
+// Search returns the chunks of the given key and TTL that may contain data in the given range:
+// the chunks with a t0 in the range, as well as the last chunk before it.
+// start inclusive, end exclusive
+func (d *DiskStore) Search(ctx context.Context, key schema.AMKey, ttl, start, end uint32) ([]chunk.IterGen, error) {
+	_, span := tracing.NewSpan(ctx, d.tracer, "DiskStore.Search")
+	defer span.Finish()
+
+	if start >= end {
+		tracing.Failure(span)
+		tracing.Error(span, errInvalidRange)
+		return nil, errInvalidRange
+	}
+	if ctx.Err() != nil {
+		// request has been canceled, so no need to read anything.
+		return nil, nil
+	}
+
+	pre := time.Now()
+	keyStr := key.String()
+
+	// we hold the read lock while reading, so that the windows don't get removed underneath us
+	d.RLock()
+	defer d.RUnlock()
+	t, ok := d.ttlTables[ttl]
+	if !ok {
+		tracing.Failure(span)
+		tracing.Error(span, errTableNotFound)
+		return nil, errTableNotFound
+	}
+
+	var refs []chunkRef
+	for _, w := range t.windows {
+		if w.start >= end {
+			continue
+		}
+		for _, e := range w.chunks[keyStr] {
+			if e.t0 < end {
+				refs = append(refs, chunkRef{w, e})
+			}
+		}
+	}
+	sort.Sort(chunkRefsAsc(refs))
+	// skip all chunks before the last one with a t0 <= start
+	first := sort.Search(len(refs), func(i int) bool { return refs[i].e.t0 > start })
+	if first > 0 {
+		first--
+	}
+	refs = refs[first:]
+
+	itgens := make([]chunk.IterGen, 0, len(refs))
+	for _, ref := range refs {
+		b := make([]byte, ref.e.length)
+		_, err := ref.w.file.ReadAt(b, ref.e.offset)
+		if err == nil && len(b) < 2 {
+			err = errCorruptRecord
+		}
+		var itgen *chunk.IterGen
+		if err == nil {
+			itgen, err = chunk.NewGen(b, ref.e.t0)
+		}
+		if err != nil {
+			tracing.Failure(span)
+			tracing.Error(span, err)
+			return nil, err
+		}
+		itgens = append(itgens, *itgen)
+	}
+
+	diskGetExecDuration.Value(time.Since(pre))
+	diskChunksPerResponse.Value(len(itgens))
+	span.SetTag("chunks", len(itgens))
+	return itgens, nil
+}
+
+// Stop writes out all queued chunks and closes the store.
+func (d *DiskStore) Stop() {
+	close(d.shutdown)
+	close(d.writeQueue)
+	<-d.writeDone
+	d.Lock()
+	defer d.Unlock()
+	for _, t := range d.tables {
+		for _, w := range t.windows {
+			w.file.Close()
+		}
+	}
+}
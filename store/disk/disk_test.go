@@ -0,0 +1,240 @@
+package disk
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/mdata/cache"
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/test"
+)
+
+const ttl = 24 * 60 * 60
+
+func newTestStore(t *testing.T, path string) *DiskStore {
+	config := NewStoreConfig()
+	config.Path = path
+	config.ExpireInterval = 0
+	d, err := NewDiskStore(config, []uint32{ttl})
+	if err != nil {
+		t.Fatalf("failed to create store: %s", err)
+	}
+	return d
+}
+
+func testDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "disk-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func newChunk(t0 uint32) *chunk.Chunk {
+	c := chunk.New(t0)
+	for ts := t0; ts < t0+600; ts += 60 {
+		c.Push(ts, float64(ts))
+	}
+	c.Finish()
+	return c
+}
+
+func writeChunks(t *testing.T, d *DiskStore, key string, t0s ...uint32) {
+	for _, t0 := range t0s {
+		_, err := d.writeChunk(key, t0, ttl, newChunk(t0).Encode(600))
+		if err != nil {
+			t.Fatalf("failed to write chunk %d: %s", t0, err)
+		}
+	}
+}
+
+func searchT0s(t *testing.T, d *DiskStore, suffix int, start, end uint32) []uint32 {
+	itgens, err := d.Search(test.NewContext(), test.GetAMKey(suffix), ttl, start, end)
+	if err != nil {
+		t.Fatalf("search failed: %s", err)
+	}
+	t0s := []uint32{}
+	for _, itgen := range itgens {
+		if itgen.Span != 600 {
+			t.Fatalf("expected span 600, got %d", itgen.Span)
+		}
+		it, err := itgen.Get()
+		if err != nil {
+			t.Fatalf("failed to decode chunk %d: %s", itgen.Ts, err)
+		}
+		if !it.Next() {
+			t.Fatalf("chunk %d has no points", itgen.Ts)
+		}
+		if ts, val := it.Values(); ts != itgen.Ts || val != float64(ts) {
+			t.Fatalf("chunk %d has unexpected first point %d:%f", itgen.Ts, ts, val)
+		}
+		t0s = append(t0s, itgen.Ts)
+	}
+	return t0s
+}
+
+func TestSearch(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+	d := newTestStore(t, dir)
+	defer d.Stop()
+
+	// one hour windows. the chunks are written out of order, and span several windows
+	now := uint32(time.Now().Unix())
+	base := now - now%3600 - 3600
+	writeChunks(t, d, test.GetAMKey(1).String(), base+3000, base+600, base+1200, base+3600, base+3600+600, base+1800, base+2400)
+	writeChunks(t, d, test.GetAMKey(2).String(), base+600)
+
+	cases := []struct {
+		start, end uint32
+		exp        []uint32
+	}{
+		{base + 1200, base + 1800, []uint32{base + 1200}},
+		{base + 1300, base + 1900, []uint32{base + 1200, base + 1800}},
+		{base + 3000, base + 4300, []uint32{base + 3000, base + 3600, base + 4200}},
+		{0, base + 601, []uint32{base + 600}},
+		{0, base + 600, []uint32{}},
+		{base + 5000, base + 6000, []uint32{base + 4200}},
+	}
+	for i, c := range cases {
+		got := searchT0s(t, d, 1, c.start, c.end)
+		if !reflect.DeepEqual(got, c.exp) {
+			t.Fatalf("case %d: expected %v, got %v", i, c.exp, got)
+		}
+	}
+
+	if got := searchT0s(t, d, 3, 0, base+7200); len(got) != 0 {
+		t.Fatalf("expected no chunks for unknown key, got %v", got)
+	}
+	if _, err := d.Search(test.NewContext(), test.GetAMKey(1), 60, 0, base+7200); err != errTableNotFound {
+		t.Fatalf("expected errTableNotFound for unknown TTL, got %v", err)
+	}
+}
+
+func TestSearchOverwrite(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+	d := newTestStore(t, dir)
+	defer d.Stop()
+
+	now := uint32(time.Now().Unix())
+	base := now - now%3600
+	key := test.GetAMKey(1).String()
+	writeChunks(t, d, key, base, base+600, base)
+	if got := searchT0s(t, d, 1, base, base+1200); !reflect.DeepEqual(got, []uint32{base, base + 600}) {
+		t.Fatalf("expected the rewritten chunk to be returned once, got %v", got)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+	d := newTestStore(t, dir)
+
+	now := uint32(time.Now().Unix())
+	base := now - now%3600
+	writeChunks(t, d, test.GetAMKey(1).String(), base, base+600)
+	d.Stop()
+
+	// simulate a crash while writing a record
+	file := filepath.Join(d.ttlTables[ttl].windows[base].dir, chunksFile)
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(encodeRecord(test.GetAMKey(1).String(), base+1200, []byte{1, 2, 3})[:10])
+	f.Close()
+
+	d = newTestStore(t, dir)
+	if got := searchT0s(t, d, 1, base, base+1800); !reflect.DeepEqual(got, []uint32{base, base + 600}) {
+		t.Fatalf("expected chunks to be loaded from disk, got %v", got)
+	}
+	// new chunks must be appended after the last good record
+	writeChunks(t, d, test.GetAMKey(1).String(), base+1200)
+	d.Stop()
+
+	d = newTestStore(t, dir)
+	defer d.Stop()
+	if got := searchT0s(t, d, 1, base, base+1800); !reflect.DeepEqual(got, []uint32{base, base + 600, base + 1200}) {
+		t.Fatalf("expected chunks to be loaded from disk, got %v", got)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+	d := newTestStore(t, dir)
+	defer d.Stop()
+
+	now := uint32(time.Now().Unix())
+	base := now - now%3600
+	key := test.GetAMKey(1).String()
+	writeChunks(t, d, key, base-3600, base)
+	old := d.ttlTables[ttl].windows[base-3600].dir
+
+	// the first window expires once all of its data is older than the TTL
+	d.expire(base + ttl - 1)
+	if _, err := os.Stat(old); err != nil {
+		t.Fatalf("expected window %s to still exist, got %s", old, err)
+	}
+	d.expire(base + ttl)
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Fatalf("expected window %s to be removed, got %v", old, err)
+	}
+	if got := searchT0s(t, d, 1, 0, base+3600); !reflect.DeepEqual(got, []uint32{base}) {
+		t.Fatalf("expected only the chunk of the remaining window, got %v", got)
+	}
+
+	// chunks of windows that have already expired are not written at all
+	writeChunks(t, d, key, base-ttl-3600)
+	if len(d.ttlTables[ttl].windows) != 1 {
+		t.Fatalf("expected no window to be created for expired data")
+	}
+}
+
+func TestAdd(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+	d := newTestStore(t, dir)
+
+	now := uint32(time.Now().Unix())
+	base := now - now%3600
+	key := test.GetAMKey(1)
+	ret := conf.Retentions{conf.NewRetentionMT(60, ttl, 600, 5, true)}
+	metric := mdata.NewAggMetric(d, &cache.MockCache{}, key, ret, 0, nil, false)
+	for _, t0 := range []uint32{base, base + 600} {
+		cwr := mdata.NewChunkWriteRequest(metric, key, newChunk(t0), ttl, 600, time.Now())
+		d.Add(&cwr)
+	}
+	// stop waits for the queued chunks to be written
+	d.Stop()
+
+	d = newTestStore(t, dir)
+	defer d.Stop()
+	if got := searchT0s(t, d, 1, base, base+1200); !reflect.DeepEqual(got, []uint32{base, base + 600}) {
+		t.Fatalf("expected the added chunks, got %v", got)
+	}
+}
+
+func TestSearchCanceled(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+	d := newTestStore(t, dir)
+	defer d.Stop()
+
+	now := uint32(time.Now().Unix())
+	writeChunks(t, d, test.GetAMKey(1).String(), now-now%600)
+	ctx, cancel := context.WithCancel(test.NewContext())
+	cancel()
+	itgens, err := d.Search(ctx, test.GetAMKey(1), ttl, 0, now+600)
+	if err != nil || len(itgens) != 0 {
+		t.Fatalf("expected no chunks and no error for a canceled search, got %d chunks, err %v", len(itgens), err)
+	}
+}
@@ -0,0 +1,167 @@
+package tiered
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"time"
+
+	"github.com/grafana/metrictank/mdata/chunk"
+)
+
+// a block holds all chunks of a series that start within one day:
+//
+//   <chunk 1>...<chunk n><index><footer>
+//
+// every chunk is stored in the same format as in cassandra: a format byte, a span code and the chunk data.
+// the index has an entry per chunk, in order of t0: its t0, offset and length (3 x uint32).
+// the footer has the offset of the index (uint64), the number of chunks (uint32), the crc32 of
+// the index (uint32), the format version (uint32) and the magic bytes.
+// readers fetch the end of the block first, which typically holds the complete index, and then
+// only the range of chunks they need.
+
+const (
+	blockVersion   = 1
+	blockMagic     = "MTCB"
+	footerLen      = 24
+	indexEntryLen  = 12
+	blockSuffixLen = 4096 // how much of the end of a block to fetch to get its footer, and hopefully its index
+
+	day       = 24 * 60 * 60
+	dayFormat = "2006-01-02"
+)
+
+var errCorruptBlock = errors.New("corrupt block")
+
+type indexEntry struct {
+	t0     uint32
+	offset uint32
+	length uint32
+}
+
+// blockName returns the name of the block of the given series and day
+func blockName(key string, dayNum uint32) string {
+	return key + "/" + dayName(dayNum)
+}
+
+// dayName returns the date of the given day, e.g. 2018-06-01
+func dayName(dayNum uint32) string {
+	return time.Unix(int64(dayNum)*day, 0).UTC().Format(dayFormat)
+}
+
+// encodeChunk returns the chunk data in the same format as it is stored in cassandra
+func encodeChunk(itgen chunk.IterGen) ([]byte, error) {
+	if itgen.Span == 0 && itgen.Format == chunk.FormatStandardGoTsz {
+		return append([]byte{byte(chunk.FormatStandardGoTsz)}, itgen.B...), nil
+	}
+	spanCode, ok := chunk.RevChunkSpans[itgen.Span]
+	if !ok {
+		return nil, fmt.Errorf("chunk span invalid: %d", itgen.Span)
+	}
+	format := itgen.Format
+	if format == chunk.FormatStandardGoTsz {
+		format = chunk.FormatStandardGoTszWithSpan
+	}
+	return append([]byte{byte(format), byte(spanCode)}, itgen.B...), nil
+}
+
+// encodeBlock encodes the given chunks, which must be sorted by t0, into a block
+func encodeBlock(itgens []chunk.IterGen) ([]byte, error) {
+	var buf []byte
+	index := make([]byte, 0, len(itgens)*indexEntryLen)
+	for _, itgen := range itgens {
+		data, err := encodeChunk(itgen)
+		if err != nil {
+			return nil, err
+		}
+		index = appendUint32(index, itgen.Ts)
+		index = appendUint32(index, uint32(len(buf)))
+		index = appendUint32(index, uint32(len(data)))
+		buf = append(buf, data...)
+	}
+	footer := make([]byte, footerLen)
+	binary.LittleEndian.PutUint64(footer, uint64(len(buf)))
+	binary.LittleEndian.PutUint32(footer[8:], uint32(len(itgens)))
+	binary.LittleEndian.PutUint32(footer[12:], crc32.ChecksumIEEE(index))
+	binary.LittleEndian.PutUint32(footer[16:], blockVersion)
+	copy(footer[20:], blockMagic)
+	buf = append(buf, index...)
+	return append(buf, footer...), nil
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// readIndex reads the index of the given block
+func readIndex(ctx context.Context, bucket Bucket, name string) ([]indexEntry, error) {
+	suffix, err := bucket.GetSuffix(ctx, name, blockSuffixLen)
+	if err != nil {
+		return nil, err
+	}
+	if len(suffix) < footerLen {
+		return nil, errCorruptBlock
+	}
+	footer := suffix[len(suffix)-footerLen:]
+	if string(footer[20:]) != blockMagic {
+		return nil, errCorruptBlock
+	}
+	if version := binary.LittleEndian.Uint32(footer[16:]); version != blockVersion {
+		return nil, fmt.Errorf("unknown block version %d", version)
+	}
+	indexOffset := int64(binary.LittleEndian.Uint64(footer))
+	indexLen := int64(binary.LittleEndian.Uint32(footer[8:])) * indexEntryLen
+
+	var index []byte
+	if indexLen+footerLen <= int64(len(suffix)) {
+		index = suffix[int64(len(suffix))-footerLen-indexLen : len(suffix)-footerLen]
+	} else {
+		index, err = bucket.GetRange(ctx, name, indexOffset, indexLen)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if crc32.ChecksumIEEE(index) != binary.LittleEndian.Uint32(footer[12:]) {
+		return nil, errCorruptBlock
+	}
+	entries := make([]indexEntry, len(index)/indexEntryLen)
+	for i := range entries {
+		e := index[i*indexEntryLen:]
+		entries[i] = indexEntry{
+			t0:     binary.LittleEndian.Uint32(e),
+			offset: binary.LittleEndian.Uint32(e[4:]),
+			length: binary.LittleEndian.Uint32(e[8:]),
+		}
+	}
+	return entries, nil
+}
+
+// readChunks reads the chunks of the given index entries, which must be consecutive, in one request.
+func readChunks(ctx context.Context, bucket Bucket, name string, entries []indexEntry) ([]chunk.IterGen, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	first := entries[0]
+	last := entries[len(entries)-1]
+	data, err := bucket.GetRange(ctx, name, int64(first.offset), int64(last.offset+last.length-first.offset))
+	if err != nil {
+		return nil, err
+	}
+	itgens := make([]chunk.IterGen, 0, len(entries))
+	for _, e := range entries {
+		start := e.offset - first.offset
+		if e.length < 2 || int(start+e.length) > len(data) {
+			return nil, errCorruptBlock
+		}
+		itgen, err := chunk.NewGen(data[start:start+e.length], e.t0)
+		if err != nil {
+			return nil, err
+		}
+		itgens = append(itgens, *itgen)
+	}
+	return itgens, nil
+}
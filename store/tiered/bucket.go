@@ -0,0 +1,114 @@
+package tiered
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var errNotFound = errors.New("object not found")
+
+// Bucket is an object store for the blocks of the cold store
+type Bucket interface {
+	// Put stores the object under the given name, replacing any existing one
+	Put(ctx context.Context, name string, data []byte) error
+	// Get returns the whole object
+	Get(ctx context.Context, name string) ([]byte, error)
+	// GetRange returns length bytes of the object, starting at offset
+	GetRange(ctx context.Context, name string, offset, length int64) ([]byte, error)
+	// GetSuffix returns the last length bytes of the object, or the whole object if it is smaller
+	GetSuffix(ctx context.Context, name string, length int64) ([]byte, error)
+}
+
+// FSBucket is a Bucket that stores the objects as files in a directory.
+// it can be used in place of an S3-compatible object store, e.g. for testing.
+type FSBucket struct {
+	path string
+}
+
+func NewFSBucket(path string) (*FSBucket, error) {
+	err := os.MkdirAll(path, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &FSBucket{path}, nil
+}
+
+func (b *FSBucket) Put(ctx context.Context, name string, data []byte) error {
+	file := filepath.Join(b.path, filepath.FromSlash(name))
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first, so that readers never see a partial object
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (b *FSBucket) Get(ctx context.Context, name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(b.path, filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		return nil, errNotFound
+	}
+	return data, err
+}
+
+func (b *FSBucket) GetRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	f, err := b.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, length)
+	_, err = f.ReadAt(data, offset)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (b *FSBucket) GetSuffix(ctx context.Context, name string, length int64) ([]byte, error) {
+	f, err := b.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if length > fi.Size() {
+		length = fi.Size()
+	}
+	data := make([]byte, length)
+	_, err = f.ReadAt(data, fi.Size()-length)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (b *FSBucket) open(name string) (*os.File, error) {
+	f, err := os.Open(filepath.Join(b.path, filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		return nil, errNotFound
+	}
+	return f, err
+}
@@ -0,0 +1,137 @@
+package tiered
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/raintank/dur"
+	"github.com/raintank/worldping-api/pkg/log"
+	"github.com/rakyll/globalconf"
+)
+
+type StoreConfig struct {
+	Enabled         bool
+	Age             string
+	Margin          string
+	Backend         string
+	S3Endpoint      string
+	S3Bucket        string
+	S3Region        string
+	S3AccessKey     string
+	S3SecretKey     string
+	FSPath          string
+	Timeout         time.Duration
+	MoveInterval    time.Duration
+	MoveConcurrency int
+}
+
+// return StoreConfig with default values set.
+func NewStoreConfig() *StoreConfig {
+	return &StoreConfig{
+		Enabled:         false,
+		Age:             "30d",
+		Margin:          "2d",
+		Backend:         "s3",
+		S3Endpoint:      "https://s3.amazonaws.com",
+		S3Bucket:        "metrictank",
+		S3Region:        "us-east-1",
+		FSPath:          "/var/lib/metrictank/cold",
+		Timeout:         30 * time.Second,
+		MoveInterval:    time.Hour,
+		MoveConcurrency: 10,
+	}
+}
+
+var CliConfig = NewStoreConfig()
+
+func ConfigSetup() *flag.FlagSet {
+	cs := flag.NewFlagSet("cold-store", flag.ExitOnError)
+	cs.BoolVar(&CliConfig.Enabled, "enabled", CliConfig.Enabled, "move old chunks from the store into an object store")
+	cs.StringVar(&CliConfig.Age, "age", CliConfig.Age, "chunks older than this are moved. (must be at least 1 day)")
+	cs.StringVar(&CliConfig.Margin, "margin", CliConfig.Margin, "how much longer than age the store keeps the chunks that are moved, so that they can still be moved when the object store is unavailable for a while. (must be at least 1 day)")
+	cs.StringVar(&CliConfig.Backend, "backend", CliConfig.Backend, "where to store the blocks of old chunks: s3 or fs")
+	cs.StringVar(&CliConfig.S3Endpoint, "s3-endpoint", CliConfig.S3Endpoint, "url of the S3-compatible api")
+	cs.StringVar(&CliConfig.S3Bucket, "s3-bucket", CliConfig.S3Bucket, "bucket to store the blocks in")
+	cs.StringVar(&CliConfig.S3Region, "s3-region", CliConfig.S3Region, "region of the bucket")
+	cs.StringVar(&CliConfig.S3AccessKey, "s3-access-key", CliConfig.S3AccessKey, "access key for the S3-compatible api")
+	cs.StringVar(&CliConfig.S3SecretKey, "s3-secret-key", CliConfig.S3SecretKey, "secret key for the S3-compatible api")
+	cs.StringVar(&CliConfig.FSPath, "fs-path", CliConfig.FSPath, "directory to store the blocks in, when using the fs backend")
+	cs.DurationVar(&CliConfig.Timeout, "timeout", CliConfig.Timeout, "timeout of requests to the object store")
+	cs.DurationVar(&CliConfig.MoveInterval, "move-interval", CliConfig.MoveInterval, "how often to look for chunks that are old enough to be moved")
+	cs.IntVar(&CliConfig.MoveConcurrency, "move-concurrency", CliConfig.MoveConcurrency, "number of series to move concurrently")
+	globalconf.Register("cold-store", cs)
+	return cs
+}
+
+func ConfigProcess() {
+	if !CliConfig.Enabled {
+		return
+	}
+	_, err := CliConfig.age()
+	if err != nil {
+		log.Fatal(4, "cold-store: %s", err)
+	}
+	_, err = CliConfig.margin()
+	if err != nil {
+		log.Fatal(4, "cold-store: %s", err)
+	}
+	if CliConfig.Backend != "s3" && CliConfig.Backend != "fs" {
+		log.Fatal(4, "cold-store: unknown backend %q", CliConfig.Backend)
+	}
+	if CliConfig.MoveConcurrency < 1 {
+		log.Fatal(4, "cold-store: move-concurrency must be at least 1")
+	}
+}
+
+// age returns the age in seconds
+func (c *StoreConfig) age() (uint32, error) {
+	age, err := dur.ParseNDuration(c.Age)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse age: %s", err)
+	}
+	if age < day {
+		return 0, errAgeTooSmall
+	}
+	return age, nil
+}
+
+// margin returns the margin in seconds
+func (c *StoreConfig) margin() (uint32, error) {
+	margin, err := dur.ParseNDuration(c.Margin)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse margin: %s", err)
+	}
+	if margin < day {
+		return 0, errMarginTooSmall
+	}
+	return margin, nil
+}
+
+// HotTTLs returns the TTLs that the hot store needs tables for, when the cold store is enabled.
+// besides the capped TTLs, it keeps the original ones, which have the chunks that were saved
+// before the cold store was enabled.
+func (c *StoreConfig) HotTTLs(ttls []uint32) []uint32 {
+	if !c.Enabled {
+		return ttls
+	}
+	age, err := c.age()
+	if err != nil {
+		return ttls
+	}
+	margin, err := c.margin()
+	if err != nil {
+		return ttls
+	}
+	seen := make(map[uint32]struct{})
+	var hot []uint32
+	for _, ttl := range ttls {
+		for _, ttl := range []uint32{ttl, hotTTL(ttl, age, margin)} {
+			if _, ok := seen[ttl]; !ok {
+				seen[ttl] = struct{}{}
+				hot = append(hot, ttl)
+			}
+		}
+	}
+	return hot
+}
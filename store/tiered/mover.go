@@ -0,0 +1,324 @@
+package tiered
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	schema "gopkg.in/raintank/schema.v1"
+
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/idx"
+	"github.com/grafana/metrictank/mdata"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/raintank/worldping-api/pkg/log"
+)
+
+// the mover works out what to move from the metric index and from the progress it keeps in the bucket,
+// not from any node-local state, so that any primary node can take over from another:
+// for every partition, the bucket has an object that lists the days that have been moved.
+// every run, the days that are older than age and not listed yet are moved, newest first, for all series
+// of the partition. this includes the days that were stored before the cold store was enabled.
+
+// maxBatchDays is how many days the mover moves at once. every series is read from the hot store once
+// per batch, and progress is saved after every batch.
+const maxBatchDays = 31
+
+// Index is the part of the metric index that the mover uses to find the series to move
+type Index interface {
+	ForEach(fn func(idx.Archive))
+}
+
+// coldSeries is a series whose chunks are moved to the cold store
+type coldSeries struct {
+	key        schema.AMKey
+	ttl        uint32
+	lastUpdate int64
+}
+
+// Start starts moving chunks every move-interval, for the series in the given index.
+// the index must be initialized.
+func (t *TieredStore) Start(index Index) {
+	t.index = index
+	if t.interval > 0 {
+		t.wg.Add(1)
+		go t.moveLoop(t.interval)
+	}
+}
+
+func (t *TieredStore) moveLoop(interval time.Duration) {
+	defer t.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.shutdown:
+			return
+		case now := <-ticker.C:
+			t.move(uint32(now.Unix()))
+		}
+	}
+}
+
+// move moves the chunks of all days that are older than age from the hot store into the cold store.
+// like saving chunks, this is done by primary nodes only.
+func (t *TieredStore) move(now uint32) {
+	if !cluster.Manager.IsPrimary() || now < t.age+day {
+		return
+	}
+	// the first day that is too recent to be moved
+	last := (now - t.age) / day
+
+	series := t.listSeries()
+	partitions := make([]int32, 0, len(series))
+	for p := range series {
+		partitions = append(partitions, p)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	for _, p := range partitions {
+		select {
+		case <-t.shutdown:
+			return
+		default:
+		}
+		t.movePartition(p, series[p], last, now)
+	}
+}
+
+// listSeries returns the series in the index that have a TTL larger than age, per partition
+func (t *TieredStore) listSeries() map[int32][]coldSeries {
+	series := make(map[int32][]coldSeries)
+	t.index.ForEach(func(archive idx.Archive) {
+		rets := mdata.Schemas.Get(archive.SchemaId).Retentions
+		for i, ret := range rets {
+			ttl := uint32(ret.MaxRetention())
+			if hotTTL(ttl, t.age, t.margin) == ttl {
+				continue
+			}
+			for _, key := range amKeys(archive.Id, i, ret, mdata.Aggregations.Get(archive.AggId)) {
+				series[archive.Partition] = append(series[archive.Partition], coldSeries{
+					key:        key,
+					ttl:        ttl,
+					lastUpdate: archive.LastUpdate,
+				})
+			}
+		}
+	})
+	return series
+}
+
+// amKeys returns the keys of the series that are stored for the i'th retention of a metric, like AggMetric does
+func amKeys(mkey schema.MKey, i int, ret conf.Retention, agg conf.Aggregation) []schema.AMKey {
+	if i == 0 {
+		return []schema.AMKey{{MKey: mkey}}
+	}
+	var methods []schema.Method
+	add := func(method schema.Method) {
+		for _, m := range methods {
+			if m == method {
+				return
+			}
+		}
+		methods = append(methods, method)
+	}
+	for _, method := range agg.AggregationMethod {
+		switch method {
+		case conf.Avg:
+			add(schema.Sum)
+			add(schema.Cnt)
+		case conf.Sum:
+			add(schema.Sum)
+		case conf.Lst:
+			add(schema.Lst)
+		case conf.Max:
+			add(schema.Max)
+		case conf.Min:
+			add(schema.Min)
+		}
+	}
+	keys := make([]schema.AMKey, 0, len(methods))
+	for _, method := range methods {
+		keys = append(keys, schema.AMKey{MKey: mkey, Archive: schema.NewArchive(method, uint32(ret.SecondsPerPoint))})
+	}
+	return keys
+}
+
+// movePartition moves the days before last that have not been moved yet, for the given series of the partition
+func (t *TieredStore) movePartition(partition int32, series []coldSeries, last, now uint32) {
+	moved, err := t.readProgress(partition)
+	if err != nil {
+		log.Error(3, "TS: failed to read which days of partition %d have been moved: %s", partition, err)
+		return
+	}
+
+	// the oldest day that may still have chunks in the hot store
+	first := last
+	for _, s := range series {
+		if s.ttl >= now {
+			first = 0
+			break
+		}
+		if (now-s.ttl)/day < first {
+			first = (now - s.ttl) / day
+		}
+	}
+	var pending []uint32
+	for d := last; d > first; d-- {
+		if _, ok := moved[d-1]; !ok {
+			pending = append(pending, d-1)
+		}
+	}
+
+	for len(pending) > 0 {
+		n := 1
+		for n < len(pending) && pending[0]-pending[n] < maxBatchDays {
+			n++
+		}
+		batch := pending[:n]
+		pending = pending[n:]
+
+		log.Info("TS: moving the chunks of %d series of partition %d from %s to %s to the cold store", len(series), partition, dayName(batch[n-1]), dayName(batch[0]))
+		if !t.moveDays(series, batch, now) {
+			// retry on the next run. the older days do not depend on this batch, so move on to them
+			continue
+		}
+		for _, d := range batch {
+			moved[d] = struct{}{}
+			coldDaysMoved.Inc()
+			// only chunks saved since we started are known to have been stored with the capped TTL
+			if d*day >= t.started && uint64(d)*day+uint64(t.age)+uint64(t.margin) <= uint64(now) {
+				coldDaysLost.Inc()
+				log.Error(3, "TS: day %s of partition %d was moved after its first chunks may have expired from the hot store", dayName(d), partition)
+			}
+		}
+		err = t.writeProgress(partition, moved, first)
+		if err != nil {
+			log.Error(3, "TS: failed to save which days of partition %d have been moved: %s", partition, err)
+			return
+		}
+	}
+}
+
+// moveDays moves the chunks of the given series and days, which are sorted in descending order.
+// it returns whether all series were moved. blocks are written as a whole, so in case of failure,
+// the days can simply be moved again.
+func (t *TieredStore) moveDays(series []coldSeries, days []uint32, now uint32) bool {
+	oldest := int64(days[len(days)-1]) * day
+	ch := make(chan coldSeries)
+	var failed uint32
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < t.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range ch {
+				err := t.moveSeries(s, days, now)
+				if err != nil {
+					coldMoveFail.Inc()
+					log.Warn("TS: failed to move the chunks of %s: %s", s.key, err)
+					mu.Lock()
+					failed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	stopped := false
+	for _, s := range series {
+		// the index is only persisted every so often, so after a restart, lastUpdate may be somewhat behind
+		if s.lastUpdate+day < oldest {
+			continue
+		}
+		select {
+		case ch <- s:
+			continue
+		case <-t.shutdown:
+			stopped = true
+		}
+		break
+	}
+	close(ch)
+	wg.Wait()
+	return failed == 0 && !stopped
+}
+
+// moveSeries writes a block for each of the given days that the series has chunks for
+func (t *TieredStore) moveSeries(s coldSeries, days []uint32, now uint32) error {
+	span := t.tracer.StartSpan("TieredStore.moveSeries")
+	defer span.Finish()
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+
+	itgens, err := t.searchHot(ctx, s.key, s.ttl, days[len(days)-1]*day, (days[0]+1)*day, now)
+	if err != nil {
+		return err
+	}
+	key := s.key.String()
+	for _, d := range days {
+		// the hot store may include a chunk from before the day
+		from := sort.Search(len(itgens), func(i int) bool { return itgens[i].Ts >= d*day })
+		to := sort.Search(len(itgens), func(i int) bool { return itgens[i].Ts >= (d+1)*day })
+		if from == to {
+			continue
+		}
+		block, err := encodeBlock(itgens[from:to])
+		if err != nil {
+			return err
+		}
+		err = t.bucket.Put(ctx, blockName(key, d), block)
+		if err != nil {
+			return err
+		}
+		coldBlocksWritten.Inc()
+	}
+	return nil
+}
+
+// progressName returns the name of the object that lists the days of the partition that have been moved.
+// it can't clash with the name of a block, as those start with the org id.
+func progressName(partition int32) string {
+	return "_moved/" + strconv.Itoa(int(partition))
+}
+
+// readProgress returns the days of the partition that have been moved
+func (t *TieredStore) readProgress(partition int32) (map[uint32]struct{}, error) {
+	moved := make(map[uint32]struct{})
+	data, err := t.bucket.Get(context.Background(), progressName(partition))
+	if err == errNotFound {
+		return moved, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Fields(string(data)) {
+		date, err := time.Parse(dayFormat, line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid day %q in %s", line, progressName(partition))
+		}
+		moved[uint32(date.Unix()/day)] = struct{}{}
+	}
+	return moved, nil
+}
+
+// writeProgress saves the days of the partition that have been moved, as of the given day
+func (t *TieredStore) writeProgress(partition int32, moved map[uint32]struct{}, first uint32) error {
+	days := make([]uint32, 0, len(moved))
+	for d := range moved {
+		if d >= first {
+			days = append(days, d)
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i] < days[j] })
+	var buf bytes.Buffer
+	for _, d := range days {
+		buf.WriteString(dayName(d))
+		buf.WriteByte('\n')
+	}
+	return t.bucket.Put(context.Background(), progressName(partition), buf.Bytes())
+}
@@ -0,0 +1,190 @@
+package tiered
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Bucket is a Bucket in an S3-compatible object store, such as AWS S3 or MinIO.
+// objects are addressed path-style: <endpoint>/<bucket>/<name>, and requests are signed
+// with AWS signature version 4.
+type S3Bucket struct {
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+func NewS3Bucket(endpoint, bucket, region, accessKey, secretKey string, timeout time.Duration) (*S3Bucket, error) {
+	u, err := url.Parse(strings.TrimRight(endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q: scheme must be http or https", endpoint)
+	}
+	return &S3Bucket{
+		endpoint:  u,
+		bucket:    bucket,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (b *S3Bucket) Put(ctx context.Context, name string, data []byte) error {
+	hash := sha256.Sum256(data)
+	_, err := b.do(ctx, "PUT", name, data, hex.EncodeToString(hash[:]), "")
+	return err
+}
+
+func (b *S3Bucket) Get(ctx context.Context, name string) ([]byte, error) {
+	return b.do(ctx, "GET", name, nil, emptyPayloadHash, "")
+}
+
+func (b *S3Bucket) GetRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	data, err := b.do(ctx, "GET", name, nil, emptyPayloadHash, fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != length {
+		return nil, fmt.Errorf("expected %d bytes of %s, got %d", length, name, len(data))
+	}
+	return data, nil
+}
+
+func (b *S3Bucket) GetSuffix(ctx context.Context, name string, length int64) ([]byte, error) {
+	return b.do(ctx, "GET", name, nil, emptyPayloadHash, fmt.Sprintf("bytes=-%d", length))
+}
+
+func (b *S3Bucket) do(ctx context.Context, method, name string, body []byte, payloadHash, byteRange string) ([]byte, error) {
+	u := *b.endpoint
+	u.Path = u.Path + "/" + b.bucket + "/" + name
+	u.RawPath = uriEncode(u.Path, false)
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+	signV4(req, payloadHash, b.region, b.accessKey, b.secretKey, time.Now())
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		if byteRange != "" && resp.StatusCode == http.StatusOK {
+			return nil, fmt.Errorf("range request for %s was not honoured", name)
+		}
+		return data, nil
+	case http.StatusNotFound:
+		return nil, errNotFound
+	}
+	return nil, fmt.Errorf("%s %s: %s: %s", method, name, resp.Status, bytes.TrimSpace(data))
+}
+
+// signV4 signs the request with AWS signature version 4, for the s3 service.
+// see https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func signV4(req *http.Request, payloadHash, region, accessKey, secretKey string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "range" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders bytes.Buffer
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s,SignedHeaders=%s,Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func canonicalQuery(values url.Values) string {
+	var params []string
+	for key, vals := range values {
+		for _, val := range vals {
+			params = append(params, uriEncode(key, true)+"="+uriEncode(val, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// uriEncode encodes the string the way AWS expects it: every byte except the unreserved characters is percent-encoded.
+// slashes are only encoded if encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			buf.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,263 @@
+package tiered
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	schema "gopkg.in/raintank/schema.v1"
+
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/stats"
+	"github.com/grafana/metrictank/tracing"
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+var (
+	errAgeTooSmall    = errors.New("age must be at least 1 day")
+	errMarginTooSmall = errors.New("margin must be at least 1 day")
+
+	// metric store.cold.get.exec is the duration of getting chunks from the cold store
+	coldGetExecDuration = stats.NewLatencyHistogram15s32("store.cold.get.exec")
+	// metric store.cold.chunks_per_response is how many chunks are retrieved from the cold store per response in get queries
+	coldChunksPerResponse = stats.NewMeter32("store.cold.chunks_per_response", false)
+	// metric store.cold.blocks_written is how many blocks have been written to the cold store
+	coldBlocksWritten = stats.NewCounter32("store.cold.blocks_written")
+	// metric store.cold.move_fail is how many series failed to be moved to the cold store. they are retried on the next move
+	coldMoveFail = stats.NewCounter32("store.cold.move_fail")
+	// metric store.cold.days_moved is how many days of a partition have been moved to the cold store
+	coldDaysMoved = stats.NewCounter32("store.cold.days_moved")
+	// metric store.cold.days_lost is how many days of a partition were moved after their first chunks may have expired from the hot store
+	coldDaysLost = stats.NewCounter32("store.cold.days_lost")
+)
+
+// hotTTL returns the TTL that chunks with the given TTL are stored with in the hot store.
+// chunks that are to be moved are kept for margin longer than age, so that the mover has time to move them.
+func hotTTL(ttl, age, margin uint32) uint32 {
+	if uint64(ttl) > uint64(age)+uint64(margin) {
+		return age + margin
+	}
+	return ttl
+}
+
+// TieredStore is an mdata.Store that moves chunks older than age from a hot store, such as cassandra,
+// into per-series-per-day blocks in a cold store (a Bucket), e.g. an S3-compatible object store.
+//
+// only the chunks of series with a TTL larger than age (plus a margin) are moved. the hot store
+// keeps those for a shorter TTL, just long enough to move them. the chunks that were saved before
+// the cold store was enabled are still in the table of their original TTL, from where they are moved
+// as well. searches fan out to both stores.
+// the cold store does not remove any data, that is up to the lifecycle rules of the bucket.
+type TieredStore struct {
+	hot         mdata.Store
+	bucket      Bucket
+	index       Index
+	age         uint32
+	margin      uint32
+	interval    time.Duration
+	concurrency int
+	started     uint32
+	tracer      opentracing.Tracer
+	shutdown    chan struct{}
+	wg          sync.WaitGroup
+}
+
+func NewTieredStore(config *StoreConfig, hot mdata.Store) (*TieredStore, error) {
+	age, err := config.age()
+	if err != nil {
+		return nil, err
+	}
+	margin, err := config.margin()
+	if err != nil {
+		return nil, err
+	}
+	var bucket Bucket
+	if config.Backend == "fs" {
+		bucket, err = NewFSBucket(config.FSPath)
+	} else {
+		bucket, err = NewS3Bucket(config.S3Endpoint, config.S3Bucket, config.S3Region, config.S3AccessKey, config.S3SecretKey, config.Timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &TieredStore{
+		hot:         hot,
+		bucket:      bucket,
+		age:         age,
+		margin:      margin,
+		interval:    config.MoveInterval,
+		concurrency: config.MoveConcurrency,
+		started:     uint32(time.Now().Unix()),
+		tracer:      opentracing.NoopTracer{},
+		shutdown:    make(chan struct{}),
+	}, nil
+}
+
+func (t *TieredStore) SetTracer(tracer opentracing.Tracer) {
+	t.tracer = tracer
+	t.hot.SetTracer(tracer)
+}
+
+// Add adds the chunk to the hot store. the mover finds the series to move in the index,
+// so every series that has chunks in the hot store will have them moved.
+func (t *TieredStore) Add(cwr *mdata.ChunkWriteRequest) {
+	ttl := hotTTL(cwr.TTL, t.age, t.margin)
+	if ttl != cwr.TTL {
+		req := *cwr
+		req.TTL = ttl
+		cwr = &req
+	}
+	t.hot.Add(cwr)
+}
+
+// Search searches the hot store and, for data older than age, the cold store.
+// start inclusive, end exclusive
+func (t *TieredStore) Search(ctx context.Context, key schema.AMKey, ttl, start, end uint32) ([]chunk.IterGen, error) {
+	hot := hotTTL(ttl, t.age, t.margin)
+	if hot == ttl {
+		return t.hot.Search(ctx, key, ttl, start, end)
+	}
+
+	now := uint32(time.Now().Unix())
+	itgens, err := t.searchHot(ctx, key, ttl, start, end, now)
+	if err != nil {
+		return nil, err
+	}
+	if now < t.age || start < now-t.age {
+		coldItgens, err := t.searchCold(ctx, key.String(), start, end, now)
+		if err != nil {
+			return nil, err
+		}
+		itgens = merge(itgens, coldItgens)
+	}
+
+	// like the hot store, only return the last chunk before start, and the chunks after it
+	first := sort.Search(len(itgens), func(i int) bool { return itgens[i].Ts > start })
+	if first > 0 {
+		first--
+	}
+	return itgens[first:], nil
+}
+
+// searchHot searches the hot store for the chunks of a series with a TTL larger than age.
+// they are in the table of the capped TTL or, if they were saved before the cold store was
+// enabled, in the table of the original TTL.
+func (t *TieredStore) searchHot(ctx context.Context, key schema.AMKey, ttl, start, end, now uint32) ([]chunk.IterGen, error) {
+	var itgens []chunk.IterGen
+	hot := hotTTL(ttl, t.age, t.margin)
+	if now < hot || end > now-hot {
+		capped, err := t.hot.Search(ctx, key, hot, start, end)
+		if err != nil {
+			return nil, err
+		}
+		itgens = capped
+	}
+	if now < ttl || end > now-ttl {
+		orig, err := t.hot.Search(ctx, key, ttl, start, end)
+		if err != nil {
+			return nil, err
+		}
+		itgens = merge(itgens, orig)
+	}
+	return itgens, nil
+}
+
+// merge merges the chunks of a series found in two places. they may overlap: e.g. the chunks
+// of the days that have been moved, but not yet expired from the hot store, are in both stores.
+func merge(a, b []chunk.IterGen) []chunk.IterGen {
+	if len(b) == 0 {
+		return a
+	}
+	if len(a) == 0 {
+		return b
+	}
+	seen := make(map[uint32]struct{}, len(a))
+	for _, itgen := range a {
+		seen[itgen.Ts] = struct{}{}
+	}
+	for _, itgen := range b {
+		if _, ok := seen[itgen.Ts]; !ok {
+			a = append(a, itgen)
+		}
+	}
+	sort.Sort(chunk.IterGensAsc(a))
+	return a
+}
+
+// searchCold returns the chunks in the cold store with a t0 < end, as of the day that contains start.
+// if there is no chunk with a t0 == start, it also returns the last chunk before start.
+func (t *TieredStore) searchCold(ctx context.Context, key string, start, end, now uint32) ([]chunk.IterGen, error) {
+	_, span := tracing.NewSpan(ctx, t.tracer, "TieredStore.searchCold")
+	defer span.Finish()
+	pre := time.Now()
+
+	// the mover only writes the blocks of the days that are older than age, so don't look for any others
+	var last uint32
+	if now > t.age {
+		last = (now - t.age) / day
+	}
+
+	var itgens []chunk.IterGen
+	for d := start / day; d <= (end-1)/day && d < last; d++ {
+		if ctx.Err() != nil {
+			// request has been canceled, so no need to continue
+			return nil, nil
+		}
+		name := blockName(key, d)
+		index, err := readIndex(ctx, t.bucket, name)
+		if err == errNotFound {
+			continue
+		}
+		if err != nil {
+			tracing.Failure(span)
+			tracing.Error(span, err)
+			return nil, err
+		}
+		n := sort.Search(len(index), func(i int) bool { return index[i].t0 >= end })
+		dayItgens, err := readChunks(ctx, t.bucket, name, index[:n])
+		if err != nil {
+			tracing.Failure(span)
+			tracing.Error(span, err)
+			return nil, err
+		}
+		itgens = append(itgens, dayItgens...)
+	}
+
+	// the chunk that contains start may have started the day before
+	if start >= day && start/day-1 < last && (len(itgens) == 0 || itgens[0].Ts > start) {
+		prev, err := t.lastColdChunk(ctx, key, start/day-1)
+		if err != nil {
+			tracing.Failure(span)
+			tracing.Error(span, err)
+			return nil, err
+		}
+		itgens = append(prev, itgens...)
+	}
+
+	coldGetExecDuration.Value(time.Since(pre))
+	coldChunksPerResponse.Value(len(itgens))
+	span.SetTag("chunks", len(itgens))
+	return itgens, nil
+}
+
+// lastColdChunk returns the last chunk of the series on the given day, if any
+func (t *TieredStore) lastColdChunk(ctx context.Context, key string, d uint32) ([]chunk.IterGen, error) {
+	name := blockName(key, d)
+	index, err := readIndex(ctx, t.bucket, name)
+	if err == errNotFound {
+		return nil, nil
+	}
+	if err != nil || len(index) == 0 {
+		return nil, err
+	}
+	return readChunks(ctx, t.bucket, name, index[len(index)-1:])
+}
+
+func (t *TieredStore) Stop() {
+	close(t.shutdown)
+	t.wg.Wait()
+	t.hot.Stop()
+}
@@ -0,0 +1,432 @@
+package tiered
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	schema "gopkg.in/raintank/schema.v1"
+
+	"github.com/grafana/metrictank/cluster"
+	"github.com/grafana/metrictank/conf"
+	"github.com/grafana/metrictank/idx"
+	"github.com/grafana/metrictank/mdata"
+	"github.com/grafana/metrictank/mdata/chunk"
+	"github.com/grafana/metrictank/test"
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+const longTTL = 365 * day
+
+func init() {
+	cluster.Init("default", "test", time.Now(), "http", 6060)
+	cluster.Manager.SetPrimary(true)
+	// schema 0 has a raw and a rollup archive with a long TTL, schema 1 is the rollup archive and
+	// schema 2 is the default schema, with a TTL of a day
+	mdata.Schemas = conf.NewSchemas([]conf.Schema{{
+		Name:    "long",
+		Pattern: regexp.MustCompile(".*"),
+		Retentions: conf.Retentions{
+			conf.NewRetentionMT(60, longTTL, 3600, 2, true),
+			conf.NewRetentionMT(3600, 2*longTTL, 6*3600, 2, true),
+		},
+	}})
+	mdata.Aggregations = conf.NewAggregations()
+}
+
+// index is an Index with the given archives
+type index []idx.Archive
+
+func (i index) ForEach(fn func(idx.Archive)) {
+	for _, archive := range i {
+		fn(archive)
+	}
+}
+
+func newArchive(key schema.AMKey, schemaId uint16, lastUpdate uint32) idx.Archive {
+	return idx.Archive{
+		MetricDefinition: schema.MetricDefinition{
+			Id:         key.MKey,
+			LastUpdate: int64(lastUpdate),
+		},
+		SchemaId: schemaId,
+	}
+}
+
+// hotStore is an in-memory hot store that keeps chunks per TTL, like the cassandra store does
+type hotStore struct {
+	chunks map[uint32]map[schema.AMKey][]chunk.IterGen
+}
+
+func newHotStore() *hotStore {
+	return &hotStore{chunks: make(map[uint32]map[schema.AMKey][]chunk.IterGen)}
+}
+
+func (h *hotStore) Add(cwr *mdata.ChunkWriteRequest) {
+	if h.chunks[cwr.TTL] == nil {
+		h.chunks[cwr.TTL] = make(map[schema.AMKey][]chunk.IterGen)
+	}
+	itgen := cwr.Chunk.IterGen(cwr.Span)
+	h.chunks[cwr.TTL][cwr.Key] = append(h.chunks[cwr.TTL][cwr.Key], *itgen)
+}
+
+// expire removes all chunks with a t0 before the given timestamp
+func (h *hotStore) expire(before uint32) {
+	for _, keys := range h.chunks {
+		for key, itgens := range keys {
+			var keep []chunk.IterGen
+			for _, itgen := range itgens {
+				if itgen.Ts >= before {
+					keep = append(keep, itgen)
+				}
+			}
+			keys[key] = keep
+		}
+	}
+}
+
+func (h *hotStore) Search(ctx context.Context, key schema.AMKey, ttl, start, end uint32) ([]chunk.IterGen, error) {
+	var res []chunk.IterGen
+	for _, itgen := range h.chunks[ttl][key] {
+		if itgen.Ts < end && itgen.EndTs() > start {
+			res = append(res, itgen)
+		}
+	}
+	sort.Sort(chunk.IterGensAsc(res))
+	return res, nil
+}
+
+func (h *hotStore) Stop()                          {}
+func (h *hotStore) SetTracer(t opentracing.Tracer) {}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "tiered-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func newTestStore(t *testing.T, dir string, hot mdata.Store, index Index) *TieredStore {
+	config := NewStoreConfig()
+	config.Enabled = true
+	config.Age = "1d"
+	config.Backend = "fs"
+	config.FSPath = dir + "/bucket"
+	config.MoveInterval = 0
+	ts, err := NewTieredStore(config, hot)
+	if err != nil {
+		t.Fatalf("failed to create store: %s", err)
+	}
+	ts.Start(index)
+	return ts
+}
+
+func newChunk(t0, span uint32) *chunk.Chunk {
+	c := chunk.New(t0)
+	for ts := t0; ts < t0+span; ts += 60 {
+		c.Push(ts, float64(ts))
+	}
+	c.Finish()
+	return c
+}
+
+func addChunks(ts *TieredStore, key schema.AMKey, ttl, span uint32, t0s ...uint32) {
+	for _, t0 := range t0s {
+		cwr := mdata.NewChunkWriteRequest(nil, key, newChunk(t0, span), ttl, span, time.Now())
+		ts.Add(&cwr)
+	}
+}
+
+func t0sOf(t *testing.T, itgens []chunk.IterGen) []uint32 {
+	t0s := []uint32{}
+	for _, itgen := range itgens {
+		it, err := itgen.Get()
+		if err != nil {
+			t.Fatalf("failed to decode chunk %d: %s", itgen.Ts, err)
+		}
+		if !it.Next() {
+			t.Fatalf("chunk %d has no points", itgen.Ts)
+		}
+		if ts, val := it.Values(); ts != itgen.Ts || val != float64(ts) {
+			t.Fatalf("chunk %d has unexpected first point %d:%f", itgen.Ts, ts, val)
+		}
+		t0s = append(t0s, itgen.Ts)
+	}
+	return t0s
+}
+
+func TestHotTTLs(t *testing.T) {
+	config := NewStoreConfig()
+	config.Enabled = true
+	config.Age = "7d"
+	got := config.HotTTLs([]uint32{day, 9 * day, 10 * day, longTTL})
+	if exp := []uint32{day, 9 * day, 10 * day, longTTL}; !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected hot TTLs %v, got %v", exp, got)
+	}
+	got = config.HotTTLs([]uint32{10 * day, longTTL})
+	if exp := []uint32{10 * day, 9 * day, longTTL}; !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected hot TTLs %v, got %v", exp, got)
+	}
+	config.Margin = "5d"
+	got = config.HotTTLs([]uint32{10 * day, longTTL})
+	if exp := []uint32{10 * day, longTTL, 12 * day}; !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected hot TTLs %v with a margin of 5 days, got %v", exp, got)
+	}
+}
+
+func TestMoveAndSearch(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	hot := newHotStore()
+	now := uint32(time.Now().Unix())
+	today := now / day
+	key := test.GetAMKey(1)
+	ts := newTestStore(t, dir, hot, index{newArchive(key, 0, now), newArchive(test.GetAMKey(2), 2, now)})
+	defer ts.Stop()
+
+	// with an age of 1 day, chunks of 2 days ago can be moved, and with the default margin of 2 days,
+	// they're kept in the hot store for 3 days
+	old := (today - 2) * day
+	var oldT0s []uint32
+	for t0 := old; t0 < old+day; t0 += 3600 {
+		oldT0s = append(oldT0s, t0)
+	}
+	addChunks(ts, key, longTTL, 3600, oldT0s...)
+	addChunks(ts, key, longTTL, 3600, today*day)
+	// series with a short TTL are left alone
+	addChunks(ts, test.GetAMKey(2), day, 3600, old)
+
+	if len(hot.chunks[3*day][key]) != 25 || len(hot.chunks[day][test.GetAMKey(2)]) != 1 {
+		t.Fatalf("expected the chunks to be added to the hot store with the capped TTL, got %v", hot.chunks)
+	}
+
+	ts.move(now)
+	moved, err := ts.readProgress(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := moved[today-2]; !ok {
+		t.Fatalf("expected day %d to be moved, got %v", today-2, moved)
+	}
+	if _, ok := moved[today-1]; ok {
+		t.Fatalf("expected day %d not to be moved yet", today-1)
+	}
+
+	// short TTLs only use the hot store
+	itgens, err := ts.Search(test.NewContext(), test.GetAMKey(2), day, old, old+3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []uint32{old}; !reflect.DeepEqual(t0sOf(t, itgens), exp) {
+		t.Fatalf("expected chunks %v, got %v", exp, t0sOf(t, itgens))
+	}
+
+	// once the chunks have expired from the hot store, they must come from the cold store
+	hot.expire(today * day)
+	itgens, err = ts.Search(test.NewContext(), key, longTTL, old+5*3600+1, today*day+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := append(oldT0s[5:], today*day); !reflect.DeepEqual(t0sOf(t, itgens), exp) {
+		t.Fatalf("expected chunks %v, got %v", exp, t0sOf(t, itgens))
+	}
+
+	// the chunk containing start is in the block of the day before
+	itgens, err = ts.searchCold(test.NewContext(), key.String(), old+day+1, old+day+7200, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []uint32{old + 23*3600}; !reflect.DeepEqual(t0sOf(t, itgens), exp) {
+		t.Fatalf("expected chunks %v, got %v", exp, t0sOf(t, itgens))
+	}
+}
+
+func TestSearchBothTiers(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	now := uint32(time.Now().Unix())
+	old := (now/day - 2) * day
+	key := test.GetAMKey(1)
+	ts := newTestStore(t, dir, newHotStore(), index{newArchive(key, 0, now)})
+	defer ts.Stop()
+
+	addChunks(ts, key, longTTL, 3600, old, old+3600)
+	ts.move(now)
+
+	// moved chunks that are still in the hot store must only be returned once
+	itgens, err := ts.Search(test.NewContext(), key, longTTL, old, old+7200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []uint32{old, old + 3600}; !reflect.DeepEqual(t0sOf(t, itgens), exp) {
+		t.Fatalf("expected chunks %v, got %v", exp, t0sOf(t, itgens))
+	}
+}
+
+// recordingBucket is a Bucket that records the names of the objects that are read
+type recordingBucket struct {
+	Bucket
+	names []string
+}
+
+func (b *recordingBucket) GetSuffix(ctx context.Context, name string, length int64) ([]byte, error) {
+	b.names = append(b.names, name)
+	return b.Bucket.GetSuffix(ctx, name, length)
+}
+
+func TestSearchColdOnlyOldDays(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	now := uint32(time.Now().Unix())
+	today := now / day
+	ts := newTestStore(t, dir, newHotStore(), index{})
+	defer ts.Stop()
+	bucket := &recordingBucket{Bucket: ts.bucket}
+	ts.bucket = bucket
+
+	// with an age of 1 day, the blocks of yesterday and today can't have been written yet
+	_, err := ts.searchCold(test.NewContext(), "some.key", (today-3)*day+1, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{blockName("some.key", today-3), blockName("some.key", today-2), blockName("some.key", today-4)}
+	if !reflect.DeepEqual(bucket.names, exp) {
+		t.Fatalf("expected to read blocks %v, got %v", exp, bucket.names)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	hot := newHotStore()
+	now := uint32(time.Now().Unix())
+	old := (now/day - 100) * day
+	key := test.GetAMKey(1)
+	ts := newTestStore(t, dir, hot, index{newArchive(key, 0, now)})
+	defer ts.Stop()
+
+	// chunks that were saved before the cold store was enabled are in the table of their original TTL
+	sum := schema.AMKey{MKey: key.MKey, Archive: schema.NewArchive(schema.Sum, 3600)}
+	cnt := schema.AMKey{MKey: key.MKey, Archive: schema.NewArchive(schema.Cnt, 3600)}
+	ttls := map[schema.AMKey]uint32{key: longTTL, sum: 2 * longTTL, cnt: 2 * longTTL}
+	for k, ttl := range ttls {
+		cwr := mdata.NewChunkWriteRequest(nil, k, newChunk(old, 3600), ttl, 3600, time.Now())
+		hot.Add(&cwr)
+	}
+	itgens, err := ts.Search(test.NewContext(), key, longTTL, old, old+3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []uint32{old}; !reflect.DeepEqual(t0sOf(t, itgens), exp) {
+		t.Fatalf("expected chunks %v before moving, got %v", exp, t0sOf(t, itgens))
+	}
+
+	ts.move(now)
+	hot.expire(now)
+	for k, ttl := range ttls {
+		itgens, err = ts.Search(test.NewContext(), k, ttl, old, old+3600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp := []uint32{old}; !reflect.DeepEqual(t0sOf(t, itgens), exp) {
+			t.Fatalf("expected chunks %v of %s to be moved, got %v", exp, k, t0sOf(t, itgens))
+		}
+	}
+}
+
+func TestMoveTakeOver(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	hot := newHotStore()
+	now := uint32(time.Now().Unix())
+	today := now / day
+	key := test.GetAMKey(1)
+	ts := newTestStore(t, dir, hot, index{newArchive(key, 0, now)})
+	defer ts.Stop()
+	addChunks(ts, key, longTTL, 3600, (today-2)*day, (today-1)*day)
+
+	// nodes that are not primary don't move anything
+	cluster.Manager.SetPrimary(false)
+	ts.move(now)
+	cluster.Manager.SetPrimary(true)
+	if moved, _ := ts.readProgress(0); len(moved) != 0 {
+		t.Fatalf("expected no days to be moved by a secondary node, got %v", moved)
+	}
+	ts.move(now)
+
+	// another node, that did not save the chunks, takes over the next day
+	other := newTestStore(t, dir, hot, index{newArchive(key, 0, now)})
+	defer other.Stop()
+	blocks := coldBlocksWritten.Peek()
+	other.move(now + day)
+	if written := coldBlocksWritten.Peek() - blocks; written != 1 {
+		t.Fatalf("expected only the block of the new day to be written, got %d blocks", written)
+	}
+	itgens, err := other.searchCold(test.NewContext(), key.String(), (today-2)*day, today*day, now+day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []uint32{(today - 2) * day, (today - 1) * day}; !reflect.DeepEqual(t0sOf(t, itgens), exp) {
+		t.Fatalf("expected chunks %v, got %v", exp, t0sOf(t, itgens))
+	}
+}
+
+func TestBlock(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	bucket, err := NewFSBucket(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// enough chunks for the index not to fit in the suffix that is read first
+	var itgens []chunk.IterGen
+	var t0s []uint32
+	for t0 := uint32(0); t0 < 500*60; t0 += 60 {
+		itgens = append(itgens, *newChunk(t0, 60).IterGen(60))
+		t0s = append(t0s, t0)
+	}
+	block, err := encodeBlock(itgens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	err = bucket.Put(ctx, "some.key/2018-06-01", block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := readIndex(ctx, bucket, "some.key/2018-06-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index) != len(itgens) {
+		t.Fatalf("expected %d index entries, got %d", len(itgens), len(index))
+	}
+	got, err := readChunks(ctx, bucket, "some.key/2018-06-01", index[10:20])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(t0sOf(t, got), t0s[10:20]) {
+		t.Fatalf("expected chunks %v, got %v", t0s[10:20], t0sOf(t, got))
+	}
+	if got[0].Span != 60 {
+		t.Fatalf("expected span 60, got %d", got[0].Span)
+	}
+
+	// corrupt the index
+	block[len(block)-footerLen-1]++
+	bucket.Put(ctx, "some.key/2018-06-02", block)
+	if _, err := readIndex(ctx, bucket, "some.key/2018-06-02"); err != errCorruptBlock {
+		t.Fatalf("expected errCorruptBlock, got %v", err)
+	}
+	if _, err := readIndex(ctx, bucket, "some.key/2018-06-03"); err != errNotFound {
+		t.Fatalf("expected errNotFound, got %v", err)
+	}
+}
@@ -1,7 +1,7 @@
 package util
 
 import (
-	"strconv"
+	"strings"
 )
 
 // NaturalSortStringSlice sorts strings lexicographically *except* substrings of numbers are sorted numerically
@@ -67,10 +67,14 @@ func compareChunks(a, b string) int {
 	}
 
 	if IsDigit(a[0]) && IsDigit(b[0]) {
-		// Both numeric, compare as numbers
-		aNum, _ := strconv.Atoi(a)
-		bNum, _ := strconv.Atoi(b)
-		return aNum - bNum
+		// Both numeric, compare as numbers.
+		// we don't parse them, so that numbers of any length are supported.
+		a = strings.TrimLeft(a, "0")
+		b = strings.TrimLeft(b, "0")
+		if len(a) != len(b) {
+			return len(a) - len(b)
+		}
+		return strings.Compare(a, b)
 	}
 
 	// Not both numeric, compare as strings
@@ -42,6 +42,10 @@ func TestNaturalSort(t *testing.T) {
 			[]string{"series00030", "series0003d", "series0003"},
 			[]string{"series0003", "series0003d", "series00030"},
 		},
+		{
+			[]string{"host.99999999999999999999999.cpu", "host.100000000000000000000000.cpu", "host.2.cpu"},
+			[]string{"host.2.cpu", "host.99999999999999999999999.cpu", "host.100000000000000000000000.cpu"},
+		},
 		{
 			shuffledRandInput,
 			randInput,